@@ -1,50 +1,143 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/alexflint/go-arg"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/check"
+	"github.com/daniel-cole/GoS3GFSBackup/config"
 	"github.com/daniel-cole/GoS3GFSBackup/download"
+	"github.com/daniel-cole/GoS3GFSBackup/hooks"
+	"github.com/daniel-cole/GoS3GFSBackup/lifecycle"
+	"github.com/daniel-cole/GoS3GFSBackup/lock"
 	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/metrics"
+	"github.com/daniel-cole/GoS3GFSBackup/notify"
+	"github.com/daniel-cole/GoS3GFSBackup/replicate"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
 	"github.com/daniel-cole/GoS3GFSBackup/rotate"
 	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
 	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
 	"github.com/daniel-cole/GoS3GFSBackup/upload"
 	"github.com/daniel-cole/GoS3GFSBackup/util"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type args struct {
-	Action                 string `arg:"help:The intended action for the tool to run [backup|upload|download|rotate]"`
-	Region                 string `arg:"required,help:The AWS region to upload the specified file to"`
-	Bucket                 string `arg:"required,help:The S3 bucket to upload the specified file to"`
-	CredFile               string `arg:"help:The full path to the AWS CLI credential file if environment variables are not being used to provide the access id and key"`
-	Profile                string `arg:"help:The profile to use for the AWS CLI credential file"`
-	PathToFile             string `arg:"help:The full path to the file to upload to the specified S3 bucket. Must be specified unless --rotateonly=true"`
-	S3FileName             string `arg:"help:The name of the file as it should appear in the S3 bucket. Must be specified unless --rotateonly=true"`
-	BucketDir              string `arg:"help:The directory chain in the bucket in which to upload the S3 object to. Must include the trailing slash"`
-	Timeout                int    `arg:"help:The timeout to upload the specified file (seconds)"`
-	DryRun                 bool   `arg:"help:If enabled then no upload or rotation actions will be executed [default: false]"`
-	ConcurrentWorkers      int    `arg:"help:The number of threads to use when uploading the file to S3"`
-	PartSize               int    `arg:"help:The part size to use when performing a multipart upload or download (MB)"`
-	EnforceRetentionPeriod bool   `arg:"help:If enabled then objects in the S3 bucket will only be rotated if they are older then the retention period"`
-	DailyRetentionCount    int    `arg:"help:The number of daily objects to keep in S3"`
-	DailyRetentionPeriod   int    `arg:"help:The retention period (hours) that a daily object should be kept in S3"`
-	WeeklyRetentionCount   int    `arg:"help:The number of weekly objects to keep in S3"`
-	WeeklyRetentionPeriod  int    `arg:"help:The retention period (hours) that a weekly object should be kept in S3"`
+	Action                  string               `arg:"help:The intended action for the tool to run [backup|upload|download|rotate|plan|apply|check|replicate|list|apply-lifecycle|prune-multipart]"`
+	Region                  string               `arg:"help:The AWS region to upload the specified file to. Required unless --config is specified"`
+	Bucket                  string               `arg:"help:The S3 bucket to upload the specified file to or a local directory such as file:///mnt/backups. Required unless --config is specified"`
+	CredFile                string               `arg:"help:The full path to the AWS CLI credential file to use instead of ~/.aws/credentials. Environment variables take precedence"`
+	Endpoint                string               `arg:"help:The URL of an S3 compatible server such as MinIO or Ceph RGW to use instead of AWS S3. --region defaults to us-east-1 if not specified"`
+	ForcePathStyle          bool                 `arg:"help:If enabled then buckets are addressed as https://host/bucket rather than https://bucket.host as required by most S3 compatible servers [default: false]"`
+	DisableSSL              bool                 `arg:"help:If enabled then http is used for an --endpoint which does not specify a scheme [default: false]"`
+	CABundle                string               `arg:"help:The full path to a PEM file of CA certificates to trust when connecting to --endpoint such as a self-signed certificate"`
+	RoleARN                 string               `arg:"help:The ARN of an IAM role to assume with the credentials found i.e. to write to a bucket in a central backup account"`
+	ExternalID              string               `arg:"help:The external id required by the trust policy of --rolearn"`
+	RoleDuration            int                  `arg:"help:The duration (seconds) of the session of --rolearn. The session is renewed before it expires"`
+	ExpectedBucketOwner     string               `arg:"help:The account id which must own the bucket. Every request is rejected by S3 if the bucket is owned by another account"`
+	ACL                     string               `arg:"help:The canned ACL applied to every object written [private|bucket-owner-read|bucket-owner-full-control]"`
+	Profile                 string               `arg:"help:The profile to use for the AWS CLI credential file. Defaults to AWS_PROFILE or the default profile"`
+	PathToFile              string               `arg:"help:The full path to the file to upload to the specified S3 bucket. Must be specified unless --rotateonly=true"`
+	S3FileName              string               `arg:"help:The name of the file as it should appear in the S3 bucket. Must be specified unless --rotateonly=true"`
+	BucketDir               string               `arg:"help:The directory chain in the bucket in which to upload the S3 object to. Must include the trailing slash"`
+	Timeout                 int                  `arg:"help:The timeout to upload the specified file (seconds)"`
+	DryRun                  bool                 `arg:"help:If enabled then no upload or rotation actions will be executed [default: false]"`
+	ConcurrentWorkers       int                  `arg:"help:The number of threads to use when uploading the file to S3"`
+	PartSize                int                  `arg:"help:The part size to use when performing a multipart upload or download (MB)"`
+	Dedup                   bool                 `arg:"help:If enabled then a backup identical to the latest backup of the series is copied within S3 instead of uploaded [default: false]"`
+	Chunked                 bool                 `arg:"help:If enabled then a backup is split into content-defined chunks stored once in the chunks/ prefix of --bucketdir and a manifest is uploaded in place of the file [default: false]"`
+	ChunkGracePeriod        int                  `arg:"help:The age (hours) an unreferenced chunk must reach before it is deleted by garbage collection"`
+	EnforceRetentionPeriod  bool                 `arg:"help:If enabled then objects in the S3 bucket will only be rotated if they are older then the retention period"`
+	DailyRetentionCount     int                  `arg:"help:The number of daily objects to keep in S3"`
+	DailyRetentionPeriod    int                  `arg:"help:The retention period (hours) that a daily object should be kept in S3"`
+	WeeklyRetentionCount    int                  `arg:"help:The number of weekly objects to keep in S3"`
+	WeeklyRetentionPeriod   int                  `arg:"help:The retention period (hours) that a weekly object should be kept in S3"`
+	MinKeep                 int                  `arg:"help:The minimum number of daily and weekly objects that must remain after rotation. The rotation is refused if it would leave fewer. 0 disables"`
+	MaxDeletes              int                  `arg:"help:The maximum number of objects a single rotation may delete. The rotation is refused if it would delete more. 0 disables"`
+	MaxBackupAge            int                  `arg:"help:The rotation is refused if the newest object of the series is older than this (hours). 0 disables"`
+	Promote                 bool                 `arg:"help:If enabled then a missed weekly or monthly backup is filled by the next backup or by promoting a daily object during rotation [default: false]"`
+	MonthlyTransitionDays   int                  `arg:"help:The number of days before a monthly object is transitioned by the bucket lifecycle configuration. 0 disables the transition"`
+	MonthlyStorageClass     string               `arg:"help:The storage class monthly objects are transitioned to by the bucket lifecycle configuration"`
+	MonthlyExpirationDays   int                  `arg:"help:The number of days before a monthly object is expired by the bucket lifecycle configuration. 0 disables expiration"`
+	AbortMultipartDays      int                  `arg:"help:The number of days before an incomplete multipart upload is aborted by the bucket lifecycle configuration. 0 disables the rule"`
+	MultipartMinAge         int                  `arg:"help:The minimum age (hours) of an incomplete multipart upload before it is aborted by the prune-multipart action"`
+	Plan                    string               `arg:"help:The full path to the rotation plan file written by the plan action and executed by the apply action"`
+	ReplicaBucket           string               `arg:"help:The secondary bucket the replicate action copies backups to"`
+	ReplicaRegion           string               `arg:"help:The AWS region of the replica bucket. Defaults to --region"`
+	ReplicaCredFile         string               `arg:"help:The full path to the AWS CLI credential file for the replica bucket. Defaults to --credfile"`
+	ReplicaProfile          string               `arg:"help:The profile to use for the AWS CLI credential file for the replica bucket. Defaults to --profile"`
+	ReplicaBucketOwner      string               `arg:"help:The account id which must own the replica bucket. Defaults to --expectedbucketowner"`
+	ReplicaDailyCount       int                  `arg:"help:The number of daily objects to keep in the replica bucket. 0 uses --dailyretentioncount"`
+	ReplicaDailyPeriod      int                  `arg:"help:The retention period (hours) that a daily object should be kept in the replica bucket. 0 uses --dailyretentionperiod"`
+	ReplicaWeeklyCount      int                  `arg:"help:The number of weekly objects to keep in the replica bucket. 0 uses --weeklyretentioncount"`
+	ReplicaWeeklyPeriod     int                  `arg:"help:The retention period (hours) that a weekly object should be kept in the replica bucket. 0 uses --weeklyretentionperiod"`
+	WarnAge                 int                  `arg:"help:The check action warns if the newest backup is older than this (hours). 0 disables"`
+	CritAge                 int                  `arg:"help:The check action is critical if the newest backup is older than this (hours). 0 disables"`
+	WarnSize                int64                `arg:"help:The check action warns if the newest backup is smaller than this (bytes). 0 disables"`
+	CritSize                int64                `arg:"help:The check action is critical if the newest backup is smaller than this (bytes). 0 disables"`
+	CheckTiers              bool                 `arg:"help:If enabled then the check action also checks the newest backup of each tier [default: false]"`
+	Config                  string               `arg:"help:The full path to a YAML config file defining named jobs. Command line arguments take precedence over the config file for every job"`
+	Job                     string               `arg:"help:The name of the job in the config file to run"`
+	All                     bool                 `arg:"help:If enabled then every job in the config file will be run [default: false]"`
+	PreBackupHook           string               `arg:"help:A shell command to run before the backup. A non-zero exit status aborts the backup"`
+	PreBackupHookTimeout    int                  `arg:"help:The timeout for the pre-backup hook (seconds)"`
+	PostSuccessHook         string               `arg:"help:A shell command to run after a successful backup"`
+	PostSuccessHookTimeout  int                  `arg:"help:The timeout for the post-success hook (seconds)"`
+	PostFailureHook         string               `arg:"help:A shell command to run after a failed backup"`
+	PostFailureHookTimeout  int                  `arg:"help:The timeout for the post-failure hook (seconds)"`
+	PostRotationHook        string               `arg:"help:A shell command to run after the rotation of a backup or rotate action"`
+	PostRotationHookTimeout int                  `arg:"help:The timeout for the post-rotation hook (seconds)"`
+	LockTTL                 int                  `arg:"help:The time (seconds) a lock on the series is held for before it expires unless renewed. The lock is renewed every third of this time"`
+	ForceUnlock             bool                 `arg:"help:If enabled then any existing lock on the series is removed before the backup or rotate action is run [default: false]"`
+	WebhookURL              string               `arg:"help:The URL of a webhook which is sent a JSON summary of every run"`
+	WebhookHeaders          []string             `arg:"help:Headers sent to the webhook in the form 'Name: value'"`
+	WebhookSecret           string               `arg:"help:If set then the webhook body is signed with HMAC-SHA256 using this secret. May also be set with GOS3GFSBACKUP_WEBHOOK_SECRET"`
+	WebhookTemplate         string               `arg:"help:A Go template used to render the webhook body instead of the JSON summary"`
+	WebhookRetries          int                  `arg:"help:The number of times a failed webhook request is retried"`
+	WebhookTimeout          int                  `arg:"help:The timeout of each webhook request (seconds)"`
+	WebhookEvents           []string             `arg:"help:The run statuses sent to the webhook [success|warning|failure]. All statuses are sent if not specified"`
+	SMTPHost                string               `arg:"help:The host of the mail relay which is sent a report of every run. No email is sent if not specified"`
+	SMTPPort                int                  `arg:"help:The port of the mail relay"`
+	SMTPUsername            string               `arg:"help:The username to authenticate with the mail relay. No authentication is used if not specified"`
+	SMTPPassword            string               `arg:"help:The password to authenticate with the mail relay. May also be set with GOS3GFSBACKUP_SMTP_PASSWORD"`
+	SMTPStartTLS            bool                 `arg:"help:If enabled then the connection to the mail relay must be upgraded with STARTTLS"`
+	SMTPTimeout             int                  `arg:"help:The timeout for sending an email (seconds)"`
+	MailFrom                string               `arg:"help:The address the report is sent from"`
+	MailTo                  []string             `arg:"help:The addresses the report is sent to"`
+	MailWhen                string               `arg:"help:When the report is sent [always|failure]"`
+	LogLevel                string               `arg:"help:The minimum level of log entries to write [debug|info|warn|error]"`
+	LogFormat               string               `arg:"help:The format of log entries [text|json]"`
+	Banners                 bool                 `arg:"help:If disabled then banners are not written to the log. Banners are never written in json format"`
+	MetricsFile             string               `arg:"help:The full path to a node_exporter textfile collector file which Prometheus metrics are written to after every run"`
+	PushgatewayURL          string               `arg:"help:The URL of a Prometheus Pushgateway which metrics are pushed to after every run"`
+	Daemon                  bool                 `arg:"help:If enabled then jobs in the config file are run continuously according to their schedule [default: false]"`
+	StateFile               string               `arg:"help:The full path to the file used to record the last successful run of each job in daemon mode. Defaults to the config file path with a '.state' suffix"`
+	Report                  string               `arg:"help:The full path to a file which a JSON report of the run is written to. If '-' then the report is written to stdout and the log to stderr"`
+	JobName                 string               `arg:"-"`
+	Schedule                string               `arg:"-"`
+	Webhooks                []config.Webhook     `arg:"-"`
+	Destinations            []config.Destination `arg:"-"` // Additional buckets the backup action uploads to, only set by the config file
+	Logger                  *log.Logger          `arg:"-"` // The logger of the run, set by runJob
+	Explicit                map[string]bool      `arg:"-"` // The names of the arguments given on the command line
 }
 
 func init() {
 	log.Init(os.Stdout, os.Stdout, os.Stderr)
 }
 
-func main() {
-	// Set default args
+// getDefaultArgs returns the built-in defaults of the arguments
+// These are overridden by the config file, which is in turn overridden by the arguments given on the command line
+func getDefaultArgs() args {
 	args := args{}
 	args.Timeout = 3600 // Default timeout to 1 hour for file upload
 	args.CredFile = ""
-	args.Profile = "default"
+	args.RoleDuration = 3600
 	args.BucketDir = ""
 	args.EnforceRetentionPeriod = true
 	args.DryRun = false
@@ -54,86 +147,761 @@ func main() {
 	args.DailyRetentionPeriod = 168
 	args.WeeklyRetentionCount = 4
 	args.WeeklyRetentionPeriod = 672
+	args.MonthlyTransitionDays = 30
+	args.MonthlyStorageClass = "GLACIER"
+	args.MonthlyExpirationDays = 0
+	args.AbortMultipartDays = 7
+	args.MultipartMinAge = 24
+	args.ChunkGracePeriod = 24
+	args.WarnAge = 26
+	args.CritAge = 50
+	args.LockTTL = 300
+	args.PreBackupHookTimeout = 3600
+	args.PostSuccessHookTimeout = 300
+	args.PostFailureHookTimeout = 300
+	args.PostRotationHookTimeout = 300
+	args.LogLevel = "info"
+	args.LogFormat = "text"
+	args.Banners = true
+	args.WebhookRetries = 3
+	args.WebhookTimeout = 10
+	args.SMTPPort = 587
+	args.SMTPStartTLS = true
+	args.SMTPTimeout = 30
+	args.MailWhen = "always"
+
+	return args
+}
+
+func main() {
+	args := getDefaultArgs()
 
 	// Parse args from command line
 	arg.MustParse(&args)
+	args.Explicit = getExplicitArgs(os.Args[1:])
+
+	// The jobs are loaded before anything is logged so that the log can be kept off stdout if any job needs it
+	jobs, jobsErr := getJobs(args)
+	if logsToStderr(args, jobs) {
+		log.Init(os.Stderr, os.Stderr, os.Stderr)
+	}
+
+	err := log.Configure(args.LogLevel, args.LogFormat, args.Banners)
+	if err != nil {
+		exitInvalid(args, err)
+	}
 
 	logArgs(args)
 
-	log.Info.Println(`
-	######################################
-	#        GoS3GFSBackup Started       #
-	######################################
-	`)
+	log.Banner("GoS3GFSBackup Started")
 
-	svc, err := s3client.CreateS3Client(args.CredFile, args.Profile, args.Region)
-	if err != nil {
-		log.Error.Println(err)
-		os.Exit(1)
+	if jobsErr != nil {
+		exitInvalid(args, jobsErr)
 	}
 
-	runAction(svc, args)
+	if args.Config != "" {
+		log.Info.Printf("Loaded %d job(s) from config file: '%s'\n", len(jobs), args.Config)
+	}
+
+	if args.Daemon {
+		err = runDaemon(jobs, args)
+		if err != nil {
+			log.Error.Println(err)
+			os.Exit(report.ExitCode(err))
+		}
+		return
+	}
+
+	failedJobs := 0
+	summaries := []*report.Summary{}
+	for _, job := range jobs {
+		summary, err := runJob(job)
+		summaries = append(summaries, summary)
+		if err != nil {
+			log.Error.Printf("Job '%s' failed. Reason: %v\n", job.JobName, err)
+			failedJobs++
+		}
+	}
+
+	result := report.NewReport(summaries)
+	writeReport(args, result)
 
 	log.Info.Println("Finished GoS3GFSBackup!")
 
-	log.Info.Println(`
-	######################################
-	#      GoS3GFSBackup Finished        #
-	######################################
-	`)
+	log.Banner("GoS3GFSBackup Finished")
+
+	if failedJobs > 0 {
+		log.Error.Printf("%d of %d job(s) failed\n", failedJobs, len(jobs))
+	}
 
+	os.Exit(result.ExitCode)
 }
 
-func runAction(svc *s3.S3, args args) {
+// logsToStderr returns true if the log must be written to stderr to keep stdout for the report,
+// the status line of a check or a listing. This is decided by the action of every job that will be run
+func logsToStderr(arguments args, jobs []args) bool {
+	if arguments.Report == "-" || arguments.Action == "check" || arguments.Action == "list" {
+		return true
+	}
+	for _, job := range jobs {
+		if job.Action == "check" || job.Action == "list" {
+			return true
+		}
+	}
+	return false
+}
+
+// exitInvalid exits as the arguments or config file are invalid and no job can be run
+// The check action exits with the UNKNOWN status so that a monitoring system does not mistake the failure for a CRITICAL backup
+func exitInvalid(arguments args, err error) {
+	log.Error.Println(err)
+
+	exitCode := report.ExitValidation
+	if arguments.Action == "check" {
+		fmt.Println(check.Result{Status: check.Unknown, Message: err.Error()})
+		exitCode = check.Unknown
+	}
+
+	writeReport(arguments, report.Report{Status: report.StatusFailure, ExitCode: exitCode, Error: err.Error(), Runs: []*report.Summary{}})
+	os.Exit(exitCode)
+}
+
+// runJob runs the action of the job and returns the summary of the run
+func runJob(arguments args) (*report.Summary, error) {
+	summary := report.New(arguments.JobName, arguments.Action, arguments.Bucket, arguments.BucketDir, arguments.DryRun)
+
+	arguments.Logger = log.Default().With("run_id", summary.RunID, "action", arguments.Action)
+	if arguments.JobName != "" {
+		arguments.Logger = arguments.Logger.With("job", arguments.JobName)
+		arguments.Logger.Info.Printf("Running job: '%s'\n", arguments.JobName)
+	}
+
+	err := runAction(arguments, summary)
+	if arguments.Action == "check" {
+		err = checkUnknown(err)
+	}
+
+	summary.Finish(err)
+	sendNotifications(arguments, summary)
+	writeMetrics(arguments, summary)
+
+	return summary, err
+}
+
+func runAction(args args, summary *report.Summary) error {
+	if storage.IsFileURL(args.Bucket) {
+		return runFileAction(args, summary)
+	}
+
+	svc, err := s3client.NewS3Client(getClientOptions(args))
+	if err == nil && args.ExpectedBucketOwner != "" {
+		err = s3client.CheckBucketOwner(svc, args.Bucket, args.ExpectedBucketOwner)
+	}
+	if err != nil {
+		return err
+	}
+
+	retries := s3client.CountRetries(svc)
+	defer func() {
+		summary.Retries = retries.Count()
+	}()
+
+	store := storage.NewS3Store(svc, args.Bucket)
 	switch args.Action {
 	case "backup":
-		runBackupAction(svc, args)
+		return runBackupAction(store, args, summary)
 	case "upload":
-		runUploadAction(svc, args)
+		return runUploadAction(store, args, summary)
 	case "download":
-		runDownloadAction(svc, args)
+		return runDownloadAction(store, args, summary)
 	case "rotate":
-		runRotateAction(svc, args)
+		return runRotateAction(store, args, summary)
+	case "plan":
+		return runPlanAction(svc, args, summary)
+	case "apply":
+		return runApplyAction(svc, args, summary)
+	case "check":
+		return runCheckAction(svc, args, summary)
+	case "replicate":
+		return runReplicateAction(svc, args, summary)
+	case "list":
+		return runListAction(store, args, summary)
+	case "apply-lifecycle":
+		return runApplyLifecycleAction(svc, args, summary)
+	case "prune-multipart":
+		return runPruneMultipartAction(svc, args, summary)
+	default:
+		return report.WithExitCode(report.ExitValidation, errors.New("unexpected action specified: "+args.Action))
+	}
+}
+
+func runBackupAction(store storage.Store, arguments args, summary *report.Summary) (err error) {
+	arguments.Logger.Info.Println("Backup action specified, backing up file")
+
+	svc := getS3Client(store)
+	rotationPolicy := getRotationPolicy(arguments)
+	prefix := getKeyType(store, arguments, rotationPolicy)
+
+	hookEnv := getHookEnv(arguments)
+	hookEnv.Tier = util.GetTierName(rotationPolicy, prefix)
+	summary.Tier = hookEnv.Tier
+
+	arguments.Logger = arguments.Logger.With("tier", summary.Tier)
+	logger := arguments.Logger
+
+	defer func() {
+		if err != nil {
+			hookEnv.Err = err
+			runPostHook(hooks.Hook{Name: "post-failure", Command: arguments.PostFailureHook,
+				Timeout: time.Second * time.Duration(arguments.PostFailureHookTimeout)}, hookEnv, arguments.Logger)
+		} else {
+			runPostHook(hooks.Hook{Name: "post-success", Command: arguments.PostSuccessHook,
+				Timeout: time.Second * time.Duration(arguments.PostSuccessHookTimeout)}, hookEnv, arguments.Logger)
+		}
+	}()
+
+	lockDone := summary.Time("lock")
+	seriesLock, err := acquireLock(svc, arguments)
+	lockDone()
+	if err != nil {
+		return err
+	}
+	defer releaseLock(seriesLock, arguments.Logger)
+
+	hookDone := summary.Time("pre_backup_hook")
+	err = runPreBackupHook(arguments, hookEnv)
+	hookDone()
+	if err != nil {
+		return fmt.Errorf("pre-backup hook failed. Aborting backup. Reason: %v", err)
+	}
+
+	summary.Path = arguments.PathToFile
+	fileInfo, err := os.Stat(arguments.PathToFile)
+	if err == nil {
+		hookEnv.Size = fileInfo.Size()
+		summary.Bytes = hookEnv.Size
+	}
+
+	logger.Info.Printf("Starting standard GFS upload and rotation of '%s'\n", store)
+	uploadDone := summary.Time("upload")
+	uploadObject := getUploadObject(arguments, true)
+	uploadObject.SeriesPrefixes = []string{rotationPolicy.DailyPrefix, rotationPolicy.WeeklyPrefix, rotationPolicy.MonthlyPrefix}
+	var uploadResult upload.Result
+	var destinations []destination
+	if fileStore, ok := store.(*storage.FileStore); ok {
+		uploadResult.Key, err = upload.UploadFileToStore(fileStore, uploadObject, prefix, arguments.DryRun)
+	} else if arguments.Chunked {
+		uploadResult.Key, err = backupChunked(svc, arguments, prefix, summary)
+	} else if len(arguments.Destinations) > 0 {
+		uploadResult.Key, destinations, err = fanOutBackup(svc, arguments, uploadObject, prefix, rotationPolicy, summary)
+	} else {
+		uploadResult, err = upload.Upload(svc, uploadObject, prefix, arguments.DryRun)
+	}
+	uploadDone()
+	hookEnv.Key = uploadResult.Key
+	summary.Key = uploadResult.Key
+	summary.CopiedFrom = uploadResult.CopiedFrom
+	if err != nil {
+		return report.WithExitCode(report.ExitUploadFailed, fmt.Errorf("failed to upload file. Aborting backup. Reason: %v", err))
+	}
+
+	// Each destination is rotated under its own lock, even if the rotation of the bucket of the job failed
+	err = rotateBackup(store, seriesLock, arguments, rotationPolicy, &hookEnv, summary)
+	if len(destinations) > 0 {
+		destinationsDone := summary.Time("destination_rotation")
+		err = withDestinationsError(err, rotateDestinations(destinations, arguments, summary))
+		destinationsDone()
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Info.Println("Upload and Rotation Complete!")
+
+	return nil
+}
+
+// rotateBackup rotates the bucket of the job after a backup and runs the post-rotation hook
+// Chunks which are no longer referenced are collected once the rotation has succeeded
+func rotateBackup(store storage.Store, seriesLock *lock.Lock, arguments args, rotationPolicy rpolicy.RotationPolicy, hookEnv *hooks.Env, summary *report.Summary) error {
+	err := seriesLock.Err()
+	if err != nil {
+		return report.WithExitCode(report.ExitLockHeld, fmt.Errorf("aborting rotation. Reason: %v", err))
+	}
+
+	rotationDone := summary.Time("rotation")
+	rotation, err := rotate.RotateStore(seriesLock.Guard(store), rotationPolicy, arguments.DryRun, arguments.Logger)
+	rotationDone()
+	summary.AddRotation(rotation)
+	if err != nil {
+		return rotationRefused(err)
+	}
+	err = lockLost(seriesLock)
+	if err != nil {
+		return err
+	}
+	countObjects(store, arguments, rotationPolicy, summary)
+	hookEnv.DeletedKeys = rotation.DeletedKeys
+	runPostHook(hooks.Hook{Name: "post-rotation", Command: arguments.PostRotationHook,
+		Timeout: time.Second * time.Duration(arguments.PostRotationHookTimeout)}, *hookEnv, arguments.Logger)
+
+	err = rotationError(rotation)
+	if err != nil {
+		return err
+	}
+
+	if arguments.Chunked {
+		collectChunkGarbage(getS3Client(store), arguments, rotationPolicy, summary)
+	}
+
+	return nil
+}
+
+// getKeyType returns the tier prefix of a backup taken now
+// If missed backups are promoted then a late backup is reclassified as the weekly or monthly backup that was missed
+func getKeyType(store storage.Store, arguments args, policy rpolicy.RotationPolicy) string {
+	keyTime := time.Now()
+	if !policy.PromoteMissed {
+		return util.GetKeyType(policy, keyTime)
+	}
+
+	// Only the keys of the series are considered so that a backup of another file does not fill the tier
+	s3FileName := arguments.S3FileName + "_"
+	lastMonthly, err := storage.NewestKeyTime(store, arguments.BucketDir+policy.MonthlyPrefix+s3FileName)
+	if err == nil {
+		var lastWeekly time.Time
+		lastWeekly, err = storage.NewestKeyTime(store, arguments.BucketDir+policy.WeeklyPrefix+s3FileName)
+		if err == nil {
+			return util.GetCatchUpKeyType(policy, keyTime, lastMonthly, lastWeekly)
+		}
+	}
+
+	arguments.Logger.Warn.Printf("Failed to check for missed weekly or monthly backups, the backup will not be reclassified. Reason: %v\n", err)
+	return util.GetKeyType(policy, keyTime)
+}
+
+func runUploadAction(store storage.Store, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Println("Upload action specified, uploading file")
+
+	summary.Path = arguments.PathToFile
+	fileInfo, err := os.Stat(arguments.PathToFile)
+	if err == nil {
+		summary.Bytes = fileInfo.Size()
+	}
+
+	uploadDone := summary.Time("upload")
+	if fileStore, ok := store.(*storage.FileStore); ok {
+		summary.Key, err = upload.UploadFileToStore(fileStore, getUploadObject(arguments, false), "", arguments.DryRun)
+	} else {
+		summary.Key, err = upload.UploadFile(getS3Client(store), getUploadObject(arguments, false), "", arguments.DryRun)
+	}
+	uploadDone()
+	if err != nil {
+		return report.WithExitCode(report.ExitUploadFailed, fmt.Errorf("failed to upload file. Reason: %v", err))
+	}
+
+	return nil
+}
+
+func runRotateAction(store storage.Store, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Println("Rotate action specified, proceeding with rotation only")
+
+	svc := getS3Client(store)
+	lockDone := summary.Time("lock")
+	seriesLock, err := acquireLock(svc, arguments)
+	lockDone()
+	if err != nil {
+		return err
+	}
+	defer releaseLock(seriesLock, arguments.Logger)
+
+	rotationPolicy := getRotationPolicy(arguments)
+	rotationDone := summary.Time("rotation")
+	rotation, err := rotate.RotateStore(seriesLock.Guard(store), rotationPolicy, arguments.DryRun, arguments.Logger)
+	rotationDone()
+	summary.AddRotation(rotation)
+	if err != nil {
+		return rotationRefused(err)
+	}
+	err = lockLost(seriesLock)
+	if err != nil {
+		return err
+	}
+	countObjects(store, arguments, rotationPolicy, summary)
+
+	hookEnv := getHookEnv(arguments)
+	hookEnv.DeletedKeys = rotation.DeletedKeys
+	runPostHook(hooks.Hook{Name: "post-rotation", Command: arguments.PostRotationHook,
+		Timeout: time.Second * time.Duration(arguments.PostRotationHookTimeout)}, hookEnv, arguments.Logger)
+
+	err = rotationError(rotation)
+	if err != nil {
+		return err
+	}
+
+	if arguments.Chunked {
+		collectChunkGarbage(svc, arguments, rotationPolicy, summary)
+	}
+
+	return nil
+}
+
+func runPlanAction(svc *s3.S3, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Println("Plan action specified, writing rotation plan")
+
+	planDone := summary.Time("plan")
+	plan, err := rotate.CreatePlan(svc, arguments.Bucket, getRotationPolicy(arguments), arguments.Logger)
+	planDone()
+	if _, ok := err.(*rotate.SafeguardError); ok {
+		return rotationRefused(err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create rotation plan. Reason: %v", err)
+	}
+
+	summary.Rotation = plan.Tiers
+
+	err = rotate.WritePlan(arguments.Plan, plan)
+	if err != nil {
+		return fmt.Errorf("failed to write rotation plan to '%s'. Reason: %v", arguments.Plan, err)
+	}
+
+	logger.Info.Printf("Wrote rotation plan with %d candidate key(s) to: '%s'\n", len(plan.Candidates), arguments.Plan)
+
+	return nil
+}
+
+func runApplyAction(svc *s3.S3, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Printf("Apply action specified, applying rotation plan: '%s'\n", arguments.Plan)
+
+	plan, err := rotate.ReadPlan(arguments.Plan)
+	if err != nil {
+		return report.WithExitCode(report.ExitValidation, fmt.Errorf("failed to read rotation plan. Reason: %v", err))
+	}
+
+	if plan.Bucket != arguments.Bucket {
+		return report.WithExitCode(report.ExitValidation, fmt.Errorf("rotation plan is for bucket '%s', not '%s'", plan.Bucket, arguments.Bucket))
+	}
+
+	lockDone := summary.Time("lock")
+	seriesLock, err := acquireLock(svc, arguments)
+	lockDone()
+	if err != nil {
+		return err
+	}
+	defer releaseLock(seriesLock, arguments.Logger)
+
+	rotationDone := summary.Time("rotation")
+	rotation, err := rotate.ApplyPlan(svc, seriesLock.Guard(storage.NewS3Store(svc, plan.Bucket)), plan, arguments.DryRun, arguments.Logger)
+	rotationDone()
+	if err == rotate.ErrBucketChanged {
+		return report.WithExitCode(report.ExitPlanStale, fmt.Errorf("refusing to apply rotation plan. Reason: %v", err))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply rotation plan. Reason: %v", err)
+	}
+	summary.AddRotation(rotation)
+	err = lockLost(seriesLock)
+	if err != nil {
+		return err
+	}
+	countObjects(storage.NewS3Store(svc, arguments.Bucket), arguments, getRotationPolicy(arguments), summary)
+
+	hookEnv := getHookEnv(arguments)
+	hookEnv.DeletedKeys = rotation.DeletedKeys
+	runPostHook(hooks.Hook{Name: "post-rotation", Command: arguments.PostRotationHook,
+		Timeout: time.Second * time.Duration(arguments.PostRotationHookTimeout)}, hookEnv, arguments.Logger)
+
+	return rotationError(rotation)
+}
+
+// runCheckAction checks the age and size of the newest backup of the series and prints the result as a monitoring plugin
+// status line. The exit code of the check follows the monitoring plugin convention rather than the exit codes of the other actions
+func runCheckAction(svc *s3.S3, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Println("Check action specified, checking the newest backup")
+
+	thresholds := check.Thresholds{
+		WarnAge:  time.Hour * time.Duration(arguments.WarnAge),
+		CritAge:  time.Hour * time.Duration(arguments.CritAge),
+		WarnSize: arguments.WarnSize,
+		CritSize: arguments.CritSize,
+		Tiers:    arguments.CheckTiers,
+	}
+
+	checkDone := summary.Time("check")
+	result := check.Run(svc, arguments.Bucket, arguments.BucketDir, arguments.S3FileName, getRotationPolicy(arguments), thresholds)
+	checkDone()
+
+	summary.Key = result.Newest.Key
+	summary.Bytes = result.Newest.Size
+	summary.Tier = result.Newest.Tier
+
+	fmt.Println(result)
+
+	switch result.Status {
+	case check.OK:
+		return nil
+	case check.Warning:
+		summary.Warnings = append(summary.Warnings, result.Message)
+		summary.ExitCode = check.Warning
+		return nil
 	default:
-		log.Error.Println("unexpected action specified: " + args.Action)
+		return report.WithExitCode(result.Status, &checkStatusError{result.Message})
+	}
+}
+
+// checkStatusError is returned by the check action when the status of the newest backup is CRITICAL or UNKNOWN
+type checkStatusError struct {
+	message string
+}
+
+func (e *checkStatusError) Error() string {
+	return e.message
+}
+
+// checkUnknown returns the error of the check action. Any error other than the status of the check is a failure of the tool
+// itself, i.e. the client could not be created, so it is printed as an UNKNOWN status line and exits with the UNKNOWN status
+func checkUnknown(err error) error {
+	if err == nil {
+		return nil
+	}
+	if exitErr, ok := err.(*report.ExitError); ok {
+		if _, ok := exitErr.Err.(*checkStatusError); ok {
+			return err
+		}
 	}
+
+	fmt.Println(check.Result{Status: check.Unknown, Message: err.Error()})
+	return report.WithExitCode(check.Unknown, err)
 }
 
-func runBackupAction(svc *s3.S3, arguments args) {
-	log.Info.Println("Backup action specified, backing up file")
+// runListAction prints every key of each tier of the series, newest first, as tab separated lines of the tier,
+// modified time and key. If s3filename is specified then only the keys of that file are listed
+func runListAction(store storage.Store, arguments args, summary *report.Summary) error {
+	arguments.Logger.Info.Printf("List action specified, listing the backups in '%s'\n", store)
+
+	s3FileName := ""
+	if arguments.S3FileName != "" {
+		s3FileName = arguments.S3FileName + "_"
+	}
+
+	policy := getRotationPolicy(arguments)
+	listDone := summary.Time("list")
+	defer listDone()
+	for _, prefix := range []string{policy.DailyPrefix, policy.WeeklyPrefix, policy.MonthlyPrefix} {
+		keys, err := store.List(arguments.BucketDir + prefix + s3FileName)
+		if err != nil {
+			return fmt.Errorf("failed to list '%s' keys. Reason: %v", prefix, err)
+		}
+
+		tier := util.GetTierName(policy, prefix)
+		summary.ObjectCounts[tier] = len(keys)
+		for _, key := range keys {
+			fmt.Printf("%s\t%s\t%s\n", tier, key.ModifiedTime.Format(time.RFC3339), key.Key)
+		}
+	}
+
+	return nil
+}
+
+// runReplicateAction copies the backups missing from the replica bucket and then rotates the replica bucket with its own policy
+// The replica bucket is not rotated if any backup could not be replicated
+func runReplicateAction(svc *s3.S3, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Printf("Replicate action specified, replicating backups to bucket '%s'\n", arguments.ReplicaBucket)
+
+	replicaArgs := getReplicaArgs(arguments)
+	replicaSvc, err := s3client.NewS3Client(getClientOptions(replicaArgs))
+	if err != nil {
+		return fmt.Errorf("failed to create client for replica bucket. Reason: %v", err)
+	}
+
+	// The lock is taken on the replica series as that is the series which is written to and rotated
+	lockDone := summary.Time("lock")
+	seriesLock, err := acquireLock(replicaSvc, replicaArgs)
+	lockDone()
+	if err != nil {
+		return err
+	}
+	defer releaseLock(seriesLock, arguments.Logger)
 
 	rotationPolicy := getRotationPolicy(arguments)
+	replicaPolicy := getReplicaRotationPolicy(arguments, rotationPolicy)
+	s3FileName := ""
+	if arguments.S3FileName != "" {
+		s3FileName = arguments.S3FileName + "_"
+	}
+
+	// Server side copies require the credentials of the replica bucket to be able to read the primary bucket
+	serverSide := replicaArgs.CredFile == arguments.CredFile && replicaArgs.Profile == arguments.Profile
+
+	replicateDone := summary.Time("replication")
+	replication, err := replicate.Replicate(svc, replicate.ReplicateObject{
+		Bucket: arguments.Bucket,
+		Prefixes: []string{
+			arguments.BucketDir + rotationPolicy.DailyPrefix + s3FileName,
+			arguments.BucketDir + rotationPolicy.WeeklyPrefix + s3FileName,
+			arguments.BucketDir + rotationPolicy.MonthlyPrefix + s3FileName,
+		},
+		ReplicaSvc:    replicaSvc,
+		ReplicaBucket: arguments.ReplicaBucket,
+		ReplicaPolicy: &replicaPolicy,
+		ServerSide:    serverSide,
+		Timeout:       time.Second * time.Duration(arguments.Timeout),
+		NumWorkers:    arguments.ConcurrentWorkers,
+		PartSize:      arguments.PartSize,
+		Logger:        arguments.Logger,
+	}, arguments.DryRun)
+	replicateDone()
+	summary.ReplicatedKeys = replication.CopiedKeys
+	summary.Bytes = replication.Bytes
+	if err != nil {
+		return report.WithExitCode(report.ExitReplicationFailed, fmt.Errorf("failed to replicate backups. The replica bucket was not rotated. Reason: %v", err))
+	}
 
-	log.Info.Println("Starting standard GFS upload and rotation")
-	prefix := util.GetKeyType(rotationPolicy, time.Now())
-	_, err := upload.UploadFile(svc, getUploadObject(arguments, true), prefix, arguments.DryRun)
+	err = seriesLock.Err()
+	if err != nil {
+		return report.WithExitCode(report.ExitLockHeld, fmt.Errorf("aborting rotation of replica bucket. Reason: %v", err))
+	}
+
+	rotationDone := summary.Time("rotation")
+	rotation, err := rotate.RotateStore(seriesLock.Guard(storage.NewS3Store(replicaSvc, arguments.ReplicaBucket)), replicaPolicy,
+		arguments.DryRun, arguments.Logger.With("replica", arguments.ReplicaBucket))
+	rotationDone()
+	summary.AddRotation(rotation)
+	if err != nil {
+		return rotationRefused(err)
+	}
+	err = lockLost(seriesLock)
 	if err != nil {
-		log.Error.Printf("Failed to upload file. Aborting backup. Reason: %v\n", err)
-		os.Exit(1)
+		return err
+	}
+
+	return rotationError(rotation)
+}
+
+// getReplicaArgs returns the arguments for the replica bucket. The region, credential file, profile and expected bucket owner default to those of the primary bucket
+func getReplicaArgs(arguments args) args {
+	replicaArgs := arguments
+	replicaArgs.Bucket = arguments.ReplicaBucket
+	if arguments.ReplicaRegion != "" {
+		replicaArgs.Region = arguments.ReplicaRegion
+	}
+	if arguments.ReplicaCredFile != "" {
+		replicaArgs.CredFile = arguments.ReplicaCredFile
+	}
+	if arguments.ReplicaProfile != "" {
+		replicaArgs.Profile = arguments.ReplicaProfile
+	}
+	if arguments.ReplicaBucketOwner != "" {
+		replicaArgs.ExpectedBucketOwner = arguments.ReplicaBucketOwner
+	}
+	return replicaArgs
+}
+
+// getReplicaRotationPolicy returns the rotation policy of the replica bucket. Retention settings which are not specified are those of the primary bucket
+func getReplicaRotationPolicy(arguments args, policy rpolicy.RotationPolicy) rpolicy.RotationPolicy {
+	if arguments.ReplicaDailyCount > 0 {
+		policy.DailyRetentionCount = arguments.ReplicaDailyCount
 	}
+	if arguments.ReplicaDailyPeriod > 0 {
+		policy.DailyRetentionPeriod = time.Hour * time.Duration(arguments.ReplicaDailyPeriod)
+	}
+	if arguments.ReplicaWeeklyCount > 0 {
+		policy.WeeklyRetentionCount = arguments.ReplicaWeeklyCount
+	}
+	if arguments.ReplicaWeeklyPeriod > 0 {
+		policy.WeeklyRetentionPeriod = time.Hour * time.Duration(arguments.ReplicaWeeklyPeriod)
+	}
+	policy.PromoteMissed = false // Promotions are replicated from the primary bucket
+	return policy
+}
 
-	rotate.StartRotation(svc, arguments.Bucket, rotationPolicy, arguments.DryRun)
-	log.Info.Println("Upload and Rotation Complete!")
+// safeguardExitCodes maps each safeguard of the rotation policy to the exit code caused when it is violated
+var safeguardExitCodes = map[string]int{
+	rotate.SafeguardMinKeep:     report.ExitMinKeep,
+	rotate.SafeguardMaxDeletes:  report.ExitMaxDeletes,
+	rotate.SafeguardStaleBackup: report.ExitStaleBackup,
+}
 
+// rotationRefused returns the error of a rotation which was refused before any key was deleted
+func rotationRefused(err error) error {
+	code := report.ExitRotationFailed
+	if safeguardErr, ok := err.(*rotate.SafeguardError); ok {
+		code = safeguardExitCodes[safeguardErr.Safeguard]
+	}
+	return report.WithExitCode(code, fmt.Errorf("rotation refused. Reason: %v", err))
 }
 
-func runUploadAction(svc *s3.S3, arguments args) {
-	log.Info.Println("Upload action specified, uploading file")
+// rotationError returns an error if any keys could not be listed or deleted during the rotation
+func rotationError(rotation rotate.Summary) error {
+	if len(rotation.Errors) == 0 {
+		return nil
+	}
+	return report.WithExitCode(report.ExitRotationFailed, fmt.Errorf("rotation partially failed with %d error(s): %s",
+		len(rotation.Errors), strings.Join(rotation.Errors, "; ")))
+}
+
+func runApplyLifecycleAction(svc *s3.S3, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
 
-	_, err := upload.UploadFile(svc, getUploadObject(arguments, false), "", arguments.DryRun)
+	logger.Info.Println("Apply lifecycle action specified, applying lifecycle configuration to bucket")
+
+	defer summary.Time("lifecycle")()
+	_, err := lifecycle.ApplyLifecycle(svc, arguments.Bucket, arguments.BucketDir, getRotationPolicy(arguments), arguments.DryRun, arguments.Logger)
 	if err != nil {
-		log.Error.Printf("Failed to upload file. Reason: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to apply lifecycle configuration. Reason: %v", err)
 	}
+
+	return nil
 }
 
-func runRotateAction(svc *s3.S3, arguments args) {
-	log.Info.Println("Rotate action specified, proceeding with rotation only")
-	rotate.StartRotation(svc, arguments.Bucket, getRotationPolicy(arguments), arguments.DryRun)
+func runPruneMultipartAction(svc *s3.S3, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Println("Prune multipart action specified, aborting incomplete multipart uploads")
+
+	minAge := time.Hour * time.Duration(arguments.MultipartMinAge)
+	pruneDone := summary.Time("prune")
+	pruned, err := util.PruneMultiPartUploads(svc, arguments.Bucket, arguments.BucketDir, minAge, arguments.DryRun, arguments.Logger)
+	pruneDone()
+
+	var reclaimed int64
+	summary.AbortedUploads = []string{}
+	for _, multiPartUpload := range pruned {
+		reclaimed += multiPartUpload.Size
+		summary.AbortedUploads = append(summary.AbortedUploads, multiPartUpload.Key)
+	}
+	if arguments.DryRun {
+		// Nothing was aborted so no bytes are recorded as reclaimed
+		logger.Info.Printf("The total number of multipart uploads that would be aborted was: %d (%d bytes would be reclaimed)\n", len(pruned), reclaimed)
+	} else {
+		summary.Bytes = reclaimed
+		logger.Info.Printf("The total number of multipart uploads aborted was: %d (%d bytes reclaimed)\n", len(pruned), reclaimed)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to prune multipart uploads. Reason: %v", err)
+	}
+
+	return nil
 }
 
-func runDownloadAction(svc *s3.S3, arguments args) {
-	log.Info.Println("Download action specified, downloading file")
+func runDownloadAction(store storage.Store, arguments args, summary *report.Summary) error {
+	logger := arguments.Logger
+
+	logger.Info.Println("Download action specified, downloading file")
 
 	downloadObject := download.DownloadObject{
 		DownloadLocation: arguments.PathToFile,
@@ -142,13 +910,262 @@ func runDownloadAction(svc *s3.S3, arguments args) {
 		Bucket:           arguments.Bucket,
 		NumWorkers:       arguments.ConcurrentWorkers,
 		PartSize:         arguments.PartSize,
+		Logger:           logger,
+	}
+	summary.Key = downloadObject.S3FileKey
+	summary.Path = downloadObject.DownloadLocation
+
+	downloadDone := summary.Time("download")
+	var err error
+	if fileStore, ok := store.(*storage.FileStore); ok {
+		err = download.DownloadFileFromStore(fileStore, downloadObject)
+	} else {
+		var restored bool
+		restored, err = restoreChunked(getS3Client(store), arguments, downloadObject.S3FileKey, downloadObject.DownloadLocation)
+		if !restored {
+			err = download.DownloadFile(getS3Client(store), downloadObject)
+		}
+	}
+	downloadDone()
+	if err != nil {
+		return fmt.Errorf("failed to download file. Aborting. Reason: %v", err)
+	}
+
+	fileInfo, err := os.Stat(downloadObject.DownloadLocation)
+	if err == nil {
+		summary.Bytes = fileInfo.Size()
+	}
+
+	return nil
+}
+
+// acquireLock takes the lock on the series so that no other host can back up or rotate it at the same time
+// If svc is nil, i.e. the bucket is a local directory, then a nil lock is returned as the directory may be on a share
+// which does not support reliable locking
+func acquireLock(svc *s3.S3, arguments args) (*lock.Lock, error) {
+	if svc == nil {
+		return nil, nil
+	}
+
+	if arguments.ForceUnlock {
+		err := lock.ForceUnlock(svc, arguments.Bucket, arguments.BucketDir, arguments.Logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to force unlock. Reason: %v", err)
+		}
+	}
+
+	seriesLock, err := lock.Acquire(svc, arguments.Bucket, arguments.BucketDir, arguments.JobName, time.Second*time.Duration(arguments.LockTTL), arguments.Logger)
+	if _, ok := err.(*lock.HeldError); ok {
+		return nil, report.WithExitCode(report.ExitLockHeld, fmt.Errorf("failed to acquire lock. Reason: %v", err))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock. Reason: %v", err)
+	}
+
+	return seriesLock, nil
+}
+
+// lockLost returns an error if the lease on the series was lost while it was being rotated
+// Keys are not deleted once the lease is lost, so the rotation may be incomplete
+func lockLost(seriesLock *lock.Lock) error {
+	err := seriesLock.Err()
+	if err != nil {
+		return report.WithExitCode(report.ExitLockHeld, fmt.Errorf("rotation stopped as the lock was lost. Reason: %v", err))
+	}
+	return nil
+}
+
+func releaseLock(seriesLock *lock.Lock, logger *log.Logger) {
+	err := seriesLock.Release()
+	if err != nil {
+		logger.Error.Printf("Failed to release lock. Reason: %v\n", err)
+	}
+}
+
+func getHookEnv(arguments args) hooks.Env {
+	return hooks.Env{
+		Job:        arguments.JobName,
+		Action:     arguments.Action,
+		Bucket:     arguments.Bucket,
+		BucketDir:  arguments.BucketDir,
+		PathToFile: arguments.PathToFile,
+		DryRun:     arguments.DryRun,
+	}
+}
+
+// runPreBackupHook runs the pre-backup hook. The hook is skipped for a dry run
+// as it usually prepares the file to be backed up, i.e. by dumping a database
+func runPreBackupHook(arguments args, env hooks.Env) error {
+	if arguments.DryRun && arguments.PreBackupHook != "" {
+		arguments.Logger.Info.Printf("Dry run enabled, skipping pre-backup hook: '%s'\n", arguments.PreBackupHook)
+		return nil
+	}
+
+	return hooks.Run(hooks.Hook{Name: "pre-backup", Command: arguments.PreBackupHook,
+		Timeout: time.Second * time.Duration(arguments.PreBackupHookTimeout)}, env, arguments.Logger)
+}
+
+// runPostHook runs a hook after the work of an action has been done
+// A failed post hook is logged but does not change the outcome of the action
+func runPostHook(hook hooks.Hook, env hooks.Env, logger *log.Logger) {
+	err := hooks.Run(hook, env, logger)
+	if err != nil {
+		logger.Error.Println(err)
+	}
+}
+
+// writeMetrics writes the metrics of the run to the textfile and pushes them to the Pushgateway if either is configured
+// Metrics are not written for a dry run. A failure is logged but does not change the outcome of the job
+func writeMetrics(arguments args, summary *report.Summary) {
+	logger := arguments.Logger
+
+	if !metricsEnabled(arguments) {
+		return
+	}
+
+	if arguments.DryRun {
+		logger.Info.Println("Skipping writing metrics as dry run has been enabled")
+		return
+	}
+
+	job := arguments.JobName
+	if job == "" {
+		job = arguments.S3FileName
+	}
+	if job == "" {
+		job = arguments.Action
+	}
+
+	samples := metrics.Collect(job, summary)
+
+	if arguments.MetricsFile != "" {
+		err := metrics.WriteTextfile(arguments.MetricsFile, samples)
+		if err != nil {
+			logger.Error.Printf("Failed to write metrics to '%s'. Reason: %v\n", arguments.MetricsFile, err)
+		} else {
+			logger.Info.Printf("Wrote metrics to: '%s'\n", arguments.MetricsFile)
+		}
+	}
+
+	if arguments.PushgatewayURL != "" {
+		err := metrics.Push(arguments.PushgatewayURL, job, samples)
+		if err != nil {
+			logger.Error.Println(err)
+		} else {
+			logger.Info.Println("Pushed metrics to pushgateway")
+		}
+	}
+}
+
+func metricsEnabled(arguments args) bool {
+	return arguments.MetricsFile != "" || arguments.PushgatewayURL != ""
+}
+
+// countObjects records the number of objects stored for each tier after the rotation
+// This is only required for metrics so the objects are not listed unless metrics are enabled
+func countObjects(store storage.Store, arguments args, policy rpolicy.RotationPolicy, summary *report.Summary) {
+	logger := arguments.Logger
+
+	if !metricsEnabled(arguments) {
+		return
+	}
+
+	counts, err := storage.CountKeysByTier(store, arguments.BucketDir, policy)
+	if err != nil {
+		logger.Warn.Printf("Failed to count objects for each tier: %v\n", err)
+		return
+	}
+	summary.ObjectCounts = counts
+}
+
+// writeReport writes the report of the runs if --report has been specified
+// A failure to write the report is logged but does not change the outcome of the runs
+func writeReport(arguments args, r report.Report) {
+	if arguments.Report == "" {
+		return
 	}
-	err := download.DownloadFile(svc, downloadObject)
+
+	err := report.Write(arguments.Report, r)
 	if err != nil {
-		log.Error.Printf("Failed to download file. Aborting. Reason: %v\n", err)
-		os.Exit(1)
+		log.Error.Printf("Failed to write report to '%s'. Reason: %v\n", arguments.Report, err)
+		return
+	}
+
+	if arguments.Report != "-" {
+		log.Info.Printf("Wrote report to: '%s'\n", arguments.Report)
+	}
+}
+
+// sendNotifications sends the summary of the run to every webhook of the job and emails a report if configured
+// A failed notification is logged but does not change the outcome of the job
+func sendNotifications(arguments args, summary *report.Summary) {
+	logger := arguments.Logger
+
+	for _, webhook := range getWebhooks(arguments) {
+		err := notify.Send(webhook, summary, arguments.Logger)
+		if err != nil {
+			logger.Error.Println(err)
+		}
 	}
 
+	sendEmail(arguments, summary)
+}
+
+// sendEmail emails a report of the run if a mail relay has been specified
+// A failed email is logged but does not change the outcome of the job
+func sendEmail(arguments args, summary *report.Summary) {
+	logger := arguments.Logger
+
+	if arguments.SMTPHost == "" {
+		return
+	}
+
+	err := notify.SendEmail(getEmail(arguments), summary, arguments.Logger)
+	if err != nil {
+		logger.Error.Printf("Failed to send email report. Reason: %v\n", err)
+	}
+}
+
+// getEmail returns the mail relay of the job
+func getEmail(arguments args) notify.Email {
+	return notify.Email{
+		Host:     arguments.SMTPHost,
+		Port:     arguments.SMTPPort,
+		Username: arguments.SMTPUsername,
+		Password: arguments.SMTPPassword,
+		StartTLS: arguments.SMTPStartTLS,
+		Timeout:  time.Second * time.Duration(arguments.SMTPTimeout),
+		From:     arguments.MailFrom,
+		To:       arguments.MailTo,
+		When:     arguments.MailWhen,
+	}
+}
+
+// getWebhooks returns the webhooks of the job. Retries and timeout default to the command line arguments
+func getWebhooks(arguments args) []notify.Webhook {
+	webhooks := []notify.Webhook{}
+	for _, webhook := range arguments.Webhooks {
+		retries := arguments.WebhookRetries
+		if webhook.Retries != nil {
+			retries = *webhook.Retries
+		}
+
+		timeout := arguments.WebhookTimeout
+		if webhook.Timeout > 0 {
+			timeout = webhook.Timeout
+		}
+
+		webhooks = append(webhooks, notify.Webhook{
+			URL:      webhook.URL,
+			Headers:  webhook.Headers,
+			Secret:   webhook.Secret,
+			Template: webhook.Template,
+			Retries:  retries,
+			Timeout:  time.Second * time.Duration(timeout),
+			Events:   webhook.Events,
+		})
+	}
+	return webhooks
 }
 
 func getUploadObject(arguments args, manipulate bool) upload.UploadObject {
@@ -161,12 +1178,45 @@ func getUploadObject(arguments args, manipulate bool) upload.UploadObject {
 		NumWorkers: arguments.ConcurrentWorkers,
 		PartSize:   arguments.PartSize,
 		Manipulate: manipulate,
+		Logger:     arguments.Logger,
+		Dedup:      arguments.Dedup,
+	}
+}
+
+// getClientOptions returns the options used to create the S3 client of the job
+func getClientOptions(arguments args) s3client.ClientOptions {
+	options := s3client.ClientOptions{
+		CredFile: arguments.CredFile,
+		Profile:  arguments.Profile,
+		Region:   arguments.Region,
+		Endpoint: s3client.Endpoint{
+			URL:            arguments.Endpoint,
+			ForcePathStyle: arguments.ForcePathStyle,
+			DisableSSL:     arguments.DisableSSL,
+			CABundle:       arguments.CABundle,
+		},
+		ExpectedBucketOwner: arguments.ExpectedBucketOwner,
+		ACL:                 arguments.ACL,
+		Logger:              arguments.Logger,
+	}
+
+	if arguments.RoleARN != "" {
+		options.AssumeRole = s3client.AssumeRole{
+			RoleARN:     arguments.RoleARN,
+			ExternalID:  arguments.ExternalID,
+			Duration:    time.Second * time.Duration(arguments.RoleDuration),
+			SessionName: s3client.RoleSessionName(arguments.JobName),
+		}
 	}
+
+	return options
 }
 
 func getRotationPolicy(arguments args) rpolicy.RotationPolicy {
+	logger := arguments.Logger
+
 	if !arguments.EnforceRetentionPeriod {
-		log.Warn.Println("GoS3GFSBackup is running with enforce retention period disabled. " +
+		logger.Warn.Println("GoS3GFSBackup is running with enforce retention period disabled. " +
 			"This may result in objects being deleted that which have not exceeded the retention period")
 	}
 
@@ -182,10 +1232,32 @@ func getRotationPolicy(arguments args) rpolicy.RotationPolicy {
 
 		MonthlyPrefix:          "monthly_",
 		EnforceRetentionPeriod: arguments.EnforceRetentionPeriod,
+
+		MinKeep:      arguments.MinKeep,
+		MaxDeletes:   arguments.MaxDeletes,
+		MaxBackupAge: time.Hour * time.Duration(arguments.MaxBackupAge),
+
+		PromoteMissed: arguments.Promote,
+		BucketDir:     arguments.BucketDir,
+		S3FileName:    arguments.S3FileName,
+
+		MonthlyTransitionDays:         arguments.MonthlyTransitionDays,
+		MonthlyTransitionStorageClass: arguments.MonthlyStorageClass,
+		MonthlyExpirationDays:         arguments.MonthlyExpirationDays,
+		AbortIncompleteMultipartDays:  arguments.AbortMultipartDays,
 	}
 
 }
 
+// getHeaderNames returns the name of each 'Name: value' header with its value redacted, as values are often tokens
+func getHeaderNames(headers []string) []string {
+	names := []string{}
+	for _, header := range headers {
+		names = append(names, strings.TrimSpace(strings.SplitN(header, ":", 2)[0])+": <redacted>")
+	}
+	return names
+}
+
 func logArgs(arguments args) {
 	log.Info.Println("Loaded GoS3GFSBackup with arguments: ")
 
@@ -193,6 +1265,14 @@ func logArgs(arguments args) {
 	log.Info.Println("--region=" + arguments.Region)
 	log.Info.Println("--bucket=" + arguments.Bucket)
 	log.Info.Println("--bucketdir=" + arguments.BucketDir)
+	log.Info.Println("--endpoint=" + arguments.Endpoint)
+	log.Info.Println("--forcepathstyle=" + strconv.FormatBool(arguments.ForcePathStyle))
+	log.Info.Println("--disablessl=" + strconv.FormatBool(arguments.DisableSSL))
+	log.Info.Println("--cabundle=" + arguments.CABundle)
+	log.Info.Println("--rolearn=" + arguments.RoleARN)
+	log.Info.Println("--roleduration=" + strconv.Itoa(arguments.RoleDuration))
+	log.Info.Println("--expectedbucketowner=" + arguments.ExpectedBucketOwner)
+	log.Info.Println("--acl=" + arguments.ACL)
 	log.Info.Println("--profile=" + arguments.Profile)
 	log.Info.Println("--action=" + arguments.Action)
 	log.Info.Println("--pathtofile=" + arguments.PathToFile)
@@ -202,9 +1282,81 @@ func logArgs(arguments args) {
 	log.Info.Println("--enforceretentionperiod=" + strconv.FormatBool(arguments.EnforceRetentionPeriod))
 	log.Info.Println("--concurrentworkers=" + strconv.Itoa(arguments.ConcurrentWorkers))
 	log.Info.Println("--partsize=" + strconv.Itoa(arguments.PartSize))
+	log.Info.Println("--dedup=" + strconv.FormatBool(arguments.Dedup))
+	log.Info.Println("--chunked=" + strconv.FormatBool(arguments.Chunked))
+	log.Info.Println("--chunkgraceperiod=" + strconv.Itoa(arguments.ChunkGracePeriod))
 	log.Info.Println("--dailyretentioncount=" + strconv.Itoa(arguments.DailyRetentionCount))
 	log.Info.Println("--dailyretentionperiod=" + strconv.Itoa(arguments.DailyRetentionPeriod))
 	log.Info.Println("--weeklyretentioncount=" + strconv.Itoa(arguments.WeeklyRetentionCount))
 	log.Info.Println("--weeklyretentionperiod=" + strconv.Itoa(arguments.WeeklyRetentionPeriod))
+	log.Info.Println("--monthlytransitiondays=" + strconv.Itoa(arguments.MonthlyTransitionDays))
+	log.Info.Println("--monthlystorageclass=" + arguments.MonthlyStorageClass)
+	log.Info.Println("--monthlyexpirationdays=" + strconv.Itoa(arguments.MonthlyExpirationDays))
+	log.Info.Println("--abortmultipartdays=" + strconv.Itoa(arguments.AbortMultipartDays))
+	log.Info.Println("--minkeep=" + strconv.Itoa(arguments.MinKeep))
+	log.Info.Println("--maxdeletes=" + strconv.Itoa(arguments.MaxDeletes))
+	log.Info.Println("--maxbackupage=" + strconv.Itoa(arguments.MaxBackupAge))
+	log.Info.Println("--promote=" + strconv.FormatBool(arguments.Promote))
+	log.Info.Println("--multipartminage=" + strconv.Itoa(arguments.MultipartMinAge))
+	log.Info.Println("--plan=" + arguments.Plan)
+	log.Info.Println("--replicabucket=" + arguments.ReplicaBucket)
+	log.Info.Println("--replicaregion=" + arguments.ReplicaRegion)
+	log.Info.Println("--replicacredfile=" + arguments.ReplicaCredFile)
+	log.Info.Println("--replicaprofile=" + arguments.ReplicaProfile)
+	log.Info.Println("--replicabucketowner=" + arguments.ReplicaBucketOwner)
+	log.Info.Println("--replicadailycount=" + strconv.Itoa(arguments.ReplicaDailyCount))
+	log.Info.Println("--replicadailyperiod=" + strconv.Itoa(arguments.ReplicaDailyPeriod))
+	log.Info.Println("--replicaweeklycount=" + strconv.Itoa(arguments.ReplicaWeeklyCount))
+	log.Info.Println("--replicaweeklyperiod=" + strconv.Itoa(arguments.ReplicaWeeklyPeriod))
+	log.Info.Println("--warnage=" + strconv.Itoa(arguments.WarnAge))
+	log.Info.Println("--critage=" + strconv.Itoa(arguments.CritAge))
+	log.Info.Println("--warnsize=" + strconv.FormatInt(arguments.WarnSize, 10))
+	log.Info.Println("--critsize=" + strconv.FormatInt(arguments.CritSize, 10))
+	log.Info.Println("--checktiers=" + strconv.FormatBool(arguments.CheckTiers))
+	log.Info.Println("--config=" + arguments.Config)
+	log.Info.Println("--job=" + arguments.Job)
+	log.Info.Println("--all=" + strconv.FormatBool(arguments.All))
+	log.Info.Println("--prebackuphook=" + arguments.PreBackupHook)
+	log.Info.Println("--prebackuphooktimeout=" + strconv.Itoa(arguments.PreBackupHookTimeout))
+	log.Info.Println("--postsuccesshook=" + arguments.PostSuccessHook)
+	log.Info.Println("--postsuccesshooktimeout=" + strconv.Itoa(arguments.PostSuccessHookTimeout))
+	log.Info.Println("--postfailurehook=" + arguments.PostFailureHook)
+	log.Info.Println("--postfailurehooktimeout=" + strconv.Itoa(arguments.PostFailureHookTimeout))
+	log.Info.Println("--postrotationhook=" + arguments.PostRotationHook)
+	log.Info.Println("--postrotationhooktimeout=" + strconv.Itoa(arguments.PostRotationHookTimeout))
+	log.Info.Println("--webhookurl=" + notify.RedactURL(arguments.WebhookURL))
+	log.Info.Println("--webhookheaders=" + strings.Join(getHeaderNames(arguments.WebhookHeaders), ","))
+	if arguments.WebhookSecret != "" {
+		log.Info.Println("--webhooksecret=<redacted>")
+	} else {
+		log.Info.Println("--webhooksecret=")
+	}
+	log.Info.Println("--webhooktemplate=" + arguments.WebhookTemplate)
+	log.Info.Println("--webhookretries=" + strconv.Itoa(arguments.WebhookRetries))
+	log.Info.Println("--webhooktimeout=" + strconv.Itoa(arguments.WebhookTimeout))
+	log.Info.Println("--webhookevents=" + strings.Join(arguments.WebhookEvents, ","))
+	log.Info.Println("--smtphost=" + arguments.SMTPHost)
+	log.Info.Println("--smtpport=" + strconv.Itoa(arguments.SMTPPort))
+	log.Info.Println("--smtpusername=" + arguments.SMTPUsername)
+	if arguments.SMTPPassword != "" {
+		log.Info.Println("--smtppassword=<redacted>")
+	} else {
+		log.Info.Println("--smtppassword=")
+	}
+	log.Info.Println("--smtpstarttls=" + strconv.FormatBool(arguments.SMTPStartTLS))
+	log.Info.Println("--smtptimeout=" + strconv.Itoa(arguments.SMTPTimeout))
+	log.Info.Println("--mailfrom=" + arguments.MailFrom)
+	log.Info.Println("--mailto=" + strings.Join(arguments.MailTo, ","))
+	log.Info.Println("--mailwhen=" + arguments.MailWhen)
+	log.Info.Println("--loglevel=" + arguments.LogLevel)
+	log.Info.Println("--logformat=" + arguments.LogFormat)
+	log.Info.Println("--banners=" + strconv.FormatBool(arguments.Banners))
+	log.Info.Println("--report=" + arguments.Report)
+	log.Info.Println("--metricsfile=" + arguments.MetricsFile)
+	log.Info.Println("--pushgatewayurl=" + arguments.PushgatewayURL)
+	log.Info.Println("--lockttl=" + strconv.Itoa(arguments.LockTTL))
+	log.Info.Println("--forceunlock=" + strconv.FormatBool(arguments.ForceUnlock))
+	log.Info.Println("--daemon=" + strconv.FormatBool(arguments.Daemon))
+	log.Info.Println("--statefile=" + arguments.StateFile)
 
 }
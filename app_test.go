@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/check"
+	"github.com/daniel-cole/GoS3GFSBackup/hooks"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPreBackupHookSkippedForDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "hook-ran")
+	arguments := getDefaultArgs()
+	arguments.Logger = log.OrDefault(nil)
+	arguments.PreBackupHook = "touch " + marker
+	arguments.DryRun = true
+
+	err = runPreBackupHook(arguments, hooks.Env{})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the skipped hook not to fail, instead got: %v", err))
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("expected the pre-backup hook not to be run for a dry run")
+	}
+
+	arguments.DryRun = false
+	err = runPreBackupHook(arguments, hooks.Env{})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the hook to succeed, instead got: %v", err))
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error(fmt.Sprintf("expected the pre-backup hook to be run, instead got: %v", err))
+	}
+}
+
+func TestLogsToStderr(t *testing.T) {
+	arguments := getDefaultArgs()
+	backup := getDefaultArgs()
+	backup.Action = "backup"
+	checkJob := getDefaultArgs()
+	checkJob.Action = "check"
+
+	if logsToStderr(arguments, []args{backup}) {
+		t.Error("expected the log to be written to stdout when no job needs stdout")
+	}
+
+	if !logsToStderr(arguments, []args{backup, checkJob}) {
+		t.Error("expected the log to be written to stderr when a job of the config file is a check")
+	}
+
+	arguments.Report = "-"
+	if !logsToStderr(arguments, nil) {
+		t.Error("expected the log to be written to stderr when the report is written to stdout")
+	}
+}
+
+func TestCheckUnknown(t *testing.T) {
+	if checkUnknown(nil) != nil {
+		t.Error("expected no error for a successful check")
+	}
+
+	err := checkUnknown(report.WithExitCode(check.Critical, &checkStatusError{"no backups found"}))
+	if report.ExitCode(err) != check.Critical {
+		t.Error(fmt.Sprintf("expected the status of the check to be kept, instead got exit code %d", report.ExitCode(err)))
+	}
+
+	err = checkUnknown(report.WithExitCode(report.ExitValidation, errors.New("failed to create client")))
+	if report.ExitCode(err) != check.Unknown {
+		t.Error(fmt.Sprintf("expected a failure of the tool to be UNKNOWN, instead got exit code %d", report.ExitCode(err)))
+	}
+}
+
+func TestGetKeyTypeUsesSeries(t *testing.T) {
+	server := s3test.NewServer("keytype-bucket")
+	defer server.Close()
+
+	arguments := getDefaultArgs()
+	arguments.Logger = log.OrDefault(nil)
+	arguments.BucketDir = "databases/"
+	arguments.S3FileName = "postgres"
+	arguments.Promote = true
+	policy := getRotationPolicy(arguments)
+	store := storage.NewS3Store(server.Client(), "keytype-bucket")
+
+	// Keys of another file in the bucket dir and of the same file outside of it must not fill the monthly tier
+	server.PutObject("keytype-bucket", "databases/monthly_mysql_20171101T020000", []byte("backup"))
+	server.PutObject("keytype-bucket", "monthly_postgres_20171101T020000", []byte("backup"))
+
+	prefix := getKeyType(store, arguments, policy)
+	if prefix != policy.MonthlyPrefix {
+		t.Error(fmt.Sprintf("expected the missed monthly backup of the series to be taken, instead got: %s", prefix))
+	}
+
+	server.PutObject("keytype-bucket", "databases/monthly_postgres_20171101T020000", []byte("backup"))
+	server.PutObject("keytype-bucket", "databases/weekly_postgres_20171106T020000", []byte("backup"))
+
+	prefix = getKeyType(store, arguments, policy)
+	if expected := util.GetKeyType(policy, time.Now()); prefix != expected {
+		t.Error(fmt.Sprintf("expected the backup not to be reclassified once the series has been backed up, expected %s but got: %s", expected, prefix))
+	}
+}
+
+func TestGetHeaderNames(t *testing.T) {
+	names := getHeaderNames([]string{"Authorization: Bearer token", "X-Team:backups", "Invalid"})
+	expected := []string{"Authorization: <redacted>", "X-Team: <redacted>", "Invalid: <redacted>"}
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected only the header names to be logged %v, instead got: %v", expected, names))
+	}
+}
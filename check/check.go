@@ -0,0 +1,205 @@
+package check
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"strings"
+	"time"
+)
+
+// Statuses of a check. These are also the exit codes of the check following the monitoring plugin convention
+const (
+	OK       = 0
+	Warning  = 1
+	Critical = 2
+	Unknown  = 3
+)
+
+var statusNames = map[int]string{
+	OK:       "OK",
+	Warning:  "WARNING",
+	Critical: "CRITICAL",
+	Unknown:  "UNKNOWN",
+}
+
+// tierAllowance is added to the age thresholds when each tier is checked on its own
+// A weekly or monthly backup is only expected once a week or month so its newest key is older than the newest backup of the series
+var tierAllowance = map[string]time.Duration{
+	"daily":   0,
+	"weekly":  time.Hour * 24 * 7,
+	"monthly": time.Hour * 24 * 31,
+}
+
+// Thresholds are the limits the newest backup is compared with. A zero threshold is disabled
+type Thresholds struct {
+	WarnAge  time.Duration // Warning if the newest backup is older than this
+	CritAge  time.Duration // Critical if the newest backup is older than this
+	WarnSize int64         // Warning if the newest backup is smaller than this (bytes)
+	CritSize int64         // Critical if the newest backup is smaller than this (bytes)
+	Tiers    bool          // If enabled then the newest backup of each tier is also checked
+}
+
+// Backup is a single backup object in the series
+type Backup struct {
+	Key      string
+	Tier     string
+	Size     int64
+	Modified time.Time
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Status   int
+	Message  string
+	Perfdata []string
+	Newest   Backup // The newest backup of the series. The key is empty if no backup was found
+}
+
+// String returns the result as a single line in the monitoring plugin format, i.e. 'GOS3GFSBACKUP OK - message | perfdata'
+func (r Result) String() string {
+	line := fmt.Sprintf("GOS3GFSBACKUP %s - %s", statusNames[r.Status], r.Message)
+	if len(r.Perfdata) > 0 {
+		line += " | " + strings.Join(r.Perfdata, " ")
+	}
+	return line
+}
+
+// Run checks the newest backup of the series with the thresholds. The series is every key in the bucket dir
+// with the prefix of a tier followed by the s3 file name. If the s3 file name is empty then every key of each tier is included
+func Run(svc *s3.S3, bucket string, bucketDir string, s3FileName string, policy rpolicy.RotationPolicy, thresholds Thresholds) Result {
+	if s3FileName != "" {
+		s3FileName += "_" // Keys uploaded by the backup action are suffixed with '_' and a timestamp
+	}
+
+	backups := []Backup{}
+	for _, prefix := range []string{policy.DailyPrefix, policy.WeeklyPrefix, policy.MonthlyPrefix} {
+		objects, err := s3client.GetObjectsByPrefix(svc, bucket, bucketDir+prefix+s3FileName)
+		if err != nil {
+			return Result{Status: Unknown, Message: fmt.Sprintf("failed to list backups with prefix '%s': %v", bucketDir+prefix+s3FileName, err)}
+		}
+
+		for _, object := range objects {
+			backups = append(backups, Backup{
+				Key:      aws.StringValue(object.Key),
+				Tier:     util.GetTierName(policy, prefix),
+				Size:     aws.Int64Value(object.Size),
+				Modified: aws.TimeValue(object.LastModified),
+			})
+		}
+	}
+
+	return Evaluate(backups, time.Now(), thresholds)
+}
+
+// Evaluate compares the newest of the backups with the thresholds at the specified time
+func Evaluate(backups []Backup, now time.Time, thresholds Thresholds) Result {
+	newest, found := newestBackup(backups, "")
+	if !found {
+		return Result{Status: Critical, Message: "no backups found", Perfdata: []string{"backups=0;;;0"}}
+	}
+
+	result := Result{Newest: newest}
+	result.Status, result.Message = evaluateBackup(newest, now, thresholds, 0)
+	result.Message = fmt.Sprintf("newest backup '%s' is %s", newest.Key, result.Message)
+	result.Perfdata = append(result.Perfdata, perfdata("", newest, now, thresholds, 0)...)
+	result.Perfdata = append(result.Perfdata, fmt.Sprintf("backups=%d;;;0", len(backups)))
+
+	if !thresholds.Tiers {
+		return result
+	}
+
+	problems := []string{}
+	for _, tier := range []string{"daily", "weekly", "monthly"} {
+		tierBackup, found := newestBackup(backups, tier)
+		if !found {
+			problems = append(problems, fmt.Sprintf("no %s backups found", tier))
+			result.Status = worst(result.Status, Critical)
+			continue
+		}
+
+		status, message := evaluateBackup(tierBackup, now, thresholds, tierAllowance[tier])
+		if status != OK {
+			problems = append(problems, fmt.Sprintf("newest %s backup '%s' is %s", tier, tierBackup.Key, message))
+			result.Status = worst(result.Status, status)
+		}
+		result.Perfdata = append(result.Perfdata, perfdata(tier+"_", tierBackup, now, thresholds, tierAllowance[tier])...)
+	}
+
+	if len(problems) > 0 {
+		result.Message += ", " + strings.Join(problems, ", ")
+	}
+
+	return result
+}
+
+// evaluateBackup returns the status of a single backup along with a description of its age and size
+func evaluateBackup(backup Backup, now time.Time, thresholds Thresholds, allowance time.Duration) (int, string) {
+	age := now.Sub(backup.Modified)
+	status := OK
+
+	if thresholds.CritAge > 0 && age > thresholds.CritAge+allowance {
+		status = worst(status, Critical)
+	} else if thresholds.WarnAge > 0 && age > thresholds.WarnAge+allowance {
+		status = worst(status, Warning)
+	}
+
+	if thresholds.CritSize > 0 && backup.Size < thresholds.CritSize {
+		status = worst(status, Critical)
+	} else if thresholds.WarnSize > 0 && backup.Size < thresholds.WarnSize {
+		status = worst(status, Warning)
+	}
+
+	return status, fmt.Sprintf("%0.1f hours old and %d bytes", age.Hours(), backup.Size)
+}
+
+// perfdata returns the age and size of the backup as monitoring plugin performance data
+// The size thresholds use the 'min:' range format as a backup is only a problem if it is too small
+func perfdata(label string, backup Backup, now time.Time, thresholds Thresholds, allowance time.Duration) []string {
+	warnAge, critAge, warnSize, critSize := "", "", "", ""
+	if thresholds.WarnAge > 0 {
+		warnAge = fmt.Sprintf("%d", int64((thresholds.WarnAge + allowance).Seconds()))
+	}
+	if thresholds.CritAge > 0 {
+		critAge = fmt.Sprintf("%d", int64((thresholds.CritAge + allowance).Seconds()))
+	}
+	if thresholds.WarnSize > 0 {
+		warnSize = fmt.Sprintf("%d:", thresholds.WarnSize)
+	}
+	if thresholds.CritSize > 0 {
+		critSize = fmt.Sprintf("%d:", thresholds.CritSize)
+	}
+
+	return []string{
+		fmt.Sprintf("%sage=%ds;%s;%s;0", label, int64(now.Sub(backup.Modified).Seconds()), warnAge, critAge),
+		fmt.Sprintf("%ssize=%dB;%s;%s;0", label, backup.Size, warnSize, critSize),
+	}
+}
+
+// newestBackup returns the newest backup of the tier. If tier is empty then the newest backup of any tier is returned
+func newestBackup(backups []Backup, tier string) (Backup, bool) {
+	var newest Backup
+	found := false
+	for _, backup := range backups {
+		if tier != "" && backup.Tier != tier {
+			continue
+		}
+		if !found || backup.Modified.After(newest.Modified) {
+			newest = backup
+			found = true
+		}
+	}
+	return newest, found
+}
+
+// worst returns the most severe of the statuses. Unknown is only more severe than OK
+func worst(a int, b int) int {
+	severity := map[int]int{OK: 0, Unknown: 1, Warning: 2, Critical: 3}
+	if severity[b] > severity[a] {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,73 @@
+package check
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2017, time.September, 20, 12, 0, 0, 0, time.UTC)
+
+func getTestBackups() []Backup {
+	return []Backup{
+		{Key: "databases/daily_postgres_20170919T020000", Tier: "daily", Size: 2048, Modified: now.Add(-time.Hour * 34)},
+		{Key: "databases/daily_postgres_20170920T020000", Tier: "daily", Size: 1024, Modified: now.Add(-time.Hour * 10)},
+		{Key: "databases/weekly_postgres_20170918T020000", Tier: "weekly", Size: 2048, Modified: now.Add(-time.Hour * 58)},
+		{Key: "databases/monthly_postgres_20170901T020000", Tier: "monthly", Size: 2048, Modified: now.Add(-time.Hour * 466)},
+	}
+}
+
+func TestEvaluateOK(t *testing.T) {
+	result := Evaluate(getTestBackups(), now, Thresholds{WarnAge: time.Hour * 26, CritAge: time.Hour * 50, CritSize: 512})
+
+	if result.Status != OK {
+		t.Error(fmt.Sprintf("expected OK but got: %s", result))
+	}
+
+	expected := "GOS3GFSBACKUP OK - newest backup 'databases/daily_postgres_20170920T020000' is 10.0 hours old and 1024 bytes | " +
+		"age=36000s;93600;180000;0 size=1024B;;512:;0 backups=4;;;0"
+	if result.String() != expected {
+		t.Error(fmt.Sprintf("expected '%s' but got '%s'", expected, result))
+	}
+}
+
+func TestEvaluateThresholds(t *testing.T) {
+	result := Evaluate(getTestBackups(), now, Thresholds{WarnAge: time.Hour * 8, CritAge: time.Hour * 50})
+	if result.Status != Warning {
+		t.Error(fmt.Sprintf("expected WARNING for age but got: %s", result))
+	}
+
+	result = Evaluate(getTestBackups(), now, Thresholds{WarnAge: time.Hour * 4, CritAge: time.Hour * 8})
+	if result.Status != Critical {
+		t.Error(fmt.Sprintf("expected CRITICAL for age but got: %s", result))
+	}
+
+	result = Evaluate(getTestBackups(), now, Thresholds{WarnSize: 2000})
+	if result.Status != Warning {
+		t.Error(fmt.Sprintf("expected WARNING for size but got: %s", result))
+	}
+
+	result = Evaluate([]Backup{}, now, Thresholds{WarnAge: time.Hour * 26})
+	if result.Status != Critical || !strings.Contains(result.Message, "no backups found") {
+		t.Error(fmt.Sprintf("expected CRITICAL when no backups are found but got: %s", result))
+	}
+}
+
+func TestEvaluateTiers(t *testing.T) {
+	thresholds := Thresholds{WarnAge: time.Hour * 26, CritAge: time.Hour * 50, Tiers: true}
+
+	result := Evaluate(getTestBackups(), now, thresholds)
+	if result.Status != OK {
+		t.Error(fmt.Sprintf("expected OK with weekly and monthly allowances but got: %s", result))
+	}
+
+	if !strings.Contains(result.String(), "weekly_age=208800s;698400;784800;0") {
+		t.Error(fmt.Sprintf("expected weekly perfdata with the weekly allowance but got: %s", result))
+	}
+
+	result = Evaluate(getTestBackups()[:3], now, thresholds)
+	if result.Status != Critical || !strings.Contains(result.Message, "no monthly backups found") {
+		t.Error(fmt.Sprintf("expected CRITICAL when a tier has no backups but got: %s", result))
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/chunkstore"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"strings"
+	"time"
+)
+
+// getRepository returns the chunk store of the bucket dir of the job
+func getRepository(arguments args) chunkstore.Repository {
+	return chunkstore.Repository{
+		Bucket:     arguments.Bucket,
+		BucketDir:  arguments.BucketDir,
+		NumWorkers: arguments.ConcurrentWorkers,
+		Logger:     arguments.Logger,
+	}
+}
+
+// backupChunked uploads the chunks of the file which are not already stored and a manifest in place of the file
+// Returns the key of the manifest, which is named in the same way as the key of a backup that is not chunked
+func backupChunked(svc *s3.S3, arguments args, prefix string, summary *report.Summary) (string, error) {
+	repository := getRepository(arguments)
+	repository.Timeout = time.Second * time.Duration(arguments.Timeout)
+
+	key := fmt.Sprintf("%s%s%s_%s", arguments.BucketDir, prefix, arguments.S3FileName, time.Now().Format(util.KeyTimestampFormat))
+	result, err := chunkstore.Backup(svc, repository, arguments.PathToFile, key, arguments.DryRun)
+	summary.Chunks = &report.Chunks{
+		Total:         result.Chunks,
+		Uploaded:      result.UploadedChunks,
+		UploadedBytes: result.UploadedBytes,
+		Reused:        result.ReusedChunks,
+		DeletedKeys:   []string{},
+	}
+	return key, err
+}
+
+// collectChunkGarbage deletes the chunks no longer referenced by a manifest once the rotation has deleted manifests
+// The backup or rotation has already succeeded, so a failure is recorded as a warning rather than failing the run
+func collectChunkGarbage(svc *s3.S3, arguments args, policy rpolicy.RotationPolicy, summary *report.Summary) {
+	if summary.Chunks == nil {
+		summary.Chunks = &report.Chunks{DeletedKeys: []string{}}
+	}
+
+	gcDone := summary.Time("garbage_collection")
+	gc, err := chunkstore.CollectGarbage(svc, getRepository(arguments),
+		[]string{policy.DailyPrefix, policy.WeeklyPrefix, policy.MonthlyPrefix},
+		time.Hour*time.Duration(arguments.ChunkGracePeriod), arguments.DryRun)
+	gcDone()
+
+	summary.Chunks.DeletedKeys = append(summary.Chunks.DeletedKeys, gc.DeletedKeys...)
+	summary.Chunks.DeletedBytes += gc.Bytes
+	if err != nil {
+		arguments.Logger.Warn.Printf("Garbage collection of unreferenced chunks failed. Reason: %v\n", err)
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("garbage collection of unreferenced chunks failed: %v", err))
+	}
+}
+
+// restoreChunked reassembles the file if the key is the manifest of a chunked backup
+// Returns false if the key is not a manifest, in which case the key is downloaded as is
+func restoreChunked(svc *s3.S3, arguments args, key string, downloadLocation string) (bool, error) {
+	isManifest, err := chunkstore.IsManifest(context.Background(), svc, arguments.Bucket, key)
+	if err != nil || !isManifest {
+		// Any error is reported by the download of the key
+		return false, nil
+	}
+
+	// The chunks are stored in the bucket dir of the manifest, which may not be the bucket dir of the job
+	repository := getRepository(arguments)
+	repository.BucketDir = key[:strings.LastIndex(key, "/")+1]
+
+	_, err = chunkstore.Restore(svc, repository, key, downloadLocation)
+	return true, err
+}
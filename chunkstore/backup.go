@@ -0,0 +1,226 @@
+package chunkstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of a chunked backup
+type Result struct {
+	Key            string // The key of the manifest
+	Size           int64  // The size of the file
+	Chunks         int    // The number of chunks the file was split into
+	UploadedChunks int    // Chunks which were not already stored (or that would be uploaded in a dry run)
+	UploadedBytes  int64
+	ReusedChunks   int // Chunks which were already stored and were not uploaded again
+}
+
+// pendingChunk is a chunk waiting to be uploaded, or to be refreshed if it is already stored
+type pendingChunk struct {
+	hash    string
+	data    []byte
+	refresh bool
+}
+
+// Backup splits the file into content-defined chunks, uploads the chunks which are not already stored and then
+// uploads the manifest of the file to the key. The manifest is only uploaded once every chunk has been stored,
+// so a failed backup leaves no manifest behind. Chunks uploaded by a failed backup are removed by garbage collection
+// Chunks which are already stored are copied over themselves so that garbage collection keeps them for the grace
+// period until the manifest referencing them has been written
+func Backup(svc *s3.S3, repository Repository, pathToFile string, key string, dryRun bool) (Result, error) {
+	logger := log.OrDefault(repository.Logger)
+	logger.Banner("Chunked Backup Started")
+
+	result := Result{Key: key}
+
+	if svc == nil {
+		return result, errors.New("svc must not be nil")
+	}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	if repository.Timeout > 0 {
+		ctx, cancelFn = context.WithTimeout(ctx, repository.Timeout)
+		defer cancelFn()
+	}
+
+	file, err := os.Open(pathToFile)
+	if err != nil {
+		return result, err
+	}
+	defer file.Close()
+
+	existing, err := listChunks(svc, repository)
+	if err != nil {
+		return result, fmt.Errorf("failed to list chunks: %v", err)
+	}
+	logger.Info.Printf("Found %d chunk(s) stored in bucket '%s'\n", len(existing), repository.Bucket)
+
+	chunker, err := NewChunker(file, DefaultMinSize, DefaultAvgSize, DefaultMaxSize)
+	if err != nil {
+		return result, err
+	}
+
+	workers := repository.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var uploadErr error
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	uploads := make(chan pendingChunk)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range uploads {
+				err := storeChunk(ctx, svc, repository, chunk)
+				if err != nil {
+					errOnce.Do(func() {
+						uploadErr = fmt.Errorf("failed to upload chunk '%s': %v", chunk.hash, err)
+						cancelFn()
+					})
+				}
+			}
+		}()
+	}
+
+	startTime := time.Now()
+	manifest := Manifest{Version: manifestVersion, Source: pathToFile, Created: time.Now().UTC(), Chunks: []ChunkRef{}}
+	fileHash := sha256.New()
+	queued := make(map[string]bool)
+	var readErr error
+
+	for ctx.Err() == nil {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		size := int64(len(data))
+		fileHash.Write(data)
+		manifest.Chunks = append(manifest.Chunks, ChunkRef{Hash: hash, Size: size})
+		manifest.Size += size
+
+		if queued[hash] {
+			result.ReusedChunks++
+			continue
+		}
+
+		entry, ok := existing[hash]
+		refresh := ok && entry.size == size
+		if refresh {
+			result.ReusedChunks++
+		} else {
+			if ok {
+				logger.Warn.Printf("Chunk '%s' is %d bytes but should be %d bytes, uploading it again\n", entry.key, entry.size, size)
+			}
+			result.UploadedChunks++
+			result.UploadedBytes += size
+		}
+		queued[hash] = true
+
+		if dryRun {
+			continue
+		}
+
+		// The chunker reuses its buffer so the chunk is copied before it is handed to a worker
+		select {
+		case uploads <- pendingChunk{hash: hash, data: append([]byte(nil), data...), refresh: refresh}:
+		case <-ctx.Done():
+		}
+	}
+
+	close(uploads)
+	wg.Wait()
+
+	elapsedTime := time.Since(startTime).Seconds()
+	logger.Info.Printf("Total time spent processing chunks: %0.2f seconds\n", elapsedTime)
+
+	if uploadErr != nil {
+		return result, uploadErr
+	}
+	if readErr != nil {
+		return result, fmt.Errorf("failed to read '%s': %v", pathToFile, readErr)
+	}
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("chunked backup did not complete: %v", ctx.Err())
+	}
+
+	manifest.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	result.Size = manifest.Size
+	result.Chunks = len(manifest.Chunks)
+
+	logger.Info.Printf("Split '%s' (%d bytes) into %d chunk(s). %d chunk(s) (%d bytes) uploaded, %d chunk(s) already stored\n",
+		pathToFile, result.Size, result.Chunks, result.UploadedChunks, result.UploadedBytes, result.ReusedChunks)
+
+	if dryRun {
+		logger.Info.Printf("Skipping upload of chunks and manifest: '%s' as dry run has been enabled\n", key)
+		return result, nil
+	}
+
+	err = writeManifest(ctx, svc, repository.Bucket, key, manifest)
+	if err != nil {
+		return result, fmt.Errorf("failed to upload manifest '%s': %v", key, err)
+	}
+	logger.Info.Printf("Uploaded manifest: '%s'\n", key)
+
+	return result, nil
+}
+
+// storeChunk refreshes the chunk if it is already stored, otherwise it uploads the chunk
+// A chunk which has been deleted since it was listed, for example by garbage collection, is uploaded again
+func storeChunk(ctx context.Context, svc *s3.S3, repository Repository, chunk pendingChunk) error {
+	if chunk.refresh {
+		err := refreshChunk(ctx, svc, repository, chunk.hash)
+		if !s3client.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+			return err
+		}
+		log.OrDefault(repository.Logger).Warn.Printf("Chunk '%s' was deleted before it could be reused, uploading it again\n", chunk.hash)
+	}
+	return putChunk(ctx, svc, repository, chunk)
+}
+
+// refreshChunk copies the chunk over itself so that its LastModified is the time of the backup reusing it
+func refreshChunk(ctx context.Context, svc *s3.S3, repository Repository, hash string) error {
+	key := repository.chunkKey(hash)
+	_, err := svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(repository.Bucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(url.PathEscape(repository.Bucket + "/" + key)),
+		// S3 refuses to copy a key over itself unless something is changed, so the (empty) metadata is replaced
+		MetadataDirective: aws.String(s3.MetadataDirectiveReplace),
+	})
+	return err
+}
+
+// putChunk uploads the content of the chunk to its key
+func putChunk(ctx context.Context, svc *s3.S3, repository Repository, chunk pendingChunk) error {
+	_, err := svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(repository.Bucket),
+		Key:           aws.String(repository.chunkKey(chunk.hash)),
+		Body:          bytes.NewReader(chunk.data),
+		ContentLength: aws.Int64(int64(len(chunk.data))),
+	})
+	return err
+}
@@ -0,0 +1,159 @@
+package chunkstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// getTestRepository returns a repository of the test bucket along with a temporary directory for the files of the test
+func getTestRepository(t *testing.T) (Repository, string, func()) {
+	dir, err := ioutil.TempDir("", "chunkstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repository := Repository{
+		Bucket:     "chunk-bucket",
+		BucketDir:  "databases/",
+		NumWorkers: 2,
+		Timeout:    time.Minute,
+		Logger:     log.New(ioutil.Discard, log.LevelInfo, log.FormatText),
+	}
+	return repository, dir, func() { os.RemoveAll(dir) }
+}
+
+// writeTestFile writes the data to the file in the directory and returns its path
+func writeTestFile(t *testing.T, dir string, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	err := ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	server := s3test.NewServer("chunk-bucket")
+	defer server.Close()
+
+	repository, dir, cleanUp := getTestRepository(t)
+	defer cleanUp()
+
+	data := getTestData(8 * 1024 * 1024)
+	path := writeTestFile(t, dir, "postgres.sql", data)
+
+	result, err := Backup(server.Client(), repository, path, "databases/daily_postgres_1", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to backup the file without any error: %v", err))
+	}
+
+	if result.Size != int64(len(data)) || result.Chunks < 2 || result.UploadedChunks != result.Chunks || result.ReusedChunks != 0 {
+		t.Error(fmt.Sprintf("expected the file to be split into several chunks which are all uploaded, instead got: %+v", result))
+	}
+
+	manifest, err := ReadManifest(context.Background(), server.Client(), "chunk-bucket", "databases/daily_postgres_1")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the manifest to be uploaded, instead got: %v", err))
+	}
+	for _, chunk := range manifest.Chunks {
+		if _, ok := server.Object("chunk-bucket", repository.chunkKey(chunk.Hash)); !ok {
+			t.Error(fmt.Sprintf("expected chunk '%s' of the manifest to be stored", chunk.Hash))
+		}
+	}
+
+	restored := filepath.Join(dir, "restored.sql")
+	_, err = Restore(server.Client(), repository, "databases/daily_postgres_1", restored)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to restore the file without any error: %v", err))
+	}
+
+	contents, err := ioutil.ReadFile(restored)
+	if err != nil || !bytes.Equal(contents, data) {
+		t.Error(fmt.Sprintf("expected the restored file to match the file backed up: %v", err))
+	}
+}
+
+func TestBackupReusesChunks(t *testing.T) {
+	server := s3test.NewServer("chunk-bucket")
+	defer server.Close()
+
+	repository, dir, cleanUp := getTestRepository(t)
+	defer cleanUp()
+
+	data := getTestData(8 * 1024 * 1024)
+	server.Now = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	first, err := Backup(server.Client(), repository, writeTestFile(t, dir, "first.sql", data), "databases/daily_postgres_1", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to backup the file without any error: %v", err))
+	}
+	server.Now = time.Now
+
+	// Only the chunks around the change should be uploaded again
+	changed := append([]byte(nil), data...)
+	copy(changed[4*1024*1024:], []byte("changed"))
+	second, err := Backup(server.Client(), repository, writeTestFile(t, dir, "second.sql", changed), "databases/daily_postgres_2", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to backup the file without any error: %v", err))
+	}
+
+	if second.ReusedChunks == 0 || second.UploadedChunks == 0 || second.UploadedChunks+second.ReusedChunks != second.Chunks || second.UploadedChunks >= first.Chunks {
+		t.Error(fmt.Sprintf("expected only the changed chunks to be uploaded, instead got: %+v", second))
+	}
+
+	// Every chunk of the second backup must be young so that garbage collection keeps it until the manifest is written
+	manifest, err := ReadManifest(context.Background(), server.Client(), "chunk-bucket", "databases/daily_postgres_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range manifest.Chunks {
+		object, ok := server.Object("chunk-bucket", repository.chunkKey(chunk.Hash))
+		if !ok || time.Since(object.LastModified) > time.Hour {
+			t.Error(fmt.Sprintf("expected reused chunk '%s' to be refreshed, instead got: %v", chunk.Hash, object.LastModified))
+		}
+	}
+}
+
+func TestBackupDryRun(t *testing.T) {
+	server := s3test.NewServer("chunk-bucket")
+	defer server.Close()
+
+	repository, dir, cleanUp := getTestRepository(t)
+	defer cleanUp()
+
+	result, err := Backup(server.Client(), repository, writeTestFile(t, dir, "postgres.sql", getTestData(4*1024*1024)), "databases/daily_postgres_1", true)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the dry run to succeed, instead got: %v", err))
+	}
+
+	if result.UploadedChunks != result.Chunks || len(server.Keys("chunk-bucket")) != 0 {
+		t.Error(fmt.Sprintf("expected every chunk to be counted but nothing to be uploaded, instead got %+v and keys: %v", result, server.Keys("chunk-bucket")))
+	}
+}
+
+func TestStoreChunkUploadsDeletedChunk(t *testing.T) {
+	server := s3test.NewServer("chunk-bucket")
+	defer server.Close()
+
+	repository, _, cleanUp := getTestRepository(t)
+	defer cleanUp()
+
+	// The chunk was listed as stored but has since been deleted, e.g. by garbage collection
+	hashes, chunks := getTestChunks(t, getTestData(testMaxSize))
+	err := storeChunk(context.Background(), server.Client(), repository, pendingChunk{hash: hashes[0], data: chunks[0], refresh: true})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected the deleted chunk to be uploaded again, instead got: %v", err))
+	}
+
+	object, ok := server.Object("chunk-bucket", repository.chunkKey(hashes[0]))
+	if !ok || !bytes.Equal(object.Data, chunks[0]) {
+		t.Error("expected the deleted chunk to be uploaded again")
+	}
+}
@@ -0,0 +1,107 @@
+package chunkstore
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Default chunk sizes. Chunks are on average roughly 1.5MiB, which keeps the number of requests for large backups
+// reasonable while still only storing the regions of a file that changed
+const (
+	DefaultMinSize = 512 * 1024
+	DefaultAvgSize = 2 * 1024 * 1024
+	DefaultMaxSize = 8 * 1024 * 1024
+)
+
+// gearSeed is the seed of the gear table. It must never change, otherwise the chunk boundaries of a file would
+// change and every chunk would be uploaded again
+const gearSeed = 0x9E3779B97F4A7C15
+
+// gear maps each byte to a random value which is mixed into the rolling hash
+var gear [256]uint64
+
+func init() {
+	// splitmix64 is used so that the table is the same for every build
+	state := uint64(gearSeed)
+	for i := range gear {
+		state += 0x9E3779B97F4A7C15
+		z := state
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Chunker splits the content of a reader into content-defined chunks using a gear rolling hash
+// A chunk ends where the hash of the preceding bytes matches the mask, so inserting or removing bytes only changes
+// the chunks around the change rather than every chunk after it
+type Chunker struct {
+	reader  io.Reader
+	buf     []byte
+	start   int
+	end     int
+	eof     bool
+	minSize int
+	mask    uint64
+}
+
+// NewChunker returns a chunker of the reader. Chunks are at least minSize bytes, except for the last chunk, and
+// at most maxSize bytes. avgSize is the approximate size of a chunk
+func NewChunker(reader io.Reader, minSize int, avgSize int, maxSize int) (*Chunker, error) {
+	if minSize <= 0 || avgSize <= minSize || maxSize <= avgSize {
+		return nil, errors.New("chunk sizes must satisfy 0 < min < avg < max")
+	}
+
+	// The highest bits of the hash are used as they depend on the most bytes
+	maskBits := uint(bits.Len64(uint64(avgSize-minSize)) - 1)
+
+	return &Chunker{
+		reader:  reader,
+		buf:     make([]byte, maxSize),
+		minSize: minSize,
+		mask:    ^uint64(0) << (64 - maskBits),
+	}, nil
+}
+
+// Next returns the next chunk. The chunk is only valid until the next call to Next
+// io.EOF is returned once every chunk has been returned
+func (c *Chunker) Next() ([]byte, error) {
+	if c.start > 0 {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+	}
+
+	for c.end < len(c.buf) && !c.eof {
+		n, err := c.reader.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.end == 0 {
+		return nil, io.EOF
+	}
+
+	c.start = c.cut(c.buf[:c.end])
+	return c.buf[:c.start], nil
+}
+
+// cut returns the length of the chunk at the start of the data
+func (c *Chunker) cut(data []byte) int {
+	if len(data) <= c.minSize {
+		return len(data)
+	}
+
+	var hash uint64
+	for i := c.minSize; i < len(data); i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
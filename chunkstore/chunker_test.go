@@ -0,0 +1,130 @@
+package chunkstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+const (
+	testMinSize = 4 * 1024
+	testAvgSize = 16 * 1024
+	testMaxSize = 64 * 1024
+)
+
+// getTestData returns random data which is the same for every run
+func getTestData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(42)).Read(data)
+	return data
+}
+
+// getTestChunks returns the sha256 of each chunk of the data along with the chunks themselves
+func getTestChunks(t *testing.T, data []byte) ([]string, [][]byte) {
+	chunker, err := NewChunker(bytes.NewReader(data), testMinSize, testAvgSize, testMaxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := []string{}
+	chunks := [][]byte{}
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to chunk data without any error: %v", err))
+		}
+		sum := sha256.Sum256(chunk)
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+	return hashes, chunks
+}
+
+func TestChunkerReassembles(t *testing.T) {
+	data := getTestData(2 * 1024 * 1024)
+
+	_, chunks := getTestChunks(t, data)
+	if len(chunks) < 2 {
+		t.Fatal(fmt.Sprintf("expected data to be split into several chunks, instead got %d", len(chunks)))
+	}
+
+	for i, chunk := range chunks {
+		if len(chunk) > testMaxSize || (len(chunk) < testMinSize && i != len(chunks)-1) {
+			t.Error(fmt.Sprintf("expected chunk %d to be between %d and %d bytes, instead got %d bytes", i, testMinSize, testMaxSize, len(chunk)))
+		}
+	}
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Error("expected chunks to reassemble into the original data")
+	}
+}
+
+func TestChunkerBoundariesFollowContent(t *testing.T) {
+	data := getTestData(2 * 1024 * 1024)
+	original, _ := getTestChunks(t, data)
+
+	// Inserting bytes near the start shifts every following byte. Only the chunks around the insert should change
+	shifted := append(append(append([]byte(nil), data[:1000]...), []byte("inserted")...), data[1000:]...)
+	changed, _ := getTestChunks(t, shifted)
+
+	stored := map[string]bool{}
+	for _, hash := range original {
+		stored[hash] = true
+	}
+
+	reused := 0
+	for _, hash := range changed {
+		if stored[hash] {
+			reused++
+		}
+	}
+
+	if reused < len(changed)-2 {
+		t.Error(fmt.Sprintf("expected all but the first chunks to be reused after an insert, instead %d of %d were reused", reused, len(changed)))
+	}
+}
+
+func TestChunkerEmpty(t *testing.T) {
+	hashes, _ := getTestChunks(t, []byte{})
+	if len(hashes) != 0 {
+		t.Error(fmt.Sprintf("expected no chunks for empty data, instead got: %v", hashes))
+	}
+}
+
+func TestNewChunkerInvalidSizes(t *testing.T) {
+	for _, sizes := range [][]int{{0, 16, 64}, {16, 16, 64}, {4, 64, 64}} {
+		_, err := NewChunker(bytes.NewReader(nil), sizes[0], sizes[1], sizes[2])
+		if err == nil {
+			t.Error(fmt.Sprintf("expected chunk sizes %v to be rejected", sizes))
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	_, err := parseManifest([]byte(fmt.Sprintf(`{"version": 1, "size": 4, "chunks": [{"hash": "%s", "size": 4}]}`, hash)))
+	if err != nil {
+		t.Error(fmt.Sprintf("expected manifest to be valid, instead got: %v", err))
+	}
+
+	invalid := []string{
+		`{"version": 2, "size": 0, "chunks": []}`,
+		`{"version": 1, "size": 4, "chunks": [{"hash": "../../etc", "size": 4}]}`,
+		fmt.Sprintf(`{"version": 1, "size": 5, "chunks": [{"hash": "%s", "size": 4}]}`, hash),
+		`{"version": 1,`,
+	}
+	for _, contents := range invalid {
+		_, err = parseManifest([]byte(contents))
+		if err == nil {
+			t.Error(fmt.Sprintf("expected manifest to be rejected: %s", contents))
+		}
+	}
+}
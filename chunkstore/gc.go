@@ -0,0 +1,147 @@
+package chunkstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// GCSummary is the outcome of garbage collection
+type GCSummary struct {
+	Manifests   int      // The number of manifests read
+	Referenced  int      // The number of chunks referenced by at least one manifest
+	DeletedKeys []string // Unreferenced chunks deleted (or that would be deleted in a dry run)
+	Bytes       int64    // The total size of the chunks deleted
+	Skipped     int      // Unreferenced chunks kept as they are younger than the grace period
+	Errors      []string
+}
+
+// CollectGarbage deletes every chunk of the repository which is not referenced by a manifest
+// Manifests are found by reading every key of the bucket dir with one of the prefixes, so every series sharing the
+// chunks must use the same prefixes. Nothing is deleted if any manifest can not be read
+// Chunks younger than the grace period are kept as they may belong to a backup whose manifest is not yet written
+// A backup refreshes every chunk it reuses, so each chunk is checked again just before it is deleted
+func CollectGarbage(svc *s3.S3, repository Repository, prefixes []string, gracePeriod time.Duration, dryRun bool) (GCSummary, error) {
+	logger := log.OrDefault(repository.Logger)
+	logger.Banner("Chunk Garbage Collection Started")
+
+	summary := GCSummary{DeletedKeys: []string{}, Errors: []string{}}
+
+	ctx := context.Background()
+	if repository.Timeout > 0 {
+		var cancelFn context.CancelFunc
+		ctx, cancelFn = context.WithTimeout(ctx, repository.Timeout)
+		defer cancelFn()
+	}
+
+	// The chunks are listed before the manifests so that a chunk uploaded after the listing can not be deleted
+	chunks, err := listChunks(svc, repository)
+	if err != nil {
+		return summary, fmt.Errorf("failed to list chunks: %v", err)
+	}
+
+	referenced := make(map[string]bool)
+	for _, prefix := range prefixes {
+		objects, err := s3client.GetObjectsByPrefix(svc, repository.Bucket, repository.BucketDir+prefix)
+		if err != nil {
+			return summary, fmt.Errorf("failed to list manifests: %v", err)
+		}
+
+		for _, object := range objects {
+			key := aws.StringValue(object.Key)
+			isManifest, err := IsManifest(ctx, svc, repository.Bucket, key)
+			if err != nil {
+				return summary, fmt.Errorf("failed to check key '%s' for a manifest. No chunks have been deleted: %v", key, err)
+			}
+			if !isManifest {
+				continue
+			}
+
+			manifest, err := ReadManifest(ctx, svc, repository.Bucket, key)
+			if err != nil {
+				return summary, fmt.Errorf("failed to read manifest '%s'. No chunks have been deleted: %v", key, err)
+			}
+
+			summary.Manifests++
+			for _, chunk := range manifest.Chunks {
+				referenced[chunk.Hash] = true
+			}
+		}
+	}
+	summary.Referenced = len(referenced)
+
+	logger.Info.Printf("Found %d chunk(s) referenced by %d manifest(s) of %d chunk(s) stored\n", summary.Referenced, summary.Manifests, len(chunks))
+
+	// Chunks are deleted in a stable order so that the log and report are easy to compare between runs
+	hashes := []string{}
+	for hash := range chunks {
+		if !referenced[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+
+	for _, hash := range hashes {
+		chunk := chunks[hash]
+		lastModified := chunk.lastModified
+		if time.Since(lastModified) >= gracePeriod {
+			lastModified, err = getLastModified(ctx, svc, repository.Bucket, chunk.key)
+			if s3client.IsStatusCode(err, http.StatusNotFound) {
+				continue // Already deleted
+			}
+			if err != nil {
+				logger.Error.Printf("Failed to check unreferenced chunk: '%s': %v\n", chunk.key, err)
+				summary.Errors = append(summary.Errors, fmt.Sprintf("failed to check chunk '%s': %v", chunk.key, err))
+				continue
+			}
+		}
+
+		age := time.Since(lastModified)
+		if age < gracePeriod {
+			logger.Info.Printf("Skipping unreferenced chunk: '%s' as it is only %0.1f hours old\n", chunk.key, age.Hours())
+			summary.Skipped++
+			continue
+		}
+
+		if dryRun {
+			logger.Info.Printf("Skipping delete of unreferenced chunk: '%s' (%d bytes) as dry run has been enabled\n", chunk.key, chunk.size)
+		} else {
+			_, err = s3client.DeleteKey(svc, repository.Bucket, chunk.key)
+			if err != nil {
+				logger.Error.Printf("Failed to delete unreferenced chunk: '%s': %v\n", chunk.key, err)
+				summary.Errors = append(summary.Errors, fmt.Sprintf("failed to delete chunk '%s': %v", chunk.key, err))
+				continue
+			}
+			logger.Info.Printf("Deleted unreferenced chunk: '%s' (%d bytes)\n", chunk.key, chunk.size)
+		}
+		summary.DeletedKeys = append(summary.DeletedKeys, chunk.key)
+		summary.Bytes += chunk.size
+	}
+
+	logger.Info.Printf("Garbage collection deleted %d chunk(s) (%d bytes), %d chunk(s) kept for the grace period\n",
+		len(summary.DeletedKeys), summary.Bytes, summary.Skipped)
+
+	if len(summary.Errors) > 0 {
+		return summary, fmt.Errorf("failed to delete %d chunk(s)", len(summary.Errors))
+	}
+
+	return summary, nil
+}
+
+// getLastModified returns the current LastModified of the key
+func getLastModified(ctx context.Context, svc *s3.S3, bucket string, key string) (time.Time, error) {
+	resp, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return aws.TimeValue(resp.LastModified), nil
+}
@@ -0,0 +1,128 @@
+package chunkstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testPrefixes = []string{"daily_", "weekly_", "monthly_"}
+
+func TestCollectGarbage(t *testing.T) {
+	server := s3test.NewServer("chunk-bucket")
+	defer server.Close()
+
+	repository, dir, cleanUp := getTestRepository(t)
+	defer cleanUp()
+
+	server.Now = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	data := getTestData(8 * 1024 * 1024)
+	changed := append([]byte(nil), data...)
+	copy(changed[4*1024*1024:], []byte("changed"))
+	for i, contents := range [][]byte{data, changed} {
+		path := writeTestFile(t, dir, fmt.Sprintf("postgres_%d.sql", i), contents)
+		_, err := Backup(server.Client(), repository, path, fmt.Sprintf("databases/daily_postgres_%d", i), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Now = time.Now
+
+	kept, err := ReadManifest(context.Background(), server.Client(), "chunk-bucket", "databases/daily_postgres_0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ReadManifest(context.Background(), server.Client(), "chunk-bucket", "databases/daily_postgres_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	referenced := map[string]bool{}
+	for _, chunk := range kept.Chunks {
+		referenced[chunk.Hash] = true
+	}
+	expected := []string{}
+	for _, chunk := range rotated.Chunks {
+		if !referenced[chunk.Hash] {
+			expected = append(expected, repository.chunkKey(chunk.Hash))
+		}
+	}
+	sort.Strings(expected)
+
+	// The rotation deletes the manifest of the second backup, leaving its changed chunks unreferenced
+	_, err = s3client.DeleteKey(server.Client(), "chunk-bucket", "databases/daily_postgres_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := CollectGarbage(server.Client(), repository, testPrefixes, 24*time.Hour, true)
+	if err != nil || len(summary.DeletedKeys) != len(expected) || len(server.Keys("chunk-bucket")) != len(kept.Chunks)+len(expected)+1 {
+		t.Error(fmt.Sprintf("expected the dry run to delete nothing, instead got %+v: %v", summary, err))
+	}
+
+	summary, err = CollectGarbage(server.Client(), repository, testPrefixes, 24*time.Hour, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected garbage collection to succeed, instead got: %v", err))
+	}
+
+	if summary.Manifests != 1 || summary.Referenced != len(kept.Chunks) || fmt.Sprint(summary.DeletedKeys) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected only the unreferenced chunks %v to be deleted, instead got: %+v", expected, summary))
+	}
+
+	for _, key := range expected {
+		if _, ok := server.Object("chunk-bucket", key); ok {
+			t.Error(fmt.Sprintf("expected unreferenced chunk '%s' to be deleted", key))
+		}
+	}
+
+	_, err = Restore(server.Client(), repository, "databases/daily_postgres_0", filepath.Join(dir, "restored.sql"))
+	if err != nil {
+		t.Error(fmt.Sprintf("expected the remaining backup to be restored after garbage collection, instead got: %v", err))
+	}
+}
+
+func TestCollectGarbageGracePeriod(t *testing.T) {
+	server := s3test.NewServer("chunk-bucket")
+	defer server.Close()
+
+	repository, _, cleanUp := getTestRepository(t)
+	defer cleanUp()
+
+	hashes, chunks := getTestChunks(t, getTestData(4*testMaxSize))
+	if len(hashes) < 3 {
+		t.Fatal(fmt.Sprintf("expected several chunks, instead got %d", len(hashes)))
+	}
+
+	server.Now = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	for i := range hashes[:2] {
+		server.PutObject("chunk-bucket", repository.chunkKey(hashes[i]), chunks[i])
+	}
+	server.Now = time.Now
+	server.PutObject("chunk-bucket", repository.chunkKey(hashes[2]), chunks[2])
+
+	// The first chunk is old but is reused by a backup whose manifest is not yet written
+	err := refreshChunk(context.Background(), server.Client(), repository, hashes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := CollectGarbage(server.Client(), repository, testPrefixes, 24*time.Hour, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected garbage collection to succeed, instead got: %v", err))
+	}
+
+	if summary.Skipped != 2 || len(summary.DeletedKeys) != 1 || summary.DeletedKeys[0] != repository.chunkKey(hashes[1]) {
+		t.Error(fmt.Sprintf("expected only the old chunk which was not reused to be deleted, instead got: %+v", summary))
+	}
+
+	for _, hash := range []string{hashes[0], hashes[2]} {
+		if _, ok := server.Object("chunk-bucket", repository.chunkKey(hash)); !ok {
+			t.Error(fmt.Sprintf("expected chunk '%s' younger than the grace period to be kept", hash))
+		}
+	}
+}
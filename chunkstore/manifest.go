@@ -0,0 +1,120 @@
+package chunkstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// FormatMetadataKey is the user metadata key which marks an object as the manifest of a chunked backup
+const FormatMetadataKey = "gos3gfsbackup-format"
+
+// Format is the value of the format metadata of a manifest. It changes if the manifest or chunk layout changes
+const Format = "chunked-v1"
+
+// manifestVersion is the version of the manifest document
+const manifestVersion = 1
+
+// Manifest lists the chunks which are concatenated to restore a chunked backup
+// The manifest is stored at the key the file would have been uploaded to, so it is rotated like any other backup
+type Manifest struct {
+	Version int        `json:"version"`
+	Source  string     `json:"source"` // The path of the file backed up
+	Size    int64      `json:"size"`
+	SHA256  string     `json:"sha256"` // The sha256 of the whole file
+	Created time.Time  `json:"created"`
+	Chunks  []ChunkRef `json:"chunks"`
+}
+
+// ChunkRef is a chunk of a manifest. Chunks are identified by the sha256 of their content
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// IsManifest returns true if the key is the manifest of a chunked backup
+func IsManifest(ctx context.Context, svc *s3.S3, bucket string, key string) (bool, error) {
+	resp, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	// The SDK canonicalises the case of metadata keys so they are compared without case
+	for metadataKey, value := range resp.Metadata {
+		if strings.EqualFold(metadataKey, FormatMetadataKey) {
+			return aws.StringValue(value) == Format, nil
+		}
+	}
+	return false, nil
+}
+
+// ReadManifest downloads and parses the manifest of the key
+func ReadManifest(ctx context.Context, svc *s3.S3, bucket string, key string) (Manifest, error) {
+	resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return parseManifest(contents)
+}
+
+// writeManifest uploads the manifest to the key with the format metadata
+func writeManifest(ctx context.Context, svc *s3.S3, bucket string, key string, manifest Manifest) error {
+	contents, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(contents),
+		ContentType: aws.String("application/json"),
+		Metadata:    aws.StringMap(map[string]string{FormatMetadataKey: Format}),
+	})
+	return err
+}
+
+// parseManifest parses and checks a manifest so that a damaged manifest is never used to restore or collect garbage
+func parseManifest(contents []byte) (Manifest, error) {
+	manifest := Manifest{}
+	err := json.Unmarshal(contents, &manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest: %v", err)
+	}
+
+	if manifest.Version != manifestVersion {
+		return Manifest{}, fmt.Errorf("unsupported manifest version: %d", manifest.Version)
+	}
+
+	var size int64
+	for _, chunk := range manifest.Chunks {
+		if !chunkHash.MatchString(chunk.Hash) {
+			return Manifest{}, fmt.Errorf("invalid chunk hash in manifest: '%s'", chunk.Hash)
+		}
+		size += chunk.Size
+	}
+	if size != manifest.Size {
+		return Manifest{}, fmt.Errorf("manifest size %d does not match the total size of its chunks %d", manifest.Size, size)
+	}
+
+	return manifest, nil
+}
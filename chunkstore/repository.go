@@ -0,0 +1,63 @@
+package chunkstore
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"path"
+	"regexp"
+	"time"
+)
+
+// ChunkPrefix is the prefix below the bucket dir which chunks are stored under
+// Every series in the bucket dir shares the same chunks
+const ChunkPrefix = "chunks/"
+
+// chunkHash matches the hex encoded sha256 which identifies a chunk
+var chunkHash = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// Repository represents the chunk store of a bucket dir along with the settings used to read and write it
+type Repository struct {
+	Bucket     string
+	BucketDir  string
+	NumWorkers int           // The number of chunks uploaded or downloaded at the same time
+	Timeout    time.Duration // The timeout of a backup or restore. 0 disables
+	Logger     *log.Logger   // If nil then the default logger is used
+}
+
+// chunkEntry is a chunk which exists in the bucket
+type chunkEntry struct {
+	key          string
+	size         int64
+	lastModified time.Time
+}
+
+// chunkKey returns the key of the chunk with the hash
+// Chunks are spread over 256 prefixes by the first byte of the hash
+func (r Repository) chunkKey(hash string) string {
+	return r.BucketDir + ChunkPrefix + hash[:2] + "/" + hash
+}
+
+// listChunks returns every chunk stored in the repository by hash. Keys which are not chunks are ignored
+func listChunks(svc *s3.S3, repository Repository) (map[string]chunkEntry, error) {
+	objects, err := s3client.GetObjectsByPrefix(svc, repository.Bucket, repository.BucketDir+ChunkPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make(map[string]chunkEntry)
+	for _, object := range objects {
+		key := aws.StringValue(object.Key)
+		hash := path.Base(key)
+		if !chunkHash.MatchString(hash) || key != repository.chunkKey(hash) {
+			continue
+		}
+		chunks[hash] = chunkEntry{
+			key:          key,
+			size:         aws.Int64Value(object.Size),
+			lastModified: aws.TimeValue(object.LastModified),
+		}
+	}
+	return chunks, nil
+}
@@ -0,0 +1,143 @@
+package chunkstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fetchedChunk is the content of a chunk downloaded by a worker
+type fetchedChunk struct {
+	data []byte
+	err  error
+}
+
+// Restore reassembles the chunked backup of the manifest key into the download location
+// Chunks are downloaded in parallel and written in order to a temporary file next to the download location, which is
+// renamed over the download location once the size and sha256 of every chunk and of the whole file have been verified
+func Restore(svc *s3.S3, repository Repository, key string, downloadLocation string) (Manifest, error) {
+	logger := log.OrDefault(repository.Logger)
+	logger.Banner("Chunked Restore Started")
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	if repository.Timeout > 0 {
+		ctx, cancelFn = context.WithTimeout(ctx, repository.Timeout)
+		defer cancelFn()
+	}
+
+	manifest, err := ReadManifest(ctx, svc, repository.Bucket, key)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest '%s': %v", key, err)
+	}
+	logger.Info.Printf("Restoring '%s' (%d bytes) from %d chunk(s) to '%s'\n", key, manifest.Size, len(manifest.Chunks), downloadLocation)
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(downloadLocation), "."+filepath.Base(downloadLocation)+".tmp")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.Remove(tmpFile.Name()) // Fails harmlessly once the file has been renamed
+	defer tmpFile.Close()
+
+	workers := repository.NumWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each chunk is downloaded by its own goroutine. The capacity of pending limits how many are downloaded ahead
+	// of the chunk being written, while the order of pending is the order the chunks are written in
+	pending := make(chan chan fetchedChunk, workers)
+	go func() {
+		defer close(pending)
+		for _, chunk := range manifest.Chunks {
+			fetched := make(chan fetchedChunk, 1)
+			select {
+			case pending <- fetched:
+			case <-ctx.Done():
+				return
+			}
+			go func(chunk ChunkRef) {
+				data, err := getChunk(ctx, svc, repository, chunk)
+				fetched <- fetchedChunk{data: data, err: err}
+			}(chunk)
+		}
+	}()
+
+	startTime := time.Now()
+	fileHash := sha256.New()
+	var written int64
+	for fetched := range pending {
+		chunk := <-fetched
+		if chunk.err != nil {
+			return manifest, chunk.err
+		}
+
+		_, err = tmpFile.Write(chunk.data)
+		if err != nil {
+			return manifest, err
+		}
+		fileHash.Write(chunk.data)
+		written += int64(len(chunk.data))
+	}
+
+	if ctx.Err() != nil {
+		return manifest, fmt.Errorf("chunked restore did not complete: %v", ctx.Err())
+	}
+
+	if written != manifest.Size {
+		return manifest, fmt.Errorf("restored %d bytes but the manifest is %d bytes", written, manifest.Size)
+	}
+
+	checksum := hex.EncodeToString(fileHash.Sum(nil))
+	if checksum != manifest.SHA256 {
+		return manifest, fmt.Errorf("restored file has checksum %s but the manifest has checksum %s", checksum, manifest.SHA256)
+	}
+
+	err = tmpFile.Sync()
+	if err == nil {
+		err = tmpFile.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), downloadLocation)
+	}
+	if err != nil {
+		return manifest, err
+	}
+
+	logger.Info.Printf("Total time spent processing restore: %0.2f seconds\n", time.Since(startTime).Seconds())
+	logger.Info.Printf("Restore complete. '%s' has been written to '%s'\n", key, downloadLocation)
+
+	return manifest, nil
+}
+
+// getChunk downloads the chunk and verifies its size and sha256
+func getChunk(ctx context.Context, svc *s3.S3, repository Repository, chunk ChunkRef) ([]byte, error) {
+	resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(repository.Bucket),
+		Key:    aws.String(repository.chunkKey(chunk.Hash)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download chunk '%s': %v", chunk.Hash, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download chunk '%s': %v", chunk.Hash, err)
+	}
+
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != chunk.Size || hex.EncodeToString(sum[:]) != chunk.Hash {
+		return nil, fmt.Errorf("chunk '%s' is damaged: expected %d bytes with a matching sha256 but got %d bytes", chunk.Hash, chunk.Size, len(data))
+	}
+
+	return data, nil
+}
@@ -0,0 +1,52 @@
+package chunkstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreDamagedChunk(t *testing.T) {
+	server := s3test.NewServer("chunk-bucket")
+	defer server.Close()
+
+	repository, dir, cleanUp := getTestRepository(t)
+	defer cleanUp()
+
+	path := writeTestFile(t, dir, "postgres.sql", getTestData(8*1024*1024))
+	_, err := Backup(server.Client(), repository, path, "databases/daily_postgres_1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ReadManifest(context.Background(), server.Client(), "chunk-bucket", "databases/daily_postgres_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	damaged := manifest.Chunks[len(manifest.Chunks)/2].Hash
+	server.Update("chunk-bucket", repository.chunkKey(damaged), func(object *s3test.Object) {
+		object.Data = append([]byte(nil), object.Data...)
+		object.Data[0] ^= 0xff
+	})
+
+	restored := filepath.Join(dir, "restored.sql")
+	_, err = Restore(server.Client(), repository, "databases/daily_postgres_1", restored)
+	if err == nil || !strings.Contains(err.Error(), damaged) || !strings.Contains(err.Error(), "damaged") {
+		t.Fatal(fmt.Sprintf("expected the restore to fail on the damaged chunk, instead got: %v", err))
+	}
+
+	if _, err := os.Stat(restored); err == nil {
+		t.Error("expected the failed restore not to write the download location")
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Error(fmt.Sprintf("expected the temporary file of the failed restore to be removed, instead found %d file(s): %v", len(files), err))
+	}
+}
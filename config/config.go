@@ -0,0 +1,666 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"github.com/robfig/cron"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Actions is the list of actions that a job is permitted to run
+var Actions = []string{"backup", "upload", "download", "rotate", "plan", "apply", "check", "replicate", "list", "apply-lifecycle", "prune-multipart"}
+
+// FileActions is the list of actions that a job with a local directory (file://) bucket is permitted to run
+var FileActions = []string{"backup", "upload", "download", "rotate", "list"}
+
+// Config represents a config file consisting of one or more named backup jobs
+type Config struct {
+	Jobs []Job
+}
+
+// Job represents a single named backup job along with everything required to run it
+type Job struct {
+	Name              string        `yaml:"name"`
+	Action            string        `yaml:"action"`
+	Region            string        `yaml:"region"`
+	Bucket            string        `yaml:"bucket"`
+	BucketDir         string        `yaml:"bucketdir"`
+	CredFile          string        `yaml:"credfile"`
+	Profile           string        `yaml:"profile"`
+	Endpoint          string        `yaml:"endpoint"`
+	ForcePathStyle    bool          `yaml:"forcepathstyle"`
+	DisableSSL        bool          `yaml:"disablessl"`
+	CABundle          string        `yaml:"cabundle"`
+	RoleARN           string        `yaml:"rolearn"`
+	ExternalID        string        `yaml:"externalid"`
+	RoleDuration      int           `yaml:"roleduration"`
+	BucketOwner       string        `yaml:"expectedbucketowner"`
+	ACL               string        `yaml:"acl"`
+	PathToFile        string        `yaml:"pathtofile"`
+	S3FileName        string        `yaml:"s3filename"`
+	Timeout           int           `yaml:"timeout"`
+	ConcurrentWorkers int           `yaml:"concurrentworkers"`
+	PartSize          int           `yaml:"partsize"`
+	Dedup             bool          `yaml:"dedup"`
+	Chunked           bool          `yaml:"chunked"`
+	ChunkGracePeriod  int           `yaml:"chunkgraceperiod"`
+	MultipartMinAge   int           `yaml:"multipartminage"`
+	Schedule          string        `yaml:"schedule"`
+	MetricsFile       string        `yaml:"metricsfile"`
+	PushgatewayURL    string        `yaml:"pushgatewayurl"`
+	Plan              string        `yaml:"plan"`
+	Check             Check         `yaml:"check"`
+	Replica           Replica       `yaml:"replica"`
+	Destinations      []Destination `yaml:"destinations"`
+	Policy            Policy        `yaml:"policy"`
+	Hooks             Hooks         `yaml:"hooks"`
+	Webhooks          []Webhook     `yaml:"webhooks"`
+	Email             Email         `yaml:"email"`
+}
+
+// Policy represents the rotation policy settings of a job
+// Periods are specified in hours and lifecycle settings in days to match the command line arguments
+type Policy struct {
+	EnforceRetentionPeriod bool   `yaml:"enforceretentionperiod"`
+	DailyRetentionCount    int    `yaml:"dailyretentioncount"`
+	DailyRetentionPeriod   int    `yaml:"dailyretentionperiod"`
+	WeeklyRetentionCount   int    `yaml:"weeklyretentioncount"`
+	WeeklyRetentionPeriod  int    `yaml:"weeklyretentionperiod"`
+	MonthlyTransitionDays  int    `yaml:"monthlytransitiondays"`
+	MonthlyStorageClass    string `yaml:"monthlystorageclass"`
+	MonthlyExpirationDays  int    `yaml:"monthlyexpirationdays"`
+	AbortMultipartDays     int    `yaml:"abortmultipartdays"`
+	MinKeep                int    `yaml:"minkeep"`
+	MaxDeletes             int    `yaml:"maxdeletes"`
+	MaxBackupAge           int    `yaml:"maxbackupage"`
+	Promote                bool   `yaml:"promote"`
+}
+
+// Replica represents the secondary bucket of the replicate action along with its own retention settings
+// The region, credential file, profile and expected bucket owner default to those of the job and retention settings of 0 to the policy of the job
+type Replica struct {
+	Bucket                string `yaml:"bucket"`
+	Region                string `yaml:"region"`
+	CredFile              string `yaml:"credfile"`
+	Profile               string `yaml:"profile"`
+	BucketOwner           string `yaml:"expectedbucketowner"`
+	DailyRetentionCount   int    `yaml:"dailyretentioncount"`
+	DailyRetentionPeriod  int    `yaml:"dailyretentionperiod"`
+	WeeklyRetentionCount  int    `yaml:"weeklyretentioncount"`
+	WeeklyRetentionPeriod int    `yaml:"weeklyretentionperiod"`
+}
+
+// DestinationAllOrNothing and DestinationBestEffort are the success modes of a destination
+// The backup fails if an all-or-nothing destination fails, whereas a best-effort destination which fails is only reported
+const (
+	DestinationAllOrNothing = "all-or-nothing"
+	DestinationBestEffort   = "best-effort"
+)
+
+// Destination represents an additional bucket the backup action uploads the file to along with its own retention settings
+// The region, credential file, profile and expected bucket owner default to those of the job and retention settings of 0 to the policy of the job
+type Destination struct {
+	Name                  string `yaml:"name"`
+	Bucket                string `yaml:"bucket"`
+	BucketDir             string `yaml:"bucketdir"`
+	Region                string `yaml:"region"`
+	CredFile              string `yaml:"credfile"`
+	Profile               string `yaml:"profile"`
+	BucketOwner           string `yaml:"expectedbucketowner"`
+	Mode                  string `yaml:"mode"` // all-or-nothing (default) or best-effort
+	DailyRetentionCount   int    `yaml:"dailyretentioncount"`
+	DailyRetentionPeriod  int    `yaml:"dailyretentionperiod"`
+	WeeklyRetentionCount  int    `yaml:"weeklyretentioncount"`
+	WeeklyRetentionPeriod int    `yaml:"weeklyretentionperiod"`
+}
+
+// Check represents the thresholds of the check action. Ages are specified in hours and sizes in bytes
+type Check struct {
+	WarnAge  int   `yaml:"warnage"`
+	CritAge  int   `yaml:"critage"`
+	WarnSize int64 `yaml:"warnsize"`
+	CritSize int64 `yaml:"critsize"`
+	Tiers    bool  `yaml:"tiers"`
+}
+
+// Hooks represents the shell commands run at each point of a backup
+type Hooks struct {
+	PreBackup    Hook `yaml:"prebackup"`
+	PostSuccess  Hook `yaml:"postsuccess"`
+	PostFailure  Hook `yaml:"postfailure"`
+	PostRotation Hook `yaml:"postrotation"`
+}
+
+// Hook represents a single hook command and its timeout (seconds)
+type Hook struct {
+	Command string `yaml:"command"`
+	Timeout int    `yaml:"timeout"`
+}
+
+// Webhook represents an endpoint which is sent the summary of every run of a job
+// If retries or timeout (seconds) are not set then the command line arguments are used
+type Webhook struct {
+	URL      string            `yaml:"url"`
+	Headers  map[string]string `yaml:"headers"`
+	Secret   string            `yaml:"secret"`
+	Template string            `yaml:"template"`
+	Retries  *int              `yaml:"retries"`
+	Timeout  int               `yaml:"timeout"`
+	Events   []string          `yaml:"events"`
+}
+
+// Email represents the mail relay which is sent a report of every run of a job. No email is sent if host is not set
+type Email struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	StartTLS bool     `yaml:"starttls"`
+	Timeout  int      `yaml:"timeout"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	When     string   `yaml:"when"`
+}
+
+// file represents the raw layout of the config file
+// Defaults and jobs are kept as raw yaml so that each job can be layered on top of the defaults
+type file struct {
+	Defaults yaml.MapSlice   `yaml:"defaults"`
+	Jobs     []yaml.MapSlice `yaml:"jobs"`
+}
+
+var jobNamePattern = regexp.MustCompile("^[A-Za-z0-9_.-]+$")
+
+var accountIDPattern = regexp.MustCompile("^[0-9]{12}$")
+
+// LoadFile reads and validates the config file at the specified path
+func LoadFile(path string, base Job, overrides func(job *Job)) (Config, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return Load(contents, base, overrides)
+}
+
+// Load parses and validates a config file. Every job starts from the base job, then the defaults section
+// of the config file is applied, followed by the settings of the job itself. If overrides is not nil then it is
+// applied to every job last, i.e. to give precedence to the arguments specified on the command line
+// Unknown fields are rejected and every job is validated before the config is returned
+func Load(contents []byte, base Job, overrides func(job *Job)) (Config, error) {
+	raw := file{}
+	err := yaml.UnmarshalStrict(contents, &raw)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse config: %v", err)
+	}
+
+	defaults := base
+	err = overlay(raw.Defaults, &defaults)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse config defaults: %v", err)
+	}
+
+	if defaults.Name != "" {
+		return Config{}, errors.New("failed to parse config defaults: name must only be specified for a job")
+	}
+
+	config := Config{}
+	for i, rawJob := range raw.Jobs {
+		job := defaults
+		err = overlay(rawJob, &job)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to parse job %d: %v", i+1, err)
+		}
+		if overrides != nil {
+			overrides(&job)
+		}
+		config.Jobs = append(config.Jobs, job)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+// Job returns the job with the specified name
+func (c Config) Job(name string) (Job, error) {
+	for _, job := range c.Jobs {
+		if job.Name == name {
+			return job, nil
+		}
+	}
+	return Job{}, fmt.Errorf("job '%s' does not exist in config. Available jobs: %s", name, strings.Join(c.JobNames(), ", "))
+}
+
+// JobNames returns the names of all the jobs in the order they are defined
+func (c Config) JobNames() []string {
+	names := []string{}
+	for _, job := range c.Jobs {
+		names = append(names, job.Name)
+	}
+	return names
+}
+
+// Validate checks every job in the config and returns a single error describing all of the problems found
+func (c Config) Validate() error {
+	problems := []string{}
+
+	if len(c.Jobs) == 0 {
+		problems = append(problems, "at least one job must be defined")
+	}
+
+	seen := make(map[string]bool)
+	for i, job := range c.Jobs {
+		name := job.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		} else if seen[name] {
+			problems = append(problems, fmt.Sprintf("job '%s': name is used by more than one job", name))
+		}
+		seen[name] = true
+
+		for _, problem := range job.Validate() {
+			problems = append(problems, fmt.Sprintf("job '%s': %s", name, problem))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid config:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+
+	return nil
+}
+
+// Validate returns a list of every problem found with the job
+func (j Job) Validate() []string {
+	problems := []string{}
+
+	if j.Name == "" {
+		problems = append(problems, "name must be specified")
+	} else if !jobNamePattern.MatchString(j.Name) {
+		problems = append(problems, "name must only contain letters, numbers, '.', '_' and '-'")
+	}
+
+	validAction := false
+	for _, action := range Actions {
+		if j.Action == action {
+			validAction = true
+		}
+	}
+	if !validAction {
+		problems = append(problems, fmt.Sprintf("action must be one of [%s]", strings.Join(Actions, "|")))
+	}
+
+	if j.Region == "" && j.Endpoint == "" && !storage.IsFileURL(j.Bucket) {
+		problems = append(problems, "region must be specified")
+	}
+
+	if j.Bucket == "" {
+		problems = append(problems, "bucket must be specified")
+	}
+
+	if j.BucketDir != "" && !strings.HasSuffix(j.BucketDir, "/") {
+		problems = append(problems, "bucketdir must include the trailing slash")
+	}
+
+	if storage.IsFileURL(j.Bucket) {
+		validFileAction := false
+		for _, action := range FileActions {
+			if j.Action == action {
+				validFileAction = true
+			}
+		}
+		if validAction && !validFileAction {
+			problems = append(problems, fmt.Sprintf("action must be one of [%s] for a file:// bucket", strings.Join(FileActions, "|")))
+		}
+		if len(j.Destinations) > 0 {
+			problems = append(problems, "destinations are not supported for a file:// bucket")
+		}
+		if j.Endpoint != "" {
+			problems = append(problems, "endpoint must not be specified for a file:// bucket")
+		}
+	}
+
+	problems = append(problems, ValidateEndpoint(j.Endpoint, j.DisableSSL)...)
+	problems = append(problems, ValidateRole(j.RoleARN, j.ExternalID, j.RoleDuration)...)
+
+	if j.RoleARN != "" && storage.IsFileURL(j.Bucket) {
+		problems = append(problems, "rolearn must not be specified for a file:// bucket")
+	}
+
+	problems = append(problems, ValidateOwnerControls(j.BucketOwner, j.ACL)...)
+	problems = append(problems, j.ValidateChunked()...)
+	if (j.BucketOwner != "" || j.ACL != "") && storage.IsFileURL(j.Bucket) {
+		problems = append(problems, "expectedbucketowner and acl must not be specified for a file:// bucket")
+	}
+	if j.Replica.BucketOwner != "" && !accountIDPattern.MatchString(j.Replica.BucketOwner) {
+		problems = append(problems, "replica expectedbucketowner must be a 12 digit account id")
+	}
+
+	if j.Action == "backup" || j.Action == "upload" || j.Action == "download" {
+		if j.PathToFile == "" {
+			problems = append(problems, "pathtofile must be specified for action "+j.Action)
+		}
+		if j.S3FileName == "" {
+			problems = append(problems, "s3filename must be specified for action "+j.Action)
+		}
+	}
+
+	if (j.Action == "plan" || j.Action == "apply") && j.Plan == "" {
+		problems = append(problems, "plan must be specified for action "+j.Action)
+	}
+
+	if j.Action == "replicate" && j.Replica.Bucket == "" {
+		problems = append(problems, "replica bucket must be specified for action replicate")
+	}
+	if j.Replica.Bucket != "" && j.Replica.Bucket == j.Bucket {
+		problems = append(problems, "replica bucket must not be the same as bucket")
+	}
+	r := j.Replica
+	if r.DailyRetentionCount < 0 || r.DailyRetentionPeriod < 0 || r.WeeklyRetentionCount < 0 || r.WeeklyRetentionPeriod < 0 {
+		problems = append(problems, "replica retention settings must not be less than 0")
+	}
+
+	if len(j.Destinations) > 0 && j.Action != "backup" {
+		problems = append(problems, "destinations may only be specified for action backup")
+	}
+	names := make(map[string]bool)
+	for _, destination := range j.Destinations {
+		problems = append(problems, destination.Validate()...)
+		if names[destination.Name] {
+			problems = append(problems, fmt.Sprintf("destination name '%s' must be unique", destination.Name))
+		}
+		names[destination.Name] = true
+		if storage.IsFileURL(destination.Bucket) {
+			problems = append(problems, fmt.Sprintf("destination '%s' bucket must be an S3 bucket", destination.Name))
+		}
+		if destination.Bucket == j.Bucket && destination.BucketDir == j.BucketDir {
+			problems = append(problems, fmt.Sprintf("destination '%s' must not be the same bucket and bucketdir as the job", destination.Name))
+		}
+	}
+
+	c := j.Check
+	if c.WarnAge < 0 || c.CritAge < 0 || c.WarnSize < 0 || c.CritSize < 0 {
+		problems = append(problems, "check thresholds must not be less than 0")
+	}
+	if c.WarnAge > 0 && c.CritAge > 0 && c.WarnAge > c.CritAge {
+		problems = append(problems, "check warnage must not be greater than critage")
+	}
+	if c.WarnSize > 0 && c.CritSize > 0 && c.WarnSize < c.CritSize {
+		problems = append(problems, "check warnsize must not be less than critsize")
+	}
+
+	if j.Action != "download" && strings.Contains(j.S3FileName, "/") {
+		problems = append(problems, "s3filename must not contain '/', use bucketdir instead")
+	}
+
+	if j.Timeout < 0 {
+		problems = append(problems, "timeout must not be less than 0")
+	}
+
+	if j.ConcurrentWorkers < 1 {
+		problems = append(problems, "concurrentworkers must not be less than 1")
+	}
+
+	if j.PartSize < 5 {
+		problems = append(problems, "partsize must not be less than 5")
+	}
+
+	if j.MultipartMinAge < 0 {
+		problems = append(problems, "multipartminage must not be less than 0")
+	}
+
+	if j.Schedule != "" {
+		_, err := cron.ParseStandard(j.Schedule)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("schedule '%s' is invalid: %v", j.Schedule, err))
+		}
+	}
+
+	for _, hook := range []Hook{j.Hooks.PreBackup, j.Hooks.PostSuccess, j.Hooks.PostFailure, j.Hooks.PostRotation} {
+		if hook.Timeout < 0 {
+			problems = append(problems, "hook timeouts must not be less than 0")
+			break
+		}
+	}
+
+	if j.PushgatewayURL != "" && !strings.HasPrefix(j.PushgatewayURL, "http://") && !strings.HasPrefix(j.PushgatewayURL, "https://") {
+		problems = append(problems, "pushgatewayurl must start with http:// or https://")
+	}
+
+	for _, webhook := range j.Webhooks {
+		problems = append(problems, webhook.Validate()...)
+	}
+
+	if j.Email.Host != "" {
+		problems = append(problems, j.Email.Validate()...)
+	}
+
+	p := j.Policy
+	if p.DailyRetentionCount < 0 || p.WeeklyRetentionCount < 0 {
+		problems = append(problems, "policy retention counts must not be less than 0")
+	}
+
+	if p.DailyRetentionPeriod < 0 || p.WeeklyRetentionPeriod < 0 {
+		problems = append(problems, "policy retention periods must not be less than 0")
+	}
+
+	if p.MonthlyTransitionDays < 0 || p.MonthlyExpirationDays < 0 || p.AbortMultipartDays < 0 {
+		problems = append(problems, "policy lifecycle days must not be less than 0")
+	}
+
+	if p.MinKeep < 0 || p.MaxDeletes < 0 || p.MaxBackupAge < 0 {
+		problems = append(problems, "policy safeguards must not be less than 0")
+	}
+
+	return problems
+}
+
+// Validate returns every problem found with the destination
+func (d Destination) Validate() []string {
+	problems := []string{}
+
+	if d.Name == "" {
+		problems = append(problems, "destination name must be specified")
+	} else if !jobNamePattern.MatchString(d.Name) {
+		problems = append(problems, "destination name must only contain letters, numbers, '.', '_' and '-'")
+	}
+
+	if d.Bucket == "" {
+		problems = append(problems, fmt.Sprintf("destination '%s' bucket must be specified", d.Name))
+	}
+
+	if d.BucketDir != "" && !strings.HasSuffix(d.BucketDir, "/") {
+		problems = append(problems, fmt.Sprintf("destination '%s' bucketdir must include the trailing slash", d.Name))
+	}
+
+	if d.Mode != "" && d.Mode != DestinationAllOrNothing && d.Mode != DestinationBestEffort {
+		problems = append(problems, fmt.Sprintf("destination '%s' mode must be one of [%s|%s]", d.Name, DestinationAllOrNothing, DestinationBestEffort))
+	}
+
+	if d.DailyRetentionCount < 0 || d.DailyRetentionPeriod < 0 || d.WeeklyRetentionCount < 0 || d.WeeklyRetentionPeriod < 0 {
+		problems = append(problems, fmt.Sprintf("destination '%s' retention settings must not be less than 0", d.Name))
+	}
+
+	if d.BucketOwner != "" && !accountIDPattern.MatchString(d.BucketOwner) {
+		problems = append(problems, fmt.Sprintf("destination '%s' expectedbucketowner must be a 12 digit account id", d.Name))
+	}
+
+	return problems
+}
+
+// Validate returns every problem found with the webhook
+// The events and template are checked by the notify package once the job has been loaded
+func (w Webhook) Validate() []string {
+	problems := []string{}
+
+	if !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://") {
+		problems = append(problems, fmt.Sprintf("webhook url '%s' must start with http:// or https://", w.URL))
+	}
+
+	if w.Retries != nil && *w.Retries < 0 {
+		problems = append(problems, "webhook retries must not be less than 0")
+	}
+
+	if w.Timeout < 0 {
+		problems = append(problems, "webhook timeout must not be less than 0")
+	}
+
+	return problems
+}
+
+// ValidateEndpoint returns every problem found with the URL of an S3 compatible server
+// The scheme may be omitted in which case it is decided by disableSSL
+func ValidateEndpoint(endpoint string, disableSSL bool) []string {
+	problems := []string{}
+
+	if endpoint == "" {
+		return problems
+	}
+
+	rawURL := endpoint
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return append(problems, fmt.Sprintf("endpoint '%s' must be a URL such as https://minio.example.com:9000", endpoint))
+	}
+
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		problems = append(problems, fmt.Sprintf("endpoint '%s' must start with http:// or https://", endpoint))
+	}
+
+	if disableSSL && strings.HasPrefix(endpoint, "https://") {
+		problems = append(problems, fmt.Sprintf("endpoint '%s' must not start with https:// when disablessl is enabled", endpoint))
+	}
+
+	return problems
+}
+
+// ValidateRole returns every problem found with the role assumed by a job
+// The duration (seconds) must be within the limits of AssumeRole
+func ValidateRole(roleARN string, externalID string, duration int) []string {
+	problems := []string{}
+
+	if roleARN == "" {
+		if externalID != "" {
+			problems = append(problems, "externalid may only be specified with rolearn")
+		}
+		return problems
+	}
+
+	if !strings.HasPrefix(roleARN, "arn:") || !strings.Contains(roleARN, ":role/") {
+		problems = append(problems, fmt.Sprintf("rolearn '%s' must be the ARN of an IAM role, e.g. arn:aws:iam::123456789012:role/backup", roleARN))
+	}
+
+	if duration < 900 || duration > 43200 {
+		problems = append(problems, "roleduration must be between 900 and 43200 seconds")
+	}
+
+	return problems
+}
+
+// ValidateChunked returns every problem found with the chunked repository settings of the job
+// Actions which copy or upload whole files do not understand chunked backups and are not permitted
+func (j Job) ValidateChunked() []string {
+	problems := []string{}
+
+	if !j.Chunked {
+		return problems
+	}
+
+	if j.Action == "upload" || j.Action == "replicate" {
+		problems = append(problems, "chunked is not supported for action "+j.Action)
+	}
+
+	if storage.IsFileURL(j.Bucket) {
+		problems = append(problems, "chunked is not supported for a file:// bucket")
+	}
+
+	if j.Dedup {
+		problems = append(problems, "chunked and dedup must not both be enabled")
+	}
+
+	if len(j.Destinations) > 0 {
+		problems = append(problems, "chunked is not supported with destinations")
+	}
+
+	if j.ChunkGracePeriod < 1 {
+		problems = append(problems, "chunkgraceperiod must not be less than 1")
+	}
+
+	return problems
+}
+
+// ValidateOwnerControls returns every problem found with the expected bucket owner and canned ACL of a job
+func ValidateOwnerControls(expectedBucketOwner string, acl string) []string {
+	problems := []string{}
+
+	if expectedBucketOwner != "" && !accountIDPattern.MatchString(expectedBucketOwner) {
+		problems = append(problems, fmt.Sprintf("expectedbucketowner '%s' must be a 12 digit account id", expectedBucketOwner))
+	}
+
+	if acl != "" {
+		validACL := false
+		for _, objectACL := range s3client.ObjectACLs {
+			if acl == objectACL {
+				validACL = true
+			}
+		}
+		if !validACL {
+			problems = append(problems, fmt.Sprintf("acl must be one of [%s]", strings.Join(s3client.ObjectACLs, "|")))
+		}
+	}
+
+	return problems
+}
+
+// Validate returns every problem found with the email settings
+// When is checked by the notify package once the job has been loaded
+func (e Email) Validate() []string {
+	problems := []string{}
+
+	if e.Port < 1 || e.Port > 65535 {
+		problems = append(problems, "email port must be between 1 and 65535")
+	}
+
+	if e.Timeout < 0 {
+		problems = append(problems, "email timeout must not be less than 0")
+	}
+
+	if e.From == "" {
+		problems = append(problems, "email from must be specified")
+	}
+
+	if len(e.To) == 0 {
+		problems = append(problems, "email to must be specified")
+	}
+
+	return problems
+}
+
+// overlay applies the raw yaml on top of the values already set in the job
+func overlay(raw yaml.MapSlice, job *Job) error {
+	if raw == nil {
+		return nil
+	}
+
+	contents, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	return yaml.UnmarshalStrict(contents, job)
+}
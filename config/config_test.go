@@ -0,0 +1,468 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var base Job
+
+// Setup testing
+func init() {
+	base = Job{
+		Action:            "backup",
+		Profile:           "default",
+		Timeout:           3600,
+		ConcurrentWorkers: 5,
+		PartSize:          50,
+		Policy: Policy{
+			EnforceRetentionPeriod: true,
+			DailyRetentionCount:    6,
+			DailyRetentionPeriod:   168,
+			WeeklyRetentionCount:   4,
+			WeeklyRetentionPeriod:  672,
+		},
+	}
+}
+
+func TestLoadJobsWithDefaults(t *testing.T) {
+	contents := []byte(`
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  policy:
+    dailyretentioncount: 10
+jobs:
+  - name: postgres
+    pathtofile: /var/backups/postgres.tar
+    s3filename: postgres
+    bucketdir: databases/
+    policy:
+      weeklyretentioncount: 8
+  - name: configs
+    bucket: otherbucket
+    profile: configs
+    pathtofile: /var/backups/etc.tar
+    s3filename: etc
+`)
+
+	cfg, err := Load(contents, base, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to load config: %v", err))
+	}
+
+	if len(cfg.Jobs) != 2 {
+		t.Fatal(fmt.Sprintf("expected 2 jobs but got %d", len(cfg.Jobs)))
+	}
+
+	postgres, err := cfg.Job("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if postgres.Region != "us-east-1" || postgres.Bucket != "mybucket" || postgres.BucketDir != "databases/" {
+		t.Error(fmt.Sprintf("expected job to inherit defaults, got: %+v", postgres))
+	}
+
+	if postgres.Policy.DailyRetentionCount != 10 || postgres.Policy.WeeklyRetentionCount != 8 {
+		t.Error(fmt.Sprintf("expected policy to be layered on top of defaults, got: %+v", postgres.Policy))
+	}
+
+	if !postgres.Policy.EnforceRetentionPeriod || postgres.Policy.WeeklyRetentionPeriod != 672 {
+		t.Error(fmt.Sprintf("expected policy to inherit base values, got: %+v", postgres.Policy))
+	}
+
+	configs, err := cfg.Job("configs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if configs.Bucket != "otherbucket" || configs.Profile != "configs" || configs.BucketDir != "" {
+		t.Error(fmt.Sprintf("expected job settings to override defaults, got: %+v", configs))
+	}
+
+	if configs.Policy.WeeklyRetentionCount != 4 {
+		t.Error("expected settings of one job not to leak into another job")
+	}
+}
+
+func TestLoadAppliesOverridesLast(t *testing.T) {
+	contents := []byte(`
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  timeout: 600
+jobs:
+  - name: postgres
+    pathtofile: /var/backups/postgres.tar
+    s3filename: postgres
+    timeout: 1200
+`)
+
+	cfg, err := Load(contents, base, func(job *Job) {
+		job.Timeout = 7200
+		job.Policy.DailyRetentionCount = -1
+	})
+	if err == nil || !strings.Contains(err.Error(), "postgres") {
+		t.Error(fmt.Sprintf("expected the overrides to be validated, instead got: %v", err))
+	}
+
+	cfg, err = Load(contents, base, func(job *Job) { job.Timeout = 7200 })
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to load config: %v", err))
+	}
+
+	if cfg.Jobs[0].Timeout != 7200 {
+		t.Error(fmt.Sprintf("expected the overrides to take precedence over the job, instead got timeout: %d", cfg.Jobs[0].Timeout))
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	contents := []byte(`
+jobs:
+  - name: postgres
+    region: us-east-1
+    bucket: mybucket
+    pathtofile: /var/backups/postgres.tar
+    s3filename: postgres
+    retentioncount: 10
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil || !strings.Contains(err.Error(), "retentioncount") {
+		t.Error(fmt.Sprintf("expected error for unknown field, instead got: %v", err))
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	contents := []byte(`
+jobs:
+  - name: postgres
+    region: us-east-1
+    bucketdir: databases
+    s3filename: postgres
+  - name: postgres
+    action: explode
+    region: us-east-1
+    bucket: mybucket
+  - name: rotation
+    action: apply
+    region: us-east-1
+    bucket: mybucket
+  - name: offsite
+    action: replicate
+    region: us-east-1
+    bucket: mybucket
+  - name: freshness
+    action: check
+    region: us-east-1
+    bucket: mybucket
+    check:
+      warnage: 50
+      critage: 26
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	expectedProblems := []string{
+		"bucket must be specified",
+		"bucketdir must include the trailing slash",
+		"pathtofile must be specified",
+		"name is used by more than one job",
+		"action must be one of",
+		"plan must be specified for action apply",
+		"check warnage must not be greater than critage",
+		"replica bucket must be specified for action replicate",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+}
+
+func TestLoadValidatesWebhooks(t *testing.T) {
+	contents := []byte(`
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  pathtofile: /var/backups/postgres.tar
+  s3filename: postgres
+jobs:
+  - name: postgres
+    webhooks:
+      - url: hooks.example.com/backup
+        retries: -1
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	expectedProblems := []string{
+		"must start with http:// or https://",
+		"webhook retries must not be less than 0",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+}
+
+func TestLoadValidatesDestinations(t *testing.T) {
+	contents := []byte(`
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  pathtofile: /var/backups/postgres.tar
+  s3filename: postgres
+jobs:
+  - name: postgres
+    destinations:
+      - name: offsite
+        bucket: mybucket
+        mode: sometimes
+      - name: offsite
+        bucket: otherbucket
+        bucketdir: databases
+        dailyretentioncount: -1
+  - name: rotation
+    action: rotate
+    destinations:
+      - name: offsite
+        bucket: otherbucket
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	expectedProblems := []string{
+		"destination 'offsite' must not be the same bucket and bucketdir as the job",
+		"destination 'offsite' mode must be one of",
+		"destination name 'offsite' must be unique",
+		"destination 'offsite' bucketdir must include the trailing slash",
+		"destination 'offsite' retention settings must not be less than 0",
+		"destinations may only be specified for action backup",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+}
+
+func TestLoadValidatesFileBuckets(t *testing.T) {
+	contents := []byte(`
+defaults:
+  bucket: file:///mnt/backups
+  pathtofile: /var/backups/postgres.tar
+  s3filename: postgres
+jobs:
+  - name: nas
+  - name: nasreplica
+    action: replicate
+    replica:
+      bucket: myoffsitebucket
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	if strings.Contains(err.Error(), "region must be specified") {
+		t.Error(fmt.Sprintf("expected region not to be required for a file:// bucket, instead got: %v", err))
+	}
+
+	if !strings.Contains(err.Error(), "action must be one of [backup|upload|download|rotate|list] for a file:// bucket") {
+		t.Error(fmt.Sprintf("expected replicate to be rejected for a file:// bucket, instead got: %v", err))
+	}
+}
+
+func TestLoadValidatesEndpoints(t *testing.T) {
+	contents := []byte(`
+defaults:
+  bucket: mybucket
+  pathtofile: /var/backups/postgres.tar
+  s3filename: postgres
+jobs:
+  - name: minio
+    endpoint: minio.example.com:9000
+    forcepathstyle: true
+    disablessl: true
+  - name: ceph
+    endpoint: ftp://rgw.example.com
+  - name: insecure
+    endpoint: https://rgw.example.com
+    disablessl: true
+  - name: nas
+    bucket: file:///mnt/backups
+    endpoint: https://rgw.example.com
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	if strings.Contains(err.Error(), "minio") {
+		t.Error(fmt.Sprintf("expected endpoint without a region or scheme to be valid, instead got: %v", err))
+	}
+
+	expectedProblems := []string{
+		"endpoint 'ftp://rgw.example.com' must start with http:// or https://",
+		"endpoint 'https://rgw.example.com' must not start with https:// when disablessl is enabled",
+		"endpoint must not be specified for a file:// bucket",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+}
+
+func TestLoadValidatesRoles(t *testing.T) {
+	contents := []byte(`
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  pathtofile: /var/backups/postgres.tar
+  s3filename: postgres
+  roleduration: 3600
+jobs:
+  - name: central
+    rolearn: arn:aws:iam::123456789012:role/backup
+    externalid: workload-a
+  - name: user
+    rolearn: arn:aws:iam::123456789012:user/backup
+    roleduration: 60
+  - name: orphan
+    externalid: workload-b
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	if strings.Contains(err.Error(), "central") {
+		t.Error(fmt.Sprintf("expected role with an external id to be valid, instead got: %v", err))
+	}
+
+	expectedProblems := []string{
+		"rolearn 'arn:aws:iam::123456789012:user/backup' must be the ARN of an IAM role",
+		"roleduration must be between 900 and 43200 seconds",
+		"externalid may only be specified with rolearn",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+}
+
+func TestLoadValidatesOwnerControls(t *testing.T) {
+	contents := []byte(`
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  pathtofile: /var/backups/postgres.tar
+  s3filename: postgres
+jobs:
+  - name: central
+    expectedbucketowner: "123456789012"
+    acl: bucket-owner-full-control
+  - name: public
+    expectedbucketowner: "1234"
+    acl: public-read
+    destinations:
+      - name: offsite
+        bucket: otherbucket
+        expectedbucketowner: backup
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	if strings.Contains(err.Error(), "central") {
+		t.Error(fmt.Sprintf("expected owner controls to be valid, instead got: %v", err))
+	}
+
+	expectedProblems := []string{
+		"expectedbucketowner '1234' must be a 12 digit account id",
+		"acl must be one of [private|bucket-owner-read|bucket-owner-full-control]",
+		"destination 'offsite' expectedbucketowner must be a 12 digit account id",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+}
+
+func TestLoadValidatesChunked(t *testing.T) {
+	contents := []byte(`
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  pathtofile: /var/backups/postgres.tar
+  s3filename: postgres
+  chunked: true
+  chunkgraceperiod: 24
+jobs:
+  - name: postgres
+  - name: offsite
+    action: replicate
+    replica:
+      bucket: otherbucket
+  - name: dedup
+    dedup: true
+    chunkgraceperiod: 0
+`)
+
+	_, err := Load(contents, base, nil)
+	if err == nil {
+		t.Fatal("expected config to be invalid")
+	}
+
+	if strings.Contains(err.Error(), "postgres") {
+		t.Error(fmt.Sprintf("expected chunked backup to be valid, instead got: %v", err))
+	}
+
+	expectedProblems := []string{
+		"chunked is not supported for action replicate",
+		"chunked and dedup must not both be enabled",
+		"chunkgraceperiod must not be less than 1",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+}
+
+func TestJobNotFound(t *testing.T) {
+	cfg := Config{Jobs: []Job{{Name: "postgres"}}}
+
+	_, err := cfg.Job("mysql")
+	if err == nil {
+		t.Error("expected error when job does not exist")
+	}
+}
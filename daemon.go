@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/scheduler"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// runDaemon runs every job with a schedule until SIGTERM or SIGINT is received
+// Running jobs are allowed to finish before returning. A second signal exits immediately
+func runDaemon(jobs []args, arguments args) error {
+	statePath := arguments.StateFile
+	if statePath == "" {
+		statePath = arguments.Config + ".state"
+	}
+
+	log.Info.Printf("Starting daemon mode with state file: '%s'\n", statePath)
+
+	s, err := scheduler.New(statePath, arguments.Logger)
+	if err != nil {
+		return err
+	}
+
+	scheduledJobs := 0
+	for _, job := range jobs {
+		if job.Schedule == "" {
+			log.Warn.Printf("Job '%s' has no schedule and will not be run in daemon mode\n", job.JobName)
+			continue
+		}
+
+		job := job
+		err = s.Add(job.JobName, job.Schedule, func() error {
+			summary, err := runJob(job)
+			reportArgs := arguments
+			reportArgs.Report = getJobReportPath(arguments.Report, job.JobName)
+			writeReport(reportArgs, report.NewReport([]*report.Summary{summary}))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		scheduledJobs++
+	}
+
+	if scheduledJobs == 0 {
+		return report.WithExitCode(report.ExitValidation, errors.New("no jobs with a schedule found in config file"))
+	}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-signals
+		log.Info.Printf("Received signal: %v. Shutting down once running jobs have finished\n", sig)
+		cancelFn()
+
+		sig = <-signals
+		log.Error.Printf("Received signal: %v. Exiting without waiting for running jobs\n", sig)
+		os.Exit(1)
+	}()
+
+	s.Run(ctx)
+
+	log.Info.Println("Daemon mode stopped")
+
+	return nil
+}
+
+// getJobReportPath returns the path of the report of a job in daemon mode so that the report of one job does not replace another
+// The name of the job is added before the extension, i.e. /var/lib/report.json becomes /var/lib/report-postgres.json
+func getJobReportPath(path string, job string) string {
+	if path == "" || path == "-" {
+		return path
+	}
+	extension := filepath.Ext(path)
+	return strings.TrimSuffix(path, extension) + "-" + job + extension
+}
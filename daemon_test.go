@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetJobReportPath(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/var/lib/gos3gfsbackup/report.json", "/var/lib/gos3gfsbackup/report-postgres.json"},
+		{"/var/lib/gos3gfsbackup/report", "/var/lib/gos3gfsbackup/report-postgres"},
+		{"-", "-"},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		path := getJobReportPath(testCase.path, "postgres")
+		if path != testCase.expected {
+			t.Error(fmt.Sprintf("expected the report path of '%s' to be '%s', instead got '%s'", testCase.path, testCase.expected, path))
+		}
+	}
+}
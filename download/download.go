@@ -11,12 +11,9 @@ import (
 
 // DownloadFile downloads a file from s3 given a bucket and key
 func DownloadFile(svc *s3.S3, downloadObject DownloadObject) error {
+	logger := log.OrDefault(downloadObject.Logger)
 
-	log.Info.Println(`
-	######################################
-	#       File Download Started        #
-	######################################
-	`)
+	logger.Banner("File Download Started")
 
 	partSize := int64(downloadObject.PartSize * 1024 * 1024)
 
@@ -30,9 +27,9 @@ func DownloadFile(svc *s3.S3, downloadObject DownloadObject) error {
 		return err
 	}
 
-	log.Info.Println("Attempting to download file from S3: " + downloadObject.S3FileKey)
+	logger.Info.Println("Attempting to download file from S3: " + downloadObject.S3FileKey)
 
-	log.Info.Printf("Downloading is about to begin with a maximum of %d workers\n", downloadObject.NumWorkers)
+	logger.Info.Printf("Downloading is about to begin with a maximum of %d workers\n", downloadObject.NumWorkers)
 
 	startTime := time.Now()
 
@@ -43,14 +40,14 @@ func DownloadFile(svc *s3.S3, downloadObject DownloadObject) error {
 
 	elapsedTime := time.Since(startTime).Seconds()
 
-	log.Info.Printf("Total time spent processing download: %0.2f seconds\n", elapsedTime)
+	logger.Info.Printf("Total time spent processing download: %0.2f seconds\n", elapsedTime)
 
 	if err != nil {
-		log.Error.Printf("Failed to download '%s' from S3: %v\n", downloadObject.S3FileKey, err)
+		logger.Error.Printf("Failed to download '%s' from S3: %v\n", downloadObject.S3FileKey, err)
 		return err
 	}
 
-	log.Info.Printf("Downloading complete. '%s' has been written to '%s'", downloadObject.S3FileKey, downloadObject.DownloadLocation)
+	logger.Info.Printf("Downloading complete. '%s' has been written to '%s'", downloadObject.S3FileKey, downloadObject.DownloadLocation)
 
 	return nil
 
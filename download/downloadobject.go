@@ -1,5 +1,9 @@
 package download
 
+import (
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+)
+
 // DownloadObject represents an object to download from S3
 type DownloadObject struct {
 	DownloadLocation string
@@ -8,4 +12,5 @@ type DownloadObject struct {
 	BucketDir        string
 	NumWorkers       int
 	PartSize         int
+	Logger           *log.Logger // If nil then the default logger is used
 }
@@ -0,0 +1,49 @@
+package download
+
+import (
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"io"
+	"os"
+	"time"
+)
+
+// DownloadFileFromStore copies a key of a local directory store to the download location
+func DownloadFileFromStore(store *storage.FileStore, downloadObject DownloadObject) error {
+	logger := log.OrDefault(downloadObject.Logger)
+
+	logger.Banner("File Download Started")
+
+	source, err := store.Open(downloadObject.S3FileKey)
+	if err != nil {
+		logger.Error.Printf("Failed to open '%s' in '%s': %v\n", downloadObject.S3FileKey, store, err)
+		return err
+	}
+	defer source.Close()
+
+	file, err := os.Create(downloadObject.DownloadLocation)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	logger.Info.Printf("Attempting to download file from '%s': %s\n", store, downloadObject.S3FileKey)
+
+	startTime := time.Now()
+
+	_, err = io.Copy(file, source)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	logger.Info.Printf("Total time spent processing download: %0.2f seconds\n", time.Since(startTime).Seconds())
+
+	if err != nil {
+		logger.Error.Printf("Failed to download '%s': %v\n", downloadObject.S3FileKey, err)
+		return err
+	}
+
+	logger.Info.Printf("Downloading complete. '%s' has been written to '%s'", downloadObject.S3FileKey, downloadObject.DownloadLocation)
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package download
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadFileFromStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := storage.NewFileStore(storage.FileScheme + filepath.ToSlash(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put("databases/daily_postgres_20171107T020000", strings.NewReader("local backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location := filepath.Join(dir, "restored.tar")
+	err = DownloadFileFromStore(store, DownloadObject{DownloadLocation: location, S3FileKey: "databases/daily_postgres_20171107T020000"})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to download file from the store without any error: %v", err))
+	}
+
+	contents, err := ioutil.ReadFile(location)
+	if err != nil || string(contents) != "local backup" {
+		t.Error(fmt.Sprintf("expected the key to be written to the download location, instead got: '%s' %v", contents, err))
+	}
+
+	err = DownloadFileFromStore(store, DownloadObject{DownloadLocation: filepath.Join(dir, "missing.tar"), S3FileKey: "databases/missing"})
+	if err == nil {
+		t.Error("expected downloading a missing key to fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "missing.tar")); err == nil {
+		t.Error("expected nothing to be written when the key is missing")
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/config"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/rotate"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"github.com/daniel-cole/GoS3GFSBackup/upload"
+	"strings"
+	"time"
+)
+
+// primaryDestination is the name of the bucket of the job when it is uploaded to alongside other destinations
+const primaryDestination = "primary"
+
+// destination is an additional destination of the backup action along with its client and rotation policy
+type destination struct {
+	config.Destination
+	svc    *s3.S3
+	policy rpolicy.RotationPolicy
+	result *report.Destination // The outcome recorded in the summary of the run
+}
+
+// required returns true if the backup fails when the destination fails
+func (d destination) required() bool {
+	return d.Mode != config.DestinationBestEffort
+}
+
+// fanOutBackup uploads the file to the bucket of the job and every destination while reading it only once
+// Returns the key uploaded to the bucket of the job and the destinations uploaded to, which are to be rotated
+// A best-effort destination which fails is recorded as a warning, any other failure is returned as an error
+func fanOutBackup(svc *s3.S3, arguments args, uploadObject upload.UploadObject, prefix string, policy rpolicy.RotationPolicy,
+	summary *report.Summary) (string, []destination, error) {
+	logger := arguments.Logger
+
+	if uploadObject.Dedup {
+		logger.Warn.Println("Dedup is not supported when uploading to several destinations, the file will be uploaded")
+	}
+
+	destinations := getDestinations(arguments, policy, summary)
+
+	// The bucket of the job is always all-or-nothing and is the first result
+	uploadDestinations := []upload.Destination{{Name: primaryDestination, Svc: svc, Bucket: arguments.Bucket,
+		BucketDir: arguments.BucketDir, Required: true}}
+	for _, d := range destinations {
+		if d.svc == nil {
+			if d.required() {
+				return "", nil, fmt.Errorf("failed to create client for destination '%s'. Reason: %s", d.Name, d.result.Error)
+			}
+			continue
+		}
+		uploadDestinations = append(uploadDestinations, upload.Destination{Name: d.Name, Svc: d.svc, Bucket: d.Bucket,
+			BucketDir: d.BucketDir, Required: d.required()})
+	}
+
+	results, err := upload.FanOut(uploadObject, uploadDestinations, prefix, arguments.DryRun)
+	if len(results) == 0 {
+		return "", nil, err
+	}
+
+	uploaded := []destination{}
+	for _, result := range results[1:] {
+		for _, d := range destinations {
+			if d.Name != result.Name {
+				continue
+			}
+			d.result.Key = result.Key
+			if result.Err != nil {
+				d.result.Error = result.Err.Error()
+				if !d.required() {
+					summary.Warnings = append(summary.Warnings, fmt.Sprintf("upload to best-effort destination '%s' failed: %v", d.Name, result.Err))
+				}
+				continue
+			}
+			uploaded = append(uploaded, d)
+		}
+	}
+
+	key := results[0].Key
+	if err != nil {
+		return key, nil, err
+	}
+
+	return key, uploaded, nil
+}
+
+// getDestinations creates the client of every destination of the job and records each destination in the summary
+// The client of a destination which could not be created is nil and the reason is recorded in its result
+func getDestinations(arguments args, policy rpolicy.RotationPolicy, summary *report.Summary) []destination {
+	first := len(summary.Destinations)
+	for _, d := range arguments.Destinations {
+		summary.Destinations = append(summary.Destinations, report.Destination{Name: d.Name, Bucket: d.Bucket,
+			BucketDir: d.BucketDir, Mode: d.Mode, DeletedKeys: []string{}})
+	}
+
+	destinations := []destination{}
+	for i, d := range arguments.Destinations {
+		if d.Mode == "" {
+			d.Mode = config.DestinationAllOrNothing
+		}
+
+		dest := destination{Destination: d, policy: getDestinationRotationPolicy(d, policy), result: &summary.Destinations[first+i]}
+		dest.result.Mode = d.Mode
+
+		destArgs := getDestinationArgs(arguments, d)
+		svc, err := s3client.NewS3Client(getClientOptions(destArgs))
+		if err != nil {
+			arguments.Logger.Error.Printf("Failed to create client for destination '%s': %v\n", d.Name, err)
+			dest.result.Error = err.Error()
+			if !dest.required() {
+				summary.Warnings = append(summary.Warnings, fmt.Sprintf("skipped best-effort destination '%s' as its client could not be created: %v", d.Name, err))
+			}
+		} else {
+			dest.svc = svc
+		}
+
+		destinations = append(destinations, dest)
+	}
+
+	return destinations
+}
+
+// rotateDestinations rotates every destination the backup was uploaded to with its own policy
+// Each destination is rotated independently while holding the lock of its own series
+// A best-effort destination which fails to rotate is recorded as a warning, any other failure is returned as an error
+func rotateDestinations(destinations []destination, arguments args, summary *report.Summary) error {
+	failed := []string{}
+
+	for _, d := range destinations {
+		logger := arguments.Logger.With("destination", d.Name)
+
+		err := rotateDestination(d, arguments, logger)
+		if err == nil {
+			continue
+		}
+
+		logger.Error.Printf("Rotation of destination '%s' failed: %v\n", d.Name, err)
+		d.result.Error = fmt.Sprintf("rotation failed: %v", err)
+		if d.required() {
+			failed = append(failed, d.Name)
+		} else {
+			summary.Warnings = append(summary.Warnings, fmt.Sprintf("rotation of best-effort destination '%s' failed: %v", d.Name, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return report.WithExitCode(report.ExitRotationFailed, fmt.Errorf("rotation of destination(s) '%s' failed", strings.Join(failed, "', '")))
+	}
+
+	return nil
+}
+
+// rotateDestination takes the lock of the series in the destination and rotates it
+// Keys are not deleted once the lease on the lock is lost
+func rotateDestination(d destination, arguments args, logger *log.Logger) error {
+	destArgs := getDestinationArgs(arguments, d.Destination)
+	destArgs.Logger = logger
+
+	seriesLock, err := acquireLock(d.svc, destArgs)
+	if err != nil {
+		return err
+	}
+	defer releaseLock(seriesLock, logger)
+
+	rotation, err := rotate.RotateStore(seriesLock.Guard(storage.NewS3Store(d.svc, d.Bucket)), d.policy, arguments.DryRun, logger)
+	d.result.DeletedKeys = append(d.result.DeletedKeys, rotation.DeletedKeys...)
+	if err != nil {
+		return err
+	}
+	err = lockLost(seriesLock)
+	if err != nil {
+		return err
+	}
+	if len(rotation.Errors) > 0 {
+		return errors.New(strings.Join(rotation.Errors, "; "))
+	}
+
+	return nil
+}
+
+// withDestinationsError adds the error of the rotation of the destinations to the error of the rotation of the bucket
+// of the job. The exit code of the bucket of the job is kept if both failed
+func withDestinationsError(err error, destinationsErr error) error {
+	if err == nil {
+		return destinationsErr
+	}
+	if destinationsErr == nil {
+		return err
+	}
+	return report.WithExitCode(report.ExitCode(err), fmt.Errorf("%v. Also %v", err, destinationsErr))
+}
+
+// getDestinationArgs returns the arguments for the destination. The region, credential file, profile and expected bucket owner default to those of the job
+func getDestinationArgs(arguments args, d config.Destination) args {
+	destArgs := arguments
+	destArgs.Bucket = d.Bucket
+	destArgs.BucketDir = d.BucketDir
+	if d.Region != "" {
+		destArgs.Region = d.Region
+	}
+	if d.CredFile != "" {
+		destArgs.CredFile = d.CredFile
+	}
+	if d.Profile != "" {
+		destArgs.Profile = d.Profile
+	}
+	if d.BucketOwner != "" {
+		destArgs.ExpectedBucketOwner = d.BucketOwner
+	}
+	return destArgs
+}
+
+// getDestinationRotationPolicy returns the rotation policy of the destination. Retention settings which are not specified are those of the job
+// Only the series uploaded to the bucket dir of the destination is rotated
+func getDestinationRotationPolicy(d config.Destination, policy rpolicy.RotationPolicy) rpolicy.RotationPolicy {
+	policy.BucketDir = d.BucketDir
+	if d.DailyRetentionCount > 0 {
+		policy.DailyRetentionCount = d.DailyRetentionCount
+	}
+	if d.DailyRetentionPeriod > 0 {
+		policy.DailyRetentionPeriod = time.Hour * time.Duration(d.DailyRetentionPeriod)
+	}
+	if d.WeeklyRetentionCount > 0 {
+		policy.WeeklyRetentionCount = d.WeeklyRetentionCount
+	}
+	if d.WeeklyRetentionPeriod > 0 {
+		policy.WeeklyRetentionPeriod = time.Hour * time.Duration(d.WeeklyRetentionPeriod)
+	}
+	policy.PromoteMissed = false // The tier of the backup is decided by the bucket of the job
+	return policy
+}
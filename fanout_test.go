@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/config"
+	"github.com/daniel-cole/GoS3GFSBackup/lock"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateDestinationsIndependently(t *testing.T) {
+	server := s3test.NewServer("locked", "offsite")
+	defer server.Close()
+
+	// The series of the offsite destination is in its bucket dir. The keys in the root of the bucket belong to another series
+	keys := map[string][]string{
+		"locked":  {"daily_postgres_%d"},
+		"offsite": {"databases/daily_postgres_%d", "daily_postgres_%d"},
+	}
+	for bucket, formats := range keys {
+		for _, format := range formats {
+			for i := 0; i < 3; i++ {
+				server.PutObject(bucket, fmt.Sprintf(format, i), []byte("backup"))
+			}
+		}
+	}
+
+	// Another run holds the lock of the first destination so it must not be rotated
+	held, err := lock.Acquire(server.Client(), "locked", "", "other", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	arguments := getDefaultArgs()
+	arguments.Logger = log.OrDefault(nil)
+	policy := rpolicy.RotationPolicy{DailyPrefix: "daily_", DailyRetentionCount: 1, WeeklyPrefix: "weekly_", WeeklyRetentionCount: 1, S3FileName: "postgres"}
+	summary := report.New("postgres", "backup", "primary", "", false)
+	summary.Destinations = []report.Destination{{Name: "locked"}, {Name: "offsite"}}
+	destinations := []destination{}
+	for i, d := range []config.Destination{{Name: "locked", Bucket: "locked"}, {Name: "offsite", Bucket: "offsite", BucketDir: "databases/"}} {
+		destinations = append(destinations, destination{Destination: d, svc: server.Client(), policy: getDestinationRotationPolicy(d, policy),
+			result: &summary.Destinations[i]})
+	}
+
+	err = rotateDestinations(destinations, arguments, summary)
+	if err == nil || report.ExitCode(err) != report.ExitRotationFailed || !strings.Contains(err.Error(), "'locked'") {
+		t.Error(fmt.Sprintf("expected the rotation of the locked destination to fail, instead got: %v", err))
+	}
+
+	if len(server.Keys("locked")) != 4 { // 3 backups and the lock
+		t.Error(fmt.Sprintf("expected no keys to be deleted from the locked destination, instead found: %v", server.Keys("locked")))
+	}
+
+	expected := []string{"databases/daily_postgres_1", "databases/daily_postgres_0"}
+	if fmt.Sprint(summary.Destinations[1].DeletedKeys) != fmt.Sprint(expected) || summary.Destinations[1].Error != "" {
+		t.Error(fmt.Sprintf("expected the series in the bucket dir of the other destination to be rotated, instead got: %+v", summary.Destinations[1]))
+	}
+
+	if len(server.Keys("offsite")) != 4 {
+		t.Error(fmt.Sprintf("expected the keys of the other series to be kept, instead found: %v", server.Keys("offsite")))
+	}
+
+	if _, ok := server.Object("offsite", lock.Key("databases/")); ok {
+		t.Error("expected the lock of the rotated destination to be released")
+	}
+}
+
+func TestWithDestinationsError(t *testing.T) {
+	destinationsErr := report.WithExitCode(report.ExitRotationFailed, fmt.Errorf("rotation of destination(s) 'offsite' failed"))
+
+	if withDestinationsError(nil, nil) != nil {
+		t.Error("expected no error when every rotation succeeded")
+	}
+
+	if err := withDestinationsError(nil, destinationsErr); err != destinationsErr {
+		t.Error(fmt.Sprintf("expected the error of the destinations, instead got: %v", err))
+	}
+
+	err := withDestinationsError(report.WithExitCode(report.ExitMaxDeletes, fmt.Errorf("rotation refused")), destinationsErr)
+	if report.ExitCode(err) != report.ExitMaxDeletes || !strings.Contains(err.Error(), "offsite") {
+		t.Error(fmt.Sprintf("expected both errors with the exit code of the bucket of the job, instead got %d: %v", report.ExitCode(err), err))
+	}
+}
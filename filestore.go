@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/config"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"strings"
+)
+
+// runFileAction runs the action of the job against the local directory of its file:// bucket
+// The actions are those run against an S3 bucket, except that the series lock is not taken
+func runFileAction(arguments args, summary *report.Summary) error {
+	store, err := storage.NewFileStore(arguments.Bucket)
+	if err != nil {
+		return report.WithExitCode(report.ExitValidation, err)
+	}
+
+	switch arguments.Action {
+	case "backup":
+		return runBackupAction(store, arguments, summary)
+	case "upload":
+		return runUploadAction(store, arguments, summary)
+	case "download":
+		return runDownloadAction(store, arguments, summary)
+	case "rotate":
+		return runRotateAction(store, arguments, summary)
+	case "list":
+		return runListAction(store, arguments, summary)
+	default:
+		return report.WithExitCode(report.ExitValidation, fmt.Errorf("action %s is not supported for a file:// bucket, expected one of [%s]",
+			arguments.Action, strings.Join(config.FileActions, "|")))
+	}
+}
+
+// getS3Client returns the client of the store, nil if the store is a local directory
+func getS3Client(store storage.Store) *s3.S3 {
+	if s3Store, ok := store.(*storage.S3Store); ok {
+		return s3Store.Svc
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// getFileTestArgs returns the arguments of a job backing up a file to a new local directory bucket
+// The bucket already has 3 daily backups of the series
+func getFileTestArgs(t *testing.T) (args, *storage.FileStore, func()) {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "bucket")
+	err = os.Mkdir(root, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.NewFileStore(storage.FileScheme + filepath.ToSlash(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"daily_postgres_20170101T020000", "daily_postgres_20170102T020000", "daily_postgres_20170103T020000"} {
+		err = store.Put(key, strings.NewReader("old backup"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pathToFile := filepath.Join(dir, "postgres.sql")
+	err = ioutil.WriteFile(pathToFile, []byte("new backup"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arguments := getDefaultArgs()
+	arguments.Logger = log.OrDefault(nil)
+	arguments.Bucket = storage.FileScheme + filepath.ToSlash(root)
+	arguments.S3FileName = "postgres"
+	arguments.PathToFile = pathToFile
+	arguments.DailyRetentionCount = 2
+	arguments.EnforceRetentionPeriod = false
+
+	return arguments, store, func() { os.RemoveAll(dir) }
+}
+
+func TestRunFileActionBackupAndDownload(t *testing.T) {
+	arguments, store, cleanUp := getFileTestArgs(t)
+	defer cleanUp()
+
+	arguments.Action = "backup"
+	summary := report.New("postgres", arguments.Action, arguments.Bucket, "", false)
+	err := runFileAction(arguments, summary)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to back up to the local directory without any error: %v", err))
+	}
+
+	if !strings.Contains(summary.Key, "_postgres_") {
+		t.Error(fmt.Sprintf("expected the key of the backup to be recorded, instead got: '%s'", summary.Key))
+	}
+
+	// The new backup is only a daily backup if it is not taken on a Monday or the 1st
+	expected := []string{"daily_postgres_20170101T020000"}
+	if summary.Tier == "daily" {
+		expected = []string{"daily_postgres_20170102T020000", "daily_postgres_20170101T020000"}
+	}
+	if !reflect.DeepEqual(summary.DeletedKeys, expected) {
+		t.Error(fmt.Sprintf("expected %v to be rotated, instead deleted: %v", expected, summary.DeletedKeys))
+	}
+
+	keys, _ := store.List("daily_postgres_20170101T020000")
+	if len(keys) != 0 {
+		t.Error("expected the rotated backup to be deleted from the local directory")
+	}
+
+	arguments.Action = "download"
+	arguments.S3FileName = summary.Key
+	arguments.PathToFile = arguments.PathToFile + ".restored"
+	err = runFileAction(arguments, report.New("postgres", arguments.Action, arguments.Bucket, "", false))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to download from the local directory without any error: %v", err))
+	}
+
+	contents, err := ioutil.ReadFile(arguments.PathToFile)
+	if err != nil || string(contents) != "new backup" {
+		t.Error(fmt.Sprintf("expected the backup to be downloaded, instead got: '%s' %v", contents, err))
+	}
+}
+
+func TestRunFileActionRotate(t *testing.T) {
+	arguments, store, cleanUp := getFileTestArgs(t)
+	defer cleanUp()
+
+	arguments.Action = "rotate"
+	summary := report.New("postgres", arguments.Action, arguments.Bucket, "", false)
+	err := runFileAction(arguments, summary)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to rotate the local directory without any error: %v", err))
+	}
+
+	keys, _ := store.List("daily_")
+	if len(keys) != 2 || !reflect.DeepEqual(summary.DeletedKeys, []string{"daily_postgres_20170101T020000"}) {
+		t.Error(fmt.Sprintf("expected the newest 2 daily backups to be kept, instead deleted: %v", summary.DeletedKeys))
+	}
+}
+
+func TestRunFileActionUnsupported(t *testing.T) {
+	arguments, _, cleanUp := getFileTestArgs(t)
+	defer cleanUp()
+
+	arguments.Action = "replicate"
+	err := runFileAction(arguments, report.New("postgres", arguments.Action, arguments.Bucket, "", false))
+	if report.ExitCode(err) != report.ExitValidation {
+		t.Error(fmt.Sprintf("expected an action which is not supported for a local directory to be rejected, instead got: %v", err))
+	}
+}
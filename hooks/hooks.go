@@ -0,0 +1,124 @@
+package hooks
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook is a shell command run at a particular point of a backup
+type Hook struct {
+	Name    string // i.e. pre-backup, post-success, post-failure, post-rotation
+	Command string
+	Timeout time.Duration
+}
+
+// Env describes the run to a hook. It is passed to the hook command as GOS3GFSBACKUP_* environment variables
+type Env struct {
+	Job         string
+	Action      string
+	Bucket      string
+	BucketDir   string
+	PathToFile  string
+	Key         string
+	Size        int64
+	Tier        string
+	DeletedKeys []string
+	DryRun      bool
+	Err         error
+}
+
+// Run executes the hook command with 'sh -c' and waits for it to finish
+// Returns an error if the command exits with a non-zero status or does not finish within the timeout
+// If the hook has no command then nothing is run. If logger is nil then the default logger is used
+func Run(hook Hook, env Env, logger *log.Logger) error {
+	if hook.Command == "" {
+		return nil
+	}
+
+	logger = log.OrDefault(logger).With("hook", hook.Name)
+	logger.Info.Printf("Running %s hook: '%s'\n", hook.Name, hook.Command)
+
+	var output bytes.Buffer
+	cmd := exec.Command("sh", "-c", hook.Command)
+	cmd.Env = append(os.Environ(), env.Vars(hook.Name)...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	setProcessGroup(cmd)
+
+	startTime := time.Now()
+	err := cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start %s hook: %v", hook.Name, err)
+	}
+
+	doneCh := make(chan error, 1)
+	go func() {
+		doneCh <- cmd.Wait()
+	}()
+
+	timedOut := false
+	var timeoutCh <-chan time.Time
+	if hook.Timeout > 0 {
+		timer := time.NewTimer(hook.Timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	select {
+	case err = <-doneCh:
+	case <-timeoutCh:
+		// Kill everything started by the hook, otherwise a child still holding the output open would block Wait
+		timedOut = true
+		killProcessGroup(cmd)
+		err = <-doneCh
+	}
+	elapsedTime := time.Since(startTime).Seconds()
+
+	for _, line := range strings.Split(strings.TrimRight(output.String(), "\n"), "\n") {
+		if line != "" {
+			logger.Info.Printf("[%s hook] %s\n", hook.Name, line)
+		}
+	}
+
+	if timedOut {
+		return fmt.Errorf("%s hook did not finish within %0.0f seconds", hook.Name, hook.Timeout.Seconds())
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s hook failed after %0.2f seconds: %v", hook.Name, elapsedTime, err)
+	}
+
+	logger.Info.Printf("%s hook completed in %0.2f seconds\n", hook.Name, elapsedTime)
+
+	return nil
+}
+
+// Vars returns the environment variables describing the run
+func (e Env) Vars(hookName string) []string {
+	errorMessage := ""
+	if e.Err != nil {
+		errorMessage = e.Err.Error()
+	}
+
+	return []string{
+		"GOS3GFSBACKUP_HOOK=" + hookName,
+		"GOS3GFSBACKUP_JOB=" + e.Job,
+		"GOS3GFSBACKUP_ACTION=" + e.Action,
+		"GOS3GFSBACKUP_BUCKET=" + e.Bucket,
+		"GOS3GFSBACKUP_BUCKET_DIR=" + e.BucketDir,
+		"GOS3GFSBACKUP_PATH_TO_FILE=" + e.PathToFile,
+		"GOS3GFSBACKUP_KEY=" + e.Key,
+		"GOS3GFSBACKUP_SIZE=" + strconv.FormatInt(e.Size, 10),
+		"GOS3GFSBACKUP_TIER=" + e.Tier,
+		"GOS3GFSBACKUP_DELETED_KEYS=" + strings.Join(e.DeletedKeys, "\n"),
+		"GOS3GFSBACKUP_DELETED_COUNT=" + strconv.Itoa(len(e.DeletedKeys)),
+		"GOS3GFSBACKUP_DRY_RUN=" + strconv.FormatBool(e.DryRun),
+		"GOS3GFSBACKUP_ERROR=" + errorMessage,
+	}
+}
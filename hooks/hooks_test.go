@@ -0,0 +1,66 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+func TestRunPassesEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outputPath := filepath.Join(dir, "output")
+	hook := Hook{Name: "post-failure", Command: "echo \"$GOS3GFSBACKUP_KEY $GOS3GFSBACKUP_DELETED_COUNT $GOS3GFSBACKUP_ERROR\" > " + outputPath}
+	env := Env{Key: "daily_postgres_2017-09-18", DeletedKeys: []string{"a", "b"}, Err: errors.New("upload failed")}
+
+	err = Run(hook, env, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "daily_postgres_2017-09-18 2 upload failed"
+	if strings.TrimSpace(string(contents)) != expected {
+		t.Error(fmt.Sprintf("expected hook output '%s' but got '%s'", expected, contents))
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	err := Run(Hook{Name: "pre-backup", Command: "exit 3"}, Env{}, nil)
+	if err == nil {
+		t.Error("expected error when the hook exits with a non-zero status")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	err := Run(Hook{Name: "pre-backup", Command: "sleep 5", Timeout: time.Millisecond * 100}, Env{}, nil)
+	if err == nil {
+		t.Error("expected error when the hook does not finish within the timeout")
+	}
+}
+
+func TestRunEmptyCommand(t *testing.T) {
+	err := Run(Hook{Name: "pre-backup"}, Env{}, nil)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected no error for an empty hook but got %v", err))
+	}
+}
@@ -0,0 +1,15 @@
+//go:build windows
+// +build windows
+
+package hooks
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/config"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// getJobs returns the arguments for every job that should be run
+// If no config file has been specified then the command line arguments are treated as a single job
+func getJobs(arguments args) ([]args, error) {
+	webhooks, err := getCLIWebhooks(arguments)
+	if err != nil {
+		return nil, err
+	}
+	arguments.Webhooks = webhooks
+
+	if arguments.SMTPPassword == "" {
+		arguments.SMTPPassword = os.Getenv("GOS3GFSBACKUP_SMTP_PASSWORD")
+	}
+
+	if arguments.Config == "" {
+		if arguments.Job != "" || arguments.All || arguments.Daemon {
+			return nil, errors.New("--job, --all and --daemon may only be specified with --config")
+		}
+		if arguments.Region == "" && arguments.Endpoint == "" && !storage.IsFileURL(arguments.Bucket) {
+			return nil, errors.New("--region is required")
+		}
+		if arguments.Bucket == "" {
+			return nil, errors.New("--bucket is required")
+		}
+		if !validAction(arguments.Action) {
+			return nil, fmt.Errorf("--action must be one of [%s]", strings.Join(config.Actions, "|"))
+		}
+		if (arguments.Action == "plan" || arguments.Action == "apply") && arguments.Plan == "" {
+			return nil, errors.New("--plan is required for action " + arguments.Action)
+		}
+		if arguments.Action == "replicate" && arguments.ReplicaBucket == "" {
+			return nil, errors.New("--replicabucket is required for action replicate")
+		}
+		if arguments.ReplicaBucket != "" && arguments.ReplicaBucket == arguments.Bucket {
+			return nil, errors.New("--replicabucket must not be the same as --bucket")
+		}
+		problems := config.ValidateEndpoint(arguments.Endpoint, arguments.DisableSSL)
+		problems = append(problems, config.ValidateRole(arguments.RoleARN, arguments.ExternalID, arguments.RoleDuration)...)
+		problems = append(problems, config.ValidateOwnerControls(arguments.ExpectedBucketOwner, arguments.ACL)...)
+		problems = append(problems, config.ValidateOwnerControls(arguments.ReplicaBucketOwner, "")...)
+		problems = append(problems, getBaseJob(arguments).ValidateChunked()...)
+		for _, webhook := range arguments.Webhooks {
+			problems = append(problems, webhook.Validate()...)
+		}
+		if arguments.SMTPHost != "" {
+			problems = append(problems, getBaseJob(arguments).Email.Validate()...)
+		}
+		problems = append(problems, validateNotifications(arguments)...)
+		if len(problems) > 0 {
+			return nil, errors.New(strings.Join(problems, ", "))
+		}
+		return []args{arguments}, nil
+	}
+
+	if arguments.Job == "" && !arguments.All && !arguments.Daemon {
+		return nil, errors.New("either --job or --all must be specified with --config")
+	}
+
+	if arguments.Job != "" && arguments.All {
+		return nil, errors.New("--job and --all must not be specified together")
+	}
+
+	// Jobs are layered as built-in defaults < config defaults < job < arguments given on the command line
+	base := arguments
+	copyArgs(&base, getDefaultArgs(), arguments.Explicit)
+
+	cfg, err := config.LoadFile(arguments.Config, getBaseJob(base), func(job *config.Job) {
+		jobArgs := getJobArgs(*job, arguments)
+		copyArgs(&jobArgs, arguments, arguments.Explicit)
+		if arguments.WebhookURL != "" && arguments.Explicit["webhookurl"] {
+			jobArgs.Webhooks = arguments.Webhooks
+		}
+		*job = getBaseJob(jobArgs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	for _, job := range cfg.Jobs {
+		for _, problem := range validateNotifications(getJobArgs(job, arguments)) {
+			problems = append(problems, fmt.Sprintf("job '%s': %s", job.Name, problem))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("invalid config:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+
+	selectedJobs := cfg.Jobs
+	if arguments.Job != "" {
+		job, err := cfg.Job(arguments.Job)
+		if err != nil {
+			return nil, err
+		}
+		selectedJobs = []config.Job{job}
+	}
+
+	jobs := []args{}
+	for _, job := range selectedJobs {
+		jobs = append(jobs, getJobArgs(job, arguments))
+	}
+
+	return jobs, nil
+}
+
+// getExplicitArgs returns the names of the arguments given on the command line, i.e. 'bucket' for '--bucket=mybucket'
+func getExplicitArgs(commandLine []string) map[string]bool {
+	explicit := make(map[string]bool)
+	for _, argument := range commandLine {
+		if argument == "--" {
+			break
+		}
+		if strings.HasPrefix(argument, "--") {
+			explicit[strings.ToLower(strings.SplitN(argument[2:], "=", 2)[0])] = true
+		}
+	}
+	return explicit
+}
+
+// copyArgs copies the named arguments from source to target. Arguments are named in the same way as on the
+// command line, i.e. the lower case name of the field
+func copyArgs(target *args, source args, names map[string]bool) {
+	targetValue := reflect.ValueOf(target).Elem()
+	sourceValue := reflect.ValueOf(source)
+	for i := 0; i < targetValue.NumField(); i++ {
+		field := targetValue.Type().Field(i)
+		if field.Tag.Get("arg") != "-" && names[strings.ToLower(field.Name)] {
+			targetValue.Field(i).Set(sourceValue.Field(i))
+		}
+	}
+}
+
+// validAction returns true if the action is one of the actions a job is permitted to run
+func validAction(action string) bool {
+	for _, validAction := range config.Actions {
+		if action == validAction {
+			return true
+		}
+	}
+	return false
+}
+
+// validateNotifications returns every problem found with the webhooks and email of the job
+// which can only be checked once they have been converted for the notify package
+func validateNotifications(arguments args) []string {
+	problems := []string{}
+	for _, webhook := range getWebhooks(arguments) {
+		problems = append(problems, webhook.Validate()...)
+	}
+	if arguments.SMTPHost != "" {
+		problems = append(problems, getEmail(arguments).Validate()...)
+	}
+	return problems
+}
+
+// getCLIWebhooks returns the webhook specified by the command line arguments, if any
+func getCLIWebhooks(arguments args) ([]config.Webhook, error) {
+	if arguments.WebhookURL == "" {
+		return nil, nil
+	}
+
+	headers := make(map[string]string)
+	for _, header := range arguments.WebhookHeaders {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid webhook header '%s', expected 'Name: value'", header)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	secret := arguments.WebhookSecret
+	if secret == "" {
+		secret = os.Getenv("GOS3GFSBACKUP_WEBHOOK_SECRET")
+	}
+
+	return []config.Webhook{{
+		URL:      arguments.WebhookURL,
+		Headers:  headers,
+		Secret:   secret,
+		Template: arguments.WebhookTemplate,
+		Events:   arguments.WebhookEvents,
+	}}, nil
+}
+
+// getBaseJob returns a job built from the arguments
+// This is used as the starting point for every job in the config file
+func getBaseJob(arguments args) config.Job {
+	return config.Job{
+		Name:              arguments.JobName,
+		Action:            arguments.Action,
+		Region:            arguments.Region,
+		Bucket:            arguments.Bucket,
+		BucketDir:         arguments.BucketDir,
+		CredFile:          arguments.CredFile,
+		Profile:           arguments.Profile,
+		Endpoint:          arguments.Endpoint,
+		ForcePathStyle:    arguments.ForcePathStyle,
+		DisableSSL:        arguments.DisableSSL,
+		CABundle:          arguments.CABundle,
+		RoleARN:           arguments.RoleARN,
+		ExternalID:        arguments.ExternalID,
+		RoleDuration:      arguments.RoleDuration,
+		BucketOwner:       arguments.ExpectedBucketOwner,
+		ACL:               arguments.ACL,
+		PathToFile:        arguments.PathToFile,
+		S3FileName:        arguments.S3FileName,
+		Timeout:           arguments.Timeout,
+		ConcurrentWorkers: arguments.ConcurrentWorkers,
+		PartSize:          arguments.PartSize,
+		Dedup:             arguments.Dedup,
+		Chunked:           arguments.Chunked,
+		ChunkGracePeriod:  arguments.ChunkGracePeriod,
+		MultipartMinAge:   arguments.MultipartMinAge,
+		Schedule:          arguments.Schedule,
+		MetricsFile:       arguments.MetricsFile,
+		PushgatewayURL:    arguments.PushgatewayURL,
+		Plan:              arguments.Plan,
+		Replica: config.Replica{
+			Bucket:                arguments.ReplicaBucket,
+			Region:                arguments.ReplicaRegion,
+			CredFile:              arguments.ReplicaCredFile,
+			Profile:               arguments.ReplicaProfile,
+			BucketOwner:           arguments.ReplicaBucketOwner,
+			DailyRetentionCount:   arguments.ReplicaDailyCount,
+			DailyRetentionPeriod:  arguments.ReplicaDailyPeriod,
+			WeeklyRetentionCount:  arguments.ReplicaWeeklyCount,
+			WeeklyRetentionPeriod: arguments.ReplicaWeeklyPeriod,
+		},
+		Destinations: arguments.Destinations,
+		Check: config.Check{
+			WarnAge:  arguments.WarnAge,
+			CritAge:  arguments.CritAge,
+			WarnSize: arguments.WarnSize,
+			CritSize: arguments.CritSize,
+			Tiers:    arguments.CheckTiers,
+		},
+		Webhooks: arguments.Webhooks,
+		Email: config.Email{
+			Host:     arguments.SMTPHost,
+			Port:     arguments.SMTPPort,
+			Username: arguments.SMTPUsername,
+			Password: arguments.SMTPPassword,
+			StartTLS: arguments.SMTPStartTLS,
+			Timeout:  arguments.SMTPTimeout,
+			From:     arguments.MailFrom,
+			To:       arguments.MailTo,
+			When:     arguments.MailWhen,
+		},
+		Hooks: config.Hooks{
+			PreBackup:    config.Hook{Command: arguments.PreBackupHook, Timeout: arguments.PreBackupHookTimeout},
+			PostSuccess:  config.Hook{Command: arguments.PostSuccessHook, Timeout: arguments.PostSuccessHookTimeout},
+			PostFailure:  config.Hook{Command: arguments.PostFailureHook, Timeout: arguments.PostFailureHookTimeout},
+			PostRotation: config.Hook{Command: arguments.PostRotationHook, Timeout: arguments.PostRotationHookTimeout},
+		},
+		Policy: config.Policy{
+			EnforceRetentionPeriod: arguments.EnforceRetentionPeriod,
+			DailyRetentionCount:    arguments.DailyRetentionCount,
+			DailyRetentionPeriod:   arguments.DailyRetentionPeriod,
+			WeeklyRetentionCount:   arguments.WeeklyRetentionCount,
+			WeeklyRetentionPeriod:  arguments.WeeklyRetentionPeriod,
+			MonthlyTransitionDays:  arguments.MonthlyTransitionDays,
+			MonthlyStorageClass:    arguments.MonthlyStorageClass,
+			MonthlyExpirationDays:  arguments.MonthlyExpirationDays,
+			AbortMultipartDays:     arguments.AbortMultipartDays,
+			MinKeep:                arguments.MinKeep,
+			MaxDeletes:             arguments.MaxDeletes,
+			MaxBackupAge:           arguments.MaxBackupAge,
+			Promote:                arguments.Promote,
+		},
+	}
+}
+
+// getJobArgs returns the arguments required to run a job from the config file
+// Dry run is always taken from the command line so that any job can be tested without editing the config file
+func getJobArgs(job config.Job, arguments args) args {
+	jobArgs := arguments
+
+	jobArgs.JobName = job.Name
+	jobArgs.Action = job.Action
+	jobArgs.Region = job.Region
+	jobArgs.Bucket = job.Bucket
+	jobArgs.BucketDir = job.BucketDir
+	jobArgs.CredFile = job.CredFile
+	jobArgs.Profile = job.Profile
+	jobArgs.Endpoint = job.Endpoint
+	jobArgs.ForcePathStyle = job.ForcePathStyle
+	jobArgs.DisableSSL = job.DisableSSL
+	jobArgs.CABundle = job.CABundle
+	jobArgs.RoleARN = job.RoleARN
+	jobArgs.ExternalID = job.ExternalID
+	jobArgs.RoleDuration = job.RoleDuration
+	jobArgs.ExpectedBucketOwner = job.BucketOwner
+	jobArgs.ACL = job.ACL
+	jobArgs.PathToFile = job.PathToFile
+	jobArgs.S3FileName = job.S3FileName
+	jobArgs.Timeout = job.Timeout
+	jobArgs.ConcurrentWorkers = job.ConcurrentWorkers
+	jobArgs.PartSize = job.PartSize
+	jobArgs.Dedup = job.Dedup
+	jobArgs.Chunked = job.Chunked
+	jobArgs.ChunkGracePeriod = job.ChunkGracePeriod
+	jobArgs.MultipartMinAge = job.MultipartMinAge
+	jobArgs.Schedule = job.Schedule
+	jobArgs.MetricsFile = job.MetricsFile
+	jobArgs.PushgatewayURL = job.PushgatewayURL
+	jobArgs.Plan = job.Plan
+	jobArgs.ReplicaBucket = job.Replica.Bucket
+	jobArgs.ReplicaRegion = job.Replica.Region
+	jobArgs.ReplicaCredFile = job.Replica.CredFile
+	jobArgs.ReplicaProfile = job.Replica.Profile
+	jobArgs.ReplicaBucketOwner = job.Replica.BucketOwner
+	jobArgs.ReplicaDailyCount = job.Replica.DailyRetentionCount
+	jobArgs.ReplicaDailyPeriod = job.Replica.DailyRetentionPeriod
+	jobArgs.ReplicaWeeklyCount = job.Replica.WeeklyRetentionCount
+	jobArgs.ReplicaWeeklyPeriod = job.Replica.WeeklyRetentionPeriod
+	jobArgs.Destinations = job.Destinations
+	jobArgs.WarnAge = job.Check.WarnAge
+	jobArgs.CritAge = job.Check.CritAge
+	jobArgs.WarnSize = job.Check.WarnSize
+	jobArgs.CritSize = job.Check.CritSize
+	jobArgs.CheckTiers = job.Check.Tiers
+	jobArgs.Webhooks = job.Webhooks
+
+	jobArgs.SMTPHost = job.Email.Host
+	jobArgs.SMTPPort = job.Email.Port
+	jobArgs.SMTPUsername = job.Email.Username
+	jobArgs.SMTPPassword = job.Email.Password
+	jobArgs.SMTPStartTLS = job.Email.StartTLS
+	jobArgs.SMTPTimeout = job.Email.Timeout
+	jobArgs.MailFrom = job.Email.From
+	jobArgs.MailTo = job.Email.To
+	jobArgs.MailWhen = job.Email.When
+
+	jobArgs.PreBackupHook = job.Hooks.PreBackup.Command
+	jobArgs.PreBackupHookTimeout = job.Hooks.PreBackup.Timeout
+	jobArgs.PostSuccessHook = job.Hooks.PostSuccess.Command
+	jobArgs.PostSuccessHookTimeout = job.Hooks.PostSuccess.Timeout
+	jobArgs.PostFailureHook = job.Hooks.PostFailure.Command
+	jobArgs.PostFailureHookTimeout = job.Hooks.PostFailure.Timeout
+	jobArgs.PostRotationHook = job.Hooks.PostRotation.Command
+	jobArgs.PostRotationHookTimeout = job.Hooks.PostRotation.Timeout
+
+	jobArgs.EnforceRetentionPeriod = job.Policy.EnforceRetentionPeriod
+	jobArgs.DailyRetentionCount = job.Policy.DailyRetentionCount
+	jobArgs.DailyRetentionPeriod = job.Policy.DailyRetentionPeriod
+	jobArgs.WeeklyRetentionCount = job.Policy.WeeklyRetentionCount
+	jobArgs.WeeklyRetentionPeriod = job.Policy.WeeklyRetentionPeriod
+	jobArgs.MonthlyTransitionDays = job.Policy.MonthlyTransitionDays
+	jobArgs.MonthlyStorageClass = job.Policy.MonthlyStorageClass
+	jobArgs.MonthlyExpirationDays = job.Policy.MonthlyExpirationDays
+	jobArgs.AbortMultipartDays = job.Policy.AbortMultipartDays
+	jobArgs.MinKeep = job.Policy.MinKeep
+	jobArgs.MaxDeletes = job.Policy.MaxDeletes
+	jobArgs.MaxBackupAge = job.Policy.MaxBackupAge
+	jobArgs.Promote = job.Policy.Promote
+
+	return jobArgs
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+// parseTestArgs parses the command line in the same way as main
+func parseTestArgs(t *testing.T, commandLine ...string) args {
+	osArgs := os.Args
+	defer func() { os.Args = osArgs }()
+	os.Args = append([]string{"GoS3GFSBackup"}, commandLine...)
+
+	arguments := getDefaultArgs()
+	err := arg.Parse(&arguments)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to parse arguments %v: %v", commandLine, err))
+	}
+	arguments.Explicit = getExplicitArgs(commandLine)
+
+	return arguments
+}
+
+// writeTestConfig writes the contents to a config file in a temporary directory
+func writeTestConfig(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "config.yml")
+	err = ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestGetJobsLayersArguments(t *testing.T) {
+	path := writeTestConfig(t, `
+defaults:
+  region: us-east-1
+  bucket: mybucket
+  timeout: 600
+  policy:
+    dailyretentioncount: 10
+    weeklyretentioncount: 8
+jobs:
+  - name: postgres
+    pathtofile: /var/backups/postgres.tar
+    s3filename: postgres
+    timeout: 1200
+    policy:
+      dailyretentioncount: 12
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	testCases := []struct {
+		name        string
+		commandLine []string
+		timeout     int
+		dailyCount  int
+		weeklyCount int
+		enforce     bool
+	}{
+		{"config file", []string{}, 1200, 12, 8, true},
+		{"explicit arguments", []string{"--timeout", "7200", "--dailyretentioncount=2", "--enforceretentionperiod=false"}, 7200, 2, 8, false},
+		{"explicit default values", []string{"--timeout=3600", "--weeklyretentioncount=4"}, 3600, 12, 4, true},
+	}
+
+	for _, testCase := range testCases {
+		commandLine := append([]string{"--action=backup", "--config=" + path, "--job=postgres"}, testCase.commandLine...)
+		jobs, err := getJobs(parseTestArgs(t, commandLine...))
+		if err != nil {
+			t.Fatal(fmt.Sprintf("%s: expected to get jobs without any error: %v", testCase.name, err))
+		}
+
+		job := jobs[0]
+		if job.JobName != "postgres" || job.Bucket != "mybucket" || job.MonthlyStorageClass != "GLACIER" {
+			t.Error(fmt.Sprintf("%s: expected the job to be built from the config file and built-in defaults, instead got: %+v", testCase.name, job))
+		}
+
+		if job.Timeout != testCase.timeout || job.DailyRetentionCount != testCase.dailyCount ||
+			job.WeeklyRetentionCount != testCase.weeklyCount || job.EnforceRetentionPeriod != testCase.enforce {
+			t.Error(fmt.Sprintf("%s: expected timeout %d, daily count %d, weekly count %d and enforce %t, instead got %d, %d, %d and %t",
+				testCase.name, testCase.timeout, testCase.dailyCount, testCase.weeklyCount, testCase.enforce,
+				job.Timeout, job.DailyRetentionCount, job.WeeklyRetentionCount, job.EnforceRetentionPeriod))
+		}
+	}
+}
+
+func TestGetJobsValidatesExplicitArguments(t *testing.T) {
+	path := writeTestConfig(t, `
+jobs:
+  - name: postgres
+    pathtofile: /var/backups/postgres.tar
+    s3filename: postgres
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	// The region and bucket are only given on the command line
+	_, err := getJobs(parseTestArgs(t, "--action=backup", "--config="+path, "--all", "--region=us-east-1", "--bucket=mybucket"))
+	if err != nil {
+		t.Error(fmt.Sprintf("expected arguments given on the command line to complete the job, instead got: %v", err))
+	}
+
+	_, err = getJobs(parseTestArgs(t, "--action=backup", "--config="+path, "--all", "--region=us-east-1", "--bucket=mybucket", "--dailyretentioncount=-1"))
+	if err == nil {
+		t.Error("expected an invalid argument given on the command line to be rejected")
+	}
+}
+
+func TestGetJobsValidatesNotifications(t *testing.T) {
+	path := writeTestConfig(t, `
+defaults:
+  region: us-east-1
+  bucket: mybucket
+jobs:
+  - name: postgres
+    pathtofile: /var/backups/postgres.tar
+    s3filename: postgres
+    webhooks:
+      - url: https://hooks.example.com/backup
+        events: [failure, broken]
+        template: '{"text": {{json .Job}'
+    email:
+      host: smtp.example.com
+      from: backups@example.com
+      to: [ops@example.com]
+      when: sometimes
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := getJobs(parseTestArgs(t, "--action=backup", "--config="+path, "--all"))
+	if err == nil {
+		t.Fatal("expected the notifications of the job to be invalid")
+	}
+
+	expectedProblems := []string{
+		"job 'postgres': webhook event 'broken'",
+		"job 'postgres': failed to parse webhook template",
+		"job 'postgres': email when must be one of",
+	}
+
+	for _, problem := range expectedProblems {
+		if !strings.Contains(err.Error(), problem) {
+			t.Error(fmt.Sprintf("expected error to contain '%s', instead got: %v", problem, err))
+		}
+	}
+
+	_, err = getJobs(parseTestArgs(t, "--action=backup", "--region=us-east-1", "--bucket=mybucket",
+		"--webhookurl=https://hooks.example.com/backup", "--webhookevents", "broken"))
+	if err == nil || !strings.Contains(err.Error(), "webhook event 'broken'") {
+		t.Error(fmt.Sprintf("expected the webhook events given on the command line to be rejected, instead got: %v", err))
+	}
+}
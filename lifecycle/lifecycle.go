@@ -0,0 +1,266 @@
+package lifecycle
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"sort"
+	"strings"
+)
+
+// RuleIDPrefix is prepended to the ID of every lifecycle rule managed by GoS3GFSBackup
+// Rules with any other ID are left untouched when the lifecycle configuration is applied
+const RuleIDPrefix = "GoS3GFSBackup-"
+
+// managedRules are the names of the rules built for each bucket dir
+var managedRules = []string{"monthly", "abort-multipart"}
+
+// ApplyLifecycle builds the lifecycle rules for the rotation policy and merges them with the rules already
+// configured on the bucket. If dry run is enabled then the difference is logged but the bucket is not modified
+// Returns the changes that were (or would have been) made to the lifecycle configuration
+func ApplyLifecycle(svc *s3.S3, bucket string, bucketDir string, policy rpolicy.RotationPolicy, dryRun bool, logger *log.Logger) ([]string, error) {
+	logger = log.OrDefault(logger)
+
+	logger.Banner("Applying Lifecycle Rules")
+
+	desired, err := BuildRules(policy, bucketDir)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info.Printf("Retrieving existing lifecycle configuration for bucket: '%s'\n", bucket)
+	existing, err := s3client.GetBucketLifecycleRules(svc, bucket)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info.Printf("Found %d existing lifecycle rule(s)\n", len(existing))
+
+	merged := MergeRules(existing, desired, bucketDir)
+	changes := Diff(existing, merged)
+
+	if len(changes) == 0 {
+		logger.Info.Println("Lifecycle configuration is already up to date, no changes required")
+		return changes, nil
+	}
+
+	for _, change := range changes {
+		logger.Info.Printf("Lifecycle change: %s\n", change)
+	}
+
+	if dryRun {
+		logger.Info.Printf("Skipping update of lifecycle configuration for bucket: '%s' as dry run has been enabled\n", bucket)
+		return changes, nil
+	}
+
+	// S3 rejects a lifecycle configuration without any rules so the configuration is deleted instead
+	if len(merged) == 0 {
+		err = s3client.DeleteBucketLifecycleRules(svc, bucket)
+	} else {
+		err = s3client.PutBucketLifecycleRules(svc, bucket, merged)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Info.Printf("Successfully applied %d lifecycle change(s) to bucket: '%s'\n", len(changes), bucket)
+
+	return changes, nil
+}
+
+// BuildRules returns the lifecycle rules that implement the parts of the rotation policy which are
+// left to S3. All of the rules are scoped to the bucket dir
+func BuildRules(policy rpolicy.RotationPolicy, bucketDir string) ([]*s3.LifecycleRule, error) {
+	err := validationCheck(policy, bucketDir)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []*s3.LifecycleRule{}
+
+	if policy.MonthlyTransitionDays > 0 || policy.MonthlyExpirationDays > 0 {
+		rule := &s3.LifecycleRule{
+			ID:     aws.String(ruleID(bucketDir, "monthly")),
+			Status: aws.String(s3.ExpirationStatusEnabled),
+			Filter: &s3.LifecycleRuleFilter{
+				Prefix: aws.String(bucketDir + policy.MonthlyPrefix),
+			},
+		}
+
+		if policy.MonthlyTransitionDays > 0 {
+			rule.Transitions = []*s3.Transition{{
+				Days:         aws.Int64(int64(policy.MonthlyTransitionDays)),
+				StorageClass: aws.String(policy.MonthlyTransitionStorageClass),
+			}}
+		}
+
+		if policy.MonthlyExpirationDays > 0 {
+			rule.Expiration = &s3.LifecycleExpiration{
+				Days: aws.Int64(int64(policy.MonthlyExpirationDays)),
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if policy.AbortIncompleteMultipartDays > 0 {
+		rules = append(rules, &s3.LifecycleRule{
+			ID:     aws.String(ruleID(bucketDir, "abort-multipart")),
+			Status: aws.String(s3.ExpirationStatusEnabled),
+			Filter: &s3.LifecycleRuleFilter{
+				Prefix: aws.String(bucketDir),
+			},
+			AbortIncompleteMultipartUpload: &s3.AbortIncompleteMultipartUpload{
+				DaysAfterInitiation: aws.Int64(int64(policy.AbortIncompleteMultipartDays)),
+			},
+		})
+	}
+
+	return rules, nil
+}
+
+// MergeRules returns the existing rules with any rule sharing an ID with a desired rule replaced.
+// Desired rules that do not exist yet are appended. Rules managed by this tool for the bucket dir which are no longer
+// desired are removed, e.g. the monthly rule once both the transition and expiration are disabled.
+// Rules not managed by this tool, or managed for another bucket dir, are preserved
+func MergeRules(existing []*s3.LifecycleRule, desired []*s3.LifecycleRule, bucketDir string) []*s3.LifecycleRule {
+	desiredByID := make(map[string]*s3.LifecycleRule)
+	for _, rule := range desired {
+		desiredByID[aws.StringValue(rule.ID)] = rule
+	}
+
+	managed := make(map[string]bool)
+	for _, name := range managedRules {
+		managed[ruleID(bucketDir, name)] = true
+	}
+
+	merged := []*s3.LifecycleRule{}
+	replaced := make(map[string]bool)
+
+	for _, rule := range existing {
+		id := aws.StringValue(rule.ID)
+		if replacement, ok := desiredByID[id]; ok {
+			merged = append(merged, replacement)
+			replaced[id] = true
+			continue
+		}
+		if managed[id] {
+			continue
+		}
+		merged = append(merged, rule)
+	}
+
+	for _, rule := range desired {
+		if !replaced[aws.StringValue(rule.ID)] {
+			merged = append(merged, rule)
+		}
+	}
+
+	return merged
+}
+
+// Diff returns a human readable list of the rules added, changed or removed between two sets of lifecycle rules
+// Unchanged rules are not included
+func Diff(before []*s3.LifecycleRule, after []*s3.LifecycleRule) []string {
+	beforeByID := make(map[string]*s3.LifecycleRule)
+	for _, rule := range before {
+		beforeByID[aws.StringValue(rule.ID)] = rule
+	}
+
+	afterByID := make(map[string]*s3.LifecycleRule)
+	for _, rule := range after {
+		afterByID[aws.StringValue(rule.ID)] = rule
+	}
+
+	changes := []string{}
+
+	for _, rule := range after {
+		id := aws.StringValue(rule.ID)
+		previous, ok := beforeByID[id]
+		if !ok {
+			changes = append(changes, "+ "+Describe(rule))
+		} else if Describe(previous) != Describe(rule) {
+			changes = append(changes, "- "+Describe(previous))
+			changes = append(changes, "+ "+Describe(rule))
+		}
+	}
+
+	removed := []string{}
+	for id, rule := range beforeByID {
+		if _, ok := afterByID[id]; !ok {
+			removed = append(removed, "- "+Describe(rule))
+		}
+	}
+	sort.Strings(removed)
+
+	return append(changes, removed...)
+}
+
+// Describe returns a single line summary of a lifecycle rule
+// The summary only includes the fields managed by this tool and is also used to compare rules, so that fields
+// which are nil in one rule and empty in another, e.g. as returned by S3, are not reported as a change
+func Describe(rule *s3.LifecycleRule) string {
+	parts := []string{fmt.Sprintf("id='%s'", aws.StringValue(rule.ID)), "status=" + aws.StringValue(rule.Status)}
+
+	if rule.Filter != nil && rule.Filter.Prefix != nil {
+		parts = append(parts, fmt.Sprintf("prefix='%s'", aws.StringValue(rule.Filter.Prefix)))
+	} else if rule.Prefix != nil {
+		parts = append(parts, fmt.Sprintf("prefix='%s'", aws.StringValue(rule.Prefix)))
+	}
+
+	for _, transition := range rule.Transitions {
+		parts = append(parts, fmt.Sprintf("transition=%dd->%s", aws.Int64Value(transition.Days), aws.StringValue(transition.StorageClass)))
+	}
+
+	if rule.Expiration != nil && rule.Expiration.Days != nil {
+		parts = append(parts, fmt.Sprintf("expiration=%dd", aws.Int64Value(rule.Expiration.Days)))
+	}
+
+	if rule.AbortIncompleteMultipartUpload != nil {
+		parts = append(parts, fmt.Sprintf("abort-incomplete-multipart=%dd", aws.Int64Value(rule.AbortIncompleteMultipartUpload.DaysAfterInitiation)))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func ruleID(bucketDir string, name string) string {
+	if bucketDir == "" {
+		return RuleIDPrefix + name
+	}
+	return RuleIDPrefix + name + "-" + strings.TrimSuffix(bucketDir, "/")
+}
+
+func validationCheck(policy rpolicy.RotationPolicy, bucketDir string) error {
+	if bucketDir != "" && !strings.HasSuffix(bucketDir, "/") {
+		return errors.New("expected bucket dir to have trailing slash")
+	}
+
+	if policy.MonthlyPrefix == "" {
+		return errors.New("monthly prefix must not be empty")
+	}
+
+	if policy.MonthlyTransitionDays < 0 || policy.MonthlyExpirationDays < 0 || policy.AbortIncompleteMultipartDays < 0 {
+		return errors.New("lifecycle days must not be less than 0")
+	}
+
+	if policy.MonthlyTransitionDays > 0 {
+		validStorageClass := false
+		for _, storageClass := range s3.TransitionStorageClass_Values() {
+			if policy.MonthlyTransitionStorageClass == storageClass {
+				validStorageClass = true
+			}
+		}
+		if !validStorageClass {
+			return fmt.Errorf("invalid monthly transition storage class: '%s'", policy.MonthlyTransitionStorageClass)
+		}
+	}
+
+	if policy.MonthlyExpirationDays > 0 && policy.MonthlyExpirationDays <= policy.MonthlyTransitionDays {
+		return errors.New("monthly expiration days must be greater than monthly transition days")
+	}
+
+	return nil
+}
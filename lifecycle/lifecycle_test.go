@@ -0,0 +1,169 @@
+package lifecycle
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"io/ioutil"
+	"testing"
+)
+
+var policy rpolicy.RotationPolicy
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+
+	policy = rpolicy.RotationPolicy{
+		DailyPrefix:                   "daily_",
+		WeeklyPrefix:                  "weekly_",
+		MonthlyPrefix:                 "monthly_",
+		MonthlyTransitionDays:         30,
+		MonthlyTransitionStorageClass: s3.TransitionStorageClassGlacier,
+		AbortIncompleteMultipartDays:  7,
+	}
+}
+
+func TestBuildRulesScopedToBucketDir(t *testing.T) {
+	rules, err := BuildRules(policy, "backups/db/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to build rules: %v", err))
+	}
+
+	if len(rules) != 2 {
+		t.Fatal(fmt.Sprintf("expected 2 rules but got %d", len(rules)))
+	}
+
+	if aws.StringValue(rules[0].Filter.Prefix) != "backups/db/monthly_" {
+		t.Error(fmt.Sprintf("expected monthly rule prefix to be 'backups/db/monthly_' but got '%s'", aws.StringValue(rules[0].Filter.Prefix)))
+	}
+
+	if aws.StringValue(rules[1].Filter.Prefix) != "backups/db/" {
+		t.Error(fmt.Sprintf("expected multipart rule prefix to be 'backups/db/' but got '%s'", aws.StringValue(rules[1].Filter.Prefix)))
+	}
+}
+
+func TestBuildRulesInvalidStorageClass(t *testing.T) {
+	invalidPolicy := policy
+	invalidPolicy.MonthlyTransitionStorageClass = "TAPE"
+
+	_, err := BuildRules(invalidPolicy, "")
+	if err == nil {
+		t.Error("expected error when building rules with an invalid storage class")
+	}
+}
+
+func TestMergeRulesPreservesUnmanagedRules(t *testing.T) {
+	unmanaged := &s3.LifecycleRule{
+		ID:     aws.String("logs-expiry"),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("logs/")},
+	}
+
+	outdated := &s3.LifecycleRule{
+		ID:     aws.String(RuleIDPrefix + "monthly"),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("monthly_")},
+		Transitions: []*s3.Transition{{
+			Days:         aws.Int64(90),
+			StorageClass: aws.String(s3.TransitionStorageClassGlacier),
+		}},
+	}
+
+	existing := []*s3.LifecycleRule{unmanaged, outdated}
+
+	desired, err := BuildRules(policy, "")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to build rules: %v", err))
+	}
+
+	merged := MergeRules(existing, desired, "")
+
+	if len(merged) != 3 {
+		t.Fatal(fmt.Sprintf("expected 3 merged rules but got %d", len(merged)))
+	}
+
+	if merged[0] != unmanaged {
+		t.Error("expected unmanaged rule to be preserved")
+	}
+
+	if aws.Int64Value(merged[1].Transitions[0].Days) != 30 {
+		t.Error("expected outdated monthly rule to be replaced")
+	}
+
+	changes := Diff(existing, merged)
+	if len(changes) != 3 { // monthly rule removed and added, multipart rule added
+		t.Error(fmt.Sprintf("expected 3 changes but got %d: %v", len(changes), changes))
+	}
+
+	if len(Diff(merged, MergeRules(merged, desired, ""))) != 0 {
+		t.Error("expected no changes when applying the same rules twice")
+	}
+}
+
+func TestMergeRulesRemovesUnwantedManagedRules(t *testing.T) {
+	monthly := &s3.LifecycleRule{
+		ID:     aws.String(RuleIDPrefix + "monthly-backups/db"),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("backups/db/monthly_")},
+		Transitions: []*s3.Transition{{
+			Days:         aws.Int64(30),
+			StorageClass: aws.String(s3.TransitionStorageClassGlacier),
+		}},
+	}
+
+	otherBucketDir := &s3.LifecycleRule{
+		ID:     aws.String(RuleIDPrefix + "monthly-backups/web"),
+		Status: aws.String(s3.ExpirationStatusEnabled),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String("backups/web/monthly_")},
+	}
+
+	disabledPolicy := policy
+	disabledPolicy.MonthlyTransitionDays = 0
+	disabledPolicy.MonthlyExpirationDays = 0
+	disabledPolicy.AbortIncompleteMultipartDays = 0
+
+	desired, err := BuildRules(disabledPolicy, "backups/db/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to build rules: %v", err))
+	}
+
+	merged := MergeRules([]*s3.LifecycleRule{monthly, otherBucketDir}, desired, "backups/db/")
+
+	if len(merged) != 1 || merged[0] != otherBucketDir {
+		t.Fatal(fmt.Sprintf("expected only the rule of the other bucket dir to remain, instead got: %v", merged))
+	}
+
+	changes := Diff([]*s3.LifecycleRule{monthly, otherBucketDir}, merged)
+	if len(changes) != 1 || changes[0] != "- "+Describe(monthly) {
+		t.Error(fmt.Sprintf("expected the monthly rule to be removed, instead got: %v", changes))
+	}
+}
+
+func TestDiffIgnoresEmptyFields(t *testing.T) {
+	desired, err := BuildRules(policy, "")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to build rules: %v", err))
+	}
+
+	// Rules returned by S3 have empty rather than nil fields and are not the same pointers
+	existing := []*s3.LifecycleRule{}
+	for _, rule := range desired {
+		returned := *rule
+		returned.NoncurrentVersionTransitions = []*s3.NoncurrentVersionTransition{}
+		if returned.Transitions == nil {
+			returned.Transitions = []*s3.Transition{}
+		}
+		if returned.Expiration == nil {
+			returned.Expiration = &s3.LifecycleExpiration{}
+		}
+		existing = append(existing, &returned)
+	}
+
+	changes := Diff(existing, MergeRules(existing, desired, ""))
+	if len(changes) != 0 {
+		t.Error(fmt.Sprintf("expected no changes, instead got: %v", changes))
+	}
+}
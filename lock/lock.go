@@ -0,0 +1,425 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the lock object stored in the bucket dir of each series
+const FileName = ".gos3gfsbackup.lock"
+
+// maxAttempts is the number of times acquiring a lock is attempted when another host is racing for it
+const maxAttempts = 3
+
+// Info is the contents of the lock object
+type Info struct {
+	Owner    string    `json:"owner"`
+	Hostname string    `json:"hostname"`
+	PID      int       `json:"pid"`
+	Job      string    `json:"job,omitempty"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+}
+
+// HeldError is returned when the lock is held by another owner and has not expired
+type HeldError struct {
+	Key  string
+	Info Info
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("lock '%s' is held by '%s' (host: %s, pid: %d, job: '%s') until %s",
+		e.Key, e.Info.Owner, e.Info.Hostname, e.Info.PID, e.Info.Job, e.Info.Expires.Format(time.RFC3339))
+}
+
+// Lock is a lease held on a backup series. The lease is renewed in the background until it is released
+// A nil *Lock holds no lease and is never lost, i.e. for a series which cannot be locked
+type Lock struct {
+	svc         *s3.S3
+	bucket      string
+	key         string
+	ttl         time.Duration
+	info        Info
+	conditional bool // False if the server does not support conditional writes
+	logger      *log.Logger
+
+	mu   sync.Mutex
+	etag string
+	err  error // Set if the lease could not be renewed before it expired
+
+	stopCh chan bool
+	doneCh chan bool
+
+	releaseOnce sync.Once
+	releaseErr  error
+}
+
+// Key returns the key of the lock object for the series in the specified bucket dir
+func Key(bucketDir string) string {
+	return bucketDir + FileName
+}
+
+// Acquire takes the lock for the series in the specified bucket dir. The lease expires after the ttl unless it is
+// renewed, which happens automatically every third of the ttl until Release is called
+// Conditional writes are used where the server supports them so that only one owner can ever hold the lock
+// Returns a *HeldError if another owner holds a lease that has not expired. If logger is nil then the default logger is used
+func Acquire(svc *s3.S3, bucket string, bucketDir string, job string, ttl time.Duration, logger *log.Logger) (*Lock, error) {
+	if ttl < time.Second*3 {
+		return nil, errors.New("lock ttl must not be less than 3 seconds")
+	}
+
+	owner, err := newOwnerID()
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+
+	l := &Lock{
+		svc:         svc,
+		bucket:      bucket,
+		key:         Key(bucketDir),
+		ttl:         ttl,
+		conditional: true,
+		logger:      log.OrDefault(logger).With("lock", Key(bucketDir)),
+		info: Info{
+			Owner:    owner,
+			Hostname: hostname,
+			PID:      os.Getpid(),
+			Job:      job,
+		},
+		stopCh: make(chan bool),
+		doneCh: make(chan bool),
+	}
+
+	l.logger.Info.Printf("Acquiring lock: '%s' in bucket: '%s' as owner: '%s'\n", l.key, bucket, owner)
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = l.acquire()
+		// Only retry if the lock was released between attempting to write it and reading it back
+		if held, ok := err.(*HeldError); !ok || held.Info.Owner != "" {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	l.logger.Info.Printf("Acquired lock: '%s' until %s\n", l.key, l.info.Expires.Format(time.RFC3339))
+
+	go l.heartbeat()
+
+	return l, nil
+}
+
+// Err returns an error if the lease has been lost, i.e. it expired before it could be renewed
+// or it was taken over by another owner. Work protected by the lock should not continue once this returns an error
+func (l *Lock) Err() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Guard returns a store which refuses to delete or copy keys once the lease has been lost
+// so that work protected by the lock stops modifying the series as soon as another owner may hold it
+func (l *Lock) Guard(store storage.Store) storage.Store {
+	if l == nil {
+		return store
+	}
+	return &guardedStore{Store: store, lock: l}
+}
+
+// Release stops renewing the lease and deletes the lock object if it is still owned by this lock
+// Calling Release more than once returns the result of the first call
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	l.releaseOnce.Do(func() {
+		l.releaseErr = l.release()
+	})
+	return l.releaseErr
+}
+
+func (l *Lock) release() error {
+	close(l.stopCh)
+	<-l.doneCh
+
+	if err := l.Err(); err != nil {
+		return err
+	}
+
+	current, _, err := l.read()
+	if err != nil {
+		return err
+	}
+
+	if current.Owner != l.info.Owner {
+		return fmt.Errorf("lock '%s' was taken over by '%s' before it was released", l.key, current.Owner)
+	}
+
+	_, err = s3client.DeleteKey(l.svc, l.bucket, l.key)
+	if err != nil {
+		return err
+	}
+
+	l.logger.Info.Printf("Released lock: '%s'\n", l.key)
+
+	return nil
+}
+
+// ForceUnlock deletes the lock for the series in the specified bucket dir regardless of who owns it
+// If logger is nil then the default logger is used
+func ForceUnlock(svc *s3.S3, bucket string, bucketDir string, logger *log.Logger) error {
+	logger = log.OrDefault(logger)
+	key := Key(bucketDir)
+
+	contents, _, err := s3client.GetObjectContents(svc, bucket, key)
+	if s3client.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+		logger.Info.Printf("No lock: '%s' exists in bucket: '%s'\n", key, bucket)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	info := Info{}
+	if json.Unmarshal(contents, &info) == nil {
+		logger.Warn.Printf("Forcibly removing lock: '%s' held by '%s' (host: %s, pid: %d, job: '%s') until %s\n",
+			key, info.Owner, info.Hostname, info.PID, info.Job, info.Expires.Format(time.RFC3339))
+	} else {
+		logger.Warn.Printf("Forcibly removing unreadable lock: '%s'\n", key)
+	}
+
+	_, err = s3client.DeleteKey(svc, bucket, key)
+	return err
+}
+
+func (l *Lock) acquire() error {
+	l.info.Acquired = time.Now().UTC()
+	l.info.Expires = l.info.Acquired.Add(l.ttl)
+
+	contents, err := json.Marshal(l.info)
+	if err != nil {
+		return err
+	}
+
+	etag, err := s3client.PutObjectConditional(l.svc, l.bucket, l.key, contents, "", "*")
+	if err == nil {
+		l.etag = etag
+		return nil
+	}
+
+	if isUnsupported(err) {
+		l.logger.Warn.Println("Conditional writes are not supported by the server, falling back to a best effort lock")
+		l.conditional = false
+		return l.acquireUnconditional(contents)
+	}
+
+	if !isConflict(err) {
+		return err
+	}
+
+	// The lock already exists, it can only be taken over once it has expired
+	current, currentETag, err := l.read()
+	if s3client.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+		return &HeldError{Key: l.key} // Released in the meantime, try again
+	}
+	if err != nil {
+		return err
+	}
+
+	if time.Now().Before(current.Expires) {
+		return &HeldError{Key: l.key, Info: current}
+	}
+
+	l.logger.Warn.Printf("Lock: '%s' held by '%s' expired at %s, taking over\n", l.key, current.Owner, current.Expires.Format(time.RFC3339))
+
+	etag, err = s3client.PutObjectConditional(l.svc, l.bucket, l.key, contents, currentETag, "")
+	if isConflict(err) {
+		return &HeldError{Key: l.key, Info: current} // Another owner took over first
+	}
+	if err != nil {
+		return err
+	}
+
+	l.etag = etag
+	return nil
+}
+
+// acquireUnconditional is used when the server does not support conditional writes
+// The lock is written and then read back to detect if another owner wrote it at the same time
+func (l *Lock) acquireUnconditional(contents []byte) error {
+	current, _, err := l.read()
+	if err != nil && !s3client.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+		return err
+	}
+
+	if err == nil && current.Owner != l.info.Owner && time.Now().Before(current.Expires) {
+		return &HeldError{Key: l.key, Info: current}
+	}
+
+	etag, err := s3client.PutObjectConditional(l.svc, l.bucket, l.key, contents, "", "")
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(time.Second) // Give any other owner writing at the same time a chance to be seen
+
+	current, _, err = l.read()
+	if err != nil {
+		return err
+	}
+
+	if current.Owner != l.info.Owner {
+		return &HeldError{Key: l.key, Info: current}
+	}
+
+	l.etag = etag
+	return nil
+}
+
+func (l *Lock) heartbeat() {
+	defer close(l.doneCh)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopCh:
+			return
+		case <-ticker.C:
+			err := l.renew()
+			if err == nil {
+				continue
+			}
+
+			if _, held := err.(*HeldError); held || time.Now().After(l.info.Expires) {
+				l.logger.Error.Printf("Lost lock: '%s': %v\n", l.key, err)
+				l.mu.Lock()
+				l.err = fmt.Errorf("lost lock '%s': %v", l.key, err)
+				l.mu.Unlock()
+				return
+			}
+
+			l.logger.Warn.Printf("Failed to renew lock: '%s', will retry: %v\n", l.key, err)
+		}
+	}
+}
+
+func (l *Lock) renew() error {
+	info := l.info
+	info.Expires = time.Now().UTC().Add(l.ttl)
+
+	contents, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	ifMatch := l.etag
+	l.mu.Unlock()
+
+	if !l.conditional {
+		current, _, err := l.read()
+		if err != nil {
+			return err
+		}
+		if current.Owner != l.info.Owner {
+			return &HeldError{Key: l.key, Info: current}
+		}
+		ifMatch = ""
+	}
+
+	etag, err := s3client.PutObjectConditional(l.svc, l.bucket, l.key, contents, ifMatch, "")
+	if isConflict(err) {
+		current, _, _ := l.read()
+		return &HeldError{Key: l.key, Info: current}
+	}
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.etag = etag
+	l.info.Expires = info.Expires
+	l.mu.Unlock()
+
+	return nil
+}
+
+func (l *Lock) read() (Info, string, error) {
+	contents, etag, err := s3client.GetObjectContents(l.svc, l.bucket, l.key)
+	if err != nil {
+		return Info{}, "", err
+	}
+
+	info := Info{}
+	err = json.Unmarshal(contents, &info)
+	if err != nil {
+		return Info{}, "", fmt.Errorf("failed to parse lock '%s': %v", l.key, err)
+	}
+
+	return info, etag, nil
+}
+
+// guardedStore is a store which only modifies keys while the lease of the lock is held
+type guardedStore struct {
+	storage.Store
+	lock *Lock
+}
+
+func (g *guardedStore) Delete(key string) error {
+	if err := g.lock.Err(); err != nil {
+		return err
+	}
+	return g.Store.Delete(key)
+}
+
+func (g *guardedStore) Copy(sourceKey string, key string) error {
+	if err := g.lock.Err(); err != nil {
+		return err
+	}
+	return g.Store.Copy(sourceKey, key)
+}
+
+// isConflict returns true if a conditional write failed because the lock was written by someone else
+func isConflict(err error) bool {
+	return s3client.IsStatusCode(err, http.StatusPreconditionFailed) || s3client.IsStatusCode(err, http.StatusConflict)
+}
+
+// isUnsupported returns true if the server rejected the conditional headers
+func isUnsupported(err error) bool {
+	return s3client.IsNotImplemented(err)
+}
+
+func newOwnerID() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	random := make([]byte, 4)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), hex.EncodeToString(random)), nil
+}
@@ -0,0 +1,226 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+const testBucket = "lockbucket"
+const testBucketDir = "backups/"
+const testTTL = time.Second * 3
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+// readTestLock returns the contents of the lock object stored by the test server
+func readTestLock(t *testing.T, server *s3test.Server) (Info, bool) {
+	object, ok := server.Object(testBucket, Key(testBucketDir))
+	if !ok {
+		return Info{}, false
+	}
+
+	info := Info{}
+	err := json.Unmarshal(object.Data, &info)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to parse lock object: %v", err))
+	}
+	return info, true
+}
+
+// writeTestLock stores a lock object owned by someone else
+func writeTestLock(t *testing.T, server *s3test.Server, owner string, expires time.Time) {
+	contents, err := json.Marshal(Info{Owner: owner, Hostname: "otherhost", PID: 1, Acquired: expires.Add(-testTTL), Expires: expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.PutObject(testBucket, Key(testBucketDir), contents)
+}
+
+func TestAcquireAndRelease(t *testing.T) {
+	server := s3test.NewServer(testBucket)
+	defer server.Close()
+
+	l, err := Acquire(server.Client(), testBucket, testBucketDir, "postgres", testTTL, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to acquire the lock, instead got: %v", err))
+	}
+
+	info, ok := readTestLock(t, server)
+	if !ok || info.Owner != l.info.Owner || info.Job != "postgres" || !info.Expires.After(time.Now()) {
+		t.Error(fmt.Sprintf("expected the lock object to be owned by '%s' and not expired, instead got: %+v", l.info.Owner, info))
+	}
+
+	if err := l.Err(); err != nil {
+		t.Error(fmt.Sprintf("expected the lease to be held, instead got: %v", err))
+	}
+
+	err = l.Release()
+	if err != nil {
+		t.Error(fmt.Sprintf("expected to release the lock, instead got: %v", err))
+	}
+
+	if _, ok := server.Object(testBucket, Key(testBucketDir)); ok {
+		t.Error("expected the lock object to be deleted when the lock is released")
+	}
+
+	// Releasing again must not panic and returns the result of the first release
+	err = l.Release()
+	if err != nil {
+		t.Error(fmt.Sprintf("expected releasing the lock a second time to return nil, instead got: %v", err))
+	}
+}
+
+func TestAcquireContention(t *testing.T) {
+	server := s3test.NewServer(testBucket)
+	defer server.Close()
+
+	first, err := Acquire(server.Client(), testBucket, testBucketDir, "first", testTTL, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to acquire the lock, instead got: %v", err))
+	}
+	defer first.Release()
+
+	_, err = Acquire(server.Client(), testBucket, testBucketDir, "second", testTTL, nil)
+	held, ok := err.(*HeldError)
+	if !ok {
+		t.Fatal(fmt.Sprintf("expected a *HeldError when the lock is held, instead got: %v", err))
+	}
+
+	if held.Info.Owner != first.info.Owner || held.Key != Key(testBucketDir) {
+		t.Error(fmt.Sprintf("expected the lock to be reported as held by '%s', instead got: %+v", first.info.Owner, held))
+	}
+}
+
+func TestAcquireTakesOverExpiredLock(t *testing.T) {
+	server := s3test.NewServer(testBucket)
+	defer server.Close()
+
+	writeTestLock(t, server, "stale-owner", time.Now().Add(-time.Minute))
+
+	l, err := Acquire(server.Client(), testBucket, testBucketDir, "postgres", testTTL, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to take over the expired lock, instead got: %v", err))
+	}
+	defer l.Release()
+
+	info, _ := readTestLock(t, server)
+	if info.Owner != l.info.Owner {
+		t.Error(fmt.Sprintf("expected the lock object to be owned by '%s', instead got '%s'", l.info.Owner, info.Owner))
+	}
+}
+
+func TestAcquireWithoutConditionalWrites(t *testing.T) {
+	server := s3test.NewServer(testBucket)
+	server.ConditionalWrites = false
+	defer server.Close()
+
+	l, err := Acquire(server.Client(), testBucket, testBucketDir, "postgres", testTTL, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to acquire a best effort lock, instead got: %v", err))
+	}
+	defer l.Release()
+
+	if l.conditional {
+		t.Error("expected the lock to fall back to unconditional writes")
+	}
+
+	_, err = Acquire(server.Client(), testBucket, testBucketDir, "second", testTTL, nil)
+	if _, ok := err.(*HeldError); !ok {
+		t.Error(fmt.Sprintf("expected a *HeldError when the best effort lock is held, instead got: %v", err))
+	}
+}
+
+func TestHeartbeatLoss(t *testing.T) {
+	server := s3test.NewServer(testBucket)
+	defer server.Close()
+
+	l, err := Acquire(server.Client(), testBucket, testBucketDir, "postgres", testTTL, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to acquire the lock, instead got: %v", err))
+	}
+
+	store := l.Guard(storage.NewS3Store(server.Client(), testBucket))
+	server.PutObject(testBucket, "backups/daily_postgres_1", []byte("backup"))
+
+	// Another owner takes over the lock so that the next renewal fails
+	writeTestLock(t, server, "other-owner", time.Now().Add(time.Hour))
+
+	deadline := time.Now().Add(testTTL * 2)
+	for l.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	if l.Err() == nil {
+		t.Fatal("expected the lease to be lost once the lock was taken over")
+	}
+
+	err = store.Delete("backups/daily_postgres_1")
+	if err == nil {
+		t.Error("expected the guarded store to refuse to delete keys once the lease was lost")
+	}
+	if _, ok := server.Object(testBucket, "backups/daily_postgres_1"); !ok {
+		t.Error("expected the key to be kept once the lease was lost")
+	}
+
+	err = l.Release()
+	if err == nil {
+		t.Error("expected releasing a lost lock to return an error")
+	}
+
+	info, _ := readTestLock(t, server)
+	if info.Owner != "other-owner" {
+		t.Error(fmt.Sprintf("expected the lock of the new owner to be kept, instead got: %+v", info))
+	}
+}
+
+func TestForceUnlock(t *testing.T) {
+	server := s3test.NewServer(testBucket)
+	defer server.Close()
+
+	err := ForceUnlock(server.Client(), testBucket, testBucketDir, nil)
+	if err != nil {
+		t.Error(fmt.Sprintf("expected force unlocking a missing lock to succeed, instead got: %v", err))
+	}
+
+	writeTestLock(t, server, "other-owner", time.Now().Add(time.Hour))
+
+	err = ForceUnlock(server.Client(), testBucket, testBucketDir, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to force unlock the lock, instead got: %v", err))
+	}
+
+	if _, ok := server.Object(testBucket, Key(testBucketDir)); ok {
+		t.Error("expected the lock object to be deleted")
+	}
+
+	l, err := Acquire(server.Client(), testBucket, testBucketDir, "postgres", testTTL, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to acquire the lock after it was force unlocked, instead got: %v", err))
+	}
+	l.Release()
+}
+
+func TestNilLock(t *testing.T) {
+	var l *Lock
+
+	if err := l.Err(); err != nil {
+		t.Error(fmt.Sprintf("expected a nil lock never to be lost, instead got: %v", err))
+	}
+
+	store := storage.NewS3Store(nil, testBucket)
+	if l.Guard(store) != storage.Store(store) {
+		t.Error("expected a nil lock not to guard the store")
+	}
+
+	if err := l.Release(); err != nil {
+		t.Error(fmt.Sprintf("expected releasing a nil lock to return nil, instead got: %v", err))
+	}
+}
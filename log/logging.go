@@ -1,37 +1,346 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
-	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
+
+// Levels in increasing order of severity
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+// textPrefixes match the prefixes written before structured logging was introduced
+var textPrefixes = map[Level]string{
+	LevelDebug: "DEBUG: ",
+	LevelInfo:  "INFO: ",
+	LevelWarn:  "WARNING: ",
+	LevelError: "ERROR: ",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the specified name [debug|info|warn|error]
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("invalid log level '%s', must be one of [debug|info|warn|error]", name)
+}
+
+// Formats a log entry can be written in
+const (
+	FormatText = "text"
+	FormatJSON = "json"
 )
 
 var (
+	// Debug Logger
+	Debug *Printer
+
 	// Info Logger
-	Info *log.Logger
+	Info *Printer
 
 	// Warn Logger
-	Warn *log.Logger
+	Warn *Printer
 
 	// Error Logger
-	Error *log.Logger
+	Error *Printer
 )
 
+var defaultLogger *Logger
+
+// Logger writes levelled log entries with key/value fields in either text or JSON format
+// The Debug, Info, Warn and Error printers write entries at that level with the fields of the logger
+type Logger struct {
+	Debug *Printer
+	Info  *Printer
+	Warn  *Printer
+	Error *Printer
+
+	out    *output
+	fields []interface{} // Key/value pairs written with every entry
+}
+
+// output is shared by a logger and every logger derived from it with With
+type output struct {
+	mu      sync.Mutex
+	writers map[Level]io.Writer
+	level   Level
+	format  string
+	banners bool
+}
+
+// Printer writes entries at a single level using the same methods as the standard library logger
+type Printer struct {
+	logger *Logger
+	level  Level
+}
+
+func init() {
+	Init(os.Stdout, os.Stdout, os.Stderr)
+}
+
 // Init initialises the the logger with the appropriate io writers
+// Debug entries are written to the info writer. The level defaults to info and the format to text
 func Init(
 	infoHandle io.Writer,
 	warningHandle io.Writer,
 	errorHandle io.Writer) {
 
-	Info = log.New(infoHandle,
-		"INFO: ",
-		log.Ldate|log.Ltime|log.Lshortfile)
+	defaultLogger = newLogger(&output{
+		writers: map[Level]io.Writer{
+			LevelDebug: infoHandle,
+			LevelInfo:  infoHandle,
+			LevelWarn:  warningHandle,
+			LevelError: errorHandle,
+		},
+		level:   LevelInfo,
+		format:  FormatText,
+		banners: true,
+	}, nil)
+
+	Debug = defaultLogger.Debug
+	Info = defaultLogger.Info
+	Warn = defaultLogger.Warn
+	Error = defaultLogger.Error
+}
+
+// Configure sets the minimum level, the format and whether banners are written by the default logger
+func Configure(level string, format string, banners bool) error {
+	parsedLevel, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("invalid log format '%s', must be one of [%s|%s]", format, FormatText, FormatJSON)
+	}
+
+	out := defaultLogger.out
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	out.level = parsedLevel
+	out.format = format
+	out.banners = banners
+
+	return nil
+}
+
+// New creates a logger which writes every level to the writer
+func New(writer io.Writer, level Level, format string) *Logger {
+	return newLogger(&output{
+		writers: map[Level]io.Writer{
+			LevelDebug: writer,
+			LevelInfo:  writer,
+			LevelWarn:  writer,
+			LevelError: writer,
+		},
+		level:   level,
+		format:  format,
+		banners: format == FormatText,
+	}, nil)
+}
+
+// Default returns the logger used by the package level printers
+func Default() *Logger {
+	return defaultLogger
+}
+
+// OrDefault returns the logger if it is not nil, otherwise the default logger
+// Library packages accept a nil logger so that callers without one do not need to create it
+func OrDefault(logger *Logger) *Logger {
+	if logger == nil {
+		return defaultLogger
+	}
+	return logger
+}
+
+// With returns a logger which writes the key/value pairs with every entry in addition to the fields of this logger
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return newLogger(l.out, fields)
+}
+
+// Log writes an entry at the level with the key/value pairs in addition to the fields of this logger
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	l.write(level, msg, keyvals, 2)
+}
+
+// Banner writes the title in a banner. If banners are disabled or the format is JSON the title is written at debug level
+func (l *Logger) Banner(title string) {
+	l.banner(title)
+}
+
+// Banner writes the title in a banner with the default logger
+func Banner(title string) {
+	defaultLogger.banner(title)
+}
+
+func (l *Logger) banner(title string) {
+	l.out.mu.Lock()
+	banners := l.out.banners && l.out.format == FormatText
+	l.out.mu.Unlock()
 
-	Warn = log.New(warningHandle,
-		"WARNING: ",
-		log.Ldate|log.Ltime|log.Lshortfile)
+	if !banners {
+		l.write(LevelDebug, title, nil, 3)
+		return
+	}
 
-	Error = log.New(errorHandle,
-		"ERROR: ",
-		log.Ldate|log.Ltime|log.Lshortfile)
+	l.write(LevelInfo, fmt.Sprintf(`
+	######################################
+	#%s#
+	######################################
+	`, center(title, 36)), nil, 3)
+}
+
+// Print writes an entry with the arguments formatted as fmt.Sprint
+func (p *Printer) Print(v ...interface{}) {
+	p.logger.write(p.level, fmt.Sprint(v...), nil, 2)
+}
+
+// Printf writes an entry with the arguments formatted as fmt.Sprintf
+func (p *Printer) Printf(format string, v ...interface{}) {
+	p.logger.write(p.level, fmt.Sprintf(format, v...), nil, 2)
+}
+
+// Println writes an entry with the arguments formatted as fmt.Sprintln
+func (p *Printer) Println(v ...interface{}) {
+	p.logger.write(p.level, fmt.Sprintln(v...), nil, 2)
+}
+
+func newLogger(out *output, fields []interface{}) *Logger {
+	l := &Logger{out: out, fields: fields}
+	l.Debug = &Printer{logger: l, level: LevelDebug}
+	l.Info = &Printer{logger: l, level: LevelInfo}
+	l.Warn = &Printer{logger: l, level: LevelWarn}
+	l.Error = &Printer{logger: l, level: LevelError}
+	return l
+}
+
+// write formats and writes a single entry. depth is the number of frames between the caller and write
+func (l *Logger) write(level Level, msg string, keyvals []interface{}, depth int) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	if level < l.out.level {
+		return
+	}
+
+	caller := "???:0"
+	if _, file, line, ok := runtime.Caller(depth); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+
+	fields := append(append([]interface{}{}, l.fields...), keyvals...)
+	msg = strings.TrimRight(msg, "\n")
+	now := time.Now()
+
+	var entry []byte
+	if l.out.format == FormatJSON {
+		entry = formatJSON(now, level, caller, msg, fields)
+	} else {
+		entry = formatText(now, level, caller, msg, fields)
+	}
+
+	l.out.writers[level].Write(entry)
+}
+
+// formatText writes the entry in the same layout as the standard library logger followed by the fields
+func formatText(now time.Time, level Level, caller string, msg string, fields []interface{}) []byte {
+	var entry bytes.Buffer
+	entry.WriteString(textPrefixes[level])
+	entry.WriteString(now.Format("2006/01/02 15:04:05 "))
+	entry.WriteString(caller + ": ")
+	entry.WriteString(msg)
+
+	for i := 0; i < len(fields); i += 2 {
+		value := "" // A key without a value is written with an empty value
+		if i+1 < len(fields) {
+			value = fmt.Sprint(fields[i+1])
+		}
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&entry, " %v=%s", fields[i], value)
+	}
+
+	entry.WriteString("\n")
+	return entry.Bytes()
+}
+
+// formatJSON writes the entry as a single line JSON object. Fields are written in the order they were added
+func formatJSON(now time.Time, level Level, caller string, msg string, fields []interface{}) []byte {
+	var entry bytes.Buffer
+	entry.WriteString("{")
+	writeJSONField(&entry, "time", now.UTC().Format(time.RFC3339Nano), true)
+	writeJSONField(&entry, "level", level.String(), false)
+	writeJSONField(&entry, "caller", caller, false)
+	writeJSONField(&entry, "msg", msg, false)
+
+	for i := 0; i < len(fields); i += 2 {
+		var value interface{}
+		if i+1 < len(fields) {
+			value = fields[i+1]
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		writeJSONField(&entry, fmt.Sprint(fields[i]), value, false)
+	}
+
+	entry.WriteString("}\n")
+	return entry.Bytes()
+}
+
+func writeJSONField(entry *bytes.Buffer, key string, value interface{}, first bool) {
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+	encodedKey, _ := json.Marshal(key)
+
+	if !first {
+		entry.WriteString(",")
+	}
+	entry.Write(encodedKey)
+	entry.WriteString(":")
+	entry.Write(encodedValue)
+}
 
+func center(text string, width int) string {
+	if len(text) >= width {
+		return text
+	}
+	left := (width - len(text)) / 2
+	return strings.Repeat(" ", left) + text + strings.Repeat(" ", width-len(text)-left)
 }
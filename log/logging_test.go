@@ -0,0 +1,91 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestJSONFormat(t *testing.T) {
+	var output bytes.Buffer
+	logger := New(&output, LevelInfo, FormatJSON).With("job", "postgres", "run_id", "abc123")
+
+	logger.With("tier", "daily").Info.Printf("Uploaded key: '%s'\n", "daily_postgres_20170918T020000")
+
+	entry := map[string]interface{}{}
+	err := json.Unmarshal(output.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected a JSON entry but got '%s': %v", output.String(), err))
+	}
+
+	expected := map[string]string{
+		"level":  "info",
+		"msg":    "Uploaded key: 'daily_postgres_20170918T020000'",
+		"job":    "postgres",
+		"run_id": "abc123",
+		"tier":   "daily",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Error(fmt.Sprintf("expected '%s' to be '%s' but got '%v'", key, value, entry[key]))
+		}
+	}
+
+	if !strings.HasPrefix(entry["caller"].(string), "logging_test.go:") {
+		t.Error(fmt.Sprintf("expected caller to be this file but got '%v'", entry["caller"]))
+	}
+}
+
+func TestTextFormatFields(t *testing.T) {
+	var output bytes.Buffer
+	logger := New(&output, LevelInfo, FormatText).With("job", "postgres", "key", "daily postgres")
+
+	logger.Warn.Println("Key kept in rotation")
+
+	line := output.String()
+	if !strings.HasPrefix(line, "WARNING: ") || !strings.Contains(line, `Key kept in rotation job=postgres key="daily postgres"`) {
+		t.Error(fmt.Sprintf("unexpected text entry: '%s'", line))
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var output bytes.Buffer
+	logger := New(&output, LevelWarn, FormatText)
+
+	logger.Debug.Println("debug")
+	logger.Info.Println("info")
+	logger.Error.Println("error")
+
+	if strings.Contains(output.String(), "debug") || strings.Contains(output.String(), "INFO") {
+		t.Error(fmt.Sprintf("expected entries below warn to be dropped, got '%s'", output.String()))
+	}
+
+	if !strings.Contains(output.String(), "ERROR: ") {
+		t.Error("expected error entry to be written")
+	}
+}
+
+func TestBannersNotWrittenInJSON(t *testing.T) {
+	var output bytes.Buffer
+	logger := New(&output, LevelInfo, FormatJSON)
+
+	logger.Banner("File Upload Started")
+
+	if output.Len() != 0 {
+		t.Error(fmt.Sprintf("expected banner to be written at debug level, got '%s'", output.String()))
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	if err != nil || level != LevelWarn {
+		t.Error(fmt.Sprintf("expected warn level but got %v: %v", level, err))
+	}
+
+	_, err = ParseLevel("verbose")
+	if err == nil {
+		t.Error("expected error for an invalid level")
+	}
+}
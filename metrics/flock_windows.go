@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package metrics
+
+import (
+	"os"
+)
+
+// lockFile does nothing on Windows, the textfile is only guarded against jobs run by the same process
+func lockFile(file *os.File) error {
+	return nil
+}
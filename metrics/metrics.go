@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sample is a single value of a metric in the Prometheus text format
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// Label is a name and value pair identifying a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+type definition struct {
+	name string
+	help string
+}
+
+// definitions are the metrics which are written, in the order that they are written. Every metric is a gauge
+var definitions = []definition{
+	{"gos3gfsbackup_last_run_timestamp_seconds", "The time the last run of the job finished"},
+	{"gos3gfsbackup_last_run_success", "1 if the last run of the job succeeded, otherwise 0"},
+	{"gos3gfsbackup_last_run_duration_seconds", "The duration of the last run of the job"},
+	{"gos3gfsbackup_last_run_uploaded_bytes", "The number of bytes uploaded by the last run of the job"},
+	{"gos3gfsbackup_last_run_deleted_keys", "The number of keys deleted by the rotation of the last run of the job"},
+	{"gos3gfsbackup_last_run_skipped_keys", "The number of keys kept due to the retention period by the rotation of the last run of the job"},
+	{"gos3gfsbackup_last_run_retries", "The number of S3 requests retried during the last run of the job"},
+	{"gos3gfsbackup_last_success_timestamp_seconds", "The time the last successful backup of the job finished for each tier"},
+	{"gos3gfsbackup_objects", "The number of objects stored for each tier of the job"},
+}
+
+// tierSuccessMetric is kept separately for each tier. The other metrics describe the last run of a job
+const tierSuccessMetric = "gos3gfsbackup_last_success_timestamp_seconds"
+
+// pushTimeout is the timeout of each request to the Pushgateway
+const pushTimeout = time.Second * 10
+
+// textfileMu guards the textfile as jobs may finish at the same time in daemon mode
+var textfileMu sync.Mutex
+
+// Collect returns the samples describing the run of the named job
+func Collect(job string, summary *report.Summary) []Sample {
+	runLabels := []Label{{"backup_job", job}, {"action", summary.Action}}
+
+	success := 0.0
+	if summary.Status != report.StatusFailure {
+		success = 1
+	}
+
+	finished := float64(summary.Finished.UnixNano()) / float64(time.Second)
+
+	samples := []Sample{
+		{"gos3gfsbackup_last_run_timestamp_seconds", runLabels, finished},
+		{"gos3gfsbackup_last_run_success", runLabels, success},
+		{"gos3gfsbackup_last_run_duration_seconds", runLabels, summary.DurationSeconds},
+		{"gos3gfsbackup_last_run_uploaded_bytes", runLabels, float64(summary.Bytes)},
+		{"gos3gfsbackup_last_run_deleted_keys", runLabels, float64(len(summary.DeletedKeys))},
+		{"gos3gfsbackup_last_run_skipped_keys", runLabels, float64(len(summary.SkippedKeys))},
+		{"gos3gfsbackup_last_run_retries", runLabels, float64(summary.Retries)},
+	}
+
+	if success == 1 && summary.Tier != "" {
+		samples = append(samples, Sample{tierSuccessMetric, []Label{{"backup_job", job}, {"tier", summary.Tier}}, finished})
+	}
+
+	tiers := []string{}
+	for tier := range summary.ObjectCounts {
+		tiers = append(tiers, tier)
+	}
+	sort.Strings(tiers)
+	for _, tier := range tiers {
+		samples = append(samples, Sample{"gos3gfsbackup_objects", []Label{{"backup_job", job}, {"tier", tier}},
+			float64(summary.ObjectCounts[tier])})
+	}
+
+	return samples
+}
+
+// WriteTextfile writes the samples to a node_exporter textfile collector file
+// Samples already in the file for other jobs and tiers are kept. The file is replaced atomically
+// A lock file next to the textfile is held while it is updated so that separate processes do not lose each other's samples
+func WriteTextfile(path string, samples []Sample) error {
+	textfileMu.Lock()
+	defer textfileMu.Unlock()
+
+	lock, err := os.OpenFile(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close() // Closing the file releases the lock
+
+	err = lockFile(lock)
+	if err != nil {
+		return fmt.Errorf("failed to lock metrics textfile: %v", err)
+	}
+
+	series := make(map[string]float64)
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		series = parse(contents)
+	}
+
+	for _, sample := range samples {
+		series[sample.series()] = sample.Value
+	}
+
+	return util.WriteFileAtomic(path, format(series), 0644)
+}
+
+// Push sends the samples to a Pushgateway under the grouping key job="gos3gfsbackup" and backup_job
+// The last success of each tier is pushed to its own group so that pushing one tier does not replace another
+func Push(pushgatewayURL string, job string, samples []Sample) error {
+	groups := make(map[string]map[string]float64)
+
+	for _, sample := range samples {
+		group := "/metrics/job/gos3gfsbackup/backup_job/" + url.PathEscape(job)
+		if sample.Name == tierSuccessMetric {
+			group += "/tier/" + url.PathEscape(sample.label("tier"))
+		}
+		if groups[group] == nil {
+			groups[group] = make(map[string]float64)
+		}
+		groups[group][sample.series()] = sample.Value
+	}
+
+	client := &http.Client{Timeout: pushTimeout}
+
+	for group, series := range groups {
+		// POST only replaces the metrics being pushed, leaving the rest of the group untouched
+		req, err := http.NewRequest("POST", strings.TrimRight(pushgatewayURL, "/")+group, bytes.NewReader(format(series)))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to push metrics to pushgateway: %v", err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("failed to push metrics to pushgateway: unexpected response status: %s", resp.Status)
+		}
+	}
+
+	return nil
+}
+
+// series returns the name and labels of the sample in the text format, i.e. name{label="value"}
+func (s Sample) series() string {
+	if len(s.Labels) == 0 {
+		return s.Name
+	}
+
+	labels := []string{}
+	for _, label := range s.Labels {
+		labels = append(labels, fmt.Sprintf(`%s="%s"`, label.Name, escape(label.Value)))
+	}
+	return s.Name + "{" + strings.Join(labels, ",") + "}"
+}
+
+func (s Sample) label(name string) string {
+	for _, label := range s.Labels {
+		if label.Name == name {
+			return label.Value
+		}
+	}
+	return ""
+}
+
+// format writes every series in the text format, grouped by metric
+func format(series map[string]float64) []byte {
+	var output bytes.Buffer
+
+	for _, def := range definitions {
+		names := []string{}
+		for name := range series {
+			if name == def.name || strings.HasPrefix(name, def.name+"{") {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		sort.Strings(names)
+
+		fmt.Fprintf(&output, "# HELP %s %s\n", def.name, def.help)
+		fmt.Fprintf(&output, "# TYPE %s gauge\n", def.name)
+		for _, name := range names {
+			fmt.Fprintf(&output, "%s %s\n", name, strconv.FormatFloat(series[name], 'g', -1, 64))
+		}
+	}
+
+	return output.Bytes()
+}
+
+// parse reads the series written by a previous run. Anything which was not written by this tool is dropped
+func parse(contents []byte) map[string]float64 {
+	series := make(map[string]float64)
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		separator := strings.LastIndex(line, " ")
+		if separator == -1 {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(line[separator+1:], 64)
+		if err != nil {
+			continue
+		}
+
+		name := line[:separator]
+		for _, def := range definitions {
+			if name == def.name || strings.HasPrefix(name, def.name+"{") {
+				series[name] = value
+			}
+		}
+	}
+
+	return series
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,152 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func getTestSummary(tier string, status error) *report.Summary {
+	summary := report.New("postgres", "backup", "mybucket", "databases/", false)
+	summary.Tier = tier
+	summary.Bytes = 1024
+	summary.DeletedKeys = []string{"databases/daily_postgres_20170910T020000"}
+	summary.ObjectCounts = map[string]int{"daily": 6, "weekly": 4, "monthly": 2}
+	summary.Finish(status)
+	return summary
+}
+
+func TestTextfileKeepsOtherTiers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gos3gfsbackup.prom")
+
+	err = WriteTextfile(path, Collect("postgres", getTestSummary("weekly", nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = WriteTextfile(path, Collect("postgres", getTestSummary("daily", fmt.Errorf("upload failed"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"# TYPE gos3gfsbackup_last_success_timestamp_seconds gauge",
+		`gos3gfsbackup_last_success_timestamp_seconds{backup_job="postgres",tier="weekly"}`,
+		`gos3gfsbackup_last_run_success{backup_job="postgres",action="backup"} 0`,
+		`gos3gfsbackup_last_run_uploaded_bytes{backup_job="postgres",action="backup"} 1024`,
+		`gos3gfsbackup_objects{backup_job="postgres",tier="monthly"} 2`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(contents), line) {
+			t.Error(fmt.Sprintf("expected textfile to contain '%s', instead got:\n%s", line, contents))
+		}
+	}
+
+	if strings.Contains(string(contents), `tier="daily"} 1`) || strings.Count(string(contents), "last_run_success{") != 1 {
+		t.Error(fmt.Sprintf("unexpected samples in textfile:\n%s", contents))
+	}
+}
+
+// TestTextfileHelperProcess writes the textfile when run as a separate process by TestTextfileSharedByProcesses
+func TestTextfileHelperProcess(t *testing.T) {
+	path := os.Getenv("GOS3GFSBACKUP_METRICS_TEXTFILE")
+	if path == "" {
+		return
+	}
+
+	job := os.Getenv("GOS3GFSBACKUP_METRICS_JOB")
+	for i := 0; i < 20; i++ {
+		err := WriteTextfile(path, Collect(job, getTestSummary("daily", nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTextfileSharedByProcesses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gos3gfsbackup.prom")
+	jobs := []string{"postgres", "mysql", "redis", "etcd"}
+
+	cmds := []*exec.Cmd{}
+	for _, job := range jobs {
+		cmd := exec.Command(os.Args[0], "-test.run=TestTextfileHelperProcess")
+		cmd.Env = append(os.Environ(), "GOS3GFSBACKUP_METRICS_TEXTFILE="+path, "GOS3GFSBACKUP_METRICS_JOB="+job)
+		err = cmd.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmds = append(cmds, cmd)
+	}
+
+	for _, cmd := range cmds {
+		err = cmd.Wait()
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected the process writing the textfile to succeed, instead got: %v", err))
+		}
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, job := range jobs {
+		line := fmt.Sprintf(`gos3gfsbackup_last_run_success{backup_job="%s",action="backup"} 1`, job)
+		if !strings.Contains(string(contents), line) {
+			t.Error(fmt.Sprintf("expected textfile to contain '%s', instead got:\n%s", line, contents))
+		}
+	}
+}
+
+func TestPushGroupsTiers(t *testing.T) {
+	var mu sync.Mutex
+	bodies := make(map[string]string)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies[r.Method+" "+r.URL.Path] = string(body)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	err := Push(server.URL+"/", "postgres", Collect("postgres", getTestSummary("weekly", nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runBody := bodies["POST /metrics/job/gos3gfsbackup/backup_job/postgres"]
+	if !strings.Contains(runBody, "gos3gfsbackup_last_run_success") || strings.Contains(runBody, "last_success_timestamp") {
+		t.Error(fmt.Sprintf("unexpected run group body:\n%s", runBody))
+	}
+
+	tierBody := bodies["POST /metrics/job/gos3gfsbackup/backup_job/postgres/tier/weekly"]
+	if !strings.Contains(tierBody, `gos3gfsbackup_last_success_timestamp_seconds{backup_job="postgres",tier="weekly"}`) {
+		t.Error(fmt.Sprintf("unexpected tier group body:\n%s", tierBody))
+	}
+}
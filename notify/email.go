@@ -0,0 +1,242 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	htmltemplate "html/template"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// When an email is sent
+const (
+	EmailAlways    = "always"
+	EmailOnFailure = "failure"
+)
+
+// Email is a mail relay which is sent a report of a run
+type Email struct {
+	Host     string
+	Port     int
+	Username string // If set then the relay is authenticated with PLAIN auth, which requires STARTTLS unless the relay is local
+	Password string
+	StartTLS bool // If true then the connection must be upgraded with STARTTLS before anything is sent
+	From     string
+	To       []string
+	When     string // Either always or failure
+	Timeout  time.Duration
+}
+
+// Notifies returns true if the report of a run with the specified status should be emailed
+func (e Email) Notifies(status string) bool {
+	return e.When != EmailOnFailure || status == report.StatusFailure
+}
+
+// Validate returns every problem found with when the email is sent
+func (e Email) Validate() []string {
+	problems := []string{}
+
+	if e.When != EmailAlways && e.When != EmailOnFailure {
+		problems = append(problems, fmt.Sprintf("email when must be one of [%s|%s]", EmailAlways, EmailOnFailure))
+	}
+
+	return problems
+}
+
+// SendEmail emails a plain text and HTML report of the run. If logger is nil then the default logger is used
+func SendEmail(email Email, summary *report.Summary, logger *log.Logger) error {
+	if !email.Notifies(summary.Status) {
+		return nil
+	}
+
+	message, err := Message(email, summary, time.Now())
+	if err != nil {
+		return err
+	}
+
+	address := net.JoinHostPort(email.Host, strconv.Itoa(email.Port))
+
+	conn, err := net.DialTimeout("tcp", address, email.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail relay '%s': %v", address, err)
+	}
+	if email.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(email.Timeout))
+	}
+
+	client, err := smtp.NewClient(conn, email.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to connect to mail relay '%s': %v", address, err)
+	}
+	defer client.Close()
+
+	if email.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("mail relay '%s' does not support STARTTLS", address)
+		}
+		err = client.StartTLS(&tls.Config{ServerName: email.Host})
+		if err != nil {
+			return fmt.Errorf("failed to start TLS with mail relay '%s': %v", address, err)
+		}
+	}
+
+	if email.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", email.Username, email.Password, email.Host))
+		if err != nil {
+			return fmt.Errorf("failed to authenticate with mail relay '%s': %v", address, err)
+		}
+	}
+
+	err = client.Mail(email.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range email.To {
+		err = client.Rcpt(to)
+		if err != nil {
+			return fmt.Errorf("mail relay '%s' rejected recipient '%s': %v", address, to, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send email to mail relay '%s': %v", address, err)
+	}
+
+	log.OrDefault(logger).Info.Printf("Sent %s report by email to: %s\n", summary.Status, strings.Join(email.To, ", "))
+
+	return client.Quit()
+}
+
+// Message returns the email containing the report of the run with both a plain text and HTML part
+func Message(email Email, summary *report.Summary, date time.Time) ([]byte, error) {
+	if len(email.To) == 0 {
+		return nil, errors.New("no email recipients specified")
+	}
+
+	var text, html bytes.Buffer
+	err := textReport.Execute(&text, summary)
+	if err != nil {
+		return nil, err
+	}
+	err = htmlReport.Execute(&html, summary)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		contents    []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		encoder.Write(part.contents)
+		encoder.Close()
+	}
+	parts.Close()
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", email.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(email.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", Subject(summary))
+	fmt.Fprintf(&message, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n", parts.Boundary())
+	fmt.Fprintf(&message, "\r\n")
+	message.Write(body.Bytes())
+
+	return message.Bytes(), nil
+}
+
+// Subject returns the subject of the email for the run
+func Subject(summary *report.Summary) string {
+	name := summary.Job
+	if name == "" {
+		name = summary.Action
+	}
+	return fmt.Sprintf("[GoS3GFSBackup] %s: %s on %s", strings.ToUpper(summary.Status), name, summary.Hostname)
+}
+
+var textReport = template.Must(template.New("text").Parse(`GoS3GFSBackup run {{.Status}}
+
+Job:         {{.Job}}
+Action:      {{.Action}}
+Host:        {{.Hostname}}
+Bucket:      {{.Bucket}}
+Bucket Dir:  {{.BucketDir}}
+Started:     {{.Started.Format "2006-01-02 15:04:05 MST"}}
+Duration:    {{printf "%0.2f" .DurationSeconds}} seconds
+Dry Run:     {{.DryRun}}
+{{if .Error}}
+Error:
+  {{.Error}}
+{{end}}{{if .Key}}
+Uploaded Key:
+  {{.Key}} ({{.Bytes}} bytes{{if .Tier}}, {{.Tier}}{{end}})
+{{end}}{{if .Warnings}}
+Warnings:
+{{range .Warnings}}  {{.}}
+{{end}}{{end}}{{if .DeletedKeys}}
+Deleted Keys ({{len .DeletedKeys}}):
+{{range .DeletedKeys}}  {{.}}
+{{end}}{{end}}{{if .SkippedKeys}}
+Keys Kept Due To The Retention Period ({{len .SkippedKeys}}):
+{{range .SkippedKeys}}  {{.}}
+{{end}}{{end}}`))
+
+var htmlReport = htmltemplate.Must(htmltemplate.New("html").Parse(`<html>
+<body style="font-family: sans-serif">
+<h2>GoS3GFSBackup run {{.Status}}</h2>
+<table>
+<tr><th align="left">Job</th><td>{{.Job}}</td></tr>
+<tr><th align="left">Action</th><td>{{.Action}}</td></tr>
+<tr><th align="left">Host</th><td>{{.Hostname}}</td></tr>
+<tr><th align="left">Bucket</th><td>{{.Bucket}}</td></tr>
+<tr><th align="left">Bucket Dir</th><td>{{.BucketDir}}</td></tr>
+<tr><th align="left">Started</th><td>{{.Started.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th align="left">Duration</th><td>{{printf "%0.2f" .DurationSeconds}} seconds</td></tr>
+<tr><th align="left">Dry Run</th><td>{{.DryRun}}</td></tr>
+</table>
+{{if .Error}}<h3>Error</h3>
+<pre>{{.Error}}</pre>
+{{end}}{{if .Key}}<h3>Uploaded Key</h3>
+<ul><li>{{.Key}} ({{.Bytes}} bytes{{if .Tier}}, {{.Tier}}{{end}})</li></ul>
+{{end}}{{if .Warnings}}<h3>Warnings</h3>
+<ul>{{range .Warnings}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .DeletedKeys}}<h3>Deleted Keys ({{len .DeletedKeys}})</h3>
+<ul>{{range .DeletedKeys}}<li>{{.}}</li>{{end}}</ul>
+{{end}}{{if .SkippedKeys}}<h3>Keys Kept Due To The Retention Period ({{len .SkippedKeys}})</h3>
+<ul>{{range .SkippedKeys}}<li>{{.}}</li>{{end}}</ul>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,83 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"github.com/daniel-cole/GoS3GFSBackup/rotate"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageContainsTextAndHTML(t *testing.T) {
+	summary := report.New("postgres", "backup", "mybucket", "databases/", false)
+	summary.Key = "databases/daily_postgres_20170918T020000"
+	summary.AddRotation(rotate.Summary{
+		DeletedKeys: []string{"databases/daily_postgres_20170910T020000"},
+		SkippedKeys: []string{"databases/daily_postgres_20170911T020000"},
+	})
+	summary.Finish(errors.New("lost lock <databases/.gos3gfsbackup.lock>"))
+
+	email := Email{From: "backup@example.com", To: []string{"ops@example.com"}}
+	message, err := Message(email, summary, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(parsed.Header.Get("Subject"), "FAILURE: postgres") {
+		t.Error(fmt.Sprintf("unexpected subject: '%s'", parsed.Header.Get("Subject")))
+	}
+
+	_, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		contents, _ := ioutil.ReadAll(quotedprintable.NewReader(part))
+		mediaType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[mediaType] = string(contents)
+	}
+
+	for _, mediaType := range []string{"text/plain", "text/html"} {
+		for _, expected := range []string{summary.Key, summary.DeletedKeys[0], summary.SkippedKeys[0], "within the retention period"} {
+			if !strings.Contains(parts[mediaType], expected) {
+				t.Error(fmt.Sprintf("expected %s part to contain '%s'", mediaType, expected))
+			}
+		}
+	}
+
+	if !strings.Contains(parts["text/html"], "&lt;databases/.gos3gfsbackup.lock&gt;") {
+		t.Error("expected error to be escaped in the html part")
+	}
+}
+
+func TestEmailOnFailureOnly(t *testing.T) {
+	email := Email{When: EmailOnFailure}
+
+	if email.Notifies(report.StatusSuccess) || email.Notifies(report.StatusWarning) {
+		t.Error("expected email not to be sent for runs which did not fail")
+	}
+
+	if !email.Notifies(report.StatusFailure) {
+		t.Error("expected email to be sent for failed runs")
+	}
+}
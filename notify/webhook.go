@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SignatureHeader is the header containing the HMAC-SHA256 signature of the body when a secret is configured
+const SignatureHeader = "X-GoS3GFSBackup-Signature"
+
+// retryDelay is the delay before the first retry. The delay is doubled for every subsequent retry
+var retryDelay = time.Second
+
+// Webhook is an endpoint which is sent the summary of a run
+type Webhook struct {
+	URL      string
+	Headers  map[string]string
+	Secret   string        // If set then the body is signed with HMAC-SHA256 using this secret
+	Template string        // If set then the body is rendered from this template instead of the JSON summary
+	Retries  int           // The number of times a failed request is retried
+	Timeout  time.Duration // The timeout of each request
+	Events   []string      // The statuses which are sent. All statuses are sent if empty
+}
+
+// Notifies returns true if the webhook should be sent the summary of a run with the specified status
+func (w Webhook) Notifies(status string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns every problem found with the events and template of the webhook
+func (w Webhook) Validate() []string {
+	problems := []string{}
+
+	for _, event := range w.Events {
+		if event != report.StatusSuccess && event != report.StatusWarning && event != report.StatusFailure {
+			problems = append(problems, fmt.Sprintf("webhook event '%s' must be one of [%s|%s|%s]",
+				event, report.StatusSuccess, report.StatusWarning, report.StatusFailure))
+		}
+	}
+
+	if w.Template != "" {
+		_, err := ParseTemplate(w.Template)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	return problems
+}
+
+// Send posts the summary of a run to the webhook
+// Requests which fail due to a network error or a 5xx/429 response are retried with an exponential backoff
+// If logger is nil then the default logger is used
+func Send(webhook Webhook, summary *report.Summary, logger *log.Logger) error {
+	if !webhook.Notifies(summary.Status) {
+		return nil
+	}
+
+	body, err := Body(webhook, summary)
+	if err != nil {
+		return err
+	}
+
+	logger = log.OrDefault(logger)
+	client := &http.Client{Timeout: webhook.Timeout}
+	delay := retryDelay
+
+	for attempt := 0; ; attempt++ {
+		retry, err := post(client, webhook, body)
+		if err == nil {
+			logger.Info.Printf("Sent %s notification to webhook: '%s'\n", summary.Status, RedactURL(webhook.URL))
+			return nil
+		}
+
+		if !retry || attempt >= webhook.Retries {
+			return fmt.Errorf("failed to send notification to webhook '%s' after %d attempt(s): %v",
+				RedactURL(webhook.URL), attempt+1, err)
+		}
+
+		logger.Warn.Printf("Failed to send notification to webhook: '%s', retrying in %v: %v\n", RedactURL(webhook.URL), delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Body returns the body sent to the webhook for the summary
+func Body(webhook Webhook, summary *report.Summary) ([]byte, error) {
+	if webhook.Template == "" {
+		return json.Marshal(summary)
+	}
+
+	tmpl, err := ParseTemplate(webhook.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render webhook template: %v", err)
+	}
+
+	return body.Bytes(), nil
+}
+
+// ParseTemplate parses a webhook payload template. The template is executed with the run summary
+// The 'json' function quotes a value for use in a JSON document and 'join' joins a list with a separator
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			encoded, err := json.Marshal(v)
+			return string(encoded), err
+		},
+		"join": func(separator string, values []string) string {
+			return strings.Join(values, separator)
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// Sign returns the signature of the body sent in the SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post sends a single request and returns whether it should be retried if it failed
+func post(client *http.Client, webhook Webhook, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoS3GFSBackup")
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, value)
+	}
+	if webhook.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(webhook.Secret, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected response status: %s", resp.Status)
+}
+
+// RedactURL removes everything after the host as webhook URLs often contain a token in the path
+func RedactURL(url string) string {
+	schemeEnd := strings.Index(url, "://")
+	if schemeEnd == -1 {
+		return url
+	}
+	pathStart := strings.Index(url[schemeEnd+3:], "/")
+	if pathStart == -1 {
+		return url
+	}
+	return url[:schemeEnd+3+pathStart] + "/..."
+}
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/report"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+	retryDelay = time.Millisecond
+}
+
+func getTestSummary() *report.Summary {
+	summary := report.New("postgres", "backup", "mybucket", "databases/", false)
+	summary.Key = "databases/daily_postgres_2017-09-18"
+	summary.Finish(nil)
+	return summary
+}
+
+func TestSendSignedJSON(t *testing.T) {
+	var received report.Summary
+	var signature, token string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+		token = r.Header.Get("Authorization")
+		if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	webhook := Webhook{URL: server.URL, Secret: "secret", Headers: map[string]string{"Authorization": "Bearer token"}}
+	err := Send(webhook, getTestSummary(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Key != "databases/daily_postgres_2017-09-18" || received.Status != report.StatusSuccess {
+		t.Error(fmt.Sprintf("unexpected summary received: %+v (signature: %s)", received, signature))
+	}
+
+	if token != "Bearer token" {
+		t.Error(fmt.Sprintf("expected configured header to be sent but got '%s'", token))
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	var attempts int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	err := Send(Webhook{URL: server.URL, Retries: 2}, getTestSummary(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&attempts) != 3 {
+		t.Error(fmt.Sprintf("expected 3 attempts but got %d", attempts))
+	}
+}
+
+func TestSendDoesNotRetryClientError(t *testing.T) {
+	var attempts int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := Send(Webhook{URL: server.URL, Retries: 2}, getTestSummary(), nil)
+	if err == nil {
+		t.Error("expected error when the webhook responds with 400")
+	}
+
+	if atomic.LoadInt32(&attempts) != 1 {
+		t.Error(fmt.Sprintf("expected 1 attempt but got %d", attempts))
+	}
+}
+
+func TestTemplateBody(t *testing.T) {
+	summary := getTestSummary()
+	summary.Job = `post"gres`
+	webhook := Webhook{Template: `{"text": {{json (printf "Backup of %s: %s" .Job .Status)}}}`}
+
+	body, err := Body(webhook, summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]string{}
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected valid JSON but got '%s': %v", body, err))
+	}
+
+	if payload["text"] != `Backup of post"gres: success` {
+		t.Error(fmt.Sprintf("unexpected rendered text: '%s'", payload["text"]))
+	}
+}
+
+func TestEvents(t *testing.T) {
+	webhook := Webhook{Events: []string{report.StatusFailure, report.StatusWarning}}
+
+	if webhook.Notifies(report.StatusSuccess) {
+		t.Error("expected webhook not to be sent successful runs")
+	}
+
+	if !webhook.Notifies(report.StatusFailure) {
+		t.Error("expected webhook to be sent failed runs")
+	}
+}
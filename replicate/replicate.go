@@ -0,0 +1,291 @@
+package replicate
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rotate"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"github.com/daniel-cole/GoS3GFSBackup/upload"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// md5ETag matches the ETag of an object uploaded in a single part, which is the MD5 of its content
+var md5ETag = regexp.MustCompile(`^"?[0-9a-f]{32}"?$`)
+
+// Summary is the outcome of a replication
+type Summary struct {
+	CopiedKeys []string // Keys copied to the secondary bucket (or that would be copied in a dry run)
+	FailedKeys []string // Keys which could not be copied or verified. Nothing is left in the secondary bucket for these keys
+	Bytes      int64    // The total size of the keys copied
+	Errors     []string
+}
+
+// digest is the checksums of the content streamed from the source key
+type digest struct {
+	sha256 string
+	md5    string
+}
+
+// Replicate copies every key of the series which is missing from the secondary bucket and verifies each copy
+// Keys are copied oldest first. A copy which fails verification is deleted from the secondary bucket
+// A copy is modified when it is made, so it is kept by the rotation of the secondary bucket for at least as long as the
+// source is. Keys which the rotation of the secondary bucket would delete, judged by their modified time in the source
+// bucket, are not copied so that a key rotated out of the secondary bucket is not copied again
+// An error is returned if the keys could not be listed or any key could not be replicated
+func Replicate(svc *s3.S3, replicateObject ReplicateObject, dryRun bool) (Summary, error) {
+	logger := log.OrDefault(replicateObject.Logger)
+	logger.Banner("Replication Started")
+
+	summary := Summary{CopiedKeys: []string{}, FailedKeys: []string{}}
+
+	if replicateObject.ReplicaSvc == nil || replicateObject.ReplicaBucket == "" {
+		return summary, errors.New("replica bucket must be specified")
+	}
+
+	sourceKeys, err := listKeys(svc, replicateObject.Bucket, replicateObject.Prefixes)
+	if err != nil {
+		return summary, fmt.Errorf("failed to list keys of bucket '%s': %v", replicateObject.Bucket, err)
+	}
+
+	replicaKeys, err := listKeys(replicateObject.ReplicaSvc, replicateObject.ReplicaBucket, replicateObject.Prefixes)
+	if err != nil {
+		return summary, fmt.Errorf("failed to list keys of replica bucket '%s': %v", replicateObject.ReplicaBucket, err)
+	}
+
+	expired := make(map[string]bool)
+	if replicateObject.ReplicaPolicy != nil {
+		expired, err = rotate.Expired(storage.NewS3Store(svc, replicateObject.Bucket), *replicateObject.ReplicaPolicy)
+		if err != nil {
+			return summary, fmt.Errorf("failed to check which keys are kept by replica bucket '%s': %v", replicateObject.ReplicaBucket, err)
+		}
+	}
+
+	replicated := make(map[string]bool)
+	for _, object := range replicaKeys {
+		replicated[aws.StringValue(object.Key)] = true
+	}
+
+	missing := []*s3.Object{}
+	skipped := 0
+	for _, object := range sourceKeys {
+		key := aws.StringValue(object.Key)
+		if replicated[key] {
+			continue
+		}
+		if expired[key] {
+			skipped++
+			continue
+		}
+		missing = append(missing, object)
+	}
+
+	logger.Info.Printf("Found %d key(s) in bucket '%s', %d of which are missing from replica bucket '%s'\n",
+		len(sourceKeys), replicateObject.Bucket, len(missing), replicateObject.ReplicaBucket)
+	if skipped > 0 {
+		logger.Info.Printf("Skipping %d key(s) which are outside the retention of replica bucket '%s'\n", skipped, replicateObject.ReplicaBucket)
+	}
+
+	for _, object := range missing {
+		key := aws.StringValue(object.Key)
+
+		if dryRun {
+			logger.Info.Printf("Skipping replication of key: '%s' as dry run has been enabled\n", key)
+			summary.CopiedKeys = append(summary.CopiedKeys, key)
+			summary.Bytes += aws.Int64Value(object.Size)
+			continue
+		}
+
+		err := replicateKey(svc, replicateObject, key, logger)
+		if err != nil {
+			logger.Error.Printf("Failed to replicate key: '%s': %v\n", key, err)
+			summary.FailedKeys = append(summary.FailedKeys, key)
+			summary.Errors = append(summary.Errors, fmt.Sprintf("failed to replicate key '%s': %v", key, err))
+			continue
+		}
+
+		logger.Info.Printf("Successfully replicated key: '%s' (%d bytes)\n", key, aws.Int64Value(object.Size))
+		summary.CopiedKeys = append(summary.CopiedKeys, key)
+		summary.Bytes += aws.Int64Value(object.Size)
+	}
+
+	if len(summary.FailedKeys) > 0 {
+		return summary, fmt.Errorf("failed to replicate %d key(s)", len(summary.FailedKeys))
+	}
+
+	return summary, nil
+}
+
+// listKeys returns every object in the bucket with one of the prefixes sorted oldest first
+func listKeys(svc *s3.S3, bucket string, prefixes []string) ([]*s3.Object, error) {
+	objects := []*s3.Object{}
+	for _, prefix := range prefixes {
+		prefixObjects, err := s3client.GetObjectsByPrefix(svc, bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, prefixObjects...)
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return aws.TimeValue(objects[i].LastModified).Before(aws.TimeValue(objects[j].LastModified))
+	})
+
+	return objects, nil
+}
+
+// replicateKey copies a single key to the secondary bucket and verifies the copy
+func replicateKey(svc *s3.S3, replicateObject ReplicateObject, key string, logger *log.Logger) error {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	if replicateObject.Timeout > 0 {
+		ctx, cancelFn = context.WithTimeout(ctx, replicateObject.Timeout)
+		defer cancelFn()
+	}
+
+	source, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(replicateObject.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+
+	partSize := int64(replicateObject.PartSize * 1024 * 1024)
+
+	var streamed *digest
+	if replicateObject.ServerSide {
+		logger.Info.Printf("Copying key: '%s' to replica bucket '%s'\n", key, replicateObject.ReplicaBucket)
+		err = s3client.CopyKeyToBucket(ctx, replicateObject.ReplicaSvc, replicateObject.Bucket, key, source,
+			replicateObject.ReplicaBucket, key, partSize)
+	} else {
+		logger.Info.Printf("Streaming key: '%s' to replica bucket '%s'\n", key, replicateObject.ReplicaBucket)
+		streamed, err = streamKey(ctx, svc, replicateObject, key, source, partSize)
+	}
+	if err != nil {
+		return err
+	}
+
+	replica, err := replicateObject.ReplicaSvc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(replicateObject.ReplicaBucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		var verifiedBy string
+		verifiedBy, err = verify(source, replica, streamed)
+		if err == nil {
+			logger.Info.Printf("Verified replica of key: '%s' by %s\n", key, verifiedBy)
+			return nil
+		}
+	}
+
+	// Remove the copy so that it is replicated again by the next run
+	_, deleteErr := s3client.DeleteKey(replicateObject.ReplicaSvc, replicateObject.ReplicaBucket, key)
+	if deleteErr != nil {
+		logger.Error.Printf("Failed to delete unverified replica of key: '%s': %v\n", key, deleteErr)
+	}
+	return fmt.Errorf("failed to verify replica: %v", err)
+}
+
+// streamKey downloads the source key and uploads it to the secondary bucket without storing it on this host
+// The user metadata and content type of the source key are kept. Returns the checksums of the content streamed
+func streamKey(ctx context.Context, svc *s3.S3, replicateObject ReplicateObject, key string, source *s3.HeadObjectOutput, partSize int64) (*digest, error) {
+	resp, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(replicateObject.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	sha256Hash := sha256.New()
+	md5Hash := md5.New()
+
+	uploader := s3manager.NewUploaderWithClient(replicateObject.ReplicaSvc, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = replicateObject.NumWorkers
+		u.LeavePartsOnError = false
+	})
+
+	_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(replicateObject.ReplicaBucket),
+		Key:         aws.String(key),
+		Body:        io.TeeReader(resp.Body, io.MultiWriter(sha256Hash, md5Hash)),
+		Metadata:    source.Metadata,
+		ContentType: source.ContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &digest{sha256: hex.EncodeToString(sha256Hash.Sum(nil)), md5: hex.EncodeToString(md5Hash.Sum(nil))}, nil
+}
+
+// verify checks the replica, as stored in the secondary bucket, against the source key and returns a description of how it was verified
+// The size is always compared. The sha256 checksum metadata written by dedup is compared if the source has it, and the
+// MD5 is compared if the replica was stored in a single part. If streamed is nil then the copy was made by S3
+func verify(source *s3.HeadObjectOutput, replica *s3.HeadObjectOutput, streamed *digest) (string, error) {
+	if aws.Int64Value(source.ContentLength) != aws.Int64Value(replica.ContentLength) {
+		return "", fmt.Errorf("replica is %d bytes but the source is %d bytes", aws.Int64Value(replica.ContentLength), aws.Int64Value(source.ContentLength))
+	}
+	verifiedBy := []string{"size"}
+
+	sourceChecksum := getMetadata(source.Metadata, upload.ChecksumMetadataKey)
+	if sourceChecksum != "" {
+		replicaChecksum := getMetadata(replica.Metadata, upload.ChecksumMetadataKey)
+		if streamed != nil {
+			replicaChecksum = streamed.sha256
+		}
+		if replicaChecksum != sourceChecksum {
+			return "", fmt.Errorf("replica has sha256 '%s' but the source has sha256 '%s'", replicaChecksum, sourceChecksum)
+		}
+		verifiedBy = append(verifiedBy, "sha256")
+	}
+
+	// The replica is only compared by MD5 if it was stored in a single part as otherwise its ETag is not the MD5 of the content
+	// A streamed copy is compared with the content streamed if the source was uploaded in parts
+	replicaETag := aws.StringValue(replica.ETag)
+	if md5ETag.MatchString(replicaETag) {
+		sourceMD5 := ""
+		if md5ETag.MatchString(aws.StringValue(source.ETag)) {
+			sourceMD5 = strings.Trim(aws.StringValue(source.ETag), `"`)
+		} else if streamed != nil {
+			sourceMD5 = streamed.md5
+		}
+		if sourceMD5 != "" {
+			replicaMD5 := strings.Trim(replicaETag, `"`)
+			if replicaMD5 != sourceMD5 {
+				return "", fmt.Errorf("replica has md5 '%s' but the source has md5 '%s'", replicaMD5, sourceMD5)
+			}
+			verifiedBy = append(verifiedBy, "md5")
+		}
+	}
+
+	if len(verifiedBy) == 1 {
+		return "size only as there is no checksum to compare", nil
+	}
+
+	return strings.Join(verifiedBy, ", "), nil
+}
+
+// getMetadata returns the value of the user metadata key. The SDK canonicalises the case of metadata keys so they are compared without case
+func getMetadata(metadata map[string]*string, key string) string {
+	for metadataKey, value := range metadata {
+		if strings.EqualFold(metadataKey, key) {
+			return aws.StringValue(value)
+		}
+	}
+	return ""
+}
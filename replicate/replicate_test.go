@@ -0,0 +1,175 @@
+package replicate
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rotate"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"github.com/daniel-cole/GoS3GFSBackup/upload"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMD5 = "5d41402abc4b2a76b9719d911017c592"
+const testSHA256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func getTestHead(size int64, etag string, checksum string) *s3.HeadObjectOutput {
+	head := &s3.HeadObjectOutput{ContentLength: aws.Int64(size), ETag: aws.String(etag), Metadata: map[string]*string{}}
+	if checksum != "" {
+		head.Metadata["Gos3gfsbackup-Sha256"] = aws.String(checksum)
+	}
+	return head
+}
+
+func TestVerifyServerSideCopy(t *testing.T) {
+	source := getTestHead(5, `"`+testMD5+`"`, testSHA256)
+
+	verifiedBy, err := verify(source, getTestHead(5, `"`+testMD5+`"`, testSHA256), nil)
+	if err != nil || verifiedBy != "size, sha256, md5" {
+		t.Error(fmt.Sprintf("expected replica to be verified by size, sha256 and md5 but got: '%s' %v", verifiedBy, err))
+	}
+
+	// A large key is copied in parts so the ETag of the replica is not the MD5 of the content
+	verifiedBy, err = verify(source, getTestHead(5, `"`+testMD5+`-2"`, testSHA256), nil)
+	if err != nil || verifiedBy != "size, sha256" {
+		t.Error(fmt.Sprintf("expected replica to be verified by size and sha256 but got: '%s' %v", verifiedBy, err))
+	}
+
+	_, err = verify(source, getTestHead(4, `"`+testMD5+`"`, testSHA256), nil)
+	if err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Error(fmt.Sprintf("expected replica with a different size to fail verification but got: %v", err))
+	}
+
+	_, err = verify(source, getTestHead(5, `"`+testMD5+`"`, "bad"), nil)
+	if err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Error(fmt.Sprintf("expected replica with a different checksum to fail verification but got: %v", err))
+	}
+}
+
+func TestVerifyStreamedCopy(t *testing.T) {
+	// The source was uploaded in parts so the replica is compared with the content streamed
+	source := getTestHead(5, `"`+testMD5+`-2"`, "")
+	replica := getTestHead(5, `"`+testMD5+`"`, "")
+
+	verifiedBy, err := verify(source, replica, &digest{sha256: testSHA256, md5: testMD5})
+	if err != nil || verifiedBy != "size, md5" {
+		t.Error(fmt.Sprintf("expected replica to be verified by size and md5 but got: '%s' %v", verifiedBy, err))
+	}
+
+	// The content streamed matches the source but the replica stored does not
+	_, err = verify(source, getTestHead(5, `"`+strings.Repeat("0", 32)+`"`, ""), &digest{sha256: testSHA256, md5: testMD5})
+	if err == nil || !strings.Contains(err.Error(), "md5") {
+		t.Error(fmt.Sprintf("expected replica with a different md5 to fail verification but got: %v", err))
+	}
+
+	_, err = verify(getTestHead(5, `"`+testMD5+`-2"`, testSHA256), replica, &digest{sha256: "bad", md5: testMD5})
+	if err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Error(fmt.Sprintf("expected content streamed with a different checksum to fail verification but got: %v", err))
+	}
+
+	// A replica stored in parts without a checksum of the source can only be verified by size
+	verifiedBy, err = verify(source, getTestHead(5, `"`+testMD5+`-1"`, ""), &digest{sha256: testSHA256, md5: testMD5})
+	if err != nil || !strings.Contains(verifiedBy, "size only") {
+		t.Error(fmt.Sprintf("expected replica to be verified by size only but got: '%s' %v", verifiedBy, err))
+	}
+}
+
+func TestGetMetadataIgnoresCase(t *testing.T) {
+	metadata := map[string]*string{"Gos3gfsbackup-Sha256": aws.String(testSHA256)}
+	if getMetadata(metadata, upload.ChecksumMetadataKey) != testSHA256 {
+		t.Error("expected metadata key to be found without case")
+	}
+}
+
+// getTestReplicateObject returns a series of 5 daily keys in the bucket dir, one a day, and an object replicating it to
+// a replica bucket which keeps the newest 2 daily keys
+func getTestReplicateObject(server *s3test.Server, bucketDir string, serverSide bool) ReplicateObject {
+	start := time.Now().AddDate(0, 0, -5)
+	for i := 0; i < 5; i++ {
+		modified := start.AddDate(0, 0, i)
+		server.Now = func() time.Time { return modified }
+		server.PutObject("primary", fmt.Sprintf("%sdaily_postgres_%d", bucketDir, i), []byte(fmt.Sprintf("backup %d", i)))
+	}
+	server.Now = time.Now
+
+	policy := rpolicy.RotationPolicy{DailyPrefix: "daily_", DailyRetentionCount: 2, WeeklyPrefix: "weekly_", WeeklyRetentionCount: 2,
+		BucketDir: bucketDir, S3FileName: "postgres"}
+	return ReplicateObject{
+		Bucket:        "primary",
+		Prefixes:      []string{bucketDir + "daily_postgres_", bucketDir + "weekly_postgres_"},
+		ReplicaSvc:    server.Client(),
+		ReplicaBucket: "replica",
+		ReplicaPolicy: &policy,
+		ServerSide:    serverSide,
+		Timeout:       time.Minute,
+		NumWorkers:    1,
+		PartSize:      5,
+		Logger:        log.New(ioutil.Discard, log.LevelInfo, log.FormatText),
+	}
+}
+
+func TestReplicateKeepsReplicaRetention(t *testing.T) {
+	for _, serverSide := range []bool{true, false} {
+		for _, bucketDir := range []string{"", "databases/"} {
+			testReplicateKeepsReplicaRetention(t, bucketDir, serverSide)
+		}
+	}
+}
+
+func testReplicateKeepsReplicaRetention(t *testing.T, bucketDir string, serverSide bool) {
+	server := s3test.NewServer("primary", "replica")
+	defer server.Close()
+	replicateObject := getTestReplicateObject(server, bucketDir, serverSide)
+	name := fmt.Sprintf("bucket dir '%s', server side %t", bucketDir, serverSide)
+
+	// A key of another series in the replica bucket must not be rotated
+	server.PutObject("replica", "daily_other_0", []byte("other"))
+
+	summary, err := Replicate(server.Client(), replicateObject, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to replicate without any error (%s): %v", name, err))
+	}
+
+	// Only the keys kept by the rotation of the replica bucket are copied
+	expected := []string{bucketDir + "daily_postgres_3", bucketDir + "daily_postgres_4"}
+	if !reflect.DeepEqual(summary.CopiedKeys, expected) || !reflect.DeepEqual(server.Keys("replica"), append([]string{"daily_other_0"}, expected...)) {
+		t.Error(fmt.Sprintf("expected %v to be replicated (%s), instead copied %v", expected, name, summary.CopiedKeys))
+	}
+
+	for _, key := range expected {
+		source, _ := server.Object("primary", key)
+		replica, _ := server.Object("replica", key)
+		if string(replica.Data) != string(source.Data) {
+			t.Error(fmt.Sprintf("expected replica of '%s' to match the source (%s), instead got: %s", key, name, replica.Data))
+		}
+	}
+
+	rotation, err := rotate.RotateStore(storage.NewS3Store(server.Client(), "replica"), *replicateObject.ReplicaPolicy, false, replicateObject.Logger)
+	if err != nil || len(rotation.DeletedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected the rotation of the replica bucket not to delete any keys (%s), instead got: %v %v", name, rotation.DeletedKeys, err))
+	}
+
+	// A new backup is copied and the key which falls out of the retention of the replica bucket is not copied again
+	server.PutObject("primary", bucketDir+"daily_postgres_5", []byte("backup 5"))
+	summary, err = Replicate(server.Client(), replicateObject, false)
+	if err != nil || !reflect.DeepEqual(summary.CopiedKeys, []string{bucketDir + "daily_postgres_5"}) {
+		t.Error(fmt.Sprintf("expected only the new key to be replicated (%s), instead copied %v %v", name, summary.CopiedKeys, err))
+	}
+
+	rotation, err = rotate.RotateStore(storage.NewS3Store(server.Client(), "replica"), *replicateObject.ReplicaPolicy, false, replicateObject.Logger)
+	if err != nil || !reflect.DeepEqual(rotation.DeletedKeys, []string{bucketDir + "daily_postgres_3"}) {
+		t.Error(fmt.Sprintf("expected the oldest replica to be rotated (%s), instead got: %v %v", name, rotation.DeletedKeys, err))
+	}
+
+	summary, err = Replicate(server.Client(), replicateObject, false)
+	if err != nil || len(summary.CopiedKeys) != 0 {
+		t.Error(fmt.Sprintf("expected the rotated key not to be replicated again (%s), instead copied %v %v", name, summary.CopiedKeys, err))
+	}
+}
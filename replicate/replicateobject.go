@@ -0,0 +1,24 @@
+package replicate
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"time"
+)
+
+// ReplicateObject represents the backups of a series to be replicated to a secondary bucket
+type ReplicateObject struct {
+	Bucket        string
+	Prefixes      []string // Every key with one of the prefixes is replicated
+	ReplicaSvc    *s3.S3   // The client of the secondary bucket, which may be in another region or use other credentials
+	ReplicaBucket string
+	// If set then keys the rotation of the replica bucket would delete are not replicated. The bucket dir and file name
+	// of the policy must be those of the prefixes, as only the keys of the series of the policy are rotated
+	ReplicaPolicy *rpolicy.RotationPolicy
+	ServerSide    bool // If enabled then keys are copied by S3. Otherwise they are streamed through this host
+	Timeout       time.Duration
+	NumWorkers    int
+	PartSize      int
+	Logger        *log.Logger // If nil then the default logger is used
+}
@@ -0,0 +1,45 @@
+package report
+
+// Exit codes of GoS3GFSBackup. If more than one job is run the exit code is that of the first job with the worst status
+const (
+	ExitSuccess           = 0  // Every job succeeded, including with a warning, e.g. keys kept due to the retention period. 5 is no longer used
+	ExitFailure           = 1  // A job failed for any reason without a more specific exit code, e.g. a hook or download failed
+	ExitValidation        = 2  // The arguments or config file are invalid. No job was run
+	ExitUploadFailed      = 3  // The file could not be uploaded. No rotation was run
+	ExitRotationFailed    = 4  // The rotation ran but keys could not be listed or deleted
+	ExitLockHeld          = 6  // The series is locked by another run
+	ExitPlanStale         = 7  // The bucket has changed since the rotation plan was created. No keys were deleted
+	ExitMinKeep           = 8  // The rotation was refused as it would leave fewer keys in a tier than the minimum. No keys were deleted
+	ExitMaxDeletes        = 9  // The rotation was refused as it would delete more keys than the maximum. No keys were deleted
+	ExitStaleBackup       = 10 // The rotation was refused as the newest backup is older than the maximum backup age. No keys were deleted
+	ExitReplicationFailed = 11 // One or more backups could not be replicated to the replica bucket. The replica bucket was not rotated
+)
+
+// ExitError is an error with the exit code it should cause
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+// WithExitCode returns the error with the exit code it should cause. If err is nil then nil is returned
+func WithExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ExitError{Code: code, Err: err}
+}
+
+// ExitCode returns the exit code of the error. An error without an exit code causes ExitFailure
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	if exitErr, ok := err.(*ExitError); ok {
+		return exitErr.Code
+	}
+	return ExitFailure
+}
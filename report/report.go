@@ -0,0 +1,200 @@
+package report
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/rotate"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Statuses of a run
+const (
+	StatusSuccess = "success"
+	StatusWarning = "warning" // The run completed but something needs attention, i.e. keys were kept due to the retention period
+	StatusFailure = "failure"
+)
+
+// Summary describes a single run of a job
+type Summary struct {
+	RunID           string               `json:"run_id"`
+	Job             string               `json:"job"`
+	Action          string               `json:"action"`
+	Status          string               `json:"status"`
+	Bucket          string               `json:"bucket"`
+	BucketDir       string               `json:"bucket_dir"`
+	Key             string               `json:"key"`         // The key uploaded or downloaded
+	Path            string               `json:"path"`        // The local file uploaded or downloaded
+	CopiedFrom      string               `json:"copied_from"` // The identical latest backup copied to the key instead of uploading (--dedup)
+	Bytes           int64                `json:"bytes"`       // The bytes uploaded, downloaded, replicated or reclaimed by aborting multipart uploads
+	Tier            string               `json:"tier"`
+	DeletedKeys     []string             `json:"deleted_keys"`
+	SkippedKeys     []string             `json:"skipped_keys"`
+	Rotation        []rotate.TierSummary `json:"rotation"`        // The decision made for every key of each tier rotated
+	Promotions      []rotate.Promotion   `json:"promotions"`      // Keys promoted to fill missed weekly or monthly backups
+	ReplicatedKeys  []string             `json:"replicated_keys"` // Keys copied to the replica bucket by the replicate action
+	Destinations    []Destination        `json:"destinations"`    // The additional destinations the backup action uploaded to
+	Chunks          *Chunks              `json:"chunks"`          // The chunks written and collected by a chunked backup or rotation. Nil if not chunked
+	AbortedUploads  []string             `json:"aborted_uploads"` // Keys of the multipart uploads aborted by the prune-multipart action
+	Warnings        []string             `json:"warnings"`
+	Retries         int64                `json:"retries"`       // The number of S3 requests which were retried
+	ObjectCounts    map[string]int       `json:"object_counts"` // Tier -> number of objects stored after the run
+	Error           string               `json:"error"`
+	ExitCode        int                  `json:"exit_code"`
+	DryRun          bool                 `json:"dry_run"`
+	Hostname        string               `json:"hostname"`
+	Started         time.Time            `json:"started"`
+	Finished        time.Time            `json:"finished"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	Timings         map[string]float64   `json:"timings"` // Phase of the run -> seconds spent in it
+}
+
+// Destination describes the upload of the backup to an additional destination and the rotation of that destination
+type Destination struct {
+	Name        string   `json:"name"`
+	Bucket      string   `json:"bucket"`
+	BucketDir   string   `json:"bucket_dir"`
+	Mode        string   `json:"mode"`
+	Key         string   `json:"key"`
+	DeletedKeys []string `json:"deleted_keys"`
+	Error       string   `json:"error"` // Set if the upload to or the rotation of the destination failed
+}
+
+// Chunks describes the chunks uploaded by a chunked backup and the unreferenced chunks deleted by garbage collection
+type Chunks struct {
+	Total         int      `json:"total"` // The number of chunks the file was split into
+	Uploaded      int      `json:"uploaded"`
+	UploadedBytes int64    `json:"uploaded_bytes"`
+	Reused        int      `json:"reused"` // Chunks which were already stored and were not uploaded again
+	DeletedKeys   []string `json:"deleted_keys"`
+	DeletedBytes  int64    `json:"deleted_bytes"`
+}
+
+// Report is written with --report and describes every run of an invocation
+type Report struct {
+	Status   string     `json:"status"` // The worst status of the runs
+	ExitCode int        `json:"exit_code"`
+	Error    string     `json:"error,omitempty"` // Set if the invocation failed before any job was run
+	Runs     []*Summary `json:"runs"`
+}
+
+// New creates the summary for a run which is starting now
+func New(job string, action string, bucket string, bucketDir string, dryRun bool) *Summary {
+	hostname, _ := os.Hostname()
+
+	return &Summary{
+		RunID:          newRunID(),
+		Job:            job,
+		Action:         action,
+		Bucket:         bucket,
+		BucketDir:      bucketDir,
+		DeletedKeys:    []string{},
+		SkippedKeys:    []string{},
+		Rotation:       []rotate.TierSummary{},
+		Promotions:     []rotate.Promotion{},
+		ReplicatedKeys: []string{},
+		Destinations:   []Destination{},
+		Warnings:       []string{},
+		ObjectCounts:   make(map[string]int),
+		Timings:        make(map[string]float64),
+		DryRun:         dryRun,
+		Hostname:       hostname,
+		Started:        time.Now().UTC(),
+	}
+}
+
+// AddRotation records the outcome of a rotation. Keys kept due to the retention period and any
+// errors encountered during the rotation are recorded as warnings
+func (s *Summary) AddRotation(rotation rotate.Summary) {
+	s.DeletedKeys = append(s.DeletedKeys, rotation.DeletedKeys...)
+	s.SkippedKeys = append(s.SkippedKeys, rotation.SkippedKeys...)
+	s.Rotation = append(s.Rotation, rotation.Tiers...)
+	s.Promotions = append(s.Promotions, rotation.Promotions...)
+
+	if len(rotation.SkippedKeys) > 0 {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("%d key(s) in excess of the retention count were kept "+
+			"as they are within the retention period", len(rotation.SkippedKeys)))
+	}
+	s.Warnings = append(s.Warnings, rotation.Errors...)
+}
+
+// Finish records the end of the run and sets its status
+func (s *Summary) Finish(err error) {
+	s.Finished = time.Now().UTC()
+	s.DurationSeconds = s.Finished.Sub(s.Started).Seconds()
+
+	switch {
+	case err != nil:
+		s.Error = err.Error()
+		s.Status = StatusFailure
+		s.ExitCode = ExitCode(err)
+	case len(s.Warnings) > 0:
+		s.Status = StatusWarning // A warning does not change the exit code as the job did what it was asked to do
+	default:
+		s.Status = StatusSuccess
+	}
+}
+
+// Time starts timing a phase of the run. The returned function records the time spent when the phase ends
+// e.g. defer summary.Time("upload")()
+func (s *Summary) Time(phase string) func() {
+	started := time.Now()
+	return func() {
+		s.Timings[phase] += time.Since(started).Seconds()
+	}
+}
+
+// NewReport creates the report of the runs. The status is the worst of the runs and the exit code
+// is that of the first run with the worst status
+func NewReport(runs []*Summary) Report {
+	r := Report{Status: StatusSuccess, Runs: runs}
+	if r.Runs == nil {
+		r.Runs = []*Summary{}
+	}
+
+	for _, run := range runs {
+		if severity[run.Status] > severity[r.Status] {
+			r.Status = run.Status
+			r.ExitCode = run.ExitCode
+		}
+	}
+
+	return r
+}
+
+var severity = map[string]int{
+	StatusSuccess: 0,
+	StatusWarning: 1,
+	StatusFailure: 2,
+}
+
+// Write writes the report as JSON to the path. If the path is '-' then the report is written to stdout
+// The file is replaced atomically so that a reader never sees a partial report
+func Write(path string, r Report) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	if path == "-" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+
+	return util.WriteFileAtomic(path, content, 0644)
+}
+
+// newRunID returns a random id used to correlate the log entries, notifications and metrics of a run
+func newRunID() string {
+	random := make([]byte, 8)
+	_, err := rand.Read(random)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(random)
+}
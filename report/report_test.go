@@ -0,0 +1,140 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/rotate"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func getTestRotation() rotate.Summary {
+	return rotate.Summary{
+		DeletedKeys: []string{"daily_postgres_20170910T020000"},
+		SkippedKeys: []string{"daily_postgres_20170911T020000"},
+		Tiers: []rotate.TierSummary{{
+			Tier:           "daily",
+			Prefix:         "daily_",
+			RetentionCount: 6,
+			KeysFound:      8,
+			Decisions: []rotate.Decision{
+				{Key: "daily_postgres_20170911T020000", Action: rotate.ActionSkip, Reason: "within the enforced retention period"},
+				{Key: "daily_postgres_20170910T020000", Action: rotate.ActionDelete, Reason: "exceeds the retention count of 6"},
+			},
+		}},
+	}
+}
+
+func TestFinishStatus(t *testing.T) {
+	summary := New("postgres", "backup", "mybucket", "databases/", false)
+	summary.Finish(nil)
+	if summary.Status != StatusSuccess || summary.ExitCode != 0 {
+		t.Error(fmt.Sprintf("expected success with exit code 0 but got %s with exit code %d", summary.Status, summary.ExitCode))
+	}
+
+	summary = New("postgres", "backup", "mybucket", "databases/", false)
+	summary.AddRotation(getTestRotation())
+	summary.Finish(nil)
+	if summary.Status != StatusWarning || summary.ExitCode != ExitSuccess {
+		t.Error(fmt.Sprintf("expected warning with exit code %d but got %s with exit code %d", ExitSuccess, summary.Status, summary.ExitCode))
+	}
+
+	summary = New("postgres", "backup", "mybucket", "databases/", false)
+	summary.Finish(errors.New("hook failed"))
+	if summary.Status != StatusFailure || summary.ExitCode != ExitFailure || summary.Error != "hook failed" {
+		t.Error(fmt.Sprintf("expected failure with exit code %d but got %s with exit code %d", ExitFailure, summary.Status, summary.ExitCode))
+	}
+
+	summary = New("postgres", "backup", "mybucket", "databases/", false)
+	summary.Finish(WithExitCode(ExitUploadFailed, errors.New("upload failed")))
+	if summary.Status != StatusFailure || summary.ExitCode != ExitUploadFailed || summary.Error != "upload failed" {
+		t.Error(fmt.Sprintf("expected failure with exit code %d but got %s with exit code %d", ExitUploadFailed, summary.Status, summary.ExitCode))
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if ExitCode(nil) != ExitSuccess {
+		t.Error("expected no error to be success")
+	}
+
+	if WithExitCode(ExitLockHeld, nil) != nil {
+		t.Error("expected no error when wrapping nil")
+	}
+
+	if ExitCode(WithExitCode(ExitLockHeld, errors.New("lock held"))) != ExitLockHeld {
+		t.Error(fmt.Sprintf("expected exit code %d", ExitLockHeld))
+	}
+}
+
+func TestNewReportUsesWorstRun(t *testing.T) {
+	warning := New("postgres", "backup", "mybucket", "databases/", false)
+	warning.AddRotation(getTestRotation())
+	warning.Finish(nil)
+
+	failure := New("mysql", "backup", "mybucket", "mysql/", false)
+	failure.Finish(WithExitCode(ExitLockHeld, errors.New("lock held")))
+
+	success := New("redis", "backup", "mybucket", "redis/", false)
+	success.Finish(nil)
+
+	r := NewReport([]*Summary{success, warning})
+	if r.Status != StatusWarning || r.ExitCode != ExitSuccess {
+		t.Error(fmt.Sprintf("expected warning with exit code %d but got %s with exit code %d", ExitSuccess, r.Status, r.ExitCode))
+	}
+
+	r = NewReport([]*Summary{warning, failure, success})
+	if r.Status != StatusFailure || r.ExitCode != ExitLockHeld {
+		t.Error(fmt.Sprintf("expected failure with exit code %d but got %s with exit code %d", ExitLockHeld, r.Status, r.ExitCode))
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	summary := New("postgres", "backup", "mybucket", "databases/", false)
+	summary.AddRotation(getTestRotation())
+	summary.Time("upload")()
+	summary.Finish(nil)
+
+	path := filepath.Join(dir, "report.json")
+	err = Write(path, NewReport([]*Summary{summary}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var written Report
+	err = json.Unmarshal(contents, &written)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected report to be valid JSON: %v", err))
+	}
+
+	if len(written.Runs) != 1 || written.Runs[0].RunID != summary.RunID {
+		t.Fatal(fmt.Sprintf("expected report to contain the run: %s", contents))
+	}
+
+	run := written.Runs[0]
+	if len(run.Rotation) != 1 || len(run.Rotation[0].Decisions) != 2 || run.Rotation[0].Decisions[0].Action != rotate.ActionSkip {
+		t.Error(fmt.Sprintf("expected rotation decisions to be written: %s", contents))
+	}
+
+	if _, ok := run.Timings["upload"]; !ok {
+		t.Error(fmt.Sprintf("expected upload timing to be written: %s", contents))
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Error(fmt.Sprintf("expected only the report in the directory but found %d file(s)", len(files)))
+	}
+}
@@ -0,0 +1,267 @@
+package rotate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PlanVersion is the version of the plan file format
+const PlanVersion = 1
+
+// Plan is a rotation which has been decided but not yet applied
+// Applying the plan deletes exactly the candidate keys, and only if the bucket has not changed since the plan was created
+type Plan struct {
+	Version     int           `json:"version"`
+	Bucket      string        `json:"bucket"`
+	Created     time.Time     `json:"created"`
+	Fingerprint string        `json:"fingerprint"` // sha256 of the key, etag, size and last modified time of every key of the rotated tiers
+	Candidates  []Candidate   `json:"candidates"`  // Keys deleted when the plan is applied
+	Tiers       []TierSummary `json:"tiers"`       // The decision made for every key of each tier
+}
+
+// Candidate is a key which will be deleted when the plan is applied
+type Candidate struct {
+	Key      string    `json:"key"`
+	Tier     string    `json:"tier"`
+	Modified time.Time `json:"modified"`
+	AgeHours float64   `json:"age_hours"` // The age of the key when the plan was created
+	Rule     string    `json:"rule"`      // The rule of the policy which selected the key for deletion
+}
+
+// ErrBucketChanged is returned when a plan is applied to a bucket which has changed since the plan was created
+var ErrBucketChanged = errors.New("the bucket has changed since the plan was created. Create a new plan")
+
+// CreatePlan decides what the rotation with the provided policy would do without deleting any keys
+// The safeguards of the policy are checked when the plan is created. A *SafeguardError is returned if any are violated
+func CreatePlan(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, logger *log.Logger) (Plan, error) {
+	logger = log.OrDefault(logger)
+
+	logger.Banner("GoS3GFSBackup Rotation Plan Started!")
+
+	// Fingerprint before listing the keys so that any change made while the plan is created is detected on apply
+	// Only the keys of the series are fingerprinted so that changes to other series do not invalidate the plan
+	prefixes := []string{}
+	for _, tier := range getTiers(policy) {
+		prefixes = append(prefixes, tier.prefix)
+	}
+	fingerprint, err := Fingerprint(svc, bucket, prefixes)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to fingerprint bucket: %v", err)
+	}
+
+	plan := Plan{
+		Version:     PlanVersion,
+		Bucket:      bucket,
+		Created:     time.Now().UTC(),
+		Fingerprint: fingerprint,
+		Candidates:  []Candidate{},
+		Tiers:       []TierSummary{},
+	}
+
+	for _, tier := range getTiers(policy) {
+		tierSummary, err := planTier(storage.NewS3Store(svc, bucket), tier.name, tier.retentionPeriod, tier.retentionCount, tier.prefix,
+			policy.EnforceRetentionPeriod, logger.With("tier", tier.name))
+		if err != nil {
+			return Plan{}, fmt.Errorf("failed to retrieve '%s' keys: %v", tier.prefix, err)
+		}
+		plan.Tiers = append(plan.Tiers, tierSummary)
+
+		for _, decision := range tierSummary.Decisions {
+			if decision.Action != ActionDelete {
+				continue
+			}
+			plan.Candidates = append(plan.Candidates, Candidate{
+				Key:      decision.Key,
+				Tier:     tier.name,
+				Modified: decision.Modified,
+				AgeHours: plan.Created.Sub(decision.Modified).Hours(),
+				Rule:     decision.Reason,
+			})
+		}
+	}
+
+	err = checkSafeguards(storage.NewS3Store(svc, bucket), policy, plan.Tiers, logger)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	logger.Info.Printf("The total number of keys that will be deleted when the plan is applied is: %d\n", len(plan.Candidates))
+	for _, candidate := range plan.Candidates {
+		logger.Info.Printf("Key planned for deletion: '%s' (%s)\n", candidate.Key, candidate.Rule)
+	}
+
+	return plan, nil
+}
+
+// Expired returns the keys of the store which a rotation with the policy would delete, judged by their modified time in
+// the store. The safeguards of the policy are not checked and nothing is logged as no keys are deleted
+func Expired(store storage.Store, policy rpolicy.RotationPolicy) (map[string]bool, error) {
+	logger := log.New(ioutil.Discard, log.LevelError, log.FormatText)
+
+	expired := make(map[string]bool)
+	for _, tier := range getTiers(policy) {
+		tierSummary, err := planTier(store, tier.name, tier.retentionPeriod, tier.retentionCount, tier.prefix,
+			policy.EnforceRetentionPeriod, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve '%s' keys: %v", tier.prefix, err)
+		}
+
+		for _, decision := range tierSummary.Decisions {
+			if decision.Action == ActionDelete {
+				expired[decision.Key] = true
+			}
+		}
+	}
+
+	return expired, nil
+}
+
+// ApplyPlan deletes exactly the candidate keys of the plan and returns a summary of the rotation
+// Candidates may be removed from the plan to keep the keys, or added if they are keys of a tier of the plan
+// ErrBucketChanged is returned without deleting any keys if the bucket has changed since the plan was created
+// The keys are deleted through the store, which must be the bucket of the plan
+// If dry run is enabled then the plan is checked but no keys are deleted
+func ApplyPlan(svc *s3.S3, store storage.Store, plan Plan, dryRun bool, logger *log.Logger) (Summary, error) {
+	logger = log.OrDefault(logger)
+
+	logger.Banner("GoS3GFSBackup Rotation Apply Started!")
+
+	if plan.Version != PlanVersion {
+		return Summary{}, fmt.Errorf("unsupported plan version %d, expected %d", plan.Version, PlanVersion)
+	}
+
+	tiers, err := getPlannedTiers(plan)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	prefixes := []string{}
+	for _, tier := range plan.Tiers {
+		prefixes = append(prefixes, tier.Prefix)
+	}
+
+	fingerprint, err := Fingerprint(svc, plan.Bucket, prefixes)
+	if err != nil {
+		return Summary{}, fmt.Errorf("failed to fingerprint bucket: %v", err)
+	}
+
+	if fingerprint != plan.Fingerprint {
+		logger.Error.Printf("Bucket fingerprint '%s' does not match the fingerprint of the plan '%s'\n", fingerprint, plan.Fingerprint)
+		return Summary{}, ErrBucketChanged
+	}
+
+	logger.Info.Printf("Applying plan created at %s with %d candidate key(s)\n", plan.Created.Format(time.RFC3339), len(plan.Candidates))
+
+	summary := Summary{DeletedKeys: []string{}}
+	for _, tier := range tiers {
+		summary.add(executeTier(store, tier, dryRun, logger.With("tier", tier.Tier)))
+	}
+
+	logger.Info.Printf("The total number of keys deleted for this rotation was: %d\n", len(summary.DeletedKeys))
+
+	return summary, nil
+}
+
+// getPlannedTiers returns the tiers of the plan with their decisions changed so that exactly the candidates are deleted
+// A candidate which is not a key of a tier of the plan is refused as the fingerprint of the plan does not cover it
+func getPlannedTiers(plan Plan) ([]TierSummary, error) {
+	candidates := make(map[string]Candidate)
+	for _, candidate := range plan.Candidates {
+		candidates[candidate.Key] = candidate
+	}
+
+	tiers := []TierSummary{}
+	for _, tier := range plan.Tiers {
+		decisions := []Decision{}
+		for _, decision := range tier.Decisions {
+			candidate, ok := candidates[decision.Key]
+			delete(candidates, decision.Key)
+
+			if ok && decision.Action != ActionDelete {
+				decision.Action = ActionDelete
+				decision.Reason = fmt.Sprintf("added to the plan: %s", candidate.Rule)
+			} else if !ok && decision.Action == ActionDelete {
+				decision.Action = ActionKeep
+				decision.Reason = fmt.Sprintf("removed from the plan: %s", decision.Reason)
+			}
+			decisions = append(decisions, decision)
+		}
+		tier.Decisions = decisions
+		tiers = append(tiers, tier)
+	}
+
+	if len(candidates) > 0 {
+		keys := []string{}
+		for key := range candidates {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return nil, fmt.Errorf("candidate key(s) '%s' are not keys of a tier of the plan", strings.Join(keys, "', '"))
+	}
+
+	return tiers, nil
+}
+
+// Fingerprint returns a sha256 of the key, etag, size and last modified time of every key with one of the prefixes
+func Fingerprint(svc *s3.S3, bucket string, prefixes []string) (string, error) {
+	lines := []string{}
+	for _, prefix := range prefixes {
+		objects, err := s3client.GetObjectsByPrefix(svc, bucket, prefix)
+		if err != nil {
+			return "", err
+		}
+		for _, object := range objects {
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%d\t%s\n", aws.StringValue(object.Key), aws.StringValue(object.ETag),
+				aws.Int64Value(object.Size), aws.TimeValue(object.LastModified).UTC().Format(time.RFC3339Nano)))
+		}
+	}
+	sort.Strings(lines)
+
+	hash := sha256.New()
+	for _, line := range lines {
+		hash.Write([]byte(line))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// WritePlan writes the plan as JSON to the path. The file is replaced atomically
+func WritePlan(path string, plan Plan) error {
+	content, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	content = append(content, '\n')
+
+	return util.WriteFileAtomic(path, content, 0644)
+}
+
+// ReadPlan reads a plan written by WritePlan
+func ReadPlan(path string) (Plan, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	var plan Plan
+	err = json.Unmarshal(content, &plan)
+	if err != nil {
+		return Plan{}, fmt.Errorf("failed to parse plan '%s': %v", path, err)
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,105 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"testing"
+	"time"
+)
+
+func TestPlanListsEveryPage(t *testing.T) {
+	server := s3test.NewServer("plan-bucket")
+	defer server.Close()
+	svc := server.Client()
+
+	// More keys than a single page of the listing, each a minute newer than the last
+	numKeys := 1005
+	modified := time.Now().Add(-time.Hour * 24 * 30).UTC()
+	server.Now = func() time.Time { return modified }
+	for i := 0; i < numKeys; i++ {
+		modified = modified.Add(time.Minute)
+		server.PutObject("plan-bucket", fmt.Sprintf("daily_postgres_%04d", i), []byte("backup"))
+	}
+
+	planPolicy := rpolicy.RotationPolicy{DailyPrefix: "daily_", DailyRetentionCount: 3, WeeklyPrefix: "weekly_", WeeklyRetentionCount: 3, MonthlyPrefix: "monthly_"}
+	plan, err := CreatePlan(svc, "plan-bucket", planPolicy, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create the plan without any error: %v", err))
+	}
+
+	if plan.Tiers[0].KeysFound != numKeys || len(plan.Candidates) != numKeys-3 {
+		t.Fatal(fmt.Sprintf("expected every page of keys to be planned, instead found %d key(s) with %d candidate(s)",
+			plan.Tiers[0].KeysFound, len(plan.Candidates)))
+	}
+
+	summary, err := ApplyPlan(svc, storage.NewS3Store(svc, "plan-bucket"), plan, false, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to apply the plan without any error: %v", err))
+	}
+
+	keys := server.Keys("plan-bucket")
+	expected := []string{"daily_postgres_1002", "daily_postgres_1003", "daily_postgres_1004"}
+	if len(summary.DeletedKeys) != numKeys-3 || fmt.Sprint(keys) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected the newest 3 keys to be kept after deleting %d key(s), instead deleted %d and kept: %v",
+			numKeys-3, len(summary.DeletedKeys), keys))
+	}
+}
+
+func TestApplyPlanDeletesCandidates(t *testing.T) {
+	server := s3test.NewServer("plan-bucket")
+	defer server.Close()
+	svc := server.Client()
+
+	modified := time.Now().Add(-time.Hour * 24 * 30).UTC()
+	server.Now = func() time.Time { return modified }
+	for i := 0; i < 5; i++ {
+		modified = modified.Add(time.Minute)
+		server.PutObject("plan-bucket", fmt.Sprintf("db/daily_postgres_%d", i), []byte("backup"))
+		server.PutObject("plan-bucket", fmt.Sprintf("daily_other_%d", i), []byte("backup"))
+	}
+
+	planPolicy := rpolicy.RotationPolicy{DailyPrefix: "daily_", DailyRetentionCount: 2, WeeklyPrefix: "weekly_", WeeklyRetentionCount: 2,
+		MonthlyPrefix: "monthly_", BucketDir: "db/", S3FileName: "postgres"}
+	plan, err := CreatePlan(svc, "plan-bucket", planPolicy, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create the plan without any error: %v", err))
+	}
+
+	if len(plan.Candidates) != 3 {
+		t.Fatal(fmt.Sprintf("expected the 3 oldest keys of the series to be candidates, instead got: %+v", plan.Candidates))
+	}
+
+	// A change to another series must not invalidate the plan
+	server.PutObject("plan-bucket", "daily_other_5", []byte("backup"))
+
+	// A candidate which is not a key of the series is refused
+	refused := plan
+	refused.Candidates = append([]Candidate{{Key: "daily_other_0"}}, plan.Candidates...)
+	_, err = ApplyPlan(svc, storage.NewS3Store(svc, "plan-bucket"), refused, false, nil)
+	if err == nil || len(server.Keys("plan-bucket")) != 11 {
+		t.Fatal(fmt.Sprintf("expected a candidate outside of the plan to be refused, instead got: %v", err))
+	}
+
+	// The reviewer keeps the oldest candidate by removing it from the plan
+	kept := plan.Candidates[len(plan.Candidates)-1].Key
+	plan.Candidates = plan.Candidates[:len(plan.Candidates)-1]
+
+	summary, err := ApplyPlan(svc, storage.NewS3Store(svc, "plan-bucket"), plan, false, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to apply the plan without any error: %v", err))
+	}
+
+	if fmt.Sprint(summary.DeletedKeys) != fmt.Sprint([]string{plan.Candidates[0].Key, plan.Candidates[1].Key}) {
+		t.Error(fmt.Sprintf("expected exactly the candidates of the plan to be deleted, instead got: %v", summary.DeletedKeys))
+	}
+
+	if _, ok := server.Object("plan-bucket", kept); !ok {
+		t.Error(fmt.Sprintf("expected '%s' which was removed from the plan to be kept", kept))
+	}
+
+	if len(server.Keys("plan-bucket")) != 9 {
+		t.Error(fmt.Sprintf("expected only the candidates to be deleted, instead found: %v", server.Keys("plan-bucket")))
+	}
+}
@@ -0,0 +1,133 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"strings"
+	"time"
+)
+
+// Promotion records a key of a lower tier copied to a higher tier to fill a missed weekly or monthly backup
+type Promotion struct {
+	Tier      string    `json:"tier"`       // The tier the key was promoted to
+	SourceKey string    `json:"source_key"` // The key of the lower tier which was copied
+	Key       string    `json:"key"`        // The key created in the higher tier
+	Modified  time.Time `json:"modified"`   // The time the source key was modified
+	Reason    string    `json:"reason"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// promoteMissed fills the weekly and monthly backups missed since their most recent anchor day by copying the first
+// later key of a lower tier within the store. In S3 the user metadata of the source key is kept
+// Only the keys of the series of the policy are considered
+// If dry run is enabled then the promotions are decided but no keys are copied
+func promoteMissed(store storage.Store, policy rpolicy.RotationPolicy, dryRun bool, logger *log.Logger) Summary {
+	logger.Banner("Promoting Missed Backups!")
+
+	keys := make(map[string][]s3client.BucketEntry)
+	for _, prefix := range []string{policy.DailyPrefix, policy.WeeklyPrefix, policy.MonthlyPrefix} {
+		sortedKeys, err := store.List(getSeriesPrefix(policy, prefix))
+		if err != nil {
+			logger.Error.Printf("Failed to retrieve keys with prefix: '%s' from bucket: %s\n", getSeriesPrefix(policy, prefix), store)
+			return Summary{Errors: []string{fmt.Sprintf("failed to retrieve '%s' keys for promotion: %v", prefix, err)}}
+		}
+		keys[prefix] = sortedKeys
+	}
+
+	summary := Summary{Promotions: []Promotion{}}
+	for _, promotion := range findPromotions(policy, keys, time.Now()) {
+		logger.Info.Printf("Promoting key: '%s' to key: '%s' as %s\n", promotion.SourceKey, promotion.Key, promotion.Reason)
+
+		if dryRun {
+			logger.Info.Printf("Skipping promotion of key: '%s' as dry run has been enabled\n", promotion.SourceKey)
+			summary.Promotions = append(summary.Promotions, promotion)
+			continue
+		}
+
+		err := store.Copy(promotion.SourceKey, promotion.Key)
+		if err != nil {
+			logger.Error.Printf("Failed to promote key: '%s' to key: '%s': %v\n", promotion.SourceKey, promotion.Key, err)
+			promotion.Error = err.Error()
+			summary.Errors = append(summary.Errors, fmt.Sprintf("failed to promote key '%s': %v", promotion.SourceKey, err))
+		} else {
+			logger.Info.Printf("Successfully promoted key: '%s' to key: '%s'\n", promotion.SourceKey, promotion.Key)
+		}
+		summary.Promotions = append(summary.Promotions, promotion)
+	}
+
+	if len(summary.Promotions) == 0 {
+		logger.Info.Println("No missed weekly or monthly backups found")
+	}
+
+	return summary
+}
+
+// findPromotions decides which keys should be promoted at the time now. keys maps each tier prefix to the keys of the
+// series sorted newest first
+// A tier is missed if it has no key modified since its most recent anchor day. A monthly key also fills the weekly tier
+// The key promoted is the first key of a lower tier modified after the anchor day
+func findPromotions(policy rpolicy.RotationPolicy, keys map[string][]s3client.BucketEntry, now time.Time) []Promotion {
+	latest := make(map[string]time.Time)
+	for prefix, sortedKeys := range keys {
+		if len(sortedKeys) > 0 {
+			latest[prefix] = sortedKeys[0].ModifiedTime
+		}
+	}
+
+	targets := []struct {
+		prefix      string
+		anchor      time.Time
+		filledBy    []string // Prefixes of the tiers whose keys fill the tier
+		promoteFrom []string // Prefixes of the lower tiers a key can be promoted from
+	}{
+		{policy.MonthlyPrefix, util.MonthlyAnchor(now), []string{policy.MonthlyPrefix}, []string{policy.DailyPrefix, policy.WeeklyPrefix}},
+		{policy.WeeklyPrefix, util.WeeklyAnchor(now), []string{policy.WeeklyPrefix, policy.MonthlyPrefix}, []string{policy.DailyPrefix}},
+	}
+
+	promotions := []Promotion{}
+	for _, target := range targets {
+		filled := false
+		for _, prefix := range target.filledBy {
+			if !latest[prefix].Before(target.anchor) {
+				filled = true
+			}
+		}
+		if filled {
+			continue
+		}
+
+		var source s3client.BucketEntry
+		var sourcePrefix string
+		for _, prefix := range target.promoteFrom {
+			for _, key := range keys[prefix] {
+				if key.ModifiedTime.Before(target.anchor) {
+					break // Keys are sorted newest first
+				}
+				if sourcePrefix == "" || key.ModifiedTime.Before(source.ModifiedTime) {
+					source = key
+					sourcePrefix = prefix
+				}
+			}
+		}
+		if sourcePrefix == "" {
+			continue // Nothing has been backed up since the anchor day
+		}
+
+		tier := util.GetTierName(policy, target.prefix)
+		promotions = append(promotions, Promotion{
+			Tier:      tier,
+			SourceKey: source.Key,
+			Key:       policy.BucketDir + target.prefix + strings.TrimPrefix(source.Key, policy.BucketDir+sourcePrefix),
+			Modified:  source.ModifiedTime,
+			Reason: fmt.Sprintf("there is no %s key since %s and this is the first %s key after it", tier,
+				target.anchor.Format("2006-01-02"), util.GetTierName(policy, sourcePrefix)),
+		})
+		latest[target.prefix] = now // The promoted key fills the tier
+	}
+
+	return promotions
+}
@@ -0,0 +1,121 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"testing"
+	"time"
+)
+
+var promotePolicy = rpolicy.RotationPolicy{DailyPrefix: "daily_", WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_"}
+
+// getTestKeys returns a key of the prefix for each of the times sorted newest first
+func getTestKeys(prefix string, times ...time.Time) []s3client.BucketEntry {
+	keys := map[string]time.Time{}
+	for _, keyTime := range times {
+		keys[prefix+"postgres_"+keyTime.Format("20060102T150405")] = keyTime
+	}
+	return s3client.SortKeysByTime(keys)
+}
+
+func TestFindPromotionsMissedWeekly(t *testing.T) {
+	// Wednesday the 8th. The host was down on Monday the 6th so there is no weekly backup this week
+	now := time.Date(2017, time.November, 8, 12, 0, 0, 0, time.Local)
+	keys := map[string][]s3client.BucketEntry{
+		"daily_": getTestKeys("daily_",
+			time.Date(2017, time.November, 5, 2, 0, 0, 0, time.Local),
+			time.Date(2017, time.November, 7, 2, 0, 0, 0, time.Local),
+			time.Date(2017, time.November, 8, 2, 0, 0, 0, time.Local)),
+		"weekly_":  getTestKeys("weekly_", time.Date(2017, time.October, 30, 2, 0, 0, 0, time.Local)),
+		"monthly_": getTestKeys("monthly_", time.Date(2017, time.November, 1, 2, 0, 0, 0, time.Local)),
+	}
+
+	promotions := findPromotions(promotePolicy, keys, now)
+	if len(promotions) != 1 {
+		t.Fatal(fmt.Sprintf("expected 1 promotion but got: %v", promotions))
+	}
+
+	if promotions[0].Tier != "weekly" || promotions[0].SourceKey != "daily_postgres_20171107T020000" ||
+		promotions[0].Key != "weekly_postgres_20171107T020000" {
+		t.Error(fmt.Sprintf("expected first daily after Monday to be promoted to weekly but got: %+v", promotions[0]))
+	}
+}
+
+func TestFindPromotionsMissedMonthly(t *testing.T) {
+	// Tuesday the 3rd. The host was down on the 1st so there is no monthly backup this month
+	// The weekly backup on Monday the 2nd is the first key after the 1st and fills the weekly tier
+	now := time.Date(2017, time.October, 3, 12, 0, 0, 0, time.Local)
+	keys := map[string][]s3client.BucketEntry{
+		"daily_": getTestKeys("daily_",
+			time.Date(2017, time.September, 30, 2, 0, 0, 0, time.Local),
+			time.Date(2017, time.October, 3, 2, 0, 0, 0, time.Local)),
+		"weekly_":  getTestKeys("weekly_", time.Date(2017, time.October, 2, 2, 0, 0, 0, time.Local)),
+		"monthly_": getTestKeys("monthly_", time.Date(2017, time.September, 1, 2, 0, 0, 0, time.Local)),
+	}
+
+	promotions := findPromotions(promotePolicy, keys, now)
+	if len(promotions) != 1 {
+		t.Fatal(fmt.Sprintf("expected 1 promotion but got: %v", promotions))
+	}
+
+	if promotions[0].Tier != "monthly" || promotions[0].SourceKey != "weekly_postgres_20171002T020000" ||
+		promotions[0].Key != "monthly_postgres_20171002T020000" {
+		t.Error(fmt.Sprintf("expected weekly key to be promoted to monthly but got: %+v", promotions[0]))
+	}
+}
+
+func TestFindPromotionsNothingMissed(t *testing.T) {
+	now := time.Date(2017, time.November, 8, 12, 0, 0, 0, time.Local)
+	keys := map[string][]s3client.BucketEntry{
+		"daily_":   getTestKeys("daily_", time.Date(2017, time.November, 8, 2, 0, 0, 0, time.Local)),
+		"weekly_":  getTestKeys("weekly_", time.Date(2017, time.November, 6, 2, 0, 0, 0, time.Local)),
+		"monthly_": getTestKeys("monthly_", time.Date(2017, time.November, 1, 2, 0, 0, 0, time.Local)),
+	}
+
+	promotions := findPromotions(promotePolicy, keys, now)
+	if len(promotions) != 0 {
+		t.Error(fmt.Sprintf("expected no promotions but got: %v", promotions))
+	}
+
+	// Nothing has been backed up since the anchor days so there is nothing to promote
+	keys["daily_"] = getTestKeys("daily_", time.Date(2017, time.October, 31, 2, 0, 0, 0, time.Local))
+	keys["weekly_"] = nil
+	keys["monthly_"] = nil
+	promotions = findPromotions(promotePolicy, keys, now)
+	if len(promotions) != 0 {
+		t.Error(fmt.Sprintf("expected no promotions without a later key but got: %v", promotions))
+	}
+}
+
+func TestPromoteMissedWithBucketDir(t *testing.T) {
+	server := s3test.NewServer("promote-bucket")
+	defer server.Close()
+
+	// Keys of another file in the bucket dir and of the same file outside of it must not fill the monthly tier
+	server.PutObject("promote-bucket", "databases/daily_postgres_20171107T020000", []byte("backup"))
+	server.PutObject("promote-bucket", "databases/monthly_mysql_20171107T020000", []byte("backup"))
+	server.PutObject("promote-bucket", "monthly_postgres_20171107T020000", []byte("backup"))
+
+	policy := promotePolicy
+	policy.BucketDir = "databases/"
+	policy.S3FileName = "postgres"
+
+	summary := promoteMissed(storage.NewS3Store(server.Client(), "promote-bucket"), policy, false, log.OrDefault(nil))
+	if len(summary.Errors) != 0 || len(summary.Promotions) != 1 {
+		t.Fatal(fmt.Sprintf("expected 1 promotion without any error but got: %+v", summary))
+	}
+
+	promotion := summary.Promotions[0]
+	if promotion.Tier != "monthly" || promotion.SourceKey != "databases/daily_postgres_20171107T020000" ||
+		promotion.Key != "databases/monthly_postgres_20171107T020000" {
+		t.Error(fmt.Sprintf("expected the daily key to be promoted within the bucket dir but got: %+v", promotion))
+	}
+
+	if _, ok := server.Object("promote-bucket", promotion.Key); !ok {
+		t.Error(fmt.Sprintf("expected the promoted key '%s' to be copied", promotion.Key))
+	}
+}
@@ -1,166 +1,297 @@
 package rotate
 
 import (
+	"fmt"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/daniel-cole/GoS3GFSBackup/log"
 	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
 	"github.com/daniel-cole/GoS3GFSBackup/s3client"
-	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
 	"time"
 )
 
-// StartRotation initiates the GFS rotation with the provided policy
-func StartRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, dryRun bool) []string {
-	log.Info.Println(`
-	######################################
-	#  GoS3GFSBackup Rotation Started!   #
-	######################################
-	`)
+// Summary is the outcome of a rotation
+type Summary struct {
+	DeletedKeys []string      // Keys deleted (or that would be deleted in a dry run)
+	SkippedKeys []string      // Keys in excess of the retention count kept because they are within the retention period
+	Errors      []string      // Problems encountered that did not stop the rotation
+	Tiers       []TierSummary // The decision made for every key of each tier rotated
+	Promotions  []Promotion   // Keys promoted to fill missed weekly or monthly backups
+}
+
+// TierSummary is the outcome of the rotation of a single tier
+type TierSummary struct {
+	Tier                 string     `json:"tier"`
+	Prefix               string     `json:"prefix"`
+	RetentionCount       int        `json:"retention_count"`
+	RetentionPeriodHours float64    `json:"retention_period_hours"`
+	KeysFound            int        `json:"keys_found"`
+	Decisions            []Decision `json:"decisions"`
+	Error                string     `json:"error,omitempty"`
+}
+
+// Decision records what was done with a key during rotation and why
+type Decision struct {
+	Key      string    `json:"key"`
+	Modified time.Time `json:"modified"`
+	Action   string    `json:"action"`
+	Reason   string    `json:"reason"`
+}
 
-	log.Info.Println("Starting GFS rotation")
+// Actions taken for a key during rotation
+const (
+	ActionKeep   = "keep"   // The key is within the retention count
+	ActionSkip   = "skip"   // The key is in excess of the retention count but within the enforced retention period
+	ActionDelete = "delete" // The key was deleted, or would be deleted in a dry run
+	ActionFailed = "failed" // The key should have been deleted but the delete failed
+)
 
-	// Keys to be returned at end of both daily and weekly rotation
-	deletedKeys := []string{}
+// StartRotation initiates the GFS rotation with the provided policy and returns the deleted keys
+// A *SafeguardError is returned if the rotation was refused, in which case no keys were deleted
+func StartRotation(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, dryRun bool) ([]string, error) {
+	summary, err := Rotate(svc, bucket, policy, dryRun, nil)
+	return summary.DeletedKeys, err
+}
 
-	log.Info.Println(`
-	######################################
-	#   Starting Daily Key Rotation!     #
-	######################################
-	`)
+// Rotate initiates the GFS rotation of the S3 bucket with the provided policy and returns a summary of the rotation
+// Every tier is planned before any key is deleted. If the plan violates a safeguard of the policy then
+// a *SafeguardError is returned and no keys are deleted
+// If the policy promotes missed backups then the promotions are made before the tiers are planned
+// If logger is nil then the default logger is used
+func Rotate(svc *s3.S3, bucket string, policy rpolicy.RotationPolicy, dryRun bool, logger *log.Logger) (Summary, error) {
+	return RotateStore(storage.NewS3Store(svc, bucket), policy, dryRun, logger)
+}
 
-	// Daily rotation
-	for _, key := range keyRotation(svc, bucket, policy.DailyRetentionPeriod, policy.DailyRetentionCount, policy.DailyPrefix, policy.EnforceRetentionPeriod, dryRun) {
-		deletedKeys = append(deletedKeys, key)
+// RotateStore rotates the keys of any store, e.g. a local directory, in the same way as Rotate
+func RotateStore(store storage.Store, policy rpolicy.RotationPolicy, dryRun bool, logger *log.Logger) (Summary, error) {
+	logger = log.OrDefault(logger)
+
+	logger.Banner("GoS3GFSBackup Rotation Started!")
+
+	logger.Info.Println("Starting GFS rotation")
+
+	// Summary to be returned at end of both daily and weekly rotation
+	summary := Summary{DeletedKeys: []string{}}
+
+	if policy.PromoteMissed {
+		summary.add(promoteMissed(store, policy, dryRun, logger))
 	}
 
-	log.Info.Println(`
-	######################################
-	#   Starting Weekly Key Rotation!    #
-	######################################
-	`)
+	tierSummaries := []TierSummary{}
+	for _, tier := range getTiers(policy) {
+		logger.Banner(tier.banner)
 
-	// Weekly rotation
-	for _, key := range keyRotation(svc, bucket, policy.WeeklyRetentionPeriod, policy.WeeklyRetentionCount, policy.WeeklyPrefix, policy.EnforceRetentionPeriod, dryRun) {
-		deletedKeys = append(deletedKeys, key)
+		tierSummary, err := planTier(store, tier.name, tier.retentionPeriod, tier.retentionCount, tier.prefix,
+			policy.EnforceRetentionPeriod, logger.With("tier", tier.name))
+		if err != nil {
+			summary.add(Summary{Errors: []string{fmt.Sprintf("failed to retrieve '%s' keys: %v", tier.prefix, err)}, Tiers: []TierSummary{tierSummary}})
+			continue
+		}
+		tierSummaries = append(tierSummaries, tierSummary)
 	}
 
-	log.Info.Println(`
-	######################################
-	#         Key Rotation Summary       #
-	######################################
-	`)
+	err := checkSafeguards(store, policy, tierSummaries, logger)
+	if err != nil {
+		logger.Error.Printf("Refusing to rotate, no keys have been deleted. Reason: %v\n", err)
+		return summary, err
+	}
 
-	log.Info.Printf("The total number of keys deleted for this rotation was: %d\n", len(deletedKeys))
-	for _, key := range deletedKeys {
-		log.Info.Printf("Key deleted in rotation: '%s'\n", key)
+	for _, tierSummary := range tierSummaries {
+		summary.add(executeTier(store, tierSummary, dryRun, logger.With("tier", tierSummary.Tier)))
 	}
 
-	log.Info.Println("Finished GFS rotation")
+	logger.Banner("Key Rotation Summary")
+
+	logger.Info.Printf("The total number of keys deleted for this rotation was: %d\n", len(summary.DeletedKeys))
+	for _, key := range summary.DeletedKeys {
+		logger.Info.Printf("Key deleted in rotation: '%s'\n", key)
+	}
+
+	if len(summary.SkippedKeys) > 0 {
+		logger.Warn.Printf("The total number of keys kept due to the retention period for this rotation was: %d\n", len(summary.SkippedKeys))
+		for _, key := range summary.SkippedKeys {
+			logger.Warn.Printf("Key kept in rotation: '%s'\n", key)
+		}
+	}
+
+	logger.Info.Println("Finished GFS rotation")
+
+	return summary, nil
+}
+
+// tier is a tier of the policy which is rotated
+type tier struct {
+	name            string
+	banner          string
+	retentionPeriod time.Duration
+	retentionCount  int
+	prefix          string
+}
+
+// getTiers returns the tiers of the policy which are rotated. Monthly keys are handled by the bucket lifecycle configuration
+// Only the keys of the series of the policy are rotated, so series in other bucket dirs or of other files are left alone
+func getTiers(policy rpolicy.RotationPolicy) []tier {
+	return []tier{
+		{"daily", "Starting Daily Key Rotation!", policy.DailyRetentionPeriod, policy.DailyRetentionCount, getSeriesPrefix(policy, policy.DailyPrefix)},
+		{"weekly", "Starting Weekly Key Rotation!", policy.WeeklyRetentionPeriod, policy.WeeklyRetentionCount, getSeriesPrefix(policy, policy.WeeklyPrefix)},
+	}
+}
+
+// getSeriesPrefix returns the prefix of the keys of the tier which belong to the series of the policy
+func getSeriesPrefix(policy rpolicy.RotationPolicy, prefix string) string {
+	if policy.S3FileName == "" {
+		return policy.BucketDir + prefix
+	}
+	return policy.BucketDir + prefix + policy.S3FileName + "_" // Keys are suffixed with '_' and a timestamp
+}
 
-	return deletedKeys
+func (s *Summary) add(other Summary) {
+	s.DeletedKeys = append(s.DeletedKeys, other.DeletedKeys...)
+	s.SkippedKeys = append(s.SkippedKeys, other.SkippedKeys...)
+	s.Errors = append(s.Errors, other.Errors...)
+	s.Tiers = append(s.Tiers, other.Tiers...)
+	s.Promotions = append(s.Promotions, other.Promotions...)
 }
 
-// Any keys with prefix _monthly should have a life cycle policy to move into glacier after 30 days
-// If enforceRetentionPeriod is set to true then no keys that are
-func keyRotation(svc *s3.S3, bucket string, retentionPeriod time.Duration, retentionCount int, prefix string, enforceRetentionPeriod bool, dryRun bool) []string {
-	sortedKeys, err := sortKeysAndLogInfo(svc, bucket, prefix) // Requirement that the keys are sorted before rotating
+// planTier decides what should be done with every key of the tier without deleting anything
+func planTier(store storage.Store, name string, retentionPeriod time.Duration, retentionCount int, prefix string, enforceRetentionPeriod bool, logger *log.Logger) (TierSummary, error) {
+	sortedKeys, err := sortKeysAndLogInfo(store, prefix, logger) // Requirement that the keys are sorted before rotating
 
-	log.Info.Println(`
-	######################################
-	#           Rotating Keys!           #
-	######################################
-	`)
+	logger.Banner("Rotating Keys!")
+
+	tierSummary := TierSummary{
+		Tier:                 name,
+		Prefix:               prefix,
+		RetentionCount:       retentionCount,
+		RetentionPeriodHours: retentionPeriod.Hours(),
+		KeysFound:            len(sortedKeys),
+		Decisions:            []Decision{},
+	}
 
 	if err != nil {
-		log.Error.Printf("Failed to retrieve sorted keys: %v\n", err)
-		return nil
+		logger.Error.Printf("Failed to retrieve sorted keys: %v\n", err)
+		tierSummary.Error = err.Error()
+		return tierSummary, err
 	}
 
 	if sortedKeys == nil {
-		log.Info.Printf("No '%s' key(s) found for rotation\n", prefix)
-		return nil
+		logger.Info.Printf("No '%s' key(s) found for rotation\n", prefix)
+		return tierSummary, nil
 	}
 
-	deletedKeys := []string{}
-
 	numKeys := len(sortedKeys)
-	if numKeys > retentionCount {
-		log.Info.Printf("Total number of '%s' keys (%d) exceeds retention policy of %d, purging old keys\n",
-			prefix, numKeys, retentionCount)
-
-		for _, kv := range sortedKeys[retentionCount:] {
-			key := kv.Key
-
-			keyAge := time.Since(kv.ModifiedTime)
-			keyAgeHours := keyAge.Hours()
-			keyAgeMinutes := keyAge.Minutes()
-
-			log.Info.Printf("Candidate key for deletion: '%s' is %0.1f hours / %0.1f minutes old\n", key, keyAgeHours, keyAgeMinutes)
-
-			// Safety check to ensure that candidate keys for deletion are not within the retentionPeriod
-			// This will prevent any key from being deleted if the retention period is enforced
-			// If the retention period is not enforced then the key will be removed and a warning logged
-			if keyAge <= retentionPeriod {
-				if enforceRetentionPeriod {
-					log.Warn.Printf("Key: '%s' is in violation of retention policy count. However, enforce "+
-						"retention period is enabled and the total time elapsed since the key was last modified is "+
-						"%0.1f hours / %0.1f minutes. This is less than the retention period of %0.1f hours / %0.1f minutes. "+
-						"This key is not eligible for deletion until the retention period has elapsed\n", key, keyAgeHours,
-						keyAgeMinutes, retentionPeriod.Hours(), retentionPeriod.Minutes())
-					continue // Skip to next candidate key for deletion
-				}
-
-				log.Warn.Printf("Key: '%s' is in violation of retention policy count. However, enforce "+
-					"retention period is NOT enabled. The total time elapsed since the key was last modified is "+
+	for i := 0; i < numKeys && i < retentionCount; i++ {
+		tierSummary.Decisions = append(tierSummary.Decisions, Decision{Key: sortedKeys[i].Key, Modified: sortedKeys[i].ModifiedTime,
+			Action: ActionKeep, Reason: fmt.Sprintf("within the retention count of %d", retentionCount)})
+	}
+
+	if numKeys <= retentionCount {
+		logger.Info.Printf("Skipping rotation for '%s' keys due to insufficient number of keys. "+
+			"Minimum of %d keys required for rotation. Found %d key(s)\n", prefix, retentionCount+1, numKeys)
+		return tierSummary, nil
+	}
+
+	logger.Info.Printf("Total number of '%s' keys (%d) exceeds retention policy of %d, purging old keys\n",
+		prefix, numKeys, retentionCount)
+
+	for _, kv := range sortedKeys[retentionCount:] {
+		key := kv.Key
+		decision := Decision{Key: key, Modified: kv.ModifiedTime, Action: ActionDelete,
+			Reason: fmt.Sprintf("exceeds the retention count of %d", retentionCount)}
+
+		keyAge := time.Since(kv.ModifiedTime)
+		keyAgeHours := keyAge.Hours()
+		keyAgeMinutes := keyAge.Minutes()
+
+		logger.Info.Printf("Candidate key for deletion: '%s' is %0.1f hours / %0.1f minutes old\n", key, keyAgeHours, keyAgeMinutes)
+
+		// Safety check to ensure that candidate keys for deletion are not within the retentionPeriod
+		// This will prevent any key from being deleted if the retention period is enforced
+		// If the retention period is not enforced then the key will be removed and a warning logged
+		if keyAge <= retentionPeriod {
+			if enforceRetentionPeriod {
+				logger.Warn.Printf("Key: '%s' is in violation of retention policy count. However, enforce "+
+					"retention period is enabled and the total time elapsed since the key was last modified is "+
 					"%0.1f hours / %0.1f minutes. This is less than the retention period of %0.1f hours / %0.1f minutes. "+
-					"This key WILL be deleted since enforce retention period is NOT enabled\n", key, keyAgeHours,
+					"This key is not eligible for deletion until the retention period has elapsed\n", key, keyAgeHours,
 					keyAgeMinutes, retentionPeriod.Hours(), retentionPeriod.Minutes())
-			}
-			if dryRun { // Do not delete any keys if dry run has been specified
-				log.Info.Printf("Skipping deletion of key: '%s' as dry run has been enabled\n", key)
-				deletedKeys = append(deletedKeys, key)
-			} else {
-				deletedKey, err := s3client.DeleteKey(svc, bucket, key)
-				if err != nil {
-					log.Error.Printf("Failed to delete key from bucket: '%s': %v\n", key, err)
-				} else {
-					log.Info.Printf("Successfully deleted key from bucket: '%s'\n", key)
-					deletedKeys = append(deletedKeys, deletedKey)
-				}
+				decision.Action = ActionSkip
+				decision.Reason = fmt.Sprintf("exceeds the retention count of %d but is within the enforced retention period of %0.1f hours",
+					retentionCount, retentionPeriod.Hours())
+				tierSummary.Decisions = append(tierSummary.Decisions, decision)
+				continue // Skip to next candidate key for deletion
 			}
 
+			logger.Warn.Printf("Key: '%s' is in violation of retention policy count. However, enforce "+
+				"retention period is NOT enabled. The total time elapsed since the key was last modified is "+
+				"%0.1f hours / %0.1f minutes. This is less than the retention period of %0.1f hours / %0.1f minutes. "+
+				"This key WILL be deleted since enforce retention period is NOT enabled\n", key, keyAgeHours,
+				keyAgeMinutes, retentionPeriod.Hours(), retentionPeriod.Minutes())
+			decision.Reason += fmt.Sprintf(" and the retention period of %0.1f hours is not enforced", retentionPeriod.Hours())
 		}
 
-		return deletedKeys
+		tierSummary.Decisions = append(tierSummary.Decisions, decision)
 	}
 
-	log.Info.Printf("Skipping rotation for '%s' keys due to insufficient number of keys. "+
-		"Minimum of %d keys required for rotation. Found %d key(s)\n", prefix, retentionCount+1, numKeys)
-	return nil
+	return tierSummary, nil
+}
+
+// executeTier deletes every key the tier summary has decided to delete. If dry run is enabled then nothing is deleted
+func executeTier(store storage.Store, tierSummary TierSummary, dryRun bool, logger *log.Logger) Summary {
+	summary := Summary{}
+
+	decisions := make([]Decision, len(tierSummary.Decisions))
+	copy(decisions, tierSummary.Decisions)
+	tierSummary.Decisions = decisions
+
+	for i := range decisions {
+		decision := &decisions[i]
+		key := decision.Key
+
+		switch decision.Action {
+		case ActionSkip:
+			summary.SkippedKeys = append(summary.SkippedKeys, key)
+		case ActionDelete:
+			if dryRun { // Do not delete any keys if dry run has been specified
+				logger.Info.Printf("Skipping deletion of key: '%s' as dry run has been enabled\n", key)
+				summary.DeletedKeys = append(summary.DeletedKeys, key)
+				continue
+			}
+
+			err := store.Delete(key)
+			if err != nil {
+				logger.Error.Printf("Failed to delete key from bucket: '%s': %v\n", key, err)
+				summary.Errors = append(summary.Errors, fmt.Sprintf("failed to delete key '%s': %v", key, err))
+				decision.Action = ActionFailed
+				decision.Reason = fmt.Sprintf("%s but the delete failed: %v", decision.Reason, err)
+			} else {
+				logger.Info.Printf("Successfully deleted key from bucket: '%s'\n", key)
+				summary.DeletedKeys = append(summary.DeletedKeys, key)
+			}
+		}
+	}
 
+	summary.Tiers = []TierSummary{tierSummary}
+	return summary
 }
 
 // Returns an array of sorted keys by LastModified date.
 // The first value in the array is the most recently modified key
-func sortKeysAndLogInfo(svc *s3.S3, bucket string, prefix string) ([]s3client.BucketEntry, error) {
-	log.Info.Println(`
-	######################################
-	#        Retrieving Key Info!        #
-	######################################
-	`)
-
-	log.Info.Printf("Attempting to retrieve list of keys with prefix: '%s'\n", prefix)
-	sortedKeys, err := util.RetrieveSortedKeysByTime(svc, bucket, prefix)
+func sortKeysAndLogInfo(store storage.Store, prefix string, logger *log.Logger) ([]s3client.BucketEntry, error) {
+	logger.Banner("Retrieving Key Info!")
+
+	logger.Info.Printf("Attempting to retrieve list of keys with prefix: '%s'\n", prefix)
+	sortedKeys, err := store.List(prefix)
 	if err != nil {
-		log.Error.Printf("Failed to retrieve keys with prefix: '%s' from bucket: %s\n", prefix, bucket)
+		logger.Error.Printf("Failed to retrieve keys with prefix: '%s' from bucket: %s\n", prefix, store)
 		return nil, err
 	}
 
 	for _, kv := range sortedKeys {
-		log.Info.Printf("Found key: '%s'\n", kv.Key)
+		logger.Debug.Printf("Found key: '%s'\n", kv.Key)
 	}
 
-	log.Info.Printf("Found %d key(s) with '%s' prefix\n", len(sortedKeys), prefix)
+	logger.Info.Printf("Found %d key(s) with '%s' prefix\n", len(sortedKeys), prefix)
 
 	if len(sortedKeys) == 0 {
 		return nil, nil
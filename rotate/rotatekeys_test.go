@@ -6,6 +6,8 @@ import (
 	"github.com/daniel-cole/GoS3GFSBackup/log"
 	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
 	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
 	"github.com/daniel-cole/GoS3GFSBackup/upload"
 	"github.com/daniel-cole/GoS3GFSBackup/util"
 	"io/ioutil"
@@ -1160,7 +1162,12 @@ func runMockBackup(t *testing.T, uploadDate time.Time, delay int, providedPolicy
 
 	time.Sleep(time.Second * time.Duration(delay))
 
-	return s3FileName, StartRotation(svc, bucket, providedPolicy, dryRun)
+	deletedKeys, err := StartRotation(svc, bucket, providedPolicy, dryRun)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to rotate keys: %v", err))
+	}
+
+	return s3FileName, deletedKeys
 }
 
 func justUploadIt(s3FileName string, s3BucketDir string) (string, error) {
@@ -1182,3 +1189,126 @@ func justUploadIt(s3FileName string, s3BucketDir string) (string, error) {
 	}
 	return backupKey, nil
 }
+
+//----------------------------------------------
+// Positive Testing
+//		Plan and Apply
+//
+// A plan is created for a bucket with more daily keys than the retention count.
+// The plan must not delete anything and must refuse to apply once the bucket has changed.
+// A new plan must then delete exactly the candidate keys
+//----------------------------------------------
+
+func TestPlanAndApply(t *testing.T) {
+	err := util.EmptyBucket(svc, bucket)
+	if err != nil {
+		t.Error("failed to empty bucket")
+	}
+
+	keepEverythingPolicy := policy
+	keepEverythingPolicy.DailyRetentionCount = 100
+	keepEverythingPolicy.WeeklyRetentionCount = 100
+
+	uploadDate := time.Date(2017, time.September, 2, 01, 0, 0, 0, time.UTC) // Saturday September 2 2017
+	for i := 0; i < 8; i++ {
+		runMockBackup(t, uploadDate.Add(time.Hour*24*time.Duration(i)), 1, keepEverythingPolicy, false)
+	}
+
+	plan, err := CreatePlan(svc, bucket, policy, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create plan: %v", err))
+	}
+
+	if len(plan.Candidates) != 1 { // 7 daily keys with a retention count of 6
+		t.Fatal(fmt.Sprintf("expected 1 candidate key but got %d", len(plan.Candidates)))
+	}
+
+	pathToPlan := "../" + testFileName + ".plan"
+	defer os.Remove(pathToPlan)
+	err = WritePlan(pathToPlan, plan)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to write plan: %v", err))
+	}
+
+	plan, err = ReadPlan(pathToPlan)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to read plan: %v", err))
+	}
+
+	bucketContents, err := s3client.GetBucketContents(svc, bucket)
+	if err != nil {
+		t.Error("failed to retrieve bucket contents")
+	}
+
+	if !util.CheckBucketSize(bucketContents, 8) { // Creating the plan must not delete anything
+		t.Error("expected bucket size to be 8")
+	}
+
+	// Change the bucket so the plan is no longer valid
+	newKey, _ := runMockBackup(t, uploadDate.Add(time.Hour*24*8), 1, keepEverythingPolicy, false)
+
+	_, err = ApplyPlan(svc, storage.NewS3Store(svc, plan.Bucket), plan, false, nil)
+	if err != ErrBucketChanged {
+		t.Fatal(fmt.Sprintf("expected plan to be refused but got: %v", err))
+	}
+
+	plan, err = CreatePlan(svc, bucket, policy, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to create plan: %v", err))
+	}
+
+	summary, err := ApplyPlan(svc, storage.NewS3Store(svc, plan.Bucket), plan, false, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to apply plan: %v", err))
+	}
+
+	if len(summary.DeletedKeys) != len(plan.Candidates) {
+		t.Error(fmt.Sprintf("expected %d keys to be deleted but got %d", len(plan.Candidates), len(summary.DeletedKeys)))
+	}
+
+	bucketContents, err = s3client.GetBucketContents(svc, bucket)
+	if err != nil {
+		t.Error("failed to retrieve bucket contents")
+	}
+
+	for _, candidate := range plan.Candidates {
+		if util.FindKeyInBucket(candidate.Key, bucketContents) {
+			t.Error("expected key to be deleted: " + candidate.Key)
+		}
+	}
+
+	if !util.FindKeyInBucket(newKey, bucketContents) {
+		t.Error("expected to find key in bucket: " + newKey)
+	}
+}
+
+func TestRotateOnlyRotatesSeries(t *testing.T) {
+	server := s3test.NewServer("series-bucket")
+	defer server.Close()
+
+	// Keys of the series are mixed with keys of another file in the bucket dir and keys in the root of the bucket
+	modified := time.Now().Add(-time.Hour * 24 * 30).UTC()
+	server.Now = func() time.Time { return modified }
+	for i := 0; i < 3; i++ {
+		modified = modified.Add(time.Hour)
+		for _, key := range []string{"db/daily_pg_%d", "db/weekly_pg_%d", "db/daily_mysql_%d", "daily_other_%d", "daily_pg_%d"} {
+			server.PutObject("series-bucket", fmt.Sprintf(key, i), []byte("backup"))
+		}
+	}
+
+	seriesPolicy := rpolicy.RotationPolicy{DailyPrefix: "daily_", DailyRetentionCount: 1, WeeklyPrefix: "weekly_", WeeklyRetentionCount: 1,
+		MonthlyPrefix: "monthly_", BucketDir: "db/", S3FileName: "pg"}
+	summary, err := RotateStore(storage.NewS3Store(server.Client(), "series-bucket"), seriesPolicy, false, nil)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to rotate without any error: %v", err))
+	}
+
+	expected := []string{"db/daily_pg_1", "db/daily_pg_0", "db/weekly_pg_1", "db/weekly_pg_0"}
+	if fmt.Sprint(summary.DeletedKeys) != fmt.Sprint(expected) {
+		t.Error(fmt.Sprintf("expected only the keys of the series %v to be deleted, instead got: %v", expected, summary.DeletedKeys))
+	}
+
+	if len(server.Keys("series-bucket")) != 11 {
+		t.Error(fmt.Sprintf("expected the keys of other series to be kept, instead found: %v", server.Keys("series-bucket")))
+	}
+}
@@ -0,0 +1,91 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"time"
+)
+
+// Safeguards of the rotation policy
+const (
+	SafeguardMinKeep     = "min-keep"
+	SafeguardMaxDeletes  = "max-deletes"
+	SafeguardStaleBackup = "stale-backup"
+)
+
+// SafeguardError is returned when a rotation is refused because it would violate a safeguard of the policy
+type SafeguardError struct {
+	Safeguard string
+	Message   string
+}
+
+func (e *SafeguardError) Error() string {
+	return fmt.Sprintf("safeguard '%s' violated: %s", e.Safeguard, e.Message)
+}
+
+// checkSafeguards returns a *SafeguardError if deleting the keys of the planned tiers would violate a safeguard of the policy
+func checkSafeguards(store storage.Store, policy rpolicy.RotationPolicy, tiers []TierSummary, logger *log.Logger) error {
+	deletes := 0
+	for _, tierSummary := range tiers {
+		tierDeletes := 0
+		for _, decision := range tierSummary.Decisions {
+			if decision.Action == ActionDelete {
+				tierDeletes++
+			}
+		}
+		deletes += tierDeletes
+
+		remaining := len(tierSummary.Decisions) - tierDeletes
+		if policy.MinKeep > 0 && tierDeletes > 0 && remaining < policy.MinKeep {
+			return &SafeguardError{SafeguardMinKeep, fmt.Sprintf("deleting %d '%s' key(s) would leave %d, the minimum is %d",
+				tierDeletes, tierSummary.Prefix, remaining, policy.MinKeep)}
+		}
+	}
+
+	if policy.MaxDeletes > 0 && deletes > policy.MaxDeletes {
+		return &SafeguardError{SafeguardMaxDeletes, fmt.Sprintf("the rotation would delete %d key(s), the maximum is %d",
+			deletes, policy.MaxDeletes)}
+	}
+
+	if policy.MaxBackupAge > 0 {
+		newest, err := newestKeyTime(store, policy, tiers)
+		if err != nil {
+			return err
+		}
+
+		if !newest.IsZero() && time.Since(newest) > policy.MaxBackupAge {
+			return &SafeguardError{SafeguardStaleBackup, fmt.Sprintf("the newest backup was modified at %s which is more than %0.1f hours ago",
+				newest.Format(time.RFC3339), policy.MaxBackupAge.Hours())}
+		}
+	}
+
+	logger.Info.Printf("Rotation is within the safeguards of the policy, %d key(s) will be deleted\n", deletes)
+
+	return nil
+}
+
+// newestKeyTime returns the time the newest key of any tier of the series was modified
+// The monthly tier is not rotated so it is listed separately. A zero time is returned if there are no keys
+func newestKeyTime(store storage.Store, policy rpolicy.RotationPolicy, tiers []TierSummary) (time.Time, error) {
+	var newest time.Time
+	for _, tierSummary := range tiers {
+		for _, decision := range tierSummary.Decisions {
+			if decision.Modified.After(newest) {
+				newest = decision.Modified
+			}
+		}
+	}
+
+	monthlyPrefix := getSeriesPrefix(policy, policy.MonthlyPrefix)
+	monthlyKeys, err := store.List(monthlyPrefix)
+	if err != nil {
+		return newest, fmt.Errorf("failed to retrieve '%s' keys: %v", monthlyPrefix, err)
+	}
+	if len(monthlyKeys) > 0 && monthlyKeys[0].ModifiedTime.After(newest) {
+		newest = monthlyKeys[0].ModifiedTime
+	}
+
+	return newest, nil
+}
@@ -0,0 +1,153 @@
+package rotate
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"testing"
+	"time"
+)
+
+func getTestTier(prefix string, keep int, delete int) TierSummary {
+	tierSummary := TierSummary{Prefix: prefix}
+	for i := 0; i < keep; i++ {
+		tierSummary.Decisions = append(tierSummary.Decisions, Decision{Key: fmt.Sprintf("%skeep%d", prefix, i), Action: ActionKeep})
+	}
+	for i := 0; i < delete; i++ {
+		tierSummary.Decisions = append(tierSummary.Decisions, Decision{Key: fmt.Sprintf("%sdelete%d", prefix, i), Action: ActionDelete})
+	}
+	return tierSummary
+}
+
+func TestSafeguardMinKeep(t *testing.T) {
+	safeguardPolicy := rpolicy.RotationPolicy{MinKeep: 3}
+
+	err := checkSafeguards(nil, safeguardPolicy, []TierSummary{getTestTier("daily_", 3, 2)}, log.Default())
+	if err != nil {
+		t.Error(fmt.Sprintf("expected rotation leaving 3 keys to be allowed but got: %v", err))
+	}
+
+	err = checkSafeguards(nil, safeguardPolicy, []TierSummary{getTestTier("daily_", 6, 0), getTestTier("weekly_", 2, 1)}, log.Default())
+	safeguardErr, ok := err.(*SafeguardError)
+	if !ok || safeguardErr.Safeguard != SafeguardMinKeep {
+		t.Error(fmt.Sprintf("expected min keep safeguard to be violated but got: %v", err))
+	}
+
+	err = checkSafeguards(nil, safeguardPolicy, []TierSummary{getTestTier("weekly_", 1, 0)}, log.Default())
+	if err != nil {
+		t.Error(fmt.Sprintf("expected a tier with too few keys but no deletes to be allowed but got: %v", err))
+	}
+}
+
+func TestSafeguardMaxDeletes(t *testing.T) {
+	safeguardPolicy := rpolicy.RotationPolicy{MaxDeletes: 2}
+
+	err := checkSafeguards(nil, safeguardPolicy, []TierSummary{getTestTier("daily_", 6, 1), getTestTier("weekly_", 4, 1)}, log.Default())
+	if err != nil {
+		t.Error(fmt.Sprintf("expected 2 deletes to be allowed but got: %v", err))
+	}
+
+	err = checkSafeguards(nil, safeguardPolicy, []TierSummary{getTestTier("daily_", 6, 2), getTestTier("weekly_", 4, 1)}, log.Default())
+	safeguardErr, ok := err.(*SafeguardError)
+	if !ok || safeguardErr.Safeguard != SafeguardMaxDeletes {
+		t.Error(fmt.Sprintf("expected max deletes safeguard to be violated but got: %v", err))
+	}
+}
+
+//----------------------------------------------
+// Negative Testing
+//		Stale backup safeguard
+//
+// Rotation must be refused without deleting anything when the newest key is older than the maximum backup age
+//----------------------------------------------
+
+func TestSafeguardStaleBackup(t *testing.T) {
+	err := util.EmptyBucket(svc, bucket)
+	if err != nil {
+		t.Error("failed to empty bucket")
+	}
+
+	keepEverythingPolicy := policy
+	keepEverythingPolicy.DailyRetentionCount = 100
+
+	uploadDate := time.Date(2017, time.September, 5, 01, 0, 0, 0, time.UTC) // Tuesday September 5 2017
+	for i := 0; i < 8; i++ {
+		runMockBackup(t, uploadDate.Add(time.Hour*24*time.Duration(i)), 1, keepEverythingPolicy, false)
+	}
+
+	stalePolicy := policy
+	stalePolicy.MaxBackupAge = time.Second
+
+	time.Sleep(time.Second * 2)
+
+	summary, err := Rotate(svc, bucket, stalePolicy, false, nil)
+	safeguardErr, ok := err.(*SafeguardError)
+	if !ok || safeguardErr.Safeguard != SafeguardStaleBackup {
+		t.Fatal(fmt.Sprintf("expected stale backup safeguard to be violated but got: %v", err))
+	}
+
+	if len(summary.DeletedKeys) > 0 {
+		t.Error("expected no keys to be deleted")
+	}
+
+	bucketContents, err := s3client.GetBucketContents(svc, bucket)
+	if err != nil {
+		t.Error("failed to retrieve bucket contents")
+	}
+
+	if !util.CheckBucketSize(bucketContents, 8) {
+		t.Error("expected bucket size to be 8")
+	}
+}
+
+func TestStartRotationReturnsSafeguardError(t *testing.T) {
+	server := s3test.NewServer("safeguard-bucket")
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		server.PutObject("safeguard-bucket", fmt.Sprintf("daily_postgres_%d", i), []byte("backup"))
+	}
+
+	safeguardPolicy := rpolicy.RotationPolicy{DailyPrefix: "daily_", DailyRetentionCount: 1, WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_", MaxDeletes: 2}
+	deletedKeys, err := StartRotation(server.Client(), "safeguard-bucket", safeguardPolicy, false)
+	safeguardErr, ok := err.(*SafeguardError)
+	if !ok || safeguardErr.Safeguard != SafeguardMaxDeletes {
+		t.Error(fmt.Sprintf("expected the max deletes safeguard error to be returned, instead got: %v", err))
+	}
+
+	if len(deletedKeys) != 0 || len(server.Keys("safeguard-bucket")) != 5 {
+		t.Error(fmt.Sprintf("expected no keys to be deleted, instead deleted: %v", deletedKeys))
+	}
+}
+
+func TestSafeguardStaleBackupOfSeries(t *testing.T) {
+	server := s3test.NewServer("safeguard-bucket")
+	defer server.Close()
+
+	server.Now = func() time.Time { return time.Now().Add(-time.Hour * 24 * 10) }
+	for i := 0; i < 3; i++ {
+		server.PutObject("safeguard-bucket", fmt.Sprintf("db/daily_postgres_%d", i), []byte("backup"))
+	}
+	server.PutObject("safeguard-bucket", "db/monthly_postgres_0", []byte("backup"))
+
+	// Fresh backups of other series must not hide the stale series
+	server.Now = time.Now
+	server.PutObject("safeguard-bucket", "monthly_other_0", []byte("backup"))
+	server.PutObject("safeguard-bucket", "db/monthly_mysql_0", []byte("backup"))
+	server.PutObject("safeguard-bucket", "daily_postgres_0", []byte("backup"))
+
+	stalePolicy := rpolicy.RotationPolicy{DailyPrefix: "daily_", DailyRetentionCount: 1, WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_",
+		BucketDir: "db/", S3FileName: "postgres", MaxBackupAge: time.Hour * 24 * 7}
+	deletedKeys, err := StartRotation(server.Client(), "safeguard-bucket", stalePolicy, false)
+	safeguardErr, ok := err.(*SafeguardError)
+	if !ok || safeguardErr.Safeguard != SafeguardStaleBackup {
+		t.Error(fmt.Sprintf("expected the stale backup safeguard error to be returned, instead got: %v", err))
+	}
+
+	if len(deletedKeys) != 0 || len(server.Keys("safeguard-bucket")) != 7 {
+		t.Error(fmt.Sprintf("expected no keys to be deleted, instead deleted: %v", deletedKeys))
+	}
+}
@@ -12,4 +12,20 @@ type RotationPolicy struct {
 	WeeklyPrefix           string
 	MonthlyPrefix          string
 	EnforceRetentionPeriod bool
+
+	// If enabled then a missed weekly or monthly backup is filled by promoting the first later key of a lower tier
+	PromoteMissed bool
+	BucketDir     string // The directory chain of the series in the bucket. Only keys of the series are rotated and promoted
+	S3FileName    string // The file name of the series. If empty then the keys of every file in the bucket dir are rotated and promoted
+
+	// Safeguards are checked before any key is deleted. The rotation is refused if any are violated
+	MinKeep      int           // The minimum number of keys of each tier that must remain after rotation. 0 disables
+	MaxDeletes   int           // The maximum number of keys a single rotation may delete. 0 disables
+	MaxBackupAge time.Duration // Rotation is refused if the newest key of the series is older than this. 0 disables
+
+	// Monthly objects are not rotated by this tool and are instead handled by a bucket lifecycle configuration
+	MonthlyTransitionDays         int    // Days after creation before monthly objects are transitioned. 0 disables
+	MonthlyTransitionStorageClass string // The storage class monthly objects are transitioned to
+	MonthlyExpirationDays         int    // Days after creation before monthly objects are expired. 0 disables
+	AbortIncompleteMultipartDays  int    // Days after initiation before incomplete multipart uploads are aborted. 0 disables
 }
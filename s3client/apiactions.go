@@ -1,9 +1,15 @@
 package s3client
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"net/http"
 	"sort"
 	"time"
 )
@@ -14,8 +20,16 @@ type BucketEntry struct {
 	ModifiedTime time.Time
 }
 
+// MultiPartUpload represents an incomplete multipart upload which exists in S3
+type MultiPartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
 // SortKeysByTime sorts the bucket keys by the last modified time
 // and Returns a bucket entry array with the newest values first
+// Keys modified at the same time, i.e. replicas copied within the same second, are sorted by the timestamp ending the key
 func SortKeysByTime(keys map[string]time.Time) []BucketEntry {
 	var sortedBucketEntry []BucketEntry
 	for k, v := range keys {
@@ -23,6 +37,9 @@ func SortKeysByTime(keys map[string]time.Time) []BucketEntry {
 	}
 
 	sort.Slice(sortedBucketEntry, func(i, j int) bool {
+		if sortedBucketEntry[i].ModifiedTime.Equal(sortedBucketEntry[j].ModifiedTime) {
+			return sortedBucketEntry[i].Key > sortedBucketEntry[j].Key
+		}
 		return sortedBucketEntry[i].ModifiedTime.After(sortedBucketEntry[j].ModifiedTime)
 	})
 
@@ -30,12 +47,9 @@ func SortKeysByTime(keys map[string]time.Time) []BucketEntry {
 }
 
 // GetKeysByPrefix returns a map of keys in the bucket along with the LastModified attribute
-// The map consists of Map[AWS Bucket Key] -> LastModifiedTime
+// The map consists of Map[AWS Bucket Key] -> LastModifiedTime. Every page of the listing is included
 func GetKeysByPrefix(svc *s3.S3, bucket string, prefix string) (map[string]time.Time, error) {
-	result, err := svc.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	})
+	objects, err := GetObjectsByPrefix(svc, bucket, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -43,13 +57,29 @@ func GetKeysByPrefix(svc *s3.S3, bucket string, prefix string) (map[string]time.
 	keys := make(map[string]time.Time)
 
 	// Loop over each object found in the bucket with the specified prefix
-	for _, key := range result.Contents {
+	for _, key := range objects {
 		keys[*key.Key] = *key.LastModified
 	}
 
 	return keys, nil
 }
 
+// GetObjectsByPrefix returns every object in the bucket with the specified prefix, following every page of the listing
+func GetObjectsByPrefix(svc *s3.S3, bucket string, prefix string) ([]*s3.Object, error) {
+	objects := []*s3.Object{}
+	err := svc.ListObjectsPages(&s3.ListObjectsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsOutput, lastPage bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
 // DeleteKey simply deletes an S3 object given a bucket and key
 func DeleteKey(svc *s3.S3, bucket string, key string) (string, error) {
 	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
@@ -83,6 +113,55 @@ func GetAllMultiPartUploads(svc *s3.S3, bucket string) (map[string]string, error
 	return multiPartUploadKeys, nil
 }
 
+// GetMultiPartUploadsByPrefix returns every multipart upload in the S3 bucket with the specified prefix
+// Unlike GetAllMultiPartUploads the results are paginated so that buckets with more than 1000 uploads are handled
+func GetMultiPartUploadsByPrefix(svc *s3.S3, bucket string, prefix string) ([]MultiPartUpload, error) {
+	multiPartUploads := []MultiPartUpload{}
+
+	err := svc.ListMultipartUploadsPages(&s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, multiPartUpload := range page.Uploads {
+			multiPartUploads = append(multiPartUploads, MultiPartUpload{
+				Key:       aws.StringValue(multiPartUpload.Key),
+				UploadID:  aws.StringValue(multiPartUpload.UploadId),
+				Initiated: aws.TimeValue(multiPartUpload.Initiated),
+			})
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return multiPartUploads, nil
+}
+
+// GetMultiPartUploadSize returns the total size in bytes of the parts uploaded so far
+// for a multipart upload given a key and UploadId
+func GetMultiPartUploadSize(svc *s3.S3, bucket string, key string, uploadId string) (int64, error) {
+	var size int64
+
+	err := svc.ListPartsPages(&s3.ListPartsInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, part := range page.Parts {
+			size += aws.Int64Value(part.Size)
+		}
+		return true
+	})
+
+	if err != nil {
+		return -1, err
+	}
+
+	return size, nil
+}
+
 // GetMultiPartUploadIDByKey finds the UploadID of the specified multi part upload by key if it exists
 func GetMultiPartUploadIDByKey(svc *s3.S3, bucket string, key string) (string, error) {
 	resp, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{
@@ -117,6 +196,22 @@ func AbortAllMultiPartUploads(svc *s3.S3, bucket string, key string, uploadId st
 
 }
 
+// AbortMultiPartUploadWithContext aborts a single multipart upload using the provided context
+// This allows an upload to be aborted after the context of the upload itself has expired
+func AbortMultiPartUploadWithContext(ctx context.Context, svc *s3.S3, bucket string, key string, uploadId string) error {
+	_, err := svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadId),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // GetCountMultiPartsById returns the total number of multiupload parts
 // That exist in a S3 bucket given a key and and UploadId
 func GetCountMultiPartsById(svc *s3.S3, bucket string, key string, uploadId string) (int64, error) {
@@ -143,3 +238,115 @@ func GetBucketContents(svc *s3.S3, bucket string) (*s3.ListObjectsOutput, error)
 	}
 	return result, nil
 }
+
+// GetBucketLifecycleRules returns the lifecycle rules currently configured on the bucket
+// A bucket without a lifecycle configuration returns no rules rather than an error
+func GetBucketLifecycleRules(svc *s3.S3, bucket string) ([]*s3.LifecycleRule, error) {
+	resp, err := svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+	})
+
+	if err != nil {
+		if IsErrorCode(err, "NoSuchLifecycleConfiguration") {
+			return []*s3.LifecycleRule{}, nil
+		}
+		return nil, err
+	}
+
+	return resp.Rules, nil
+}
+
+// PutBucketLifecycleRules replaces the lifecycle configuration of the bucket with the provided rules
+func PutBucketLifecycleRules(svc *s3.S3, bucket string, rules []*s3.LifecycleRule) error {
+	_, err := svc.PutBucketLifecycleConfiguration(&s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucket),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: rules,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteBucketLifecycleRules removes the lifecycle configuration of the bucket
+func DeleteBucketLifecycleRules(svc *s3.S3, bucket string) error {
+	_, err := svc.DeleteBucketLifecycle(&s3.DeleteBucketLifecycleInput{
+		Bucket: aws.String(bucket),
+	})
+
+	return err
+}
+
+// PutObjectConditional writes the contents to the key and returns the ETag of the new object
+// If ifMatch is specified then the write only succeeds if the current object has that ETag
+// If ifNoneMatch is '*' then the write only succeeds if the key does not already exist
+func PutObjectConditional(svc *s3.S3, bucket string, key string, contents []byte, ifMatch string, ifNoneMatch string) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(contents),
+		ContentType: aws.String("application/json"),
+	}
+
+	// The conditional headers are not modelled by the SDK for PutObject so they are set on the request directly
+	headers := make(map[string]string)
+	if ifMatch != "" {
+		headers["If-Match"] = ifMatch
+	}
+
+	if ifNoneMatch != "" {
+		headers["If-None-Match"] = ifNoneMatch
+	}
+
+	resp, err := svc.PutObjectWithContext(aws.BackgroundContext(), input, request.WithSetRequestHeaders(headers))
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(resp.ETag), nil
+}
+
+// GetObjectContents returns the entire contents of a small object along with its ETag
+func GetObjectContents(svc *s3.S3, bucket string, key string) ([]byte, string, error) {
+	resp, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return contents, aws.StringValue(resp.ETag), nil
+}
+
+// IsErrorCode returns true if the error was returned by S3 with the specified error code
+func IsErrorCode(err error, code string) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() == code
+	}
+	return false
+}
+
+// IsStatusCode returns true if the error was returned by S3 with the specified HTTP status code
+func IsStatusCode(err error, statusCode int) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		return reqErr.StatusCode() == statusCode
+	}
+	return false
+}
+
+// IsNotImplemented returns true if the server does not support the request
+// Some S3 compatible servers do not implement every API, e.g. listing multipart uploads or conditional writes
+func IsNotImplemented(err error) bool {
+	return IsStatusCode(err, http.StatusNotImplemented) || IsErrorCode(err, "NotImplemented")
+}
@@ -0,0 +1,77 @@
+package s3client
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"testing"
+	"time"
+)
+
+func TestGetMultiPartUploads(t *testing.T) {
+	server := s3test.NewServer("test-bucket")
+	defer server.Close()
+	svc := server.Client()
+
+	for _, key := range []string{"db/daily_postgres_20171108T020304", "web/daily_nginx_20171108T020304"} {
+		upload, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String("test-bucket"), Key: aws.String(key)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for partNumber, size := range []int{1024, 512} {
+			_, err = svc.UploadPart(&s3.UploadPartInput{
+				Bucket:     aws.String("test-bucket"),
+				Key:        aws.String(key),
+				UploadId:   upload.UploadId,
+				PartNumber: aws.Int64(int64(partNumber + 1)),
+				Body:       bytes.NewReader(make([]byte, size)),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	uploads, err := GetMultiPartUploadsByPrefix(svc, "test-bucket", "db/")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to list multipart uploads without any error: %v", err))
+	}
+
+	if len(uploads) != 1 || uploads[0].Key != "db/daily_postgres_20171108T020304" || uploads[0].Initiated.IsZero() {
+		t.Fatal(fmt.Sprintf("expected only the upload with the prefix to be listed, instead got: %v", uploads))
+	}
+
+	size, err := GetMultiPartUploadSize(svc, "test-bucket", uploads[0].Key, uploads[0].UploadID)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to get the size of the multipart upload without any error: %v", err))
+	}
+
+	if size != 1536 {
+		t.Error(fmt.Sprintf("expected the size of the multipart upload to be the total of its parts (1536 bytes), instead got: %d", size))
+	}
+
+	_, err = GetMultiPartUploadSize(svc, "test-bucket", uploads[0].Key, "unknown")
+	if err == nil {
+		t.Error("expected an error getting the size of an unknown multipart upload")
+	}
+}
+
+func TestSortKeysByTimeBreaksTiesByKey(t *testing.T) {
+	copied := time.Date(2017, time.November, 8, 2, 0, 0, 0, time.UTC)
+	sortedKeys := SortKeysByTime(map[string]time.Time{
+		"daily_postgres_20171106T020000": copied,
+		"daily_postgres_20171108T020000": copied,
+		"daily_postgres_20171107T020000": copied,
+		"daily_postgres_20171105T020000": copied.Add(-time.Hour),
+	})
+
+	expected := []string{"daily_postgres_20171108T020000", "daily_postgres_20171107T020000", "daily_postgres_20171106T020000", "daily_postgres_20171105T020000"}
+	for i, key := range expected {
+		if sortedKeys[i].Key != key {
+			t.Error(fmt.Sprintf("expected key %d to be '%s', instead got '%s'", i, key, sortedKeys[i].Key))
+		}
+	}
+}
@@ -1,46 +1,165 @@
 package s3client
 
 import (
-	"errors"
+	"bytes"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"io/ioutil"
 	"os"
+	"regexp"
+	"time"
 )
 
-// CreateS3Client creates an S3 client using environment variables if present; else AWS creds file
-// 2. Use the specified credential file
+// DefaultEndpointRegion is the region used to sign requests to a custom endpoint when no region is specified
+// S3 compatible servers such as MinIO and Ceph RGW accept this region unless configured otherwise
+const DefaultEndpointRegion = "us-east-1"
+
+// DefaultRoleSessionName is the session name of an assumed role, followed by the name of the job if there is one
+const DefaultRoleSessionName = "GoS3GFSBackup"
+
+// maxRoleSessionNameLength is the maximum length of a role session name
+const maxRoleSessionNameLength = 64
+
+// invalidRoleSessionNameChars matches the characters which are not allowed in a role session name
+var invalidRoleSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// Endpoint represents the settings required to use an S3 compatible server instead of AWS S3
+// The zero value uses AWS S3
+type Endpoint struct {
+	URL            string // The URL of the server, e.g. https://minio.example.com:9000
+	ForcePathStyle bool   // Address buckets as https://host/bucket rather than https://bucket.host
+	DisableSSL     bool   // Use http if the URL does not specify a scheme
+	CABundle       string // The full path to a PEM file of certificates to trust in addition to the system certificates
+}
+
+// AssumeRole represents an IAM role which is assumed with the credentials found by the provider chain
+// The zero value does not assume a role
+type AssumeRole struct {
+	RoleARN     string
+	ExternalID  string
+	Duration    time.Duration
+	SessionName string
+}
+
+// ClientOptions represents everything required to create an S3 client
+type ClientOptions struct {
+	CredFile   string // A shared credentials file used instead of ~/.aws/credentials
+	Profile    string
+	Region     string
+	Endpoint   Endpoint
+	AssumeRole AssumeRole
+	// The account id which must own the bucket of every request, see AddBucketOwnerControls
+	ExpectedBucketOwner string
+	// The canned ACL applied to every object written, e.g. bucket-owner-full-control
+	ACL string
+	// The logger the source of the credentials is logged to. If nil then the default logger is used
+	Logger *log.Logger
+}
+
+// RoleSessionName returns the session name of a role assumed for the job, DefaultRoleSessionName followed by the name
+// of the job. Characters which are not allowed in a session name are replaced with '-' and it is cut to 64 characters
+func RoleSessionName(job string) string {
+	sessionName := DefaultRoleSessionName
+	if job != "" {
+		sessionName += "-" + invalidRoleSessionNameChars.ReplaceAllString(job, "-")
+	}
+	if len(sessionName) > maxRoleSessionNameLength {
+		sessionName = sessionName[:maxRoleSessionNameLength]
+	}
+	return sessionName
+}
+
+// CreateS3Client creates an S3 client with the credentials of the standard provider chain
 func CreateS3Client(credFile string, profile string, region string) (*s3.S3, error) {
-	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	return NewS3Client(ClientOptions{CredFile: credFile, Profile: profile, Region: region})
+}
+
+// NewS3Client creates an S3 client with the credentials of the standard provider chain, which are tried in order:
+// 1. Environment variables, including AWS_SESSION_TOKEN
+// 2. Web identity token (AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE), e.g. IAM roles for service accounts
+// 3. The profile of the shared credentials and config files, including SSO, credential_process and role_arn
+// 4. The ECS task role or EC2 instance role
+// If a role is specified then it is assumed with the credentials found. The source of the credentials is logged
+func NewS3Client(options ClientOptions) (*s3.S3, error) {
+	logger := log.OrDefault(options.Logger)
+
+	sessionOptions := session.Options{
+		Profile:           options.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	sessionOptions.Config.CredentialsChainVerboseErrors = aws.Bool(true)
+
+	if options.CredFile != "" {
+		// The credential file replaces the default credentials file but the config file is still read as the SDK would
+		// Files later in the list take precedence, as with the default credentials and config files
+		configFile := os.Getenv("AWS_CONFIG_FILE")
+		if configFile == "" {
+			configFile = defaults.SharedConfigFilename()
+		}
+		sessionOptions.SharedConfigFiles = []string{configFile, options.CredFile}
+	}
 
-	session := session.Must(session.NewSession())
+	if options.Endpoint.CABundle != "" {
+		caBundle, err := ioutil.ReadFile(options.Endpoint.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		sessionOptions.CustomCABundle = bytes.NewReader(caBundle)
+	}
 
-	var creds *credentials.Credentials
+	region := options.Region
+	if region == "" && options.Endpoint.URL != "" {
+		region = DefaultEndpointRegion
+	}
+	sessionOptions.Config.Region = aws.String(region)
 
-	if accessKey == "" && secretAccessKey == "" {
-		// Missing both of the required environment variables
-		log.Info.Println("Environment variables missing to create client: 'AWS_ACCESS_KEY_ID', 'AWS_SECRET_ACCESS_KEY'")
-	} else if accessKey == "" {
-		log.Info.Println("Environment variable missing: 'AWS_ACCESS_KEY_ID'")
-	} else if secretAccessKey == "" {
-		log.Info.Println("Environment variable missing: 'AWS_SECRET_ACCESS_KEY'")
+	sess, err := session.NewSessionWithOptions(sessionOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
 
-	} else {
-		log.Info.Println("Loaded AWS credentials from environment variables")
-		creds = credentials.NewEnvCredentials()
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials: %v", err)
 	}
+	logger.Info.Printf("Loaded AWS credentials from: %s\n", creds.ProviderName)
+
+	config := &aws.Config{}
+	if options.AssumeRole.RoleARN != "" {
+		assumeRole := options.AssumeRole
+		config.Credentials = stscreds.NewCredentials(sess, assumeRole.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = assumeRole.SessionName
+			if assumeRole.ExternalID != "" {
+				p.ExternalID = aws.String(assumeRole.ExternalID)
+			}
+			if assumeRole.Duration > 0 {
+				p.Duration = assumeRole.Duration
+			}
+			// Refresh the credentials before they expire rather than part way through a request
+			p.ExpiryWindow = time.Minute
+		})
 
-	if creds == nil {
-		log.Info.Printf("Attempting to create S3 client with specified credential file and profile: [%s | %s]\n", credFile, profile)
-		creds = credentials.NewSharedCredentials(credFile, profile)
+		_, err = config.Credentials.Get()
+		if err != nil {
+			return nil, fmt.Errorf("failed to assume role '%s': %v", assumeRole.RoleARN, err)
+		}
+		logger.Info.Printf("Assumed role: %s [session: %s]\n", assumeRole.RoleARN, assumeRole.SessionName)
 	}
 
-	if creds == nil {
-		return nil, errors.New("failed to retrieve S3 client access key id and access key secret")
+	if options.Endpoint.URL != "" {
+		logger.Info.Printf("Using S3 compatible endpoint: %s [path style: %t]\n", options.Endpoint.URL, options.Endpoint.ForcePathStyle)
+		config.Endpoint = aws.String(options.Endpoint.URL)
+		config.DisableSSL = aws.Bool(options.Endpoint.DisableSSL)
 	}
+	config.S3ForcePathStyle = aws.Bool(options.Endpoint.ForcePathStyle)
+
+	svc := s3.New(sess, config)
+	AddBucketOwnerControls(svc, options.ExpectedBucketOwner, options.ACL)
 
-	return s3.New(session, &aws.Config{Region: aws.String(region), Credentials: creds}), nil
+	return svc, nil
 }
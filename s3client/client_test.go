@@ -0,0 +1,247 @@
+package s3client
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestEnv sets the environment variables for the duration of the test, unsetting those with an empty value
+func setTestEnv(env map[string]string) func() {
+	previous := make(map[string]*string)
+	for name, value := range env {
+		if current, ok := os.LookupEnv(name); ok {
+			previous[name] = &current
+		} else {
+			previous[name] = nil
+		}
+
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+
+	return func() {
+		for name, value := range previous {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+}
+
+// setTestCredentials sets static credentials in the environment and hides any credentials of the host
+func setTestCredentials() func() {
+	return setTestEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":           "AKIDTEST",
+		"AWS_SECRET_ACCESS_KEY":       "secret",
+		"AWS_SESSION_TOKEN":           "",
+		"AWS_PROFILE":                 "",
+		"AWS_DEFAULT_PROFILE":         "",
+		"AWS_ROLE_ARN":                "",
+		"AWS_WEB_IDENTITY_TOKEN_FILE": "",
+		"AWS_CA_BUNDLE":               "",
+		"AWS_CONFIG_FILE":             os.DevNull,
+		"AWS_SHARED_CREDENTIALS_FILE": os.DevNull,
+	})
+}
+
+func TestNewS3ClientUsesLogger(t *testing.T) {
+	defer setTestCredentials()()
+
+	server := s3test.NewServer("test-bucket")
+	defer server.Close()
+
+	var output bytes.Buffer
+	svc, err := NewS3Client(ClientOptions{
+		Endpoint: Endpoint{URL: server.URL, ForcePathStyle: true},
+		Logger:   log.New(&output, log.LevelInfo, log.FormatText).With("job", "postgres"),
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create the client without any error: %v", err))
+	}
+
+	_, err = svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("test-bucket")})
+	if err != nil {
+		t.Error(fmt.Sprintf("expected the client to use the endpoint, instead got: %v", err))
+	}
+
+	for _, expected := range []string{"Loaded AWS credentials from: EnvConfigCredentials", "Using S3 compatible endpoint: " + server.URL, "job=postgres"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Error(fmt.Sprintf("expected the log to contain '%s', instead got:\n%s", expected, output.String()))
+		}
+	}
+}
+
+func TestNewS3ClientUsesProfileFromEnvironment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credFile := filepath.Join(dir, "credentials")
+	err = ioutil.WriteFile(credFile, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = secret\n\n"+
+		"[backup]\naws_access_key_id = AKIDBACKUP\naws_secret_access_key = secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer setTestCredentials()()
+	defer setTestEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_SHARED_CREDENTIALS_FILE": credFile,
+		"AWS_PROFILE":                 "backup",
+	})()
+
+	for profile, expected := range map[string]string{"": "AKIDBACKUP", "default": "AKIDDEFAULT"} {
+		svc, err := NewS3Client(ClientOptions{Profile: profile, Region: "us-east-1", Logger: log.New(ioutil.Discard, log.LevelInfo, log.FormatText)})
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to create the client without any error: %v", err))
+		}
+
+		creds, err := svc.Config.Credentials.Get()
+		if err != nil || creds.AccessKeyID != expected {
+			t.Error(fmt.Sprintf("expected profile '%s' to use the credentials '%s', instead got: '%s' %v", profile, expected, creds.AccessKeyID, err))
+		}
+	}
+}
+
+func TestNewS3ClientReadsConfigFileWithCredFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	err = ioutil.WriteFile(credFile, []byte("[default]\naws_access_key_id = AKIDCREDFILE\naws_secret_access_key = secret\n"), 0600)
+	if err == nil {
+		err = ioutil.WriteFile(configFile, []byte("[profile backup]\naws_access_key_id = AKIDCONFIG\naws_secret_access_key = secret\n"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer setTestCredentials()()
+	defer setTestEnv(map[string]string{
+		"AWS_ACCESS_KEY_ID":     "",
+		"AWS_SECRET_ACCESS_KEY": "",
+		"AWS_CONFIG_FILE":       configFile,
+	})()
+
+	// The profile is only in the config file named by AWS_CONFIG_FILE
+	for profile, expected := range map[string]string{"default": "AKIDCREDFILE", "backup": "AKIDCONFIG"} {
+		svc, err := NewS3Client(ClientOptions{CredFile: credFile, Profile: profile, Region: "us-east-1", Logger: log.New(ioutil.Discard, log.LevelInfo, log.FormatText)})
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to create the client without any error: %v", err))
+		}
+
+		creds, err := svc.Config.Credentials.Get()
+		if err != nil || creds.AccessKeyID != expected {
+			t.Error(fmt.Sprintf("expected profile '%s' to use the credentials '%s', instead got: '%s' %v", profile, expected, creds.AccessKeyID, err))
+		}
+	}
+}
+
+// stsTransport answers AssumeRole requests sent to AWS STS and sends every other request to the next transport
+type stsTransport struct {
+	next     http.RoundTripper
+	requests []url.Values
+}
+
+func (s *stsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !strings.HasPrefix(r.URL.Host, "sts.") {
+		return s.next.RoundTrip(r)
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	form, _ := url.ParseQuery(string(body))
+	s.requests = append(s.requests, form)
+
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(recorder, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>`+
+		`<AccessKeyId>ASIAROLE</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>`+
+		`<Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	return recorder.Result(), nil
+}
+
+func TestNewS3ClientAssumesRole(t *testing.T) {
+	defer setTestCredentials()()
+
+	server := s3test.NewServer("test-bucket")
+	defer server.Close()
+
+	transport := &stsTransport{next: http.DefaultTransport}
+	http.DefaultClient.Transport = transport
+	defer func() { http.DefaultClient.Transport = nil }()
+
+	var output bytes.Buffer
+	svc, err := NewS3Client(ClientOptions{
+		Region:   "us-east-1",
+		Endpoint: Endpoint{URL: server.URL, ForcePathStyle: true},
+		AssumeRole: AssumeRole{
+			RoleARN:     "arn:aws:iam::123456789012:role/backup-writer",
+			ExternalID:  "workload-a",
+			Duration:    time.Hour,
+			SessionName: RoleSessionName("postgres"),
+		},
+		Logger: log.New(&output, log.LevelInfo, log.FormatText),
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to assume the role without any error: %v", err))
+	}
+
+	if len(transport.requests) != 1 {
+		t.Fatal(fmt.Sprintf("expected the role to be assumed once, instead got %d request(s)", len(transport.requests)))
+	}
+
+	request := transport.requests[0]
+	if request.Get("Action") != "AssumeRole" || request.Get("RoleArn") != "arn:aws:iam::123456789012:role/backup-writer" ||
+		request.Get("RoleSessionName") != "GoS3GFSBackup-postgres" || request.Get("ExternalId") != "workload-a" || request.Get("DurationSeconds") != "3600" {
+		t.Error(fmt.Sprintf("expected the role to be assumed with the options of the client, instead got: %v", request))
+	}
+
+	creds, err := svc.Config.Credentials.Get()
+	if err != nil || creds.AccessKeyID != "ASIAROLE" {
+		t.Error(fmt.Sprintf("expected the client to use the credentials of the role, instead got: '%s' %v", creds.AccessKeyID, err))
+	}
+
+	if !strings.Contains(output.String(), "Assumed role: arn:aws:iam::123456789012:role/backup-writer [session: GoS3GFSBackup-postgres]") {
+		t.Error(fmt.Sprintf("expected the role to be logged, instead got:\n%s", output.String()))
+	}
+}
+
+func TestRoleSessionName(t *testing.T) {
+	for job, expected := range map[string]string{
+		"":                       "GoS3GFSBackup",
+		"postgres":               "GoS3GFSBackup-postgres",
+		"db main/postgres":       "GoS3GFSBackup-db-main-postgres",
+		"a+b=c,d.e@f_g":          "GoS3GFSBackup-a+b=c,d.e@f_g",
+		strings.Repeat("x", 100): "GoS3GFSBackup-" + strings.Repeat("x", 50),
+	} {
+		sessionName := RoleSessionName(job)
+		if sessionName != expected {
+			t.Error(fmt.Sprintf("expected the session name of job '%s' to be '%s', instead got '%s'", job, expected, sessionName))
+		}
+	}
+}
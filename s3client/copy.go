@@ -0,0 +1,120 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/url"
+	"time"
+)
+
+// MaxCopyObjectSize is the largest object which can be copied with a single CopyObject request (5GiB)
+// Larger objects are copied part by part with UploadPartCopy
+const MaxCopyObjectSize = int64(5 * 1024 * 1024 * 1024)
+
+// DefaultCopyPartSize is the part size of a multipart copy if no part size is specified (512MiB)
+const DefaultCopyPartSize = int64(512 * 1024 * 1024)
+
+// abortCopyTimeout is the maximum amount of time to spend aborting a failed multipart copy
+const abortCopyTimeout = time.Second * 30
+
+// maxParts is the maximum number of parts of a multipart upload
+const maxParts = 10000
+
+// CopyKey copies the source key to the key within the bucket without transferring the content through this host
+// The user metadata and content type of the source key are kept. If part size is 0 then DefaultCopyPartSize is used
+// If a multipart copy fails then the incomplete multipart upload is aborted
+func CopyKey(ctx context.Context, svc *s3.S3, bucket string, sourceKey string, key string, partSize int64) error {
+	source, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(sourceKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	return CopyKeyToBucket(ctx, svc, bucket, sourceKey, source, bucket, key, partSize)
+}
+
+// CopyKeyToBucket copies the source key, described by its HeadObject output, to the key in another bucket in the same
+// way as CopyKey. The copy is requested with svc so its credentials must be able to read the source bucket
+func CopyKeyToBucket(ctx context.Context, svc *s3.S3, sourceBucket string, sourceKey string, source *s3.HeadObjectOutput, bucket string, key string, partSize int64) error {
+	copySource := url.PathEscape(sourceBucket + "/" + sourceKey)
+	size := aws.Int64Value(source.ContentLength)
+
+	if size <= MaxCopyObjectSize {
+		_, err := svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(key),
+			CopySource:        aws.String(copySource),
+			MetadataDirective: aws.String(s3.MetadataDirectiveCopy),
+		})
+		return err
+	}
+
+	if partSize <= 0 {
+		partSize = DefaultCopyPartSize
+	}
+	if size > partSize*maxParts {
+		partSize = (size + maxParts - 1) / maxParts // Round up
+	}
+	totalParts := (size + partSize - 1) / partSize
+
+	// A multipart upload does not copy the metadata of the source key so it is set from the source
+	createResp, err := svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Metadata:    source.Metadata,
+		ContentType: source.ContentType,
+	})
+	if err != nil {
+		return err
+	}
+
+	completedParts := []*s3.CompletedPart{}
+	for partNumber := int64(1); partNumber <= totalParts; partNumber++ {
+		start := (partNumber - 1) * partSize
+		end := start + partSize - 1
+		if end > size-1 {
+			end = size - 1
+		}
+
+		partResp, err := svc.UploadPartCopyWithContext(ctx, &s3.UploadPartCopyInput{
+			Bucket:          aws.String(bucket),
+			Key:             aws.String(key),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			PartNumber:      aws.Int64(partNumber),
+			UploadId:        createResp.UploadId,
+		})
+		if err != nil {
+			abortCopy(svc, bucket, key, aws.StringValue(createResp.UploadId))
+			return err
+		}
+
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       partResp.CopyPartResult.ETag,
+			PartNumber: aws.Int64(partNumber),
+		})
+	}
+
+	_, err = svc.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        createResp.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completedParts},
+	})
+	if err != nil {
+		abortCopy(svc, bucket, key, aws.StringValue(createResp.UploadId))
+	}
+	return err
+}
+
+// abortCopy aborts the multipart upload of a failed copy
+// A fresh context is used as the context of the copy may have already been cancelled or timed out
+func abortCopy(svc *s3.S3, bucket string, key string, uploadId string) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), abortCopyTimeout)
+	defer cancelFn()
+	AbortMultiPartUploadWithContext(ctx, svc, bucket, key, uploadId)
+}
@@ -0,0 +1,36 @@
+package s3client
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"net/http"
+	"testing"
+)
+
+func TestCopyKeyAbortsFailedMultipartCopy(t *testing.T) {
+	server := s3test.NewServer("test-bucket")
+	defer server.Close()
+
+	server.PutObject("test-bucket", "daily_postgres_1", []byte("backup"))
+	server.Fail(func(r *http.Request) bool {
+		return r.Header.Get("X-Amz-Copy-Source-Range") != ""
+	}, http.StatusForbidden, "InvalidObjectState")
+
+	// The size is faked so that the key is copied part by part
+	source := &s3.HeadObjectOutput{ContentLength: aws.Int64(MaxCopyObjectSize + 1)}
+	err := CopyKeyToBucket(context.Background(), server.Client(), "test-bucket", "daily_postgres_1", source, "test-bucket", "daily_postgres_2", 0)
+	if err == nil {
+		t.Fatal("expected the copy to fail when a part cannot be copied")
+	}
+
+	if server.Uploads() != 0 {
+		t.Error(fmt.Sprintf("expected the multipart upload of the failed copy to be aborted, instead %d remain", server.Uploads()))
+	}
+
+	if _, ok := server.Object("test-bucket", "daily_postgres_2"); ok {
+		t.Error("expected the failed copy not to create the key")
+	}
+}
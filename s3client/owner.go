@@ -0,0 +1,61 @@
+package s3client
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/http"
+)
+
+const (
+	expectedBucketOwnerHeader = "X-Amz-Expected-Bucket-Owner"
+	aclHeader                 = "X-Amz-Acl"
+)
+
+// ObjectACLs is the list of canned ACLs that may be applied to the objects written
+// ACLs which would make a backup readable outside of the accounts involved are not permitted
+var ObjectACLs = []string{
+	s3.ObjectCannedACLPrivate,
+	s3.ObjectCannedACLBucketOwnerRead,
+	s3.ObjectCannedACLBucketOwnerFullControl,
+}
+
+// AddBucketOwnerControls adds a handler to the client which sets the expected bucket owner of every request and the
+// canned ACL of every object written with it. This includes requests made on behalf of the upload and download managers
+// S3 rejects a request with 403 Access Denied if the bucket is not owned by the expected account. Either may be empty
+func AddBucketOwnerControls(svc *s3.S3, expectedBucketOwner string, acl string) {
+	if expectedBucketOwner == "" && acl == "" {
+		return
+	}
+
+	svc.Handlers.Build.PushBackNamed(request.NamedHandler{
+		Name: "GoS3GFSBackup.BucketOwnerControls",
+		Fn: func(r *request.Request) {
+			if expectedBucketOwner != "" {
+				r.HTTPRequest.Header.Set(expectedBucketOwnerHeader, expectedBucketOwner)
+			}
+			if acl != "" && writesObject(r.Operation.Name) {
+				r.HTTPRequest.Header.Set(aclHeader, acl)
+			}
+		},
+	})
+}
+
+// CheckBucketOwner returns an error if the bucket is not owned by the expected account of the client
+// This allows a run to fail before any work is done rather than on the first write
+func CheckBucketOwner(svc *s3.S3, bucket string, expectedBucketOwner string) error {
+	_, err := svc.HeadBucket(&s3.HeadBucketInput{
+		Bucket:              aws.String(bucket),
+		ExpectedBucketOwner: aws.String(expectedBucketOwner),
+	})
+	if IsStatusCode(err, http.StatusForbidden) {
+		return fmt.Errorf("bucket '%s' is not owned by the expected account '%s' or access to it is denied", bucket, expectedBucketOwner)
+	}
+	return err
+}
+
+// writesObject returns true if the operation creates an object and therefore accepts a canned ACL
+func writesObject(operation string) bool {
+	return operation == "PutObject" || operation == "CreateMultipartUpload" || operation == "CopyObject"
+}
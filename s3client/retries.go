@@ -0,0 +1,32 @@
+package s3client
+
+import (
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"sync/atomic"
+)
+
+// RetryCounter counts the requests made by a client which were retried
+type RetryCounter struct {
+	count int64
+}
+
+// Count returns the number of retries made so far
+func (c *RetryCounter) Count() int64 {
+	return atomic.LoadInt64(&c.count)
+}
+
+// CountRetries adds a handler to the client which counts every retry of a request made with it
+// This includes requests made on behalf of the upload and download managers
+func CountRetries(svc *s3.S3) *RetryCounter {
+	counter := &RetryCounter{}
+	svc.Handlers.AfterRetry.PushFrontNamed(request.NamedHandler{
+		Name: "GoS3GFSBackup.RetryCounter",
+		Fn: func(r *request.Request) {
+			if r.WillRetry() {
+				atomic.AddInt64(&counter.count, 1)
+			}
+		},
+	})
+	return counter
+}
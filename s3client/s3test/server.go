@@ -0,0 +1,704 @@
+// Package s3test provides an in-memory S3 compatible server for tests which need to make requests to S3
+// It implements the subset of the S3 API used by this tool with path style requests
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Region is the region of the clients returned by Client
+const Region = "us-east-1"
+
+// timeFormat is the format of the times returned in XML responses
+const timeFormat = "2006-01-02T15:04:05.000Z"
+
+// Object is an object stored by the server
+type Object struct {
+	Data         []byte
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string // User metadata with lower case names, without the 'x-amz-meta-' prefix
+	ContentType  string
+	StorageClass string // Objects with the GLACIER or DEEP_ARCHIVE storage class can not be read or copied
+}
+
+type part struct {
+	data         []byte
+	etag         string
+	lastModified time.Time
+}
+
+type multipartUpload struct {
+	bucket    string
+	key       string
+	initiated time.Time
+	metadata  map[string]string
+	parts     map[int]part
+}
+
+// Server is an in-memory S3 compatible server
+type Server struct {
+	*httptest.Server
+
+	// Now returns the time used as the last modified time of objects and the initiated time of multipart uploads
+	Now func() time.Time
+
+	// ConditionalWrites can be set to false to reject writes with an If-Match or If-None-Match header as not implemented
+	ConditionalWrites bool
+
+	mu       sync.Mutex
+	buckets  map[string]map[string]*Object
+	uploads  map[string]*multipartUpload
+	uploadID int
+	failures []failure
+}
+
+type failure struct {
+	match  func(r *http.Request) bool
+	status int
+	code   string
+}
+
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+// NewServer starts a server with the specified buckets. The server should be closed once the test has completed
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		Now:               time.Now,
+		ConditionalWrites: true,
+		buckets:           make(map[string]map[string]*Object),
+		uploads:           make(map[string]*multipartUpload),
+	}
+
+	for _, bucket := range buckets {
+		s.buckets[bucket] = make(map[string]*Object)
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns an S3 client which sends requests to the server. Failed requests are not retried
+func (s *Server) Client() *s3.S3 {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String(Region),
+		Endpoint:         aws.String(s.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("AKIDTEST", "SECRETTEST", ""),
+		MaxRetries:       aws.Int(0),
+	}))
+	return s3.New(sess)
+}
+
+// Fail makes every request matched by the function fail with the status and error code
+func (s *Server) Fail(match func(r *http.Request) bool, status int, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{match, status, code})
+}
+
+// PutObject stores the data as the key without making a request
+func (s *Server) PutObject(bucket string, key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucket][key] = &Object{Data: data, ETag: etag(data), LastModified: s.Now().UTC(), Metadata: map[string]string{}}
+}
+
+// Object returns a copy of the object stored as the key
+func (s *Server) Object(bucket string, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.buckets[bucket][key]
+	if !ok {
+		return Object{}, false
+	}
+	return *object, true
+}
+
+// Update changes the object stored as the key, i.e. to damage it or make it older. The ETag is updated to match
+// the data. Returns false if the key does not exist
+func (s *Server) Update(bucket string, key string, fn func(object *Object)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	object, ok := s.buckets[bucket][key]
+	if !ok {
+		return false
+	}
+	fn(object)
+	object.ETag = etag(object.Data)
+	return true
+}
+
+// Keys returns the keys stored in the bucket in lexical order
+func (s *Server) Keys(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []string{}
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Uploads returns the number of multipart uploads which have not been completed or aborted
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range s.failures {
+		if f.match(r) {
+			writeError(w, r, &apiError{f.status, f.code, "injected failure"})
+			return
+		}
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, r, &apiError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"})
+		return
+	}
+
+	query := r.URL.Query()
+	var response interface{}
+	var err *apiError
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+	case key == "" && r.Method == http.MethodGet && hasQuery(query, "uploads"):
+		response = s.listMultipartUploads(bucket, query)
+	case key == "" && r.Method == http.MethodGet:
+		response = listObjects(bucket, objects, query)
+	case r.Method == http.MethodPost && hasQuery(query, "uploads"):
+		response = s.createMultipartUpload(bucket, key, r)
+	case r.Method == http.MethodPost && hasQuery(query, "uploadId"):
+		response, err = s.completeMultipartUpload(bucket, key, query.Get("uploadId"), r)
+	case r.Method == http.MethodPut && hasQuery(query, "uploadId"):
+		response, err = s.uploadPart(bucket, key, query, w, r)
+	case r.Method == http.MethodDelete && hasQuery(query, "uploadId"):
+		err = s.abortMultipartUpload(bucket, key, query.Get("uploadId"))
+		if err == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	case r.Method == http.MethodGet && hasQuery(query, "uploadId"):
+		response, err = s.listParts(bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		response, err = s.copyObject(bucket, key, r)
+	case r.Method == http.MethodPut:
+		err = s.putObject(objects, key, w, r)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		err = getObject(objects, key, w, r)
+		if err == nil {
+			return
+		}
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		err = &apiError{http.StatusNotImplemented, "NotImplemented", "The request is not implemented by the test server"}
+	}
+
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	body, _ := xml.Marshal(response)
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func (s *Server) putObject(objects map[string]*Object, key string, w http.ResponseWriter, r *http.Request) *apiError {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if (ifMatch != "" || ifNoneMatch != "") && !s.ConditionalWrites {
+		return &apiError{http.StatusNotImplemented, "NotImplemented", "Conditional writes are not implemented"}
+	}
+
+	current, exists := objects[key]
+	if ifNoneMatch == "*" && exists {
+		return &apiError{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+	}
+	if ifMatch != "" && !exists {
+		return &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	}
+	if ifMatch != "" && ifMatch != current.ETag {
+		return &apiError{http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold"}
+	}
+
+	data, readErr := ioutil.ReadAll(r.Body)
+	if readErr != nil {
+		return &apiError{http.StatusBadRequest, "IncompleteBody", readErr.Error()}
+	}
+
+	object := &Object{
+		Data:         data,
+		ETag:         etag(data),
+		LastModified: s.Now().UTC(),
+		Metadata:     getMetadata(r.Header),
+		ContentType:  r.Header.Get("Content-Type"),
+		StorageClass: r.Header.Get("X-Amz-Storage-Class"),
+	}
+	objects[key] = object
+
+	w.Header().Set("ETag", object.ETag)
+	return nil
+}
+
+func getObject(objects map[string]*Object, key string, w http.ResponseWriter, r *http.Request) *apiError {
+	object, ok := objects[key]
+	if !ok {
+		return &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	}
+
+	if r.Method == http.MethodGet && isArchived(object) {
+		return &apiError{http.StatusForbidden, "InvalidObjectState", "The operation is not valid for the object's storage class"}
+	}
+
+	data := object.Data
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && r.Method == http.MethodGet {
+		start, end, ok := parseRange(rangeHeader, int64(len(data)))
+		if !ok {
+			return &apiError{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"}
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+
+	for name, value := range object.Metadata {
+		w.Header().Set("X-Amz-Meta-"+name, value)
+	}
+	if object.StorageClass != "" {
+		w.Header().Set("X-Amz-Storage-Class", object.StorageClass)
+	}
+	if object.ContentType != "" {
+		w.Header().Set("Content-Type", object.ContentType)
+	}
+	w.Header().Set("ETag", object.ETag)
+	w.Header().Set("Last-Modified", object.LastModified.Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+	return nil
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string
+	ETag         string
+}
+
+func (s *Server) copyObject(bucket string, key string, r *http.Request) (interface{}, *apiError) {
+	source, err := s.getCopySource(r)
+	if err != nil {
+		return nil, err
+	}
+
+	object := &Object{
+		Data:         source.Data,
+		ETag:         source.ETag,
+		LastModified: s.Now().UTC(),
+		Metadata:     source.Metadata,
+		ContentType:  source.ContentType,
+		StorageClass: r.Header.Get("X-Amz-Storage-Class"),
+	}
+	if r.Header.Get("X-Amz-Metadata-Directive") == s3.MetadataDirectiveReplace {
+		object.Metadata = getMetadata(r.Header)
+		object.ContentType = r.Header.Get("Content-Type")
+	}
+	s.buckets[bucket][key] = object
+
+	return copyObjectResult{LastModified: object.LastModified.Format(timeFormat), ETag: object.ETag}, nil
+}
+
+func (s *Server) getCopySource(r *http.Request) (*Object, *apiError) {
+	copySource, unescapeErr := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	i := strings.Index(copySource, "/")
+	if unescapeErr != nil || i < 0 {
+		return nil, &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid copy source"}
+	}
+
+	object, ok := s.buckets[copySource[:i]][copySource[i+1:]]
+	if !ok {
+		return nil, &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	}
+	if isArchived(object) {
+		return nil, &apiError{http.StatusForbidden, "InvalidObjectState", "Operation is not valid for the source object's storage class"}
+	}
+	return object, nil
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	Marker      string
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []listEntry
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+func listObjects(bucket string, objects map[string]*Object, query url.Values) listBucketResult {
+	prefix, marker := query.Get("prefix"), query.Get("marker")
+	maxKeys := 1000
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n < maxKeys {
+		maxKeys = n
+	}
+
+	keys := []string{}
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{Name: bucket, Prefix: prefix, Marker: marker, MaxKeys: maxKeys}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+	}
+
+	for _, key := range keys {
+		object := objects[key]
+		storageClass := object.StorageClass
+		if storageClass == "" {
+			storageClass = s3.StorageClassStandard
+		}
+		result.Contents = append(result.Contents, listEntry{
+			Key:          key,
+			LastModified: object.LastModified.Format(timeFormat),
+			ETag:         object.ETag,
+			Size:         len(object.Data),
+			StorageClass: storageClass,
+		})
+	}
+
+	return result
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+func (s *Server) createMultipartUpload(bucket string, key string, r *http.Request) initiateMultipartUploadResult {
+	s.uploadID++
+	uploadID := fmt.Sprintf("upload-%d", s.uploadID)
+	s.uploads[uploadID] = &multipartUpload{
+		bucket:    bucket,
+		key:       key,
+		initiated: s.Now().UTC(),
+		metadata:  getMetadata(r.Header),
+		parts:     make(map[int]part),
+	}
+
+	return initiateMultipartUploadResult{Bucket: bucket, Key: key, UploadId: uploadID}
+}
+
+type copyPartResult struct {
+	XMLName      xml.Name `xml:"CopyPartResult"`
+	LastModified string
+	ETag         string
+}
+
+func (s *Server) uploadPart(bucket string, key string, query url.Values, w http.ResponseWriter, r *http.Request) (interface{}, *apiError) {
+	upload, err := s.getUpload(bucket, key, query.Get("uploadId"))
+	if err != nil {
+		return nil, err
+	}
+
+	partNumber, convErr := strconv.Atoi(query.Get("partNumber"))
+	if convErr != nil || partNumber < 1 {
+		return nil, &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid part number"}
+	}
+
+	var data []byte
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		source, err := s.getCopySource(r)
+		if err != nil {
+			return nil, err
+		}
+		data = source.Data
+		if copyRange := r.Header.Get("X-Amz-Copy-Source-Range"); copyRange != "" {
+			start, end, ok := parseRange(copyRange, int64(len(data)))
+			if !ok {
+				return nil, &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid copy source range"}
+			}
+			data = data[start : end+1]
+		}
+	} else {
+		var readErr error
+		data, readErr = ioutil.ReadAll(r.Body)
+		if readErr != nil {
+			return nil, &apiError{http.StatusBadRequest, "IncompleteBody", readErr.Error()}
+		}
+	}
+
+	uploaded := part{data: data, etag: etag(data), lastModified: s.Now().UTC()}
+	upload.parts[partNumber] = uploaded
+
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		return copyPartResult{LastModified: uploaded.lastModified.Format(timeFormat), ETag: uploaded.etag}, nil
+	}
+
+	w.Header().Set("ETag", uploaded.etag)
+	return nil, nil
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string
+	Key     string
+	ETag    string
+}
+
+func (s *Server) completeMultipartUpload(bucket string, key string, uploadID string, r *http.Request) (interface{}, *apiError) {
+	upload, err := s.getUpload(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	request := completeMultipartUpload{}
+	body, _ := ioutil.ReadAll(r.Body)
+	if xml.Unmarshal(body, &request) != nil || len(request.Parts) == 0 {
+		return nil, &apiError{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed"}
+	}
+
+	data := []byte{}
+	digests := []byte{}
+	for i, requested := range request.Parts {
+		uploaded, ok := upload.parts[requested.PartNumber]
+		if !ok || uploaded.etag != requested.ETag || (i > 0 && requested.PartNumber <= request.Parts[i-1].PartNumber) {
+			return nil, &apiError{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+		}
+		data = append(data, uploaded.data...)
+		digest := md5.Sum(uploaded.data)
+		digests = append(digests, digest[:]...)
+	}
+
+	digest := md5.Sum(digests)
+	object := &Object{
+		Data:         data,
+		ETag:         fmt.Sprintf("\"%s-%d\"", hex.EncodeToString(digest[:]), len(request.Parts)),
+		LastModified: s.Now().UTC(),
+		Metadata:     upload.metadata,
+	}
+	s.buckets[bucket][key] = object
+	delete(s.uploads, uploadID)
+
+	return completeMultipartUploadResult{Bucket: bucket, Key: key, ETag: object.ETag}, nil
+}
+
+func (s *Server) abortMultipartUpload(bucket string, key string, uploadID string) *apiError {
+	_, err := s.getUpload(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+type listMultipartUploadsResult struct {
+	XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+	Bucket      string
+	Prefix      string
+	IsTruncated bool
+	Uploads     []uploadEntry `xml:"Upload"`
+}
+
+type uploadEntry struct {
+	Key       string
+	UploadId  string
+	Initiated string
+}
+
+func (s *Server) listMultipartUploads(bucket string, query url.Values) listMultipartUploadsResult {
+	prefix := query.Get("prefix")
+	result := listMultipartUploadsResult{Bucket: bucket, Prefix: prefix}
+
+	uploadIDs := []string{}
+	for uploadID, upload := range s.uploads {
+		if upload.bucket == bucket && strings.HasPrefix(upload.key, prefix) {
+			uploadIDs = append(uploadIDs, uploadID)
+		}
+	}
+	sort.Strings(uploadIDs)
+
+	for _, uploadID := range uploadIDs {
+		upload := s.uploads[uploadID]
+		result.Uploads = append(result.Uploads, uploadEntry{Key: upload.key, UploadId: uploadID, Initiated: upload.initiated.Format(timeFormat)})
+	}
+
+	return result
+}
+
+type listPartsResult struct {
+	XMLName     xml.Name `xml:"ListPartsResult"`
+	Bucket      string
+	Key         string
+	UploadId    string
+	IsTruncated bool
+	Parts       []partEntry `xml:"Part"`
+}
+
+type partEntry struct {
+	PartNumber   int
+	LastModified string
+	ETag         string
+	Size         int
+}
+
+func (s *Server) listParts(bucket string, key string, uploadID string) (interface{}, *apiError) {
+	upload, err := s.getUpload(bucket, key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	partNumbers := []int{}
+	for partNumber := range upload.parts {
+		partNumbers = append(partNumbers, partNumber)
+	}
+	sort.Ints(partNumbers)
+
+	result := listPartsResult{Bucket: bucket, Key: key, UploadId: uploadID}
+	for _, partNumber := range partNumbers {
+		uploaded := upload.parts[partNumber]
+		result.Parts = append(result.Parts, partEntry{
+			PartNumber:   partNumber,
+			LastModified: uploaded.lastModified.Format(timeFormat),
+			ETag:         uploaded.etag,
+			Size:         len(uploaded.data),
+		})
+	}
+
+	return result, nil
+}
+
+func (s *Server) getUpload(bucket string, key string, uploadID string) (*multipartUpload, *apiError) {
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.bucket != bucket || upload.key != key {
+		return nil, &apiError{http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist"}
+	}
+	return upload, nil
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err *apiError) {
+	// Responses to HEAD requests do not have a body so the client only sees the status code
+	if r.Method == http.MethodHead {
+		w.WriteHeader(err.status)
+		return
+	}
+
+	body, _ := xml.Marshal(errorResponse{Code: err.code, Message: err.message})
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(err.status)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
+
+func getMetadata(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for name, values := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") && len(values) > 0 {
+			metadata[strings.ToLower(strings.TrimPrefix(strings.ToLower(name), "x-amz-meta-"))] = values[0]
+		}
+	}
+	return metadata
+}
+
+func parseRange(header string, size int64) (int64, int64, bool) {
+	var start, end int64
+	_, err := fmt.Sscanf(header, "bytes=%d-%d", &start, &end)
+	if err != nil || start < 0 || start > end || start >= size {
+		return 0, 0, false
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end, true
+}
+
+func isArchived(object *Object) bool {
+	return object.StorageClass == s3.StorageClassGlacier || object.StorageClass == s3.StorageClassDeepArchive
+}
+
+func hasQuery(query url.Values, name string) bool {
+	_, ok := query[name]
+	return ok
+}
+
+func etag(data []byte) string {
+	digest := md5.Sum(data)
+	return "\"" + hex.EncodeToString(digest[:]) + "\""
+}
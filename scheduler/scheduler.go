@@ -0,0 +1,215 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"github.com/robfig/cron"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Scheduler runs jobs according to their cron expressions
+// A job is never run while a previous run of the same job is still in progress and
+// any run missed or failed since the last successful run is caught up on start
+type Scheduler struct {
+	statePath string
+	jobs      []*job
+	logger    *log.Logger
+
+	mu    sync.Mutex
+	state map[string]time.Time // Job name -> scheduled time of the last run that succeeded
+	wg    sync.WaitGroup
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	run      func() error
+
+	running bool // Guarded by the scheduler mutex
+}
+
+// New creates a scheduler which records the last successful run of every job in the state file at the specified path
+// If the path is empty then the state is not persisted and missed runs are not caught up
+// If logger is nil then the default logger is used
+func New(statePath string, logger *log.Logger) (*Scheduler, error) {
+	s := &Scheduler{
+		statePath: statePath,
+		logger:    log.OrDefault(logger),
+		state:     make(map[string]time.Time),
+	}
+
+	if statePath == "" {
+		return s, nil
+	}
+
+	contents, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(contents, &s.state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scheduler state file '%s': %v", statePath, err)
+	}
+
+	return s, nil
+}
+
+// Add registers a job to be run on the specified cron expression
+// Standard 5 field expressions (minute hour day-of-month month day-of-week) and descriptors such as '@daily' are supported
+func (s *Scheduler) Add(name string, spec string, run func() error) error {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule '%s' for job '%s': %v", spec, name, err)
+	}
+
+	s.jobs = append(s.jobs, &job{name: name, spec: spec, schedule: schedule, run: run})
+	return nil
+}
+
+// Run starts every job and blocks until the context is cancelled
+// Once cancelled no new runs are started and Run returns after all in progress runs have finished
+func (s *Scheduler) Run(ctx context.Context) {
+	now := time.Now()
+
+	for _, j := range s.jobs {
+		lastRun, ok := s.lastRun(j.name)
+		if !ok {
+			// Nothing to catch up on for a new job, but any run missed from now on should be
+			s.setLastRun(j.name, now)
+		} else if MissedRun(j.schedule, lastRun, now) {
+			s.logger.Warn.Printf("Job '%s' missed or failed a scheduled run since its last successful run at %s, catching up now\n",
+				j.name, lastRun.Format(time.RFC3339))
+			s.trigger(j, now)
+		}
+
+		s.logger.Info.Printf("Scheduled job '%s' with schedule '%s'. Next run at %s\n",
+			j.name, j.spec, j.schedule.Next(now).Format(time.RFC3339))
+	}
+
+	var loops sync.WaitGroup
+	for _, j := range s.jobs {
+		loops.Add(1)
+		go func(j *job) {
+			defer loops.Done()
+			s.loop(ctx, j)
+		}(j)
+	}
+	loops.Wait()
+
+	s.mu.Lock()
+	running := 0
+	for _, j := range s.jobs {
+		if j.running {
+			running++
+		}
+	}
+	s.mu.Unlock()
+
+	if running > 0 {
+		s.logger.Info.Printf("Waiting for %d running job(s) to finish before shutting down\n", running)
+	}
+	s.wg.Wait()
+}
+
+// MissedRun returns true if the schedule had a run due after the last run and at or before now
+func MissedRun(schedule cron.Schedule, lastRun time.Time, now time.Time) bool {
+	next := schedule.Next(lastRun)
+	return !next.After(now)
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	for {
+		next := j.schedule.Next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.trigger(j, next)
+		}
+	}
+}
+
+// trigger starts a run of the job unless a previous run is still in progress
+func (s *Scheduler) trigger(j *job, scheduled time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j.running {
+		s.logger.Warn.Printf("Skipping scheduled run of job '%s' at %s as the previous run is still in progress\n",
+			j.name, scheduled.Format(time.RFC3339))
+		return
+	}
+
+	j.running = true
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		s.logger.Info.Printf("Starting scheduled run of job '%s'\n", j.name)
+		startTime := time.Now()
+		err := j.run()
+		elapsedTime := time.Since(startTime).Seconds()
+
+		s.mu.Lock()
+		j.running = false
+		s.mu.Unlock()
+
+		// A failed run is not recorded so that it is caught up if the scheduler is restarted before the next run
+		if err != nil {
+			s.logger.Error.Printf("Scheduled run of job '%s' failed after %0.2f seconds: %v\n", j.name, elapsedTime, err)
+			return
+		}
+
+		s.logger.Info.Printf("Scheduled run of job '%s' completed in %0.2f seconds\n", j.name, elapsedTime)
+		s.setLastRun(j.name, scheduled)
+	}()
+}
+
+func (s *Scheduler) setLastRun(name string, lastRun time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state[name] = lastRun
+
+	err := s.saveState()
+	if err != nil {
+		s.logger.Error.Printf("Failed to save scheduler state: %v\n", err)
+	}
+}
+
+func (s *Scheduler) lastRun(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lastRun, ok := s.state[name]
+	return lastRun, ok
+}
+
+// saveState atomically writes the state file. The scheduler mutex must be held
+func (s *Scheduler) saveState() error {
+	if s.statePath == "" {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return util.WriteFileAtomic(s.statePath, contents, 0644)
+}
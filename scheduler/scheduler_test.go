@@ -0,0 +1,153 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/robfig/cron"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Setup testing
+func init() {
+	log.Init(ioutil.Discard, ioutil.Discard, ioutil.Discard)
+}
+
+func TestMissedRun(t *testing.T) {
+	daily, err := cron.ParseStandard("0 2 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastRun := time.Date(2017, time.September, 18, 2, 0, 0, 0, time.Local)
+
+	if MissedRun(daily, lastRun, lastRun.Add(time.Hour*23)) {
+		t.Error("expected no missed run less than a day after the last run")
+	}
+
+	if !MissedRun(daily, lastRun, lastRun.Add(time.Hour*24)) {
+		t.Error("expected a missed run exactly a day after the last run")
+	}
+
+	if !MissedRun(daily, lastRun, lastRun.Add(time.Hour*72)) {
+		t.Error("expected a missed run after three days of downtime")
+	}
+}
+
+func TestTriggerDoesNotOverlap(t *testing.T) {
+	s, err := New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var runs int32
+	release := make(chan bool)
+
+	err = s.Add("slow", "@daily", func() error {
+		atomic.AddInt32(&runs, 1)
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.trigger(s.jobs[0], time.Now())
+	s.trigger(s.jobs[0], time.Now()) // Should be skipped as the first run is still in progress
+
+	close(release)
+	s.wg.Wait()
+
+	if atomic.LoadInt32(&runs) != 1 {
+		t.Error(fmt.Sprintf("expected 1 run but got %d", runs))
+	}
+}
+
+func TestStateIsPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	statePath := filepath.Join(dir, "state")
+	scheduled := time.Date(2017, time.September, 18, 2, 0, 0, 0, time.UTC)
+
+	s, err := New(statePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setLastRun("postgres", scheduled)
+
+	reloaded, err := New(statePath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastRun, ok := reloaded.lastRun("postgres")
+	if !ok || !lastRun.Equal(scheduled) {
+		t.Error(fmt.Sprintf("expected last run to be %v but got %v", scheduled, lastRun))
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 || files[0].Mode().Perm() != 0644 {
+		t.Error(fmt.Sprintf("expected only the state file to be written with mode 0644, instead found %d file(s): %v", len(files), err))
+	}
+}
+
+func TestFailedRunIsNotRecorded(t *testing.T) {
+	s, err := New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fail int32 = 1
+	err = s.Add("postgres", "@daily", func() error {
+		if atomic.LoadInt32(&fail) == 1 {
+			return errors.New("upload failed")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastSuccess := time.Date(2017, time.September, 17, 0, 0, 0, 0, time.UTC)
+	s.setLastRun("postgres", lastSuccess)
+
+	s.trigger(s.jobs[0], lastSuccess.Add(time.Hour*24))
+	s.wg.Wait()
+
+	lastRun, _ := s.lastRun("postgres")
+	if !lastRun.Equal(lastSuccess) {
+		t.Error(fmt.Sprintf("expected a failed run not to be recorded, instead the last run is %v", lastRun))
+	}
+	if !MissedRun(s.jobs[0].schedule, lastRun, lastSuccess.Add(time.Hour*25)) {
+		t.Error("expected the failed run to be caught up")
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	s.trigger(s.jobs[0], lastSuccess.Add(time.Hour*48))
+	s.wg.Wait()
+
+	lastRun, _ = s.lastRun("postgres")
+	if !lastRun.Equal(lastSuccess.Add(time.Hour * 48)) {
+		t.Error(fmt.Sprintf("expected a successful run to be recorded, instead the last run is %v", lastRun))
+	}
+}
+
+func TestAddInvalidSchedule(t *testing.T) {
+	s, err := New("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Add("postgres", "every day", func() error { return nil })
+	if err == nil {
+		t.Error("expected error when adding a job with an invalid schedule")
+	}
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileStore is a Store backed by a local directory, e.g. an NFS share. Each key is a file below the root directory
+// Keys are ordered by the timestamp embedded in their name by a backup, or by the modified time of the file if there is none
+type FileStore struct {
+	Root string
+}
+
+// NewFileStore returns the store of the directory of a file:// URL, e.g. file:///mnt/backups
+// The directory must already exist
+func NewFileStore(rawURL string) (*FileStore, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file URL '%s': %v", rawURL, err)
+	}
+
+	if parsed.Scheme != "file" {
+		return nil, fmt.Errorf("invalid file URL '%s': expected the file:// scheme", rawURL)
+	}
+
+	if parsed.Host != "" && parsed.Host != "localhost" {
+		return nil, fmt.Errorf("invalid file URL '%s': only local directories are supported, use file:///path", rawURL)
+	}
+
+	if !path.IsAbs(parsed.Path) {
+		return nil, fmt.Errorf("invalid file URL '%s': the path must be absolute", rawURL)
+	}
+
+	root := filepath.FromSlash(path.Clean(parsed.Path))
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("'%s' is not a directory", root)
+	}
+
+	return &FileStore{Root: root}, nil
+}
+
+// List returns every key with the prefix sorted newest first. Temporary files of writes in progress are ignored
+func (f *FileStore) List(prefix string) ([]s3client.BucketEntry, error) {
+	// Only the directory containing the prefix needs to be walked, as with S3 a prefix may match files in subdirectories
+	dir := f.Root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		var err error
+		dir, err = f.path(prefix[:i+1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make(map[string]time.Time)
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if !info.Mode().IsRegular() || isTempFile(info.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(f.Root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		modified, ok := util.GetKeyTimestamp(key)
+		if !ok {
+			modified = info.ModTime()
+		}
+		keys[key] = modified
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return s3client.SortKeysByTime(keys), nil
+}
+
+// Delete removes the file of the key
+func (f *FileStore) Delete(key string) error {
+	filePath, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Copy copies the file of the source key to the key. The copy is written atomically
+func (f *FileStore) Copy(sourceKey string, key string) error {
+	source, err := f.Open(sourceKey)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	return f.Put(key, source)
+}
+
+// Open opens the file of the key for reading
+func (f *FileStore) Open(key string) (*os.File, error) {
+	filePath, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filePath)
+}
+
+// Put writes the contents of the reader to the key. The contents are written to a temporary file in the same directory,
+// synced and then renamed over the key so that a partially written key is never listed. Directories are created as required
+func (f *FileStore) Put(key string, reader io.Reader) error {
+	filePath, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(filePath)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tmpFile, reader)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if err == nil {
+		err = tmpFile.Chmod(0644)
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	err = os.Rename(tmpFile.Name(), filePath)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	return nil
+}
+
+func (f *FileStore) String() string {
+	return FileScheme + filepath.ToSlash(f.Root)
+}
+
+// path returns the path of the file of the key. Keys which would escape the root directory are rejected
+func (f *FileStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("invalid key '%s'", key)
+	}
+	for _, element := range strings.Split(key, "/") {
+		if element == ".." {
+			return "", errors.New("key must not contain '..': " + key)
+		}
+	}
+	return filepath.Join(f.Root, filepath.FromSlash(key)), nil
+}
+
+// isTempFile returns true if the file name is that of a write in progress by Put
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp")
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getTestStore(t *testing.T) *FileStore {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFileStore(FileScheme + filepath.ToSlash(dir))
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to create file store without any error: %v", err))
+	}
+	return store
+}
+
+func TestNewFileStoreInvalidURL(t *testing.T) {
+	for _, rawURL := range []string{"s3://mybucket", "file://nas/backups", "file://relative", "file:///does/not/exist"} {
+		_, err := NewFileStore(rawURL)
+		if err == nil {
+			t.Error(fmt.Sprintf("expected '%s' to be rejected", rawURL))
+		}
+	}
+}
+
+func TestFileStorePutListDelete(t *testing.T) {
+	store := getTestStore(t)
+	defer os.RemoveAll(store.Root)
+
+	keys := []string{"daily_postgres_20171106T020000", "daily_postgres_20171108T020000", "databases/daily_postgres_20171107T020000"}
+	for _, key := range keys {
+		err := store.Put(key, strings.NewReader(key))
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to put key '%s' without any error: %v", key, err))
+		}
+	}
+
+	// A write in progress must never be listed
+	err := ioutil.WriteFile(filepath.Join(store.Root, ".daily_postgres_20171109T020000.tmp123"), []byte("partial"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sortedKeys, err := store.List("daily_")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to list keys without any error: %v", err))
+	}
+	if len(sortedKeys) != 2 || sortedKeys[0].Key != keys[1] || sortedKeys[1].Key != keys[0] {
+		t.Fatal(fmt.Sprintf("expected keys '%s' and '%s' newest first, instead got: %v", keys[1], keys[0], sortedKeys))
+	}
+
+	expected := time.Date(2017, time.November, 8, 2, 0, 0, 0, time.Local)
+	if !sortedKeys[0].ModifiedTime.Equal(expected) {
+		t.Error(fmt.Sprintf("expected modified time to be the embedded timestamp %v, instead got: %v", expected, sortedKeys[0].ModifiedTime))
+	}
+
+	dirKeys, err := store.List("databases/daily_")
+	if err != nil || len(dirKeys) != 1 || dirKeys[0].Key != keys[2] {
+		t.Error(fmt.Sprintf("expected only key '%s' in the bucket dir, instead got: %v %v", keys[2], dirKeys, err))
+	}
+
+	err = store.Delete(keys[0])
+	if err != nil {
+		t.Error(fmt.Sprintf("expected to delete key without any error: %v", err))
+	}
+	err = store.Delete(keys[0])
+	if err != nil {
+		t.Error(fmt.Sprintf("expected deleting a missing key not to be an error, instead got: %v", err))
+	}
+
+	sortedKeys, _ = store.List("daily_")
+	if len(sortedKeys) != 1 {
+		t.Error(fmt.Sprintf("expected 1 key after delete, instead got: %v", sortedKeys))
+	}
+}
+
+func TestFileStoreListMissingDir(t *testing.T) {
+	store := getTestStore(t)
+	defer os.RemoveAll(store.Root)
+
+	sortedKeys, err := store.List("missing/daily_")
+	if err != nil || sortedKeys != nil {
+		t.Error(fmt.Sprintf("expected no keys and no error for a missing bucket dir, instead got: %v %v", sortedKeys, err))
+	}
+}
+
+func TestFileStoreListUsesModifiedTime(t *testing.T) {
+	store := getTestStore(t)
+	defer os.RemoveAll(store.Root)
+
+	err := store.Put("daily_notes", strings.NewReader("notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modified := time.Date(2017, time.November, 6, 2, 0, 0, 0, time.Local)
+	err = os.Chtimes(filepath.Join(store.Root, "daily_notes"), modified, modified)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sortedKeys, err := store.List("daily_")
+	if err != nil || len(sortedKeys) != 1 || !sortedKeys[0].ModifiedTime.Equal(modified) {
+		t.Error(fmt.Sprintf("expected key without a timestamp to use the modified time %v, instead got: %v %v", modified, sortedKeys, err))
+	}
+}
+
+func TestFileStoreCopy(t *testing.T) {
+	store := getTestStore(t)
+	defer os.RemoveAll(store.Root)
+
+	err := store.Put("daily_postgres_20171106T020000", strings.NewReader("backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Copy("daily_postgres_20171106T020000", "weekly_postgres_20171106T020000")
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to copy key without any error: %v", err))
+	}
+
+	file, err := store.Open("weekly_postgres_20171106T020000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	contents, _ := ioutil.ReadAll(file)
+	if string(contents) != "backup" {
+		t.Error(fmt.Sprintf("expected copy to contain 'backup', instead got: '%s'", contents))
+	}
+}
+
+func TestFileStoreRejectsEscapingKeys(t *testing.T) {
+	store := getTestStore(t)
+	defer os.RemoveAll(store.Root)
+
+	for _, key := range []string{"../outside", "databases/../../outside", "/etc/passwd"} {
+		err := store.Put(key, strings.NewReader("escape"))
+		if err == nil {
+			t.Error(fmt.Sprintf("expected key '%s' to be rejected", key))
+		}
+	}
+}
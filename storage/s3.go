@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+)
+
+// S3Store is a Store backed by an S3 bucket. Keys are ordered by their LastModified time
+type S3Store struct {
+	Svc    *s3.S3
+	Bucket string
+}
+
+// NewS3Store returns the store of the S3 bucket
+func NewS3Store(svc *s3.S3, bucket string) *S3Store {
+	return &S3Store{Svc: svc, Bucket: bucket}
+}
+
+// List returns every key with the prefix sorted by LastModified, newest first
+func (s *S3Store) List(prefix string) ([]s3client.BucketEntry, error) {
+	return util.RetrieveSortedKeysByTime(s.Svc, s.Bucket, prefix)
+}
+
+// Delete deletes the key from the bucket
+func (s *S3Store) Delete(key string) error {
+	_, err := s3client.DeleteKey(s.Svc, s.Bucket, key)
+	return err
+}
+
+// Copy copies the source key to the key within S3. The user metadata of the source key is kept
+func (s *S3Store) Copy(sourceKey string, key string) error {
+	return s3client.CopyKey(context.Background(), s.Svc, s.Bucket, sourceKey, key, 0)
+}
+
+func (s *S3Store) String() string {
+	return s.Bucket
+}
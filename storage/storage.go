@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
+	"strings"
+	"time"
+)
+
+// FileScheme is the scheme of a bucket which is a local directory rather than an S3 bucket, e.g. file:///mnt/backups
+const FileScheme = "file://"
+
+// Store is a location the keys of a series are kept in, either an S3 bucket or a local directory
+type Store interface {
+	// List returns every key with the prefix sorted by the time it was modified, newest first. Nil if there are none
+	List(prefix string) ([]s3client.BucketEntry, error)
+	// Delete deletes the key. Deleting a key which does not exist is not an error
+	Delete(key string) error
+	// Copy copies the source key to the key within the store
+	Copy(sourceKey string, key string) error
+	// String returns the bucket or URL of the store for logging
+	String() string
+}
+
+// IsFileURL returns true if the bucket is a local directory specified with the file:// scheme
+func IsFileURL(bucket string) bool {
+	return strings.HasPrefix(bucket, FileScheme)
+}
+
+// NewestKeyTime returns the time the newest key with the prefix was modified, zero if there are no keys
+func NewestKeyTime(store Store, prefix string) (time.Time, error) {
+	sortedKeys, err := store.List(prefix)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(sortedKeys) == 0 {
+		return time.Time{}, nil
+	}
+	return sortedKeys[0].ModifiedTime, nil
+}
+
+// CountKeysByTier returns the number of keys currently stored in the bucket dir for each tier of the policy
+func CountKeysByTier(store Store, bucketDir string, policy rpolicy.RotationPolicy) (map[string]int, error) {
+	counts := make(map[string]int)
+	for _, prefix := range []string{policy.DailyPrefix, policy.WeeklyPrefix, policy.MonthlyPrefix} {
+		keys, err := store.List(bucketDir + prefix)
+		if err != nil {
+			return nil, err
+		}
+		counts[util.GetTierName(policy, prefix)] = len(keys)
+	}
+	return counts, nil
+}
@@ -0,0 +1,107 @@
+package upload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"io"
+	"os"
+	"strings"
+)
+
+// ChecksumMetadataKey is the user metadata key of the sha256 of the content of a backup uploaded with dedup enabled
+const ChecksumMetadataKey = "gos3gfsbackup-sha256"
+
+// fileChecksum returns the hex encoded sha256 of the file and rewinds it so that it can be uploaded
+func fileChecksum(file *os.File) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findLatestBackup returns the most recently modified backup of the series. The series is every key in the bucket dir
+// with one of the tier prefixes followed by the s3 file name. Nil is returned if the series has no backups
+func findLatestBackup(svc *s3.S3, uploadObject UploadObject) (*s3.Object, error) {
+	var latest *s3.Object
+	for _, prefix := range uploadObject.SeriesPrefixes {
+		objects, err := s3client.GetObjectsByPrefix(svc, uploadObject.Bucket, uploadObject.BucketDir+prefix+uploadObject.S3FileName+"_")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range objects {
+			if latest == nil || aws.TimeValue(object.LastModified).After(aws.TimeValue(latest.LastModified)) {
+				latest = object
+			}
+		}
+	}
+	return latest, nil
+}
+
+// getChecksum returns the checksum metadata of the key. An empty string is returned if the key has no checksum metadata
+// The SDK canonicalises the case of metadata keys so they are compared without case
+func getChecksum(ctx context.Context, svc *s3.S3, bucket string, key string) (string, error) {
+	resp, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for metadataKey, value := range resp.Metadata {
+		if strings.EqualFold(metadataKey, ChecksumMetadataKey) {
+			return aws.StringValue(value), nil
+		}
+	}
+	return "", nil
+}
+
+// findIdenticalBackup returns the key of the latest backup of the series if its content has the checksum
+// An empty string is returned if the series has no backups or the latest backup differs
+func findIdenticalBackup(ctx context.Context, svc *s3.S3, uploadObject UploadObject, checksum string, size int64, logger *log.Logger) (string, error) {
+	latest, err := findLatestBackup(svc, uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	if latest == nil {
+		logger.Info.Println("No previous backup found in the series, uploading")
+		return "", nil
+	}
+
+	latestKey := aws.StringValue(latest.Key)
+	if aws.Int64Value(latest.Size) != size {
+		logger.Info.Printf("Latest backup '%s' is %d bytes and the file is %d bytes, uploading\n", latestKey, aws.Int64Value(latest.Size), size)
+		return "", nil
+	}
+
+	latestChecksum, err := getChecksum(ctx, svc, uploadObject.Bucket, latestKey)
+	if err != nil {
+		return "", err
+	}
+
+	if latestChecksum != checksum {
+		if latestChecksum == "" {
+			logger.Info.Printf("Latest backup '%s' has no checksum metadata, uploading\n", latestKey)
+		} else {
+			logger.Info.Printf("Latest backup '%s' has checksum %s and the file has checksum %s, uploading\n", latestKey, latestChecksum, checksum)
+		}
+		return "", nil
+	}
+
+	return latestKey, nil
+}
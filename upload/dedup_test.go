@@ -0,0 +1,104 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// getDedupTestObject writes a file to a temporary directory and returns an object uploading it with dedup enabled
+func getDedupTestObject(t *testing.T, contents []byte) (UploadObject, func()) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "backup.tar")
+	err = ioutil.WriteFile(path, contents, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return UploadObject{
+		PathToFile:     path,
+		S3FileName:     "postgres",
+		BucketDir:      "databases/",
+		Bucket:         "dedup-bucket",
+		Timeout:        time.Minute,
+		NumWorkers:     1,
+		PartSize:       5,
+		Manipulate:     true,
+		Dedup:          true,
+		SeriesPrefixes: []string{"daily_", "weekly_", "monthly_"},
+	}, func() { os.RemoveAll(dir) }
+}
+
+func TestUploadDedupCopiesIdenticalBackup(t *testing.T) {
+	server := s3test.NewServer("dedup-bucket")
+	defer server.Close()
+
+	uploadObject, cleanUp := getDedupTestObject(t, []byte("identical backup"))
+	defer cleanUp()
+
+	first, err := Upload(server.Client(), uploadObject, "daily_", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+
+	keyTime = func() time.Time { return time.Now().Add(time.Hour) }
+	defer func() { keyTime = time.Now }()
+
+	second, err := Upload(server.Client(), uploadObject, "daily_", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to copy file without any error: %v", err))
+	}
+
+	if second.Key == first.Key || second.CopiedFrom != first.Key {
+		t.Error(fmt.Sprintf("expected '%s' to be copied from '%s', instead got: %+v", second.Key, first.Key, second))
+	}
+
+	object, ok := server.Object("dedup-bucket", second.Key)
+	if !ok || !bytes.Equal(object.Data, []byte("identical backup")) || object.Metadata[ChecksumMetadataKey] != first.Checksum {
+		t.Error(fmt.Sprintf("expected the copy to have the content and checksum of the latest backup, instead got: %+v", object))
+	}
+}
+
+func TestUploadDedupFallsBackToUpload(t *testing.T) {
+	server := s3test.NewServer("dedup-bucket")
+	defer server.Close()
+
+	uploadObject, cleanUp := getDedupTestObject(t, []byte("identical backup"))
+	defer cleanUp()
+
+	first, err := Upload(server.Client(), uploadObject, "daily_", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+
+	// The latest backup can no longer be copied once it has been transitioned to GLACIER
+	server.Update("dedup-bucket", first.Key, func(object *s3test.Object) {
+		object.StorageClass = "GLACIER"
+	})
+
+	keyTime = func() time.Time { return time.Now().Add(time.Hour) }
+	defer func() { keyTime = time.Now }()
+
+	second, err := Upload(server.Client(), uploadObject, "daily_", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload the file when the copy failed, instead got: %v", err))
+	}
+
+	if second.CopiedFrom != "" {
+		t.Error(fmt.Sprintf("expected the file to be uploaded, instead it was copied from: %s", second.CopiedFrom))
+	}
+
+	object, ok := server.Object("dedup-bucket", second.Key)
+	if !ok || !bytes.Equal(object.Data, []byte("identical backup")) || object.Metadata[ChecksumMetadataKey] != first.Checksum {
+		t.Error(fmt.Sprintf("expected the file to be uploaded with its checksum, instead got: %+v", object))
+	}
+}
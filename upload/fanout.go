@@ -0,0 +1,205 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fanOutBufferSize is the size of each chunk read from the file and written to every destination
+const fanOutBufferSize = 1024 * 1024
+
+// Destination is a bucket the file is uploaded to by FanOut
+type Destination struct {
+	Name      string
+	Svc       *s3.S3
+	Bucket    string
+	BucketDir string
+	Required  bool // If enabled then the whole upload fails if this destination fails (all-or-nothing). Otherwise only this destination fails (best-effort)
+}
+
+// DestinationResult is the outcome of the upload to a single destination
+type DestinationResult struct {
+	Destination
+	Key string
+	Err error // Nil if the file was uploaded to the destination
+}
+
+// FanOut uploads the file to every destination in parallel while reading it only once
+// The bucket and bucket dir of the upload object are ignored in favour of those of each destination
+// If a required destination fails then the uploads to every other destination are cancelled, any key already uploaded
+// is deleted and an error is returned. A best-effort destination which fails is only reported in its result
+func FanOut(uploadObject UploadObject, destinations []Destination, prefix string, dryRun bool) ([]DestinationResult, error) {
+	if len(destinations) == 0 {
+		return nil, errors.New("at least one destination must be specified")
+	}
+
+	results := make([]DestinationResult, len(destinations))
+	timestamp := keyTime()
+	for i, destination := range destinations {
+		if destination.Svc == nil {
+			return nil, fmt.Errorf("svc of destination '%s' must not be nil", destination.Name)
+		}
+
+		destinationObject := uploadObject
+		destinationObject.Bucket = destination.Bucket
+		destinationObject.BucketDir = destination.BucketDir
+		destinationObject.Manipulate = true
+		err := validationCheck(destinationObject)
+		if err != nil {
+			return nil, fmt.Errorf("destination '%s': %v", destination.Name, err)
+		}
+
+		results[i] = DestinationResult{Destination: destination, Key: getKey(destinationObject, prefix, timestamp)}
+	}
+
+	logger := log.OrDefault(uploadObject.Logger)
+	logger.Banner("File Fan-Out Upload Started")
+
+	file, err := os.Open(uploadObject.PathToFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fileInfo, _ := file.Stat()
+	logger.Info.Printf("Uploading '%s' (%d bytes) to %d destination(s)\n", uploadObject.PathToFile, fileInfo.Size(), len(destinations))
+
+	if dryRun {
+		for _, result := range results {
+			logger.Info.Printf("Skipping upload of key: '%s' to destination '%s' as dry run has been enabled\n", result.Key, result.Name)
+		}
+		return results, nil
+	}
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	if uploadObject.Timeout > 0 {
+		ctx, cancelFn = context.WithTimeout(ctx, uploadObject.Timeout)
+		defer cancelFn()
+	}
+
+	startTime := time.Now()
+
+	writers := make([]*io.PipeWriter, len(results))
+	var wg sync.WaitGroup
+	for i := range results {
+		reader, writer := io.Pipe()
+		writers[i] = writer
+
+		wg.Add(1)
+		go func(result *DestinationResult, reader *io.PipeReader) {
+			defer wg.Done()
+			result.Err = uploadStream(ctx, uploadObject, result, reader)
+			// Unblock the writes of the file to this destination once its upload has finished
+			reader.CloseWithError(fmt.Errorf("upload to destination '%s' has finished", result.Name))
+			if result.Err != nil && result.Required {
+				cancelFn() // All-or-nothing, there is no point continuing with the other destinations
+			}
+		}(&results[i], reader)
+	}
+
+	readErr := copyToAll(file, writers)
+	for _, writer := range writers {
+		writer.CloseWithError(readErr) // A nil error closes the writer normally
+	}
+	wg.Wait()
+
+	logger.Info.Printf("Total time spent processing fan-out upload: %0.2f seconds\n", time.Since(startTime).Seconds())
+
+	failedRequired := []string{}
+	for i := range results {
+		result := &results[i]
+		if result.Err == nil {
+			logger.Info.Printf("Successfully uploaded key: '%s' to destination '%s'\n", result.Key, result.Name)
+			continue
+		}
+
+		logger.Error.Printf("Upload of key: '%s' to destination '%s' failed: %v\n", result.Key, result.Name, result.Err)
+		result.Err = cleanUpFailedUpload(result.Svc, result.Bucket, result.Key, result.Err, logger)
+		if result.Required {
+			failedRequired = append(failedRequired, result.Name)
+		}
+	}
+
+	if len(failedRequired) == 0 {
+		return results, nil
+	}
+
+	// All-or-nothing, remove the keys uploaded to the other destinations
+	for i := range results {
+		result := &results[i]
+		if result.Err != nil {
+			continue
+		}
+		_, err := s3client.DeleteKey(result.Svc, result.Bucket, result.Key)
+		if err != nil {
+			logger.Error.Printf("Failed to delete key: '%s' from destination '%s' after a required destination failed: %v\n", result.Key, result.Name, err)
+		} else {
+			logger.Info.Printf("Deleted key: '%s' from destination '%s' as a required destination failed\n", result.Key, result.Name)
+		}
+		result.Err = errors.New("cancelled as a required destination failed")
+	}
+
+	return results, fmt.Errorf("upload to required destination(s) '%s' failed", strings.Join(failedRequired, "', '"))
+}
+
+// uploadStream uploads everything written to the reader to the key of the destination
+func uploadStream(ctx context.Context, uploadObject UploadObject, result *DestinationResult, reader io.Reader) error {
+	uploader := s3manager.NewUploaderWithClient(result.Svc, func(u *s3manager.Uploader) {
+		u.PartSize = int64(uploadObject.PartSize * 1024 * 1024)
+		u.Concurrency = uploadObject.NumWorkers
+		u.LeavePartsOnError = false
+	})
+
+	_, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(result.Bucket),
+		Key:    aws.String(result.Key),
+		Body:   reader,
+	})
+	return err
+}
+
+// copyToAll reads the file once and writes each chunk to every writer
+// A writer which fails is skipped from then on so that a failed destination does not stop the others
+// Returns an error only if the file could not be read or every writer has failed
+func copyToAll(file io.Reader, writers []*io.PipeWriter) error {
+	failed := make([]bool, len(writers))
+	remaining := len(writers)
+	buf := make([]byte, fanOutBufferSize)
+
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			for i, writer := range writers {
+				if failed[i] {
+					continue
+				}
+				_, writeErr := writer.Write(buf[:n])
+				if writeErr != nil {
+					failed[i] = true
+					remaining--
+				}
+			}
+			if remaining == 0 {
+				return errors.New("the upload to every destination has failed")
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package upload
+
+import (
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"os"
+	"time"
+)
+
+// UploadFileToStore copies the file to a local directory store and returns the key it was written to
+// The key is named in the same way as UploadFile. The key is written atomically so a failed copy leaves nothing behind
+func UploadFileToStore(store *storage.FileStore, uploadObject UploadObject, prefix string, dryRun bool) (string, error) {
+	err := validationCheck(uploadObject)
+	if err != nil {
+		return "", err
+	}
+
+	logger := log.OrDefault(uploadObject.Logger)
+	logger.Banner("File Upload Started")
+
+	file, err := os.Open(uploadObject.PathToFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	fileInfo, _ := file.Stat()
+	key := getKey(uploadObject, prefix, keyTime())
+
+	logger.Info.Printf("Uploading '%s' (%d bytes) to '%s'\n", uploadObject.PathToFile, fileInfo.Size(), store)
+
+	if dryRun {
+		logger.Info.Printf("Skipping upload of key: '%s' as dry run has been enabled\n", key)
+		return key, nil
+	}
+
+	startTime := time.Now()
+	err = store.Put(key, file)
+	logger.Info.Printf("Total time spent processing upload: %0.2f seconds\n", time.Since(startTime).Seconds())
+	if err != nil {
+		logger.Error.Printf("Upload of key: '%s' failed: %v\n", key, err)
+		return "", err
+	}
+
+	return key, nil
+}
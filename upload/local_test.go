@@ -0,0 +1,90 @@
+package upload
+
+import (
+	"fmt"
+	"github.com/daniel-cole/GoS3GFSBackup/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getTestFileStore returns the store of a new temporary directory along with a file to be uploaded to it
+func getTestFileStore(t *testing.T) (*storage.FileStore, string, func()) {
+	dir, err := ioutil.TempDir("", "gos3gfsbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(dir, "store")
+	err = os.Mkdir(root, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.NewFileStore(storage.FileScheme + filepath.ToSlash(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "backup.tar")
+	err = ioutil.WriteFile(path, []byte("local backup"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, path, func() { os.RemoveAll(dir) }
+}
+
+func TestUploadFileToStore(t *testing.T) {
+	store, path, cleanUp := getTestFileStore(t)
+	defer cleanUp()
+
+	uploadObject := UploadObject{PathToFile: path, S3FileName: "postgres", BucketDir: "databases/", Bucket: "file://" + store.Root,
+		NumWorkers: 1, PartSize: 5, Timeout: time.Minute, Manipulate: true}
+
+	key, err := UploadFileToStore(store, uploadObject, "daily_", false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file to the store without any error: %v", err))
+	}
+
+	if !strings.HasPrefix(key, "databases/daily_postgres_") {
+		t.Error(fmt.Sprintf("expected the key to be named in the same way as an upload to S3, instead got: %s", key))
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(store.Root, filepath.FromSlash(key)))
+	if err != nil || string(contents) != "local backup" {
+		t.Error(fmt.Sprintf("expected the file to be written to the key, instead got: '%s' %v", contents, err))
+	}
+}
+
+func TestUploadFileToStoreDryRun(t *testing.T) {
+	store, path, cleanUp := getTestFileStore(t)
+	defer cleanUp()
+
+	uploadObject := UploadObject{PathToFile: path, S3FileName: "postgres", Bucket: "file://" + store.Root, NumWorkers: 1, PartSize: 5}
+
+	key, err := UploadFileToStore(store, uploadObject, "", true)
+	if err != nil || key != "postgres" {
+		t.Fatal(fmt.Sprintf("expected the key of the dry run to be returned without any error, instead got: '%s' %v", key, err))
+	}
+
+	keys, _ := store.List("")
+	if len(keys) != 0 {
+		t.Error(fmt.Sprintf("expected nothing to be written for a dry run, instead found: %v", keys))
+	}
+}
+
+func TestUploadFileToStoreValidates(t *testing.T) {
+	store, path, cleanUp := getTestFileStore(t)
+	defer cleanUp()
+
+	uploadObject := UploadObject{PathToFile: path, S3FileName: "postgres", BucketDir: "databases", Bucket: "file://" + store.Root, NumWorkers: 1, PartSize: 5}
+
+	_, err := UploadFileToStore(store, uploadObject, "", false)
+	if err == nil || !strings.Contains(err.Error(), "trailing slash") {
+		t.Error(fmt.Sprintf("expected a bucket dir without a trailing slash to be rejected, instead got: %v", err))
+	}
+}
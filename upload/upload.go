@@ -9,6 +9,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/daniel-cole/GoS3GFSBackup/log"
 	"github.com/daniel-cole/GoS3GFSBackup/s3client"
+	"github.com/daniel-cole/GoS3GFSBackup/util"
 	"math"
 	"os"
 	"regexp"
@@ -16,52 +17,59 @@ import (
 	"time"
 )
 
+// abortTimeout is the maximum amount of time to spend aborting a failed multipart upload
+const abortTimeout = time.Second * 30
+
+// keyTime returns the time used for the timestamp of a new key. Tests replace it so that keys do not collide
+var keyTime = time.Now
+
 // UploadFile returns the name of the file that was uploaded to S3
 // If manipulate name is true then the file the prefix will be applied and timestamp appended to the S3 file name
+// If the upload fails, is cancelled or times out then an *UploadFailure is returned once any incomplete
+// multipart upload has been aborted
 func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (string, error) {
+	result, err := Upload(svc, uploadObject, prefix, dryRun)
+	return result.Key, err
+}
+
+// Upload uploads the file to S3 in the same way as UploadFile and returns the outcome of the upload
+// If dedup is enabled and the file is identical to the latest backup of the series then the latest backup
+// is copied to the new key within S3 instead of uploading the file again
+func Upload(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun bool) (Result, error) {
 
 	if svc == nil {
-		return "", errors.New("svc must not be nil")
+		return Result{}, errors.New("svc must not be nil")
 	}
 
 	err := validationCheck(uploadObject)
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
 
-	log.Info.Println(`
-	######################################
-	#         File Upload Started        #
-	######################################
-	`)
+	logger := log.OrDefault(uploadObject.Logger)
+	logger.Banner("File Upload Started")
 
 	// Context provides a timeout with AWS SDK calls 'WithContext'
-	ctx := context.Background()
-	var cancelFn func()
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
 	if uploadObject.Timeout > 0 {
 		ctx, cancelFn = context.WithTimeout(ctx, uploadObject.Timeout)
+		defer cancelFn()
 	}
-	defer cancelFn()
 
 	file, err := os.Open(uploadObject.PathToFile)
 	defer file.Close()
 
 	if err != nil {
-		return "", err
+		return Result{}, err
 	}
 
 	fileInfo, _ := file.Stat()
 	fileSize := fileInfo.Size()
 
-	log.Info.Printf("Uploading '%s' (%d bytes) to s3 bucket '%s'\n", uploadObject.PathToFile, fileSize, uploadObject.Bucket)
+	logger.Info.Printf("Uploading '%s' (%d bytes) to s3 bucket '%s'\n", uploadObject.PathToFile, fileSize, uploadObject.Bucket)
 
-	s3FileName := uploadObject.S3FileName
-
-	if uploadObject.Manipulate { // Mutate the file name to comply with GFS
-		s3FileName = fmt.Sprintf("%s%s%s_%s", uploadObject.BucketDir, prefix, uploadObject.S3FileName, time.Now().Format("20060102T150405"))
-	} else {
-		s3FileName = uploadObject.BucketDir + s3FileName
-	}
+	s3FileName := getKey(uploadObject, prefix, keyTime())
 
 	uploadParams := &s3manager.UploadInput{
 		Bucket: aws.String(uploadObject.Bucket),
@@ -71,7 +79,33 @@ func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun boo
 
 	partSize := int64(uploadObject.PartSize * 1024 * 1024)
 
-	log.Info.Printf("Upload part size is: %d bytes\n", partSize)
+	result := Result{Key: s3FileName}
+
+	if uploadObject.Dedup && uploadObject.Manipulate {
+		result.Checksum, err = fileChecksum(file)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to compute checksum of '%s': %v", uploadObject.PathToFile, err)
+		}
+		logger.Info.Printf("Checksum of '%s' is: %s\n", uploadObject.PathToFile, result.Checksum)
+		uploadParams.Metadata = aws.StringMap(map[string]string{ChecksumMetadataKey: result.Checksum})
+
+		// Failing to find the latest backup is not fatal, the file is uploaded as if dedup was not enabled
+		identicalKey, err := findIdenticalBackup(ctx, svc, uploadObject, result.Checksum, fileSize, logger)
+		if err != nil {
+			logger.Warn.Printf("Failed to compare the file with the latest backup, uploading. Reason: %v\n", err)
+		} else if identicalKey != "" {
+			result.CopiedFrom = identicalKey
+			err = copyIdenticalBackup(ctx, svc, uploadObject, result, fileSize, partSize, dryRun, logger)
+			if err == nil {
+				return result, nil
+			}
+			// The copy may be refused, i.e. the latest backup has been transitioned to GLACIER, so the file is uploaded instead
+			logger.Warn.Printf("Failed to copy the latest backup '%s', uploading instead. Reason: %v\n", identicalKey, err)
+			result.CopiedFrom = ""
+		}
+	}
+
+	logger.Info.Printf("Upload part size is: %d bytes\n", partSize)
 
 	finishedCh := make(chan bool)
 
@@ -80,12 +114,12 @@ func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun boo
 			<-finishedCh
 		} else {
 			totalParts := int64(math.Ceil(float64(fileSize) / float64(partSize))) // Round up
-			log.Info.Printf("Upload is larger than %d bytes and therefore will be uploaded in %d chunks\n", partSize, totalParts)
-			checkUploadProgress(svc, s3FileName, uploadObject.Bucket, partSize, totalParts, finishedCh) // Attempt to track progress of file upload
+			logger.Info.Printf("Upload is larger than %d bytes and therefore will be uploaded in %d chunks\n", partSize, totalParts)
+			checkUploadProgress(svc, s3FileName, uploadObject.Bucket, partSize, totalParts, finishedCh, logger) // Attempt to track progress of file upload
 		}
 	}()
 
-	log.Info.Printf("Uploading is about to begin with a maximum of %d workers\n", uploadObject.NumWorkers)
+	logger.Info.Printf("Uploading is about to begin with a maximum of %d workers\n", uploadObject.NumWorkers)
 
 	uploader := s3manager.NewUploaderWithClient(svc, func(u *s3manager.Uploader) {
 		u.PartSize = partSize                   // 50MiB part size. Limit of 10,000 parts. http://docs.aws.amazon.com/AmazonS3/latest/dev/mpuoverview.html
@@ -96,49 +130,141 @@ func UploadFile(svc *s3.S3, uploadObject UploadObject, prefix string, dryRun boo
 	startTime := time.Now()
 
 	if dryRun {
-		log.Info.Printf("Skipping upload of key: '%s' as dry run has been enabled\n", s3FileName)
+		logger.Info.Printf("Skipping upload of key: '%s' as dry run has been enabled\n", s3FileName)
 	} else {
 		_, err = uploader.UploadWithContext(ctx, uploadParams) // Upload file
 	}
 	elapsedTime := time.Since(startTime).Seconds()
 
-	log.Info.Printf("Total time spent processing upload: %0.2f seconds\n", elapsedTime)
+	logger.Info.Printf("Total time spent processing upload: %0.2f seconds\n", elapsedTime)
 
 	finishedCh <- true // Stop checking for upload
 
 	if err != nil {
-		return "", err
+		logger.Error.Printf("Upload of key: '%s' failed: %v\n", s3FileName, err)
+		return Result{}, cleanUpFailedUpload(svc, uploadObject.Bucket, s3FileName, err, logger)
+	}
+
+	return result, nil
+}
+
+// copyIdenticalBackup copies the latest backup of the series, which is identical to the file, to the key of the result
+func copyIdenticalBackup(ctx context.Context, svc *s3.S3, uploadObject UploadObject, result Result, size int64, partSize int64, dryRun bool, logger *log.Logger) error {
+	logger.Info.Printf("File is identical to the latest backup '%s', copying it to key: '%s' instead of uploading\n", result.CopiedFrom, result.Key)
+
+	if dryRun {
+		logger.Info.Printf("Skipping copy of key: '%s' as dry run has been enabled\n", result.CopiedFrom)
+		return nil
+	}
+
+	startTime := time.Now()
+	if size > s3client.MaxCopyObjectSize {
+		logger.Info.Printf("Source key is larger than %d bytes and therefore will be copied in parts\n", s3client.MaxCopyObjectSize)
+	}
+	err := s3client.CopyKey(ctx, svc, uploadObject.Bucket, result.CopiedFrom, result.Key, partSize)
+	logger.Info.Printf("Total time spent processing copy: %0.2f seconds\n", time.Since(startTime).Seconds())
+
+	if err != nil {
+		logger.Error.Printf("Copy of key: '%s' to key: '%s' failed: %v\n", result.CopiedFrom, result.Key, err)
+		return cleanUpFailedUpload(svc, uploadObject.Bucket, result.Key, err, logger)
+	}
+
+	return nil
+}
+
+// getKey returns the key the file is uploaded to
+// If manipulate is enabled then the prefix is applied and the timestamp appended to the file name to comply with GFS
+func getKey(uploadObject UploadObject, prefix string, timestamp time.Time) string {
+	if uploadObject.Manipulate {
+		return fmt.Sprintf("%s%s%s_%s", uploadObject.BucketDir, prefix, uploadObject.S3FileName, timestamp.Format(util.KeyTimestampFormat))
+	}
+	return uploadObject.BucketDir + uploadObject.S3FileName
+}
+
+// cleanUpFailedUpload aborts the multipart upload left behind by a failed upload
+// A fresh context is used as the context of the upload may have already been cancelled or timed out
+func cleanUpFailedUpload(svc *s3.S3, bucket string, s3FileName string, uploadErr error, logger *log.Logger) *UploadFailure {
+	failure := &UploadFailure{Err: uploadErr, Key: s3FileName}
+
+	if multiUploadFailure, ok := uploadErr.(s3manager.MultiUploadFailure); ok {
+		failure.UploadID = multiUploadFailure.UploadID()
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancelFn()
+
+	if failure.UploadID == "" {
+		// The upload may have failed before the multipart upload id was returned to the uploader
+		multiPartUploads, err := s3client.GetMultiPartUploadsByPrefix(svc, bucket, s3FileName)
+		if s3client.IsNotImplemented(err) {
+			// Some S3 compatible servers can not list multipart uploads, the upload is left for the bucket to expire
+			logger.Warn.Printf("Unable to find incomplete multipart upload for key: '%s' as the server does not support listing multipart uploads\n", s3FileName)
+			failure.CleanupErr = err
+			return failure
+		}
+		if err != nil {
+			logger.Warn.Printf("Failed to check for incomplete multipart uploads for key: '%s': %v\n", s3FileName, err)
+			failure.CleanupErr = err
+			return failure
+		}
+		for _, multiPartUpload := range multiPartUploads {
+			if multiPartUpload.Key == s3FileName {
+				failure.UploadID = multiPartUpload.UploadID
+			}
+		}
+	}
+
+	if failure.UploadID == "" {
+		logger.Info.Printf("No incomplete multipart upload found for key: '%s'\n", s3FileName)
+		failure.CleanedUp = true
+		return failure
+	}
+
+	logger.Info.Printf("Aborting incomplete multipart upload: '%s' for key: '%s'\n", failure.UploadID, s3FileName)
+	err := s3client.AbortMultiPartUploadWithContext(ctx, svc, bucket, s3FileName, failure.UploadID)
+	if err != nil {
+		if s3client.IsErrorCode(err, s3.ErrCodeNoSuchUpload) {
+			// The uploader already aborted the upload itself
+			logger.Info.Printf("Multipart upload: '%s' has already been aborted\n", failure.UploadID)
+			failure.CleanedUp = true
+			return failure
+		}
+		logger.Error.Printf("Failed to abort multipart upload: '%s' for key: '%s': %v\n", failure.UploadID, s3FileName, err)
+		failure.CleanupErr = err
+		return failure
 	}
 
-	return s3FileName, nil
+	logger.Info.Printf("Successfully aborted multipart upload: '%s' for key: '%s'\n", failure.UploadID, s3FileName)
+	failure.CleanedUp = true
+	return failure
 }
 
 // This function attempts to track the progress of an S3 multipart upload
 // It will only work if there are no other multipart uploads running at the same time with the same key
 // This function provides better feedback when the file size is sufficiently large or the number of workers relative
 // To the file size is low. i.e. 1 worker for 200MiB. 5 workers for 5GiB
-func checkUploadProgress(svc *s3.S3, s3FileName string, bucket string, partSize int64, totalParts int64, uploadFinishedCh <-chan bool) {
-	log.Info.Println("Attempting to display progress of upload. This will give a very rough estimate of progress, " +
+func checkUploadProgress(svc *s3.S3, s3FileName string, bucket string, partSize int64, totalParts int64, uploadFinishedCh <-chan bool, logger *log.Logger) {
+	logger.Info.Println("Attempting to display progress of upload. This will give a very rough estimate of progress, " +
 		"especially if the upload is being handled by multiple workers. Only a maximum of 1000 parts will be displayed")
 	for { // Loop will only exit once channel has been updated
 		time.Sleep(time.Second * 30) // Sleep first to allow time for multi-part upload to start
 		select {
 		case <-uploadFinishedCh:
-			log.Info.Println("Stopping upload checks as upload has finished processing")
+			logger.Info.Println("Stopping upload checks as upload has finished processing")
 			// Received a value from the channel which means that the upload has finished
 			return
 		default:
 			uploadId, err := s3client.GetMultiPartUploadIDByKey(svc, bucket, s3FileName)
 			if err != nil {
-				log.Warn.Printf("Failed to retrieve upload id: %v\n", err)
+				logger.Warn.Printf("Failed to retrieve upload id: %v\n", err)
 			}
 
 			partsCompleted, err := s3client.GetCountMultiPartsById(svc, bucket, s3FileName, uploadId)
 			if err != nil {
-				log.Warn.Printf("Failed to retrieve uploaded parts: %v\n", err)
+				logger.Warn.Printf("Failed to retrieve uploaded parts: %v\n", err)
 			}
 			// Display the current estimated upload progress
-			log.Info.Printf("Upload progress: parts uploaded: %d/%d (%d bytes)\n", partsCompleted, totalParts, partsCompleted*partSize)
+			logger.Info.Printf("Upload progress: parts uploaded: %d/%d (%d bytes)\n", partsCompleted, totalParts, partsCompleted*partSize)
 		}
 	}
 
@@ -149,6 +149,8 @@ func init() {
 //	4: Upload a Significantly Large File (250MiB)
 //	5: Attempt to upload a file with dry run set to true
 //	6: Upload file with bucket dir specified
+//	7: Upload an identical file twice with dedup enabled
+//	8: Fan out a file to two bucket dirs and a best-effort destination which fails
 //
 //----------------------------------------------
 
@@ -346,6 +348,96 @@ func TestUploadBucketDir(t *testing.T) {
 	}
 }
 
+// Test 7 - Positive Upload Testing
+//	Upload an identical file twice with dedup enabled. The second backup should be copied from the first
+func TestUploadDedup(t *testing.T) {
+	err := util.EmptyBucket(svc, bucket)
+	if err != nil {
+		t.Error("failed to empty bucket")
+	}
+
+	testUploadDedupObject := testUploadObjectManipulated
+	testUploadDedupObject.Dedup = true
+	testUploadDedupObject.SeriesPrefixes = []string{policy.DailyPrefix, policy.WeeklyPrefix, policy.MonthlyPrefix}
+
+	prefix := util.GetKeyType(policy, time.Now())
+	first, err := Upload(svc, testUploadDedupObject, prefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to upload file without any error: %v", err))
+	}
+	if first.CopiedFrom != "" {
+		t.Error(fmt.Sprintf("expected first backup to be uploaded, instead it was copied from: %s", first.CopiedFrom))
+	}
+
+	// Ensure the timestamp of the second key differs from the first
+	keyTime = func() time.Time { return time.Now().Add(time.Second) }
+	defer func() { keyTime = time.Now }()
+
+	second, err := Upload(svc, testUploadDedupObject, prefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected to copy file without any error: %v", err))
+	}
+	if second.CopiedFrom != first.Key {
+		t.Error(fmt.Sprintf("expected second backup to be copied from '%s', instead got: '%s'", first.Key, second.CopiedFrom))
+	}
+	if second.Checksum != first.Checksum {
+		t.Error(fmt.Sprintf("expected checksums to match, instead got '%s' and '%s'", first.Checksum, second.Checksum))
+	}
+
+	bucketContents, err := s3client.GetBucketContents(svc, bucket)
+	if err != nil {
+		t.Error("failed to retrieve bucket contents")
+	}
+
+	if !util.CheckBucketSize(bucketContents, 2) {
+		t.Error("expected bucket size to be 2")
+	}
+
+	if !util.FindKeyInBucket(second.Key, bucketContents) {
+		t.Error("expected to find key in bucket: " + second.Key)
+	}
+}
+
+// Test 8 - Positive Upload Testing
+//	Fan out a file to two bucket dirs and a best-effort destination which fails. Both bucket dirs should have the file
+func TestFanOutBestEffort(t *testing.T) {
+	err := util.EmptyBucket(svc, bucket)
+	if err != nil {
+		t.Error("failed to empty bucket")
+	}
+
+	destinations := []Destination{
+		{Name: "first", Svc: svc, Bucket: bucket, BucketDir: "first/", Required: true},
+		{Name: "second", Svc: svc, Bucket: bucket, BucketDir: "second/", Required: true},
+		{Name: "forbidden", Svc: svc, Bucket: awsForbiddenBucket, Required: false},
+	}
+
+	prefix := util.GetKeyType(policy, time.Now())
+	results, err := FanOut(testUploadObjectManipulated, destinations, prefix, false)
+	if err != nil {
+		t.Fatal(fmt.Sprintf("expected best-effort destination failure to be ignored, instead got: %v", err))
+	}
+
+	if results[2].Err == nil {
+		t.Error("expected upload to the forbidden bucket to fail")
+	}
+
+	bucketContents, err := s3client.GetBucketContents(svc, bucket)
+	if err != nil {
+		t.Error("failed to retrieve bucket contents")
+	}
+
+	if !util.CheckBucketSize(bucketContents, 2) {
+		t.Error("expected bucket size to be 2")
+	}
+
+	for _, result := range results[:2] {
+		if result.Err != nil || !util.FindKeyInBucket(result.Key, bucketContents) {
+			t.Error(fmt.Sprintf("expected to find key in bucket: %s, upload error: %v", result.Key, result.Err))
+		}
+	}
+}
+
 func TestJustUploadItWithBucket(t *testing.T) {
 
 }
@@ -357,6 +449,8 @@ func TestJustUploadItWithBucket(t *testing.T) {
 //	3: Upload a file that does not exist
 //	4: Upload a file to a bucket without the appropriate permissions
 //	5: Upload a file that exceeds the specified timeout period (60 seconds)
+//	10: Upload a file that exceeds the timeout and leaves no multipart upload behind
+//	11: Fan out a file to a required destination without the appropriate permissions
 //
 //----------------------------------------------
 
@@ -583,3 +677,74 @@ func TestUploadNegativeTimeout(t *testing.T) {
 		t.Error("expected error when timeout less than 0")
 	}
 }
+
+// Test 10 - Negative Upload Testing
+//	Upload a file that exceeds the specified timeout period (10 seconds)
+//	The incomplete multipart upload should be aborted once the upload has failed
+func TestUploadExceedTimeoutAbortsMultipartUpload(t *testing.T) {
+
+	testUploadTimeoutObject := UploadObject{
+		PathToFile: pathToBigFile,
+		S3FileName: bigS3FileName,
+		BucketDir:  "",
+		Bucket:     bucket,
+		Timeout:    time.Second * 10,
+		NumWorkers: 5,
+		PartSize:   50,
+		Manipulate: true,
+	}
+
+	prefix := util.GetKeyType(policy, time.Now())
+	_, err := UploadFile(svc, testUploadTimeoutObject, prefix, false)
+	if err == nil {
+		t.Fatal("expected file upload to timeout")
+	}
+
+	failure, ok := err.(*UploadFailure)
+	if !ok {
+		t.Fatal(fmt.Sprintf("expected error to be an *UploadFailure, instead got: %v", err))
+	}
+
+	if !failure.CleanedUp {
+		t.Error(fmt.Sprintf("expected multipart upload to be cleaned up, instead got: %v", failure))
+	}
+
+	multiPartUploads, err := s3client.GetMultiPartUploadsByPrefix(svc, bucket, failure.Key)
+	if err != nil {
+		t.Error("failed to retrieve multipart uploads")
+	}
+
+	if len(multiPartUploads) != 0 {
+		t.Error(fmt.Sprintf("expected no multipart uploads for key '%s' but found %d", failure.Key, len(multiPartUploads)))
+	}
+}
+
+// Test 11 - Negative Upload Testing
+//	Fan out a file to a bucket dir and a required destination without the appropriate permissions
+//	The upload should fail and nothing should be left in the bucket
+func TestFanOutRequiredForbidden(t *testing.T) {
+	err := util.EmptyBucket(svc, bucket)
+	if err != nil {
+		t.Error("failed to empty bucket")
+	}
+
+	destinations := []Destination{
+		{Name: "first", Svc: svc, Bucket: bucket, BucketDir: "first/", Required: true},
+		{Name: "forbidden", Svc: svc, Bucket: awsForbiddenBucket, Required: true},
+	}
+
+	prefix := util.GetKeyType(policy, time.Now())
+	_, err = FanOut(testUploadObjectManipulated, destinations, prefix, false)
+	if err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Error(fmt.Sprintf("expected upload to fail because of the forbidden destination, instead got: %v", err))
+	}
+
+	bucketContents, err := s3client.GetBucketContents(svc, bucket)
+	if err != nil {
+		t.Error("failed to retrieve bucket contents")
+	}
+
+	if !util.CheckBucketSize(bucketContents, 0) {
+		t.Error("expected bucket to be empty")
+	}
+}
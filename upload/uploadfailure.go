@@ -0,0 +1,25 @@
+package upload
+
+import "fmt"
+
+// UploadFailure is returned by UploadFile when an upload fails, is cancelled or times out
+// It records whether the incomplete multipart upload left behind by the failed upload was aborted
+type UploadFailure struct {
+	Err        error  // The error which caused the upload to fail
+	Key        string // The key the file was being uploaded to
+	UploadID   string // The id of the multipart upload in flight. Empty if no multipart upload was started
+	CleanedUp  bool   // True if there is no incomplete multipart upload left in the bucket
+	CleanupErr error  // The error returned when attempting to abort the multipart upload
+}
+
+func (f *UploadFailure) Error() string {
+	if f.UploadID == "" {
+		return fmt.Sprintf("%v (no multipart upload to clean up)", f.Err)
+	}
+
+	if f.CleanedUp {
+		return fmt.Sprintf("%v (aborted multipart upload: '%s')", f.Err, f.UploadID)
+	}
+
+	return fmt.Sprintf("%v (failed to abort multipart upload: '%s': %v)", f.Err, f.UploadID, f.CleanupErr)
+}
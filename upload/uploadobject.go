@@ -1,6 +1,9 @@
 package upload
 
-import "time"
+import (
+	"github.com/daniel-cole/GoS3GFSBackup/log"
+	"time"
+)
 
 // UploadObject represents an object to be uploaded to S3
 type UploadObject struct {
@@ -12,4 +15,15 @@ type UploadObject struct {
 	Timeout    time.Duration
 	NumWorkers int
 	PartSize   int
+	Logger     *log.Logger // If nil then the default logger is used
+	Dedup      bool        // If enabled then a file identical to the latest backup of the series is copied within S3 instead of uploaded
+	// SeriesPrefixes are the tier prefixes searched for the latest backup of the series when dedup is enabled
+	SeriesPrefixes []string
+}
+
+// Result is the outcome of an upload
+type Result struct {
+	Key        string // The key uploaded, or copied to
+	CopiedFrom string // The key of the identical latest backup copied instead of uploading. Empty if the file was uploaded
+	Checksum   string // The sha256 of the file. Only computed when dedup is enabled
 }
@@ -3,17 +3,41 @@ package util
 import (
 	"crypto/md5"
 	"errors"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/log"
 	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
 	"github.com/daniel-cole/GoS3GFSBackup/s3client"
 	"github.com/jinzhu/now"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"time"
 )
 
+// KeyTimestampFormat is the format of the timestamp appended to the name of every key uploaded by a backup
+const KeyTimestampFormat = "20060102T150405"
+
+// keyTimestamp matches the timestamp appended to the name of a key
+var keyTimestamp = regexp.MustCompile(`_(\d{8}T\d{6})$`)
+
+// GetKeyTimestamp returns the local time embedded in the name of a key uploaded by a backup
+// False is returned if the key does not end with a timestamp
+func GetKeyTimestamp(key string) (time.Time, bool) {
+	match := keyTimestamp.FindStringSubmatch(key)
+	if match == nil {
+		return time.Time{}, false
+	}
+	keyTime, err := time.ParseInLocation(KeyTimestampFormat, match[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return keyTime, true
+}
+
 // CheckPrefix checks if the prefix of a string matches the specified prefix.
 // Returns true if it matches; else false
 func CheckPrefix(key string, prefix string) bool {
@@ -23,14 +47,70 @@ func CheckPrefix(key string, prefix string) bool {
 
 // CleanUpMultiPartUploads is a Helpful function to get rid of all abandoned multipart uploads
 func CleanUpMultiPartUploads(svc *s3.S3, bucket string) error {
-	multiPartUploads, err := s3client.GetAllMultiPartUploads(svc, bucket)
+	_, err := PruneMultiPartUploads(svc, bucket, "", 0, false, nil)
+	return err
+}
+
+// PrunedMultiPartUpload represents a multipart upload which was aborted along with the bytes reclaimed
+type PrunedMultiPartUpload struct {
+	s3client.MultiPartUpload
+	Size int64
+}
+
+// PruneMultiPartUploads aborts all multipart uploads with the specified prefix that were initiated more than
+// minAge ago. If dry run is enabled then the uploads are reported but not aborted
+// Returns the uploads that were aborted along with the size of the parts reclaimed by each
+func PruneMultiPartUploads(svc *s3.S3, bucket string, prefix string, minAge time.Duration, dryRun bool, logger *log.Logger) ([]PrunedMultiPartUpload, error) {
+	logger = log.OrDefault(logger)
+
+	multiPartUploads, err := s3client.GetMultiPartUploadsByPrefix(svc, bucket, prefix)
+	if s3client.IsNotImplemented(err) {
+		return nil, fmt.Errorf("the server does not support listing multipart uploads, incomplete uploads must be removed by the server: %v", err)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	for key, uploadId := range multiPartUploads {
-		s3client.AbortAllMultiPartUploads(svc, bucket, key, uploadId)
+
+	logger.Info.Printf("Found %d multipart upload(s) with prefix: '%s'\n", len(multiPartUploads), prefix)
+
+	pruned := []PrunedMultiPartUpload{}
+	var failed int
+
+	for _, multiPartUpload := range multiPartUploads {
+		age := time.Since(multiPartUpload.Initiated)
+		if age < minAge {
+			logger.Info.Printf("Skipping multipart upload for key: '%s' as it is only %0.1f hours old\n", multiPartUpload.Key, age.Hours())
+			continue
+		}
+
+		size, err := s3client.GetMultiPartUploadSize(svc, bucket, multiPartUpload.Key, multiPartUpload.UploadID)
+		if err != nil {
+			logger.Warn.Printf("Failed to retrieve parts for multipart upload for key: '%s': %v\n", multiPartUpload.Key, err)
+			size = 0
+		}
+
+		if dryRun {
+			logger.Info.Printf("Skipping abort of multipart upload for key: '%s' (%d bytes, %0.1f hours old) as dry run has been enabled\n",
+				multiPartUpload.Key, size, age.Hours())
+		} else {
+			err = s3client.AbortAllMultiPartUploads(svc, bucket, multiPartUpload.Key, multiPartUpload.UploadID)
+			if err != nil {
+				logger.Error.Printf("Failed to abort multipart upload for key: '%s': %v\n", multiPartUpload.Key, err)
+				failed++
+				continue
+			}
+			logger.Info.Printf("Aborted multipart upload for key: '%s' (%d bytes reclaimed, %0.1f hours old)\n",
+				multiPartUpload.Key, size, age.Hours())
+		}
+
+		pruned = append(pruned, PrunedMultiPartUpload{multiPartUpload, size})
 	}
-	return nil
+
+	if failed > 0 {
+		return pruned, fmt.Errorf("failed to abort %d multipart upload(s)", failed)
+	}
+
+	return pruned, nil
 }
 
 // RetrieveSortedKeysByTime is a helper function to get all sorted keys
@@ -67,6 +147,49 @@ func GetKeyType(policy rpolicy.RotationPolicy, keyTime time.Time) string {
 	return policy.DailyPrefix
 }
 
+// GetCatchUpKeyType returns the key type for a particular time in the same way as GetKeyType, except that a late
+// backup is reclassified as the tier that was missed. The monthly backup is missed if there is no monthly key since the
+// first day of the month and the weekly backup is missed if there is no weekly or monthly key since the Monday of the week
+// lastMonthly and lastWeekly are the modified times of the newest monthly and weekly keys, zero if the tier has no keys
+func GetCatchUpKeyType(policy rpolicy.RotationPolicy, keyTime time.Time, lastMonthly time.Time, lastWeekly time.Time) string {
+	if lastMonthly.Before(MonthlyAnchor(keyTime)) {
+		return policy.MonthlyPrefix
+	}
+
+	weeklyAnchor := WeeklyAnchor(keyTime)
+	if lastWeekly.Before(weeklyAnchor) && lastMonthly.Before(weeklyAnchor) {
+		return policy.WeeklyPrefix
+	}
+
+	return GetKeyType(policy, keyTime)
+}
+
+// MonthlyAnchor returns the start of the first day of the month, the day a monthly backup is taken
+func MonthlyAnchor(t time.Time) time.Time {
+	return now.New(t).BeginningOfMonth()
+}
+
+// WeeklyAnchor returns the start of the Monday of the week, the day a weekly backup is taken
+func WeeklyAnchor(t time.Time) time.Time {
+	day := now.New(t).BeginningOfDay()
+	daysSinceMonday := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -daysSinceMonday)
+}
+
+// GetTierName returns the name of the tier (daily, weekly, monthly) that a key prefix belongs to
+// An empty string is returned if the prefix does not belong to any tier of the policy
+func GetTierName(policy rpolicy.RotationPolicy, prefix string) string {
+	switch prefix {
+	case policy.MonthlyPrefix:
+		return "monthly"
+	case policy.WeeklyPrefix:
+		return "weekly"
+	case policy.DailyPrefix:
+		return "daily"
+	}
+	return ""
+}
+
 // FindKeyInBucket returns true if the specified key exists in the *s3.ListObjectOutput; otherwise false
 func FindKeyInBucket(keyToFind string, bucketContents *s3.ListObjectsOutput) bool {
 	for _, key := range bucketContents.Contents {
@@ -159,6 +282,32 @@ func CreateFile(pathToFile string, contents []byte) error {
 	return nil
 }
 
+// WriteFileAtomic writes the contents to a temporary file next to the path and renames it over the path
+// so that a reader never sees a partially written file. The file is synced before it is renamed
+func WriteFileAtomic(path string, contents []byte, perm os.FileMode) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpFile.Write(contents)
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
+
 // ComputeMD5Sum takes the full path of a file and returns the md5sum
 func ComputeMD5Sum(filePath string) ([]byte, error) {
 	var result []byte
@@ -0,0 +1,185 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/daniel-cole/GoS3GFSBackup/rpolicy"
+	"github.com/daniel-cole/GoS3GFSBackup/s3client/s3test"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var policy = rpolicy.RotationPolicy{DailyPrefix: "daily_", WeeklyPrefix: "weekly_", MonthlyPrefix: "monthly_"}
+
+func TestWeeklyAnchor(t *testing.T) {
+	expected := time.Date(2017, time.November, 6, 0, 0, 0, 0, time.Local)
+	for day := 6; day <= 12; day++ {
+		anchor := WeeklyAnchor(time.Date(2017, time.November, day, 15, 4, 5, 0, time.Local))
+		if !anchor.Equal(expected) {
+			t.Error(fmt.Sprintf("expected weekly anchor of the %d to be %v but got: %v", day, expected, anchor))
+		}
+	}
+}
+
+func TestGetCatchUpKeyType(t *testing.T) {
+	wednesday := time.Date(2017, time.November, 8, 2, 0, 0, 0, time.Local)
+	monthly := time.Date(2017, time.November, 1, 2, 0, 0, 0, time.Local)
+	weekly := time.Date(2017, time.November, 6, 2, 0, 0, 0, time.Local)
+	lastWeek := time.Date(2017, time.October, 30, 2, 0, 0, 0, time.Local)
+
+	testCases := []struct {
+		name        string
+		lastMonthly time.Time
+		lastWeekly  time.Time
+		expected    string
+	}{
+		{"nothing missed", monthly, weekly, policy.DailyPrefix},
+		{"weekly missed", monthly, lastWeek, policy.WeeklyPrefix},
+		{"monthly missed", time.Time{}, weekly, policy.MonthlyPrefix},
+	}
+
+	for _, testCase := range testCases {
+		keyType := GetCatchUpKeyType(policy, wednesday, testCase.lastMonthly, testCase.lastWeekly)
+		if keyType != testCase.expected {
+			t.Error(fmt.Sprintf("%s: expected key type '%s' but got: '%s'", testCase.name, testCase.expected, keyType))
+		}
+	}
+
+	// A monthly backup on the Monday also fills the weekly tier
+	tuesday := time.Date(2017, time.May, 2, 2, 0, 0, 0, time.Local)
+	keyType := GetCatchUpKeyType(policy, tuesday, time.Date(2017, time.May, 1, 2, 0, 0, 0, time.Local), lastWeek)
+	if keyType != policy.DailyPrefix {
+		t.Error(fmt.Sprintf("expected a monthly key since Monday to fill the weekly tier but got: '%s'", keyType))
+	}
+}
+
+func TestGetKeyTimestamp(t *testing.T) {
+	keyTime, ok := GetKeyTimestamp("databases/daily_postgres_20171108T020304")
+	expected := time.Date(2017, time.November, 8, 2, 3, 4, 0, time.Local)
+	if !ok || !keyTime.Equal(expected) {
+		t.Error(fmt.Sprintf("expected key timestamp %v, instead got: %v", expected, keyTime))
+	}
+
+	for _, key := range []string{"daily_postgres", "daily_postgres_20171108T020304.tar", "daily_postgres_20171308T020304"} {
+		_, ok := GetKeyTimestamp(key)
+		if ok {
+			t.Error(fmt.Sprintf("expected key '%s' to have no timestamp", key))
+		}
+	}
+}
+
+// startTestUpload starts a multipart upload of the key on the server with a part of the specified size
+func startTestUpload(t *testing.T, svc *s3.S3, bucket string, key string, size int) {
+	upload, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to start multipart upload of '%s': %v", key, err))
+	}
+
+	_, err = svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		UploadId:   upload.UploadId,
+		PartNumber: aws.Int64(1),
+		Body:       bytes.NewReader(make([]byte, size)),
+	})
+	if err != nil {
+		t.Fatal(fmt.Sprintf("failed to upload part of '%s': %v", key, err))
+	}
+}
+
+func TestPruneMultiPartUploads(t *testing.T) {
+	server := s3test.NewServer("test-bucket")
+	defer server.Close()
+	svc := server.Client()
+
+	// Uploads started two days ago, except for the recent upload
+	started := time.Now().Add(-time.Hour * 48)
+	server.Now = func() time.Time { return started }
+	startTestUpload(t, svc, "test-bucket", "db/daily_postgres_20171108T020304", 1024)
+	startTestUpload(t, svc, "test-bucket", "db/weekly_postgres_20171106T020304", 2048)
+	startTestUpload(t, svc, "test-bucket", "web/daily_nginx_20171108T020304", 4096)
+	server.Now = time.Now
+	startTestUpload(t, svc, "test-bucket", "db/daily_postgres_20171110T020304", 8192)
+
+	testCases := []struct {
+		name     string
+		prefix   string
+		minAge   time.Duration
+		dryRun   bool
+		expected []string
+		size     int64
+	}{
+		{"dry run", "db/", time.Hour * 24, true, []string{"db/daily_postgres_20171108T020304", "db/weekly_postgres_20171106T020304"}, 3072},
+		{"minimum age", "db/", time.Hour * 72, true, []string{}, 0},
+		{"prefix", "web/", 0, true, []string{"web/daily_nginx_20171108T020304"}, 4096},
+		{"abort", "db/", time.Hour * 24, false, []string{"db/daily_postgres_20171108T020304", "db/weekly_postgres_20171106T020304"}, 3072},
+	}
+
+	for _, testCase := range testCases {
+		uploads := server.Uploads()
+
+		pruned, err := PruneMultiPartUploads(svc, "test-bucket", testCase.prefix, testCase.minAge, testCase.dryRun, nil)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("%s: expected to prune multipart uploads without any error: %v", testCase.name, err))
+		}
+
+		keys := []string{}
+		var size int64
+		for _, upload := range pruned {
+			keys = append(keys, upload.Key)
+			size += upload.Size
+		}
+
+		if fmt.Sprint(keys) != fmt.Sprint(testCase.expected) || size != testCase.size {
+			t.Error(fmt.Sprintf("%s: expected %v (%d bytes) to be pruned, instead got: %v (%d bytes)", testCase.name, testCase.expected, testCase.size, keys, size))
+		}
+
+		aborted := uploads - server.Uploads()
+		if testCase.dryRun && aborted != 0 {
+			t.Error(fmt.Sprintf("%s: expected no uploads to be aborted in a dry run, instead %d were aborted", testCase.name, aborted))
+		}
+		if !testCase.dryRun && aborted != len(testCase.expected) {
+			t.Error(fmt.Sprintf("%s: expected %d uploads to be aborted, instead %d were aborted", testCase.name, len(testCase.expected), aborted))
+		}
+	}
+
+	if server.Uploads() != 2 {
+		t.Error(fmt.Sprintf("expected the recent upload and the upload outside the prefix to remain, instead %d remain", server.Uploads()))
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "report.json")
+	for _, contents := range []string{"first\n", "second\n"} {
+		err = WriteFileAtomic(path, []byte(contents), 0640)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("expected to write the file without any error: %v", err))
+		}
+
+		written, err := ioutil.ReadFile(path)
+		if err != nil || string(written) != contents {
+			t.Error(fmt.Sprintf("expected the file to contain '%s', instead got '%s' (%v)", contents, written, err))
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0640 {
+		t.Error(fmt.Sprintf("expected the file to have mode 0640, instead got: %v (%v)", info.Mode().Perm(), err))
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Error(fmt.Sprintf("expected no temporary file to be left behind, instead got %d entries (%v)", len(entries), err))
+	}
+}